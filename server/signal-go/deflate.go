@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// permessage-deflate (RFC 7692) support.
+
+const (
+	deflateThresholdDefault = 512
+	deflateLevel            = flate.BestSpeed
+	// deflateWindow is the LZ77 window compress/flate always uses, which is
+	// why offers limiting server_max_window_bits below 15 are declined.
+	deflateWindow = 1 << 15
+)
+
+// deflateTail is the empty stored block a sync flush ends with. Senders strip
+// it and receivers put it back (RFC 7692 section 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateConfig is the server's compression policy.
+type deflateConfig struct {
+	enabled bool
+	// threshold is the smallest payload worth compressing; smaller messages
+	// such as chat go out as they are.
+	threshold int
+	// serverContextTakeover and clientContextTakeover say whether each side
+	// may keep its LZ77 window between messages. Turning them off saves
+	// memory per connection at the cost of ratio.
+	serverContextTakeover bool
+	clientContextTakeover bool
+}
+
+func defaultDeflateConfig() deflateConfig {
+	return deflateConfig{
+		enabled:               true,
+		threshold:             deflateThresholdDefault,
+		serverContextTakeover: true,
+		clientContextTakeover: true,
+	}
+}
+
+// parseContextTakeover reads a WS_DEFLATE_CONTEXT_TAKEOVER value naming the
+// sides that keep their window: both, server, client or none.
+func (c *deflateConfig) parseContextTakeover(value string) error {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "both":
+		c.serverContextTakeover, c.clientContextTakeover = true, true
+	case "server":
+		c.serverContextTakeover, c.clientContextTakeover = true, false
+	case "client":
+		c.serverContextTakeover, c.clientContextTakeover = false, true
+	case "none":
+		c.serverContextTakeover, c.clientContextTakeover = false, false
+	default:
+		return fmt.Errorf("want both, server, client or none, got %q", value)
+	}
+	return nil
+}
+
+// deflateParams are the negotiated parameters for one connection.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// negotiateDeflate picks the first acceptable permessage-deflate offer from a
+// Sec-WebSocket-Extensions header. It returns the response header value, or
+// ok false when nothing was accepted.
+func negotiateDeflate(cfg deflateConfig, header string) (params deflateParams, response string, ok bool) {
+	if !cfg.enabled {
+		return params, "", false
+	}
+	for _, offer := range splitExtensionOffers(header) {
+		if offer.name != "permessage-deflate" {
+			continue
+		}
+		if p, resp, accepted := acceptDeflateOffer(cfg, offer.params); accepted {
+			return p, resp, true
+		}
+	}
+	return params, "", false
+}
+
+func acceptDeflateOffer(cfg deflateConfig, offer map[string]string) (deflateParams, string, bool) {
+	p := deflateParams{
+		serverNoContextTakeover: !cfg.serverContextTakeover,
+		clientNoContextTakeover: !cfg.clientContextTakeover,
+	}
+	for name, value := range offer {
+		switch name {
+		case "server_no_context_takeover":
+			if value != "" {
+				return p, "", false
+			}
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if value != "" {
+				return p, "", false
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			if bits, err := strconv.Atoi(value); err != nil || bits != 15 {
+				return p, "", false
+			}
+		case "client_max_window_bits":
+			// Any client window up to 15 bits inflates fine; an explicit
+			// value only has to be valid.
+			if value != "" {
+				if bits, err := strconv.Atoi(value); err != nil || bits < 8 || bits > 15 {
+					return p, "", false
+				}
+			}
+		default:
+			return p, "", false
+		}
+	}
+	resp := "permessage-deflate"
+	if p.serverNoContextTakeover {
+		resp += "; server_no_context_takeover"
+	}
+	if p.clientNoContextTakeover {
+		resp += "; client_no_context_takeover"
+	}
+	return p, resp, true
+}
+
+type extensionOffer struct {
+	name   string
+	params map[string]string
+}
+
+// splitExtensionOffers parses a Sec-WebSocket-Extensions value. Offers with
+// repeated parameters are dropped, as RFC 7692 requires declining them.
+func splitExtensionOffers(header string) []extensionOffer {
+	var offers []extensionOffer
+	for _, raw := range strings.Split(header, ",") {
+		parts := strings.Split(raw, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		offer := extensionOffer{name: name, params: make(map[string]string)}
+		valid := true
+		for _, part := range parts[1:] {
+			key, value, _ := strings.Cut(part, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if _, dup := offer.params[key]; dup || key == "" {
+				valid = false
+				break
+			}
+			offer.params[key] = value
+		}
+		if valid {
+			offers = append(offers, offer)
+		}
+	}
+	return offers
+}
+
+// deflater compresses outgoing messages for one connection. Only the peer's
+// writer goroutine uses it.
+type deflater struct {
+	threshold int
+	takeover  bool
+	buf       bytes.Buffer
+	w         *flate.Writer
+}
+
+func newDeflater(params deflateParams, threshold int) *deflater {
+	return &deflater{threshold: threshold, takeover: !params.serverNoContextTakeover}
+}
+
+// compress returns the compressed form of payload, or ok false when it is
+// below the threshold and should go out as is.
+func (d *deflater) compress(payload []byte) (out []byte, ok bool) {
+	if len(payload) < d.threshold {
+		return nil, false
+	}
+	d.buf.Reset()
+	if d.w == nil {
+		d.w, _ = flate.NewWriter(&d.buf, deflateLevel)
+	} else if !d.takeover {
+		d.w.Reset(&d.buf)
+	}
+	_, _ = d.w.Write(payload)
+	_ = d.w.Flush()
+	out = bytes.TrimSuffix(d.buf.Bytes(), deflateTail)
+	return append([]byte(nil), out...), true
+}
+
+// inflater decompresses incoming messages for one connection, carrying the
+// window between messages when the client keeps its context.
+type inflater struct {
+	takeover bool
+	r        io.ReadCloser
+	window   []byte
+}
+
+func newInflater(params deflateParams) *inflater {
+	return &inflater{takeover: !params.clientNoContextTakeover}
+}
+
+// inflate decompresses one message, failing with a 1009 close once the
+// output passes maxSize.
+func (in *inflater) inflate(payload []byte, maxSize int) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail))
+	var dict []byte
+	if in.takeover {
+		dict = in.window
+	}
+	if in.r == nil {
+		in.r = flate.NewReaderDict(src, dict)
+	} else if err := in.r.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(in.r, int64(maxSize)+1))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, &closeError{code: closeInvalidPayload, reason: "invalid compressed data"}
+	}
+	if len(out) > maxSize {
+		return nil, &closeError{code: closeMessageTooBig, reason: "message too big"}
+	}
+	if in.takeover {
+		in.window = append(in.window, out...)
+		if len(in.window) > deflateWindow {
+			in.window = append([]byte(nil), in.window[len(in.window)-deflateWindow:]...)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	cases := []struct {
+		name   string
+		cfg    func(*deflateConfig)
+		header string
+		want   string
+	}{
+		{"no offer", nil, "", ""},
+		{"plain offer", nil, "permessage-deflate", "permessage-deflate"},
+		{"client asks no takeover", nil, "permessage-deflate; server_no_context_takeover", "permessage-deflate; server_no_context_takeover"},
+		{"small server window declined", nil, "permessage-deflate; server_max_window_bits=10", ""},
+		{"falls back to next offer", nil, "permessage-deflate; server_max_window_bits=10, permessage-deflate; client_max_window_bits", "permessage-deflate"},
+		{"duplicate param declined", nil, "permessage-deflate; client_no_context_takeover; client_no_context_takeover", ""},
+		{"unknown param declined", nil, "permessage-deflate; foo=1", ""},
+		{"disabled", func(c *deflateConfig) { c.enabled = false }, "permessage-deflate", ""},
+		{"server policy none", func(c *deflateConfig) { _ = c.parseContextTakeover("none") }, "permessage-deflate",
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultDeflateConfig()
+			if tc.cfg != nil {
+				tc.cfg(&cfg)
+			}
+			_, resp, ok := negotiateDeflate(cfg, tc.header)
+			if resp != tc.want || ok != (tc.want != "") {
+				t.Fatalf("response = %q ok = %v, want %q", resp, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	for _, noTakeover := range []bool{false, true} {
+		params := deflateParams{serverNoContextTakeover: noTakeover, clientNoContextTakeover: noTakeover}
+		d, in := newDeflater(params, 0), newInflater(params)
+		msg := []byte(strings.Repeat(`{"type":"game.snapshot","tick":1}`, 40))
+		var sizes []int
+		for i := 0; i < 3; i++ {
+			out, ok := d.compress(msg)
+			if !ok {
+				t.Fatal("payload over threshold not compressed")
+			}
+			sizes = append(sizes, len(out))
+			got, err := in.inflate(out, 1<<20)
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Fatalf("takeover off=%v message %d: %v", noTakeover, i, err)
+			}
+		}
+		// With context takeover a repeated message compresses to a back
+		// reference into the previous one.
+		if !noTakeover && sizes[1] >= sizes[0] {
+			t.Fatalf("context not carried over, sizes = %v", sizes)
+		}
+		if noTakeover && sizes[1] != sizes[0] {
+			t.Fatalf("context carried over, sizes = %v", sizes)
+		}
+	}
+	if _, ok := newDeflater(deflateParams{}, 512).compress([]byte("short")); ok {
+		t.Fatal("payload under threshold compressed")
+	}
+}
+
+func TestInflateEnforcesMessageSize(t *testing.T) {
+	out, _ := newDeflater(deflateParams{}, 0).compress(make([]byte, 4096))
+	_, err := newInflater(deflateParams{}).inflate(out, 1024)
+	if ce, ok := err.(*closeError); !ok || ce.code != closeMessageTooBig {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDeflateOverWebSocket(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.deflate.threshold = 0
+	c, resp := dialTestClientWith(t, ts, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != "permessage-deflate" {
+		t.Fatalf("extensions = %q", got)
+	}
+
+	// A compressed client message is inflated before it is handled.
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	msg, _ := json.Marshal(map[string]any{"type": "room.list.request", "payload": map[string]any{}, "requestId": "z"})
+	_, _ = w.Write(msg)
+	_ = w.Flush()
+	c.writeFrame(0x80|rsv1|opText, bytes.TrimSuffix(buf.Bytes(), deflateTail))
+
+	first, data := c.readFrame()
+	if first != 0x80|rsv1|opText {
+		t.Fatalf("frame header = %x, want compressed text", first)
+	}
+	plain, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail))))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	var env struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(plain, &env); err != nil || env.Type != "room.list.response" {
+		t.Fatalf("inflated %q (%v)", plain, err)
+	}
+
+	// Control frames are never compressed.
+	c.writeFrame(0x80|opPing, []byte("hi"))
+	if first, data := c.readFrame(); first != 0x80|opPong || string(data) != "hi" {
+		t.Fatalf("pong = %x %q", first, data)
+	}
+}
+
+func TestCompressedFrameWithoutNegotiationCloses(t *testing.T) {
+	_, ts := newTestServer(t)
+	c, resp := dialTestClientWith(t, ts, "")
+	if resp.Header.Get("Sec-WebSocket-Extensions") != "" {
+		t.Fatal("extension accepted without an offer")
+	}
+	c.writeFrame(0x80|rsv1|opText, []byte{0x01})
+	if code := c.expectClose(); code != closeProtocolError {
+		t.Fatalf("close code = %d", code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"math"
+
+	"scorched-signal-go/engine"
+)
+
+// roomEconomy is the server's view of each player's cash and inventory,
+// rebuilt from the host's snapshots so guest shop requests can be checked
+// before they reach the host.
+type roomEconomy struct {
+	view     string
+	shopDone map[string]bool
+	players  map[string]engine.Player
+	// confirmed is each player as the last snapshot had them, and pending
+	// the shop actions forwarded to the host since that the snapshots don't
+	// show yet. players is confirmed with pending replayed on top.
+	confirmed map[string]engine.Player
+	pending   map[string][]shopAction
+}
+
+// shopAction is a forwarded shop.buy or shop.sell.
+type shopAction struct {
+	msgType  string
+	weaponID string
+}
+
+// trackSnapshot refreshes the economy from a host snapshot. Snapshots that
+// don't carry player state leave the economy untouched.
+func (e *roomEconomy) trackSnapshot(snap snapshotState) {
+	if snap.View != "" {
+		e.view = snap.View
+	}
+	if snap.ShopDone != nil {
+		e.shopDone = snap.ShopDone
+	}
+	if e.view != "shop" {
+		// Whatever the host didn't apply before the shop closed never will be.
+		e.pending = nil
+	}
+	if len(snap.Match.Players) == 0 {
+		return
+	}
+	confirmed := make(map[string]engine.Player, len(snap.Match.Players))
+	players := make(map[string]engine.Player, len(snap.Match.Players))
+	for _, sp := range snap.Match.Players {
+		id := sp.Config.ID
+		p := engine.Player{
+			Cash:      int(math.Floor(sp.Cash)),
+			Inventory: engine.Inventory(sp.Inventory),
+		}
+		confirmed[id] = p
+		pending := e.pending[id][e.reflected(id, p):]
+		for _, a := range pending {
+			p = a.update(p)
+		}
+		players[id] = p
+		if len(pending) == 0 {
+			delete(e.pending, id)
+		} else {
+			e.pending[id] = pending
+		}
+	}
+	e.confirmed = confirmed
+	e.players = players
+}
+
+// reflected counts how many of a player's pending actions the snapshot
+// showing them as got has applied, by replaying the actions on the last
+// confirmed state until it matches.
+func (e *roomEconomy) reflected(peerID string, got engine.Player) int {
+	pending := e.pending[peerID]
+	prev, ok := e.confirmed[peerID]
+	if !ok || len(pending) == 0 {
+		return 0
+	}
+	states := make([]engine.Player, 0, len(pending)+1)
+	states = append(states, prev)
+	for _, a := range pending {
+		prev = a.update(prev)
+		states = append(states, prev)
+	}
+	for n := len(pending); n > 0; n-- {
+		if sameHoldings(states[n], got) {
+			return n
+		}
+	}
+	return 0
+}
+
+func (a shopAction) update(p engine.Player) engine.Player {
+	if a.msgType == "shop.sell" {
+		return engine.SellWeapon(p, a.weaponID)
+	}
+	return engine.BuyWeapon(p, a.weaponID)
+}
+
+func sameHoldings(a, b engine.Player) bool {
+	if a.Cash != b.Cash {
+		return false
+	}
+	for id, n := range a.Inventory {
+		if b.Inventory[id] != n {
+			return false
+		}
+	}
+	for id, n := range b.Inventory {
+		if a.Inventory[id] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// apply validates a guest's shop.buy or shop.sell and, if it is allowed,
+// records it as pending so further requests see the new balance until a
+// snapshot shows the host has applied it.
+func (e *roomEconomy) apply(peerID, msgType, weaponID string) error {
+	if e.view != "shop" || e.shopDone[peerID] {
+		return engine.ErrShopClosed
+	}
+	p, ok := e.players[peerID]
+	if !ok {
+		return engine.ErrShopClosed
+	}
+	check := engine.CheckBuy
+	if msgType == "shop.sell" {
+		check = engine.CheckSell
+	}
+	if err := check(p, weaponID); err != nil {
+		return err
+	}
+	action := shopAction{msgType, weaponID}
+	e.players[peerID] = action.update(p)
+	if e.pending == nil {
+		e.pending = make(map[string][]shopAction)
+	}
+	e.pending[peerID] = append(e.pending[peerID], action)
+	return nil
+}
+
+// shopErrorCode maps a rejected shop action to its error code.
+func shopErrorCode(err error) string {
+	switch {
+	case errors.Is(err, engine.ErrInsufficientFunds):
+		return "insufficient_funds"
+	case errors.Is(err, engine.ErrInsufficientStock):
+		return "insufficient_stock"
+	case errors.Is(err, engine.ErrUnknownWeapon), errors.Is(err, engine.ErrNotForSale):
+		return "invalid_item"
+	case errors.Is(err, engine.ErrShopClosed):
+		return "shop_closed"
+	}
+	return "bad_request"
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"math"
+	"sort"
+)
+
+// aiFireDelay is how long a bot waits on its turn before firing, as the
+// browser host's 650 ms timeout.
+const aiFireDelay = 0.65
+
+// AIShot is a bot's chosen aim, as AIShot in AimAI.ts.
+type AIShot struct {
+	Angle    float64
+	Power    float64
+	WeaponID string
+}
+
+// chooseTarget picks the nearest living enemy.
+func chooseTarget(shooter Player, players []Player) *Player {
+	var enemies []Player
+	for _, p := range players {
+		if p.Alive && p.Config.ID != shooter.Config.ID {
+			enemies = append(enemies, p)
+		}
+	}
+	if len(enemies) == 0 {
+		return nil
+	}
+	sort.SliceStable(enemies, func(a, b int) bool {
+		return math.Abs(enemies[a].X-shooter.X) < math.Abs(enemies[b].X-shooter.X)
+	})
+	return &enemies[0]
+}
+
+func randomIn(rng *PRNG, lo, hi float64) float64 {
+	return lo + rng.Float64()*(hi-lo)
+}
+
+func estimateAnglePower(shooter, target Player, wind, gravity, inaccuracy float64, rng *PRNG) AIShot {
+	dx := target.X - shooter.X
+	dy := shooter.Y - target.Y
+	var angleBase float64
+	if dx >= 0 {
+		angleBase = randomIn(rng, 20, 75)
+	} else {
+		angleBase = randomIn(rng, 105, 160)
+	}
+	absAngle, vxSign := angleBase, 1.0
+	if dx < 0 {
+		absAngle, vxSign = 180-angleBase, -1
+	}
+	distance := math.Max(30, math.Abs(dx))
+	idealV := math.Sqrt(distance * gravity / math.Max(0.2, math.Sin(2*angleBase*math.Pi/180)))
+	windComp := wind * 0.2 * vxSign
+	power := math.Max(120, math.Min(shooter.MaxPower, idealV*(1+dy*0.001)+windComp+randomIn(rng, -inaccuracy, inaccuracy)))
+	return AIShot{
+		Angle:    math.Max(2, math.Min(178, absAngle+randomIn(rng, -inaccuracy*0.05, inaccuracy*0.05))),
+		Power:    power,
+		WeaponID: shooter.SelectedWeaponID,
+	}
+}
+
+// scoreShot traces shot and returns how close it gets to target before
+// leaving the field or hitting ground.
+func scoreShot(shooter, target Player, shot AIShot, wind, gravity float64, t *Terrain) float64 {
+	cx, cy := ToVelocity(shot.Angle, shot.Power)
+	x, y := shooter.X, shooter.Y-3
+	best := math.Inf(1)
+	for i := 0; i < 240; i++ {
+		cx += wind / 60
+		cy += gravity / 60
+		x += cx / 60
+		y += cy / 60
+		if x < 0 || x >= float64(t.Width) || y < 0 || y >= float64(t.Height) {
+			break
+		}
+		if t.Mask[int(y)*t.Width+int(x)] == 1 {
+			break
+		}
+		best = math.Min(best, math.Hypot(target.X-x, target.Y-y))
+	}
+	return best
+}
+
+// ComputeAIShot ports computeAIShot: easy and normal bots guess with a
+// spread, hard bots trace fifty guesses and keep the closest.
+func ComputeAIShot(m MatchState, shooter Player, t *Terrain, level string, rng *PRNG) AIShot {
+	target := chooseTarget(shooter, m.Players)
+	if target == nil {
+		return AIShot{Angle: shooter.Angle, Power: shooter.Power, WeaponID: shooter.SelectedWeaponID}
+	}
+	gravity := m.Settings.Gravity
+	switch level {
+	case "easy":
+		return estimateAnglePower(shooter, *target, m.Wind, gravity, 32, rng)
+	case "normal":
+		return estimateAnglePower(shooter, *target, m.Wind, gravity, 16, rng)
+	}
+	best := estimateAnglePower(shooter, *target, m.Wind, gravity, 8, rng)
+	bestScore := math.Inf(1)
+	for i := 0; i < 50; i++ {
+		candidate := estimateAnglePower(shooter, *target, m.Wind, gravity, 7, rng)
+		if s := scoreShot(shooter, *target, candidate, m.Wind, gravity, t); s < bestScore {
+			best, bestScore = candidate, s
+		}
+	}
+	return best
+}
+
+// stepAI fires for a bot once it has waited aiFireDelay on its turn, the
+// way the browser host's AI effect does.
+func (e *Engine) stepAI() {
+	if e.Match.Phase != PhaseAim {
+		return
+	}
+	index := e.Match.PlayerIndex(e.Match.ActivePlayerID)
+	if index < 0 || e.Match.Players[index].Config.Kind != "ai" {
+		return
+	}
+	e.aiWait += FixedDT
+	if e.aiWait < aiFireDelay {
+		return
+	}
+	shooter := e.Match.Players[index]
+	shot := ComputeAIShot(e.Match, shooter, e.Terrain, shooter.Config.AILevel, e.rng)
+	weaponID := shot.WeaponID
+	if !e.Match.Settings.FreeFireMode && shooter.Inventory[weaponID] <= 0 {
+		weaponID = pickNextWeapon(shooter, 1, false)
+	}
+	shooter.Angle = shot.Angle
+	shooter.Power = clamp(shot.Power, 0, shooter.MaxPower)
+	shooter.SelectedWeaponID = weaponID
+	e.Match.Players[index] = shooter
+	e.fire(index)
+}
@@ -0,0 +1,49 @@
+package engine
+
+import "math"
+
+// FixedDT is the simulation step, matching FIXED_DT in Ballistics.ts.
+const FixedDT = 1.0 / 60.0
+
+// ToVelocity converts a launch angle in degrees and a power into a velocity.
+func ToVelocity(angleDeg, power float64) (vx, vy float64) {
+	radians := angleDeg * math.Pi / 180
+	return math.Cos(radians) * power, -math.Sin(radians) * power
+}
+
+// StepProjectile advances p by dt under gravity and wind.
+func StepProjectile(p Projectile, dt, gravity, wind float64) Projectile {
+	p.VX += wind * dt
+	p.VY += gravity * dt
+	p.X += p.VX * dt
+	p.Y += p.VY * dt
+	p.TTL -= dt
+	return p
+}
+
+// SpreadAngles fans count shots symmetrically around base.
+func SpreadAngles(base float64, count int, spread float64) []float64 {
+	if count <= 1 {
+		return []float64{base}
+	}
+	mid := float64(count-1) / 2
+	out := make([]float64, count)
+	for i := range out {
+		out[i] = base + (float64(i)-mid)*spread
+	}
+	return out
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,76 @@
+package engine
+
+import "math"
+
+// DamageInput describes one tank caught in an explosion.
+type DamageInput struct {
+	Dist            float64
+	BlastRadius     float64
+	WeaponDamage    float64
+	SecondaryDamage float64
+	Shield          float64
+	Armor           float64
+}
+
+// DamageResult is what remains of the tank after the blast.
+type DamageResult struct {
+	HPLoss     float64
+	NextShield float64
+	NextArmor  float64
+}
+
+// ComputeExplosionDamage mirrors computeExplosionDamage in Combat.ts: shields
+// soak damage first, then armor mitigates and wears down.
+func ComputeExplosionDamage(in DamageInput) DamageResult {
+	if in.Dist > in.BlastRadius || in.BlastRadius <= 0 {
+		return DamageResult{NextShield: in.Shield, NextArmor: in.Armor}
+	}
+
+	splashRadius := in.BlastRadius * 1.02
+	normalized := math.Max(0, (splashRadius-in.Dist)/splashRadius)
+	blast := normalized*in.WeaponDamage + in.SecondaryDamage*0.6
+	if in.Dist <= 4 {
+		blast += in.WeaponDamage * 0.7
+	}
+
+	remaining := math.Max(0, blast)
+	shieldAbsorb := math.Min(in.Shield, remaining)
+	remaining -= shieldAbsorb
+
+	armorMitigation := clamp(in.Armor/500, 0, 0.35)
+	return DamageResult{
+		HPLoss:     math.Max(0, remaining*(1-armorMitigation)),
+		NextShield: math.Max(0, in.Shield-shieldAbsorb),
+		NextArmor:  math.Max(0, in.Armor-remaining*1.15),
+	}
+}
+
+var (
+	funkyColors       = []string{"#ff4747", "#ff932f", "#ffe94d", "#6ee1ff", "#9e8dff", "#ff69d4"}
+	funkyAngleOffsets = []float64{-58, -34, -12, 12, 34, 58}
+)
+
+// SpawnFunkyBomblets mirrors spawnFunkeyBomblets in Combat.ts.
+func SpawnFunkyBomblets(x, y float64, ownerID string, parentVX, parentVY float64) []Projectile {
+	direction := 1.0
+	if parentVX < 0 {
+		direction = -1
+	}
+	out := make([]Projectile, len(funkyAngleOffsets))
+	for i, offset := range funkyAngleOffsets {
+		radians := (90 + offset*direction) * math.Pi / 180
+		speed := 220 + float64(i%3)*28
+		out[i] = Projectile{
+			X:          x,
+			Y:          y,
+			VX:         math.Cos(radians)*speed + parentVX*0.18,
+			VY:         -math.Sin(radians)*speed + math.Min(0, parentVY)*0.1,
+			OwnerID:    ownerID,
+			WeaponID:   "funky-bomb",
+			TTL:        4.4,
+			SplitDepth: 1,
+			Color:      funkyColors[i%len(funkyColors)],
+		}
+	}
+	return out
+}
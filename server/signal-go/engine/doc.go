@@ -0,0 +1,10 @@
+// Package engine is a headless port of the browser match simulation, used by
+// the signaling server to run "server-hosted" rooms where no player is
+// trusted with authority.
+//
+// It follows stepSimulation in App.tsx and the TS code in src/game and
+// src/engine closely, down to the terrain edits queued for animation and
+// the staged weapons (MIRVs, rollers, diggers, sandhogs, funky bombs and
+// napalm) that play out over many steps. The snapshot it produces has the
+// same shape as the browser host's game.snapshot payload.
+package engine
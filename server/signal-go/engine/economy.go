@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"math"
+)
+
+// SellFactor is the share of a pack's price refunded on sale.
+const SellFactor = 0.6
+
+var (
+	ErrUnknownWeapon     = errors.New("unknown weapon")
+	ErrNotForSale        = errors.New("item is not for sale")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInsufficientStock = errors.New("not enough items to sell")
+	ErrShopClosed        = errors.New("shop is closed")
+)
+
+// CheckBuy reports why p cannot buy a pack of weaponID, or nil if it can.
+func CheckBuy(p Player, weaponID string) error {
+	w, ok := LookupWeapon(weaponID)
+	if !ok {
+		return ErrUnknownWeapon
+	}
+	if w.PackPrice <= 0 {
+		return ErrNotForSale
+	}
+	if p.Cash < w.PackPrice {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// CheckSell reports why p cannot sell a pack of weaponID, or nil if it can.
+func CheckSell(p Player, weaponID string) error {
+	w, ok := LookupWeapon(weaponID)
+	if !ok {
+		return ErrUnknownWeapon
+	}
+	if p.Inventory[weaponID] < w.PackQty {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+// BuyWeapon mirrors buyWeapon in Economy.ts; invalid purchases return p unchanged.
+func BuyWeapon(p Player, weaponID string) Player {
+	if CheckBuy(p, weaponID) != nil {
+		return p
+	}
+	w := WeaponByID(weaponID)
+	p.Inventory = p.Inventory.with(weaponID, p.Inventory[weaponID]+w.PackQty)
+	p.Cash -= w.PackPrice
+	return p
+}
+
+// SellWeapon mirrors sellWeapon in Economy.ts.
+func SellWeapon(p Player, weaponID string) Player {
+	if CheckSell(p, weaponID) != nil {
+		return p
+	}
+	w := WeaponByID(weaponID)
+	p.Inventory = p.Inventory.with(weaponID, p.Inventory[weaponID]-w.PackQty)
+	p.Cash += SellPrice(w)
+	return p
+}
+
+// SellPrice is the refund for selling one pack of w.
+func SellPrice(w Weapon) int {
+	return int(math.Floor(float64(w.PackPrice) * SellFactor))
+}
+
+func AwardDamageCash(p Player, damage float64) Player {
+	p.Cash += int(math.Max(0, math.Floor(damage*2.5)))
+	return p
+}
+
+func AwardKillBonus(p Player) Player {
+	p.Cash += 350
+	return p
+}
+
+func AwardRoundWin(p Player) Player {
+	p.Cash += 700
+	p.Score++
+	return p
+}
@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func basePlayer() Player {
+	return Player{
+		Config:           PlayerConfig{ID: "p1", Name: "P1", Kind: "human", AILevel: "easy", Enabled: true},
+		Cash:             30000,
+		Armor:            100,
+		ShieldType:       ShieldNone,
+		Fuel:             100,
+		Inventory:        Inventory{StarterWeaponID: 999},
+		Alive:            true,
+		HP:               100,
+		MaxPower:         1000,
+		Angle:            45,
+		Power:            500,
+		SelectedWeaponID: StarterWeaponID,
+	}
+}
+
+func TestToVelocity(t *testing.T) {
+	vx, vy := ToVelocity(90, 500)
+	if math.Abs(vx) > 1e-6 || vy >= 0 {
+		t.Fatalf("ToVelocity(90) = (%v, %v), want straight up", vx, vy)
+	}
+}
+
+func TestExplosionDamage(t *testing.T) {
+	direct := ComputeExplosionDamage(DamageInput{BlastRadius: 14, WeaponDamage: 16})
+	if direct.HPLoss <= 20 {
+		t.Fatalf("direct hit hp loss = %v", direct.HPLoss)
+	}
+	shielded := ComputeExplosionDamage(DamageInput{BlastRadius: 20, WeaponDamage: 20, Shield: 120, Armor: 50})
+	if shielded.HPLoss != 0 || shielded.NextShield >= 120 {
+		t.Fatalf("shielded = %+v", shielded)
+	}
+	bomblets := SpawnFunkyBomblets(100, 80, "p1", 40, -20)
+	if len(bomblets) != 6 {
+		t.Fatalf("bomblets = %d", len(bomblets))
+	}
+	for _, b := range bomblets {
+		if b.WeaponID != "funky-bomb" || b.SplitDepth != 1 {
+			t.Fatalf("bomblet = %+v", b)
+		}
+	}
+}
+
+func TestEconomy(t *testing.T) {
+	p := basePlayer()
+	bought := BuyWeapon(p, "baby-digger")
+	if bought.Cash >= p.Cash || bought.Inventory["baby-digger"] != WeaponByID("baby-digger").PackQty {
+		t.Fatalf("buy = cash %d inv %d", bought.Cash, bought.Inventory["baby-digger"])
+	}
+	if p.Inventory["baby-digger"] != 0 {
+		t.Fatal("buy mutated the original inventory")
+	}
+	p.Inventory = p.Inventory.with("nuke", 2)
+	sold := SellWeapon(p, "nuke")
+	if sold.Cash <= p.Cash || sold.Inventory["nuke"] != 1 {
+		t.Fatalf("sell = cash %d inv %d", sold.Cash, sold.Inventory["nuke"])
+	}
+	if err := CheckSell(basePlayer(), "nuke"); err != ErrInsufficientStock {
+		t.Fatalf("CheckSell without stock = %v", err)
+	}
+	poor := basePlayer()
+	poor.Cash = 0
+	if err := CheckBuy(poor, "nuke"); err != ErrInsufficientFunds {
+		t.Fatalf("CheckBuy without cash = %v", err)
+	}
+}
+
+func TestShield(t *testing.T) {
+	p := basePlayer()
+	p.Inventory = Inventory{"auto-defense": 1, "regular-shield": 1, "heavy-shield": 1}
+	next := AutoActivateShieldAtRoundStart(p)
+	if next.Shield != 1000 || next.ShieldType != "heavy" || next.Inventory["heavy-shield"] != 0 || next.Inventory["regular-shield"] != 1 {
+		t.Fatalf("auto-defense = %+v", next)
+	}
+	next = DegradeShield(next)
+	if next.Shield != 900 || next.ShieldType != "heavy" {
+		t.Fatalf("heavy degrade = %v %s", next.Shield, next.ShieldType)
+	}
+	p.Shield, p.ShieldType = 200, "regular"
+	if next := DegradeShield(p); next.Shield != 0 || next.ShieldType != ShieldNone {
+		t.Fatalf("regular degrade = %v %s", next.Shield, next.ShieldType)
+	}
+}
+
+func TestCarveCraterRemovesTerrain(t *testing.T) {
+	heights := make([]int, 200)
+	for i := range heights {
+		heights[i] = 100
+	}
+	terrain := TerrainFromHeights(200, 200, heights)
+	revision := terrain.Revision
+	terrain.CarveCrater(100, 110, 12, true)
+	if terrain.SolidAt(100, 110) {
+		t.Fatal("crater centre still solid")
+	}
+	if terrain.Heights[100] <= 100 {
+		t.Fatalf("height after crater = %d", terrain.Heights[100])
+	}
+	if terrain.Revision == revision {
+		t.Fatal("revision not bumped")
+	}
+}
+
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	configs := []PlayerConfig{
+		{ID: "a", Name: "A", Kind: "human", Enabled: true},
+		{ID: "b", Name: "B", Kind: "human", ColorIndex: 1, Enabled: true},
+	}
+	return New(DefaultSettings(), configs, 640, 360, 1)
+}
+
+func TestEngineShopThenBattle(t *testing.T) {
+	e := newTestEngine(t)
+	if e.View != ViewShop {
+		t.Fatalf("view = %s", e.View)
+	}
+	if err := e.Buy("a", "missile"); err != nil {
+		t.Fatalf("buy: %v", err)
+	}
+	if err := e.Sell("a", "nuke"); err == nil {
+		t.Fatal("sold a weapon that was never bought")
+	}
+	e.SetShopDone("a", true)
+	if err := e.Buy("a", "missile"); err == nil {
+		t.Fatal("bought after leaving the shop")
+	}
+	e.SetShopDone("b", true)
+	if e.View != ViewBattle || e.Match.Phase != PhaseAim {
+		t.Fatalf("view = %s phase = %s", e.View, e.Match.Phase)
+	}
+}
+
+func TestEngineResolvesShot(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetShopDone("a", true)
+	e.SetShopDone("b", true)
+	shooter := e.Match.ActivePlayerID
+	other := "b"
+	if shooter == "b" {
+		other = "a"
+	}
+
+	e.ApplyInput(other, Input{FirePressed: true}, 16)
+	if e.Match.Phase != PhaseAim {
+		t.Fatal("inactive player was able to fire")
+	}
+	e.ApplyInput(shooter, Input{FirePressed: true}, 16)
+	if e.Match.Phase != PhaseProjectile || len(e.Runtime.Projectiles) != 1 {
+		t.Fatalf("phase = %s projectiles = %d", e.Match.Phase, len(e.Runtime.Projectiles))
+	}
+	for i := 0; i < 60*20 && e.Match.ActivePlayerID == shooter; i++ {
+		e.Step()
+	}
+	if e.Match.ActivePlayerID == shooter {
+		t.Fatal("turn never passed after the shot")
+	}
+	if len(e.Runtime.Projectiles) != 0 {
+		t.Fatalf("projectiles left = %d", len(e.Runtime.Projectiles))
+	}
+}
+
+func TestEngineResolvesStagedWeapons(t *testing.T) {
+	// Diggers and sandhogs carve as they go, and napalm only scorches.
+	for _, tc := range []struct {
+		weaponID string
+		queues   bool
+	}{
+		{"mirv", true}, {"funky-bomb", true}, {"roller", true}, {"ton-of-dirt", true},
+		{"riot-blast", true}, {"leapfrog", true}, {"digger", false}, {"sandhog", false}, {"napalm", false},
+	} {
+		weaponID := tc.weaponID
+		t.Run(weaponID, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.SetShopDone("a", true)
+			e.SetShopDone("b", true)
+			shooter := e.Match.ActivePlayerID
+			for i := range e.Match.Players {
+				if p := &e.Match.Players[i]; p.Config.ID == shooter {
+					p.Inventory = p.Inventory.with(weaponID, 1)
+					p.SelectedWeaponID = weaponID
+				}
+			}
+			e.ApplyInput(shooter, Input{FirePressed: true}, 16)
+			queued := false
+			for i := 0; i < 60*30 && e.Match.ActivePlayerID == shooter && e.Match.Phase != PhaseRoundEnd; i++ {
+				e.Step()
+				queued = queued || len(e.Runtime.TerrainEdits) > 0
+			}
+			if e.Match.ActivePlayerID == shooter && e.Match.Phase != PhaseRoundEnd {
+				t.Fatalf("turn never passed, phase = %s", e.Match.Phase)
+			}
+			if queued != tc.queues {
+				t.Fatalf("terrain edits queued = %v", queued)
+			}
+			if len(e.Runtime.Projectiles) != 0 || len(e.Runtime.TerrainEdits) != 0 || e.Runtime.FunkySequence != nil || e.Runtime.MirvSequence != nil {
+				t.Fatalf("left over: %+v", e.Runtime)
+			}
+		})
+	}
+}
+
+func TestSnapshotShape(t *testing.T) {
+	e := newTestEngine(t)
+	data, err := json.Marshal(e.Snapshot("room-1", 7, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"roomId", "tick", "view", "shopDoneByPlayerId", "match", "runtime", "terrain"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("snapshot missing %q", key)
+		}
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	if err := DefaultSettings().Validate(); err != nil {
+		t.Fatalf("defaults invalid: %v", err)
+	}
+	tooShort := 2.0
+	for _, s := range []Settings{
+		func() Settings { s := DefaultSettings(); s.WindMode = "gale"; return s }(),
+		func() Settings { s := DefaultSettings(); s.TerrainPreset = "moon"; return s }(),
+		func() Settings { s := DefaultSettings(); s.TurnTimeLimitSec = &tooShort; return s }(),
+	} {
+		if s.Validate() == nil {
+			t.Fatalf("accepted %+v", s)
+		}
+	}
+}
+
+func TestBotsSkipShopAndTakeTheirTurns(t *testing.T) {
+	configs := []PlayerConfig{
+		{ID: "a", Name: "A", Kind: "human", Enabled: true},
+		{ID: "bot", Name: "Bot", Kind: "ai", AILevel: "hard", ColorIndex: 1, Enabled: true},
+	}
+	e := New(DefaultSettings(), configs, 640, 360, 3)
+	if !e.ShopDone["bot"] {
+		t.Fatal("bot holds up the shop")
+	}
+	e.SetShopDone("a", true)
+	if e.View != ViewBattle {
+		t.Fatalf("view = %s", e.View)
+	}
+	if e.Match.ActivePlayerID != "bot" {
+		e.SkipTurn()
+	}
+	fired := false
+	for i := 0; i < 60*5 && !fired; i++ {
+		e.Step()
+		fired = e.Match.Phase == PhaseProjectile || e.Match.ActivePlayerID != "bot"
+	}
+	if !fired {
+		t.Fatal("bot never fired")
+	}
+}
+
+func TestComputeAIShotAimsAtNearestEnemy(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetShopDone("a", true)
+	e.SetShopDone("b", true)
+	shooter, target := e.Match.Players[0], e.Match.Players[1]
+	rng := NewPRNG(7)
+	for _, level := range []string{"easy", "normal", "hard"} {
+		shot := ComputeAIShot(e.Match, shooter, e.Terrain, level, rng)
+		towardTarget := (target.X > shooter.X) == (shot.Angle < 90)
+		if !towardTarget || shot.Power < 120 || shot.Power > shooter.MaxPower {
+			t.Fatalf("%s shot = %+v from x=%v at x=%v", level, shot, shooter.X, target.X)
+		}
+	}
+}
+
+func TestTerrainFromMapFitsTheField(t *testing.T) {
+	src := TerrainFromHeights(300, 120, make([]int, 300))
+	for x := range src.Heights {
+		src.Heights[x] = 40 + x%30
+	}
+	src.ColorIndices = make([]byte, 300*120)
+	for i := range src.ColorIndices {
+		src.ColorIndices[i] = byte(1 + i%7)
+	}
+	src.ColorPalette = [][3]int{{0, 0, 0}, {1, 2, 3}}
+
+	build := func(seed uint64) *Terrain { return TerrainFromMap(src, 640, 360, NewPRNG(seed)) }
+	got := build(7)
+	if got.Width != 640 || got.Height != 360 || len(got.Mask) != 640*360 || len(got.ColorIndices) != 640*360 {
+		t.Fatalf("terrain is %dx%d", got.Width, got.Height)
+	}
+	if !reflect.DeepEqual(got.ColorPalette, src.ColorPalette) {
+		t.Fatal("palette not carried over")
+	}
+	sum := 0
+	for x, top := range got.Heights {
+		sum += top
+		if idx := got.ColorIndices[top*640+x]; idx == 0 && top < floorTop(360) {
+			t.Fatalf("column %d has no color at its top", x)
+		}
+	}
+	// The average surface lands in the 40-65% band, give or take smoothing.
+	if avg := sum / 640; avg < 360*35/100 || avg > 360*70/100 {
+		t.Fatalf("average top = %d", avg)
+	}
+	if !reflect.DeepEqual(got.Mask, build(7).Mask) {
+		t.Fatal("same seed cut the map differently")
+	}
+}
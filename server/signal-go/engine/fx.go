@@ -0,0 +1,83 @@
+package engine
+
+import "math"
+
+// pushFx adds fx to the runtime's explosions with the next effect id.
+func (e *Engine) pushFx(fx Explosion) {
+	fx.ID = e.fxID()
+	if fx.MaxLife == 0 {
+		fx.MaxLife = fx.Life
+	}
+	e.Runtime.Explosions = append(e.Runtime.Explosions, fx)
+}
+
+// fxID hands out effect ids, which also name cluster sequences.
+func (e *Engine) fxID() int {
+	id := e.nextFxID
+	e.nextFxID++
+	return id
+}
+
+// weaponImpactFx mirrors emitWeaponImpactFx in App.tsx.
+func (e *Engine) weaponImpactFx(weaponID string, x, y, radius float64, color string) {
+	switch weaponID {
+	case "sand-bomb":
+		e.pushFx(Explosion{X: x, Y: y, Radius: math.Max(40, radius), Life: 0.95, Kind: "sand", Color: "#d8c386"})
+	case "napalm", "hot-napalm":
+		hot := weaponID == "hot-napalm"
+		blobs, spread, blobRadius, life := 7, 1.8, 14.0, 1.05
+		if hot {
+			blobs, spread, blobRadius, life = 10, 2.4, 18, 1.35
+		}
+		for i := 0; i < blobs; i++ {
+			a := math.Pi * 2 * float64(i) / float64(blobs)
+			dist := 6 + float64(i)*spread
+			e.pushFx(Explosion{X: x + math.Cos(a)*dist, Y: y + math.Sin(a)*dist*0.45, Radius: blobRadius, Life: life, Kind: "fire"})
+		}
+	case "mirv":
+		e.pushFx(Explosion{X: x, Y: y, Radius: math.Max(28, radius*0.82), Life: 0.46, Kind: "mirv", Seed: e.rng.Intn(1000000)})
+	case "death-head":
+		e.pushFx(Explosion{X: x, Y: y, Radius: math.Max(34, radius*0.9), Life: 0.52, Kind: "mirv", Seed: e.rng.Intn(1000000)})
+	case "riot-bomb", "heavy-riot-bomb":
+		life := 0.56
+		if weaponID == "heavy-riot-bomb" {
+			life = 0.7
+		}
+		e.pushFx(Explosion{X: x, Y: y, Radius: math.Max(18, radius), Life: life, Kind: "riot-rings", Color: "#bb58ff"})
+	default:
+		fx := Explosion{X: x, Y: y, Radius: radius, Life: 0.45, Kind: "burst", Color: color}
+		switch weaponID {
+		case StarterWeaponID, "missile":
+			fx.Kind = "simple"
+		case "baby-nuke":
+			fx.Kind, fx.Life = "nuke", 1.7
+		case "nuke":
+			fx.Kind, fx.Life = "nuke", 2.2
+		}
+		if fx.Kind != "burst" {
+			fx.Seed = e.rng.Intn(1000000)
+		}
+		e.pushFx(fx)
+	}
+}
+
+// tankDeathFx picks one of the seven ways a tank goes up, as
+// enqueueTankDeathFx does.
+func (e *Engine) tankDeathFx(p Player) {
+	switch e.rng.Intn(7) {
+	case 0:
+		e.pushFx(Explosion{X: p.X, Y: p.Y + 8, Radius: 24, Life: 1.05, Kind: "fire"})
+	case 1:
+		e.pushFx(Explosion{X: p.X, Y: p.Y - 2, Radius: 12, Life: 0.34, Color: "#ff9b2f", Kind: "simple", Seed: e.rng.Intn(1000000)})
+	case 2:
+		e.pushFx(Explosion{X: p.X, Y: p.Y - 2, Radius: 60, Life: 0.72, Color: "#ff8c22", Kind: "simple", Seed: e.rng.Intn(1000000)})
+	case 3:
+		e.pushFx(Explosion{X: p.X, Y: p.Y - 2, Radius: 100, Life: 1.04, Color: "#ff7f1a", Kind: "simple", Seed: e.rng.Intn(1000000)})
+	case 4:
+		e.pushFx(Explosion{X: p.X, Y: p.Y + 4, Radius: 44, Life: 0.86, Kind: "sand"})
+	case 5:
+		e.pushFx(Explosion{X: p.X, Y: p.Y + 8, Radius: 12, BeamHeight: 200, Life: 0.95, Kind: "laser"})
+	default:
+		e.pushFx(Explosion{X: p.X, Y: p.Y - 2, Radius: 34, Life: 0.8, Kind: "funky"})
+	}
+}
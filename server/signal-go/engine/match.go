@@ -0,0 +1,347 @@
+package engine
+
+import (
+	"math"
+)
+
+const maxWind = 10
+
+func randomWind(s Settings, rng *PRNG) float64 {
+	if s.WindMode == "off" {
+		return 0
+	}
+	return (rng.Float64()*2 - 1) * maxWind
+}
+
+func newPlayer(config PlayerConfig, s Settings) Player {
+	return Player{
+		Config:           config,
+		Cash:             s.CashStart,
+		Armor:            100,
+		ShieldType:       ShieldNone,
+		Inventory:        Inventory{StarterWeaponID: 999},
+		Alive:            true,
+		HP:               100,
+		MaxPower:         1000,
+		Angle:            30,
+		Power:            200,
+		SelectedWeaponID: StarterWeaponID,
+	}
+}
+
+// InitMatch mirrors initMatch in MatchController.ts.
+func InitMatch(s Settings, configs []PlayerConfig, width, height int, rng *PRNG) (MatchState, *Terrain) {
+	terrain := GenerateTerrain(width, height, s.TerrainPreset, rng)
+	var players []Player
+	for _, c := range configs {
+		if c.Enabled {
+			players = append(players, newPlayer(c, s))
+		}
+	}
+	spacing := float64(width) / float64(len(players)+1)
+	for i := range players {
+		x := int(math.Floor(spacing * float64(i+1)))
+		if terrain.Heights[x] >= height {
+			for offset := 1; offset < width; offset++ {
+				if x+offset < width && terrain.Heights[x+offset] < height {
+					x += offset
+					break
+				}
+				if x-offset >= 0 && terrain.Heights[x-offset] < height {
+					x -= offset
+					break
+				}
+			}
+		}
+		players[i].X = float64(x)
+		players[i].Y = float64(terrain.Heights[x] - 8)
+	}
+	m := MatchState{
+		Settings:   s,
+		Players:    players,
+		RoundIndex: 1,
+		Wind:       randomWind(s, rng),
+		Phase:      PhaseAim,
+		Width:      width,
+		Height:     height,
+	}
+	if len(players) > 0 {
+		m.ActivePlayerID = players[0].Config.ID
+	}
+	return m, terrain
+}
+
+// PlayerIndex returns the index of id in m.Players, or -1.
+func (m *MatchState) PlayerIndex(id string) int {
+	for i := range m.Players {
+		if m.Players[i].Config.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ActivePlayer returns the player whose turn it is, or nil.
+func (m *MatchState) ActivePlayer() *Player {
+	if i := m.PlayerIndex(m.ActivePlayerID); i >= 0 {
+		return &m.Players[i]
+	}
+	return nil
+}
+
+// NextActivePlayer mirrors nextActivePlayer: it hands the turn to the next
+// living tank or ends the round.
+func NextActivePlayer(m MatchState, rng *PRNG) MatchState {
+	var alive []Player
+	for _, p := range m.Players {
+		if p.Alive {
+			alive = append(alive, p)
+		}
+	}
+	if len(alive) <= 1 {
+		if len(alive) == 1 {
+			m.Phase = PhaseRoundEnd
+		} else {
+			m.Phase = PhaseMatchEnd
+		}
+		return m
+	}
+	index := -1
+	for i, p := range alive {
+		if p.Config.ID == m.ActivePlayerID {
+			index = i
+			break
+		}
+	}
+	m.ActivePlayerID = alive[(index+1)%len(alive)].Config.ID
+	m.Phase = PhaseAim
+	if m.Settings.WindMode == "changing" {
+		m.Wind = randomWind(m.Settings, rng)
+	}
+	return m
+}
+
+// ApplyRoundEnd mirrors applyRoundEnd: it scores the survivor, restores every
+// tank and either starts the next round or ends the match.
+func ApplyRoundEnd(m MatchState, rng *PRNG) MatchState {
+	winnerID := ""
+	for _, p := range m.Players {
+		if p.Alive {
+			winnerID = p.Config.ID
+			break
+		}
+	}
+	players := make([]Player, len(m.Players))
+	matchWon := false
+	for i, p := range m.Players {
+		p.Alive = true
+		p.HP = 100
+		p.MaxPower = 1000
+		p.Power = 200
+		p.Shield = 0
+		p.ShieldType = ShieldNone
+		p.FallDistance = 0
+		if p.Config.ID == winnerID {
+			p.Score++
+		}
+		if p.Score >= m.Settings.RoundsToWin {
+			matchWon = true
+		}
+		players[i] = p
+	}
+	m.Players = players
+	if matchWon {
+		m.Phase = PhaseMatchEnd
+		return m
+	}
+	m.RoundIndex++
+	m.Phase = PhaseAim
+	m.ActivePlayerID = players[0].Config.ID
+	m.Wind = randomWind(m.Settings, rng)
+	return m
+}
+
+func maxPowerForHP(hp float64) float64 {
+	return clamp(math.Round(hp*10), 0, 1000)
+}
+
+func pickNextWeapon(p Player, delta int, freeFire bool) string {
+	var available []Weapon
+	for _, w := range Weapons {
+		if w.ProjectileCount > 0 && (freeFire || p.Inventory[w.ID] > 0) {
+			available = append(available, w)
+		}
+	}
+	if len(available) == 0 {
+		return p.SelectedWeaponID
+	}
+	index := 0
+	for i, w := range available {
+		if w.ID == p.SelectedWeaponID {
+			index = i
+			break
+		}
+	}
+	n := len(available)
+	return available[((index+delta)%n+n)%n].ID
+}
+
+func nearestSurfaceColumn(t *Terrain, xRaw float64, maxRadius int) (x, y int, ok bool) {
+	x0 := clampInt(int(math.Floor(xRaw)), 0, t.Width-1)
+	for r := 0; r <= maxRadius; r++ {
+		if left := x0 - r; left >= 0 && t.Heights[left] < t.Height {
+			return left, clampInt(t.Heights[left], 0, t.Height-1), true
+		}
+		if r == 0 {
+			continue
+		}
+		if right := x0 + r; right < t.Width && t.Heights[right] < t.Height {
+			return right, clampInt(t.Heights[right], 0, t.Height-1), true
+		}
+	}
+	return 0, 0, false
+}
+
+func tankSupportCountAt(t *Terrain, x int, y float64) int {
+	footY := int(math.Floor(y + 5))
+	count := 0
+	for sx := max(0, x-6); sx <= min(t.Width-1, x+6); sx++ {
+		if t.solid(sx, footY) || t.solid(sx, footY+1) {
+			count++
+		}
+	}
+	return count
+}
+
+func tankBodyOverlapCountAt(t *Terrain, x int, y float64) int {
+	count := 0
+	for sx := max(0, x-6); sx <= min(t.Width-1, x+6); sx++ {
+		for sy := max(0, int(math.Floor(y-4))); sy <= min(t.Height-1, int(math.Floor(y+1))); sy++ {
+			if t.solid(sx, sy) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// solveTankSeatedY finds the resting y closest to preferredY (or the surface
+// when preferredY is NaN) where a tank at x has footing and is not buried.
+func solveTankSeatedY(t *Terrain, xRaw, preferredY float64) (float64, bool) {
+	x := clampInt(int(math.Floor(xRaw)), 0, t.Width-1)
+	surfaceTop := t.Heights[x]
+	if _, y, ok := nearestSurfaceColumn(t, float64(x), 64); ok {
+		surfaceTop = y
+	}
+	surfaceY := clamp(float64(surfaceTop-4), 2, float64(t.Height-10))
+	anchorY := preferredY
+	if math.IsNaN(anchorY) {
+		anchorY = surfaceY
+	}
+	minY := max(2, int(math.Floor(math.Min(surfaceY, anchorY)-10)))
+	maxY := min(t.Height-10, int(math.Ceil(math.Max(surfaceY, anchorY)+10)))
+	best, bestDist, found := 0.0, math.Inf(1), false
+	for y := minY; y <= maxY; y++ {
+		fy := float64(y)
+		if tankBodyOverlapCountAt(t, x, fy) > 2 || tankSupportCountAt(t, x, fy) < 2 {
+			continue
+		}
+		if dist := math.Abs(fy - anchorY); dist < bestDist {
+			best, bestDist, found = fy, dist, true
+			if dist <= 0.01 {
+				break
+			}
+		}
+	}
+	return best, found
+}
+
+func resolveTankGroundY(t *Terrain, xRaw, currentY float64) float64 {
+	x := clampInt(int(math.Floor(xRaw)), 0, t.Width-1)
+	if y, ok := solveTankSeatedY(t, float64(x), currentY); ok {
+		return y
+	}
+	surfaceTop := t.Heights[x]
+	if _, y, ok := nearestSurfaceColumn(t, float64(x), 64); ok {
+		surfaceTop = y
+	}
+	return clamp(float64(surfaceTop-4), 2, float64(t.Height-10))
+}
+
+func isStableSpawnX(t *Terrain, x int) bool {
+	low, high := t.Height, 0
+	for sx := max(0, x-7); sx <= min(t.Width-1, x+7); sx++ {
+		low = min(low, t.Heights[sx])
+		high = max(high, t.Heights[sx])
+	}
+	if high-low > 5 {
+		return false
+	}
+	return abs(t.Heights[max(0, x-5)]-t.Heights[min(t.Width-1, x+5)]) <= 3
+}
+
+// PlacePlayers spreads tanks over stable spots on t, like
+// placePlayersOnTerrain in App.tsx.
+func PlacePlayers(m MatchState, t *Terrain, rng *PRNG) MatchState {
+	n := len(m.Players)
+	if n == 0 {
+		return m
+	}
+	margin := max(22, t.Width*8/100)
+	minSep := max(20, int(math.Floor(float64(t.Width-2*margin)/math.Max(2, float64(n)*1.2))))
+	relaxedSep := max(10, minSep*6/10)
+	minX := margin
+	maxX := max(minX+1, t.Width-margin-1)
+	seatable := func(x int) bool {
+		_, ok := solveTankSeatedY(t, float64(x), math.NaN())
+		return ok
+	}
+	spacedBy := func(picks []int, x, sep int) bool {
+		for _, p := range picks {
+			if abs(p-x) < sep {
+				return false
+			}
+		}
+		return true
+	}
+	picks := make([]int, 0, n)
+	for range m.Players {
+		best := -1
+		for attempt := 0; attempt < 180; attempt++ {
+			candidate := clampInt(minX+rng.Intn(maxX-minX+1), minX, maxX)
+			if !isStableSpawnX(t, candidate) || !seatable(candidate) {
+				continue
+			}
+			if spacedBy(picks, candidate, minSep) {
+				best = candidate
+				break
+			}
+			if best < 0 && spacedBy(picks, candidate, relaxedSep) {
+				best = candidate
+			}
+		}
+		for candidate := minX; best < 0 && candidate <= maxX; candidate++ {
+			if seatable(candidate) && spacedBy(picks, candidate, relaxedSep) {
+				best = candidate
+			}
+		}
+		for candidate := minX; best < 0 && candidate <= maxX; candidate++ {
+			if seatable(candidate) {
+				best = candidate
+			}
+		}
+		if best < 0 {
+			best = minX + rng.Intn(maxX-minX+1)
+		}
+		picks = append(picks, best)
+	}
+	players := make([]Player, n)
+	for i, p := range m.Players {
+		p.X = float64(picks[i])
+		p.Y = resolveTankGroundY(t, p.X, math.NaN())
+		p.FallDistance = 0
+		players[i] = p
+	}
+	m.Players = players
+	return m
+}
@@ -0,0 +1,53 @@
+package engine
+
+import "math"
+
+// PRNG is the match random number generator, SplitMix64. It is the reference
+// for createPrng in src/game/Prng.ts: both produce the same sequence from the
+// same seed, so a match seed reproduces its terrain and wind anywhere.
+type PRNG struct {
+	state uint64
+}
+
+const (
+	splitMixGamma = 0x9E3779B97F4A7C15
+	splitMixMul1  = 0xBF58476D1CE4E5B9
+	splitMixMul2  = 0x94D049BB133111EB
+)
+
+// NewPRNG returns a generator seeded with seed.
+func NewPRNG(seed uint64) *PRNG {
+	return &PRNG{state: seed}
+}
+
+// RoundSeed derives the seed of round round (from 1) from a match seed. It is
+// the round-th output of NewPRNG(matchSeed), so any round can be rebuilt
+// without replaying the ones before it.
+func RoundSeed(matchSeed uint64, round int) uint64 {
+	return splitMix(matchSeed + uint64(round)*splitMixGamma)
+}
+
+// Uint64 returns the next 64 random bits.
+func (p *PRNG) Uint64() uint64 {
+	p.state += splitMixGamma
+	return splitMix(p.state)
+}
+
+// Float64 returns a number in [0, 1) built from the top 53 bits of Uint64.
+func (p *PRNG) Float64() float64 {
+	return float64(p.Uint64()>>11) / (1 << 53)
+}
+
+// Intn returns a number in [0, n). It panics if n <= 0.
+func (p *PRNG) Intn(n int) int {
+	if n <= 0 {
+		panic("engine: invalid argument to Intn")
+	}
+	return int(math.Floor(p.Float64() * float64(n)))
+}
+
+func splitMix(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * splitMixMul1
+	z = (z ^ (z >> 27)) * splitMixMul2
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+// These vectors are shared with src/game/Prng.test.ts. The seed 0 row is the
+// published SplitMix64 reference output.
+var prngVectors = []struct {
+	seed uint64
+	want []uint64
+}{
+	{0, []uint64{0xe220a8397b1dcdaf, 0x6e789e6aa1b965f4, 0x06c45d188009454f, 0xf88bb8a8724c81ec}},
+	{1, []uint64{0x910a2dec89025cc1, 0xbeeb8da1658eec67, 0xf893a2eefb32555e, 0x71c18690ee42c90b}},
+	{0x0123456789abcdef, []uint64{0x157a3807a48faa9d, 0xd573529b34a1d093, 0x2f90b72e996dccbe, 0xa2d419334c4667ec}},
+}
+
+func TestPRNGVectors(t *testing.T) {
+	for _, v := range prngVectors {
+		p := NewPRNG(v.seed)
+		for i, want := range v.want {
+			if got := p.Uint64(); got != want {
+				t.Fatalf("seed %#x output %d = %#016x, want %#016x", v.seed, i, got, want)
+			}
+		}
+		for round := 1; round <= len(v.want); round++ {
+			if got := RoundSeed(v.seed, round); got != v.want[round-1] {
+				t.Fatalf("seed %#x round %d seed = %#016x, want %#016x", v.seed, round, got, v.want[round-1])
+			}
+		}
+	}
+
+	p := NewPRNG(42)
+	for i, want := range []float64{0.7415648787718233, 0.1599103928769201, 0.27860113025513866} {
+		if got := p.Float64(); got != want {
+			t.Fatalf("Float64 %d = %v, want %v", i, got, want)
+		}
+	}
+	p = NewPRNG(42)
+	for i, want := range []int{7, 1, 2, 3, 0, 8} {
+		if got := p.Intn(10); got != want {
+			t.Fatalf("Intn %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+// roundVectors are the battlefields of the first rounds of match seed
+// 0x0123456789abcdef, shared with src/game/Prng.test.ts: three tanks on a
+// 320x180 field, random terrain and constant wind.
+var roundVectors = []struct {
+	wind      float64
+	heightSum int
+	spawns    [][2]float64
+}{
+	{-4.253770923687499, 34756, [][2]float64{{80, 127}, {160, 88}, {240, 57}}},
+	{6.443608111782644, 30189, [][2]float64{{80, 121}, {160, 46}, {240, 50}}},
+	{4.430987895049903, 35331, [][2]float64{{80, 128}, {160, 84}, {240, 109}}},
+}
+
+func TestRoundSeedBuildsSharedBattlefields(t *testing.T) {
+	s := DefaultSettings()
+	s.WindMode = "constant"
+	configs := []PlayerConfig{{ID: "a", Enabled: true}, {ID: "b", Enabled: true}, {ID: "c", Enabled: true}}
+	for i, want := range roundVectors {
+		round := i + 1
+		m, terrain := InitMatch(s, configs, 320, 180, NewPRNG(RoundSeed(0x0123456789abcdef, round)))
+		sum := 0
+		for _, h := range terrain.Heights {
+			sum += h
+		}
+		spawns := make([][2]float64, len(m.Players))
+		for j, p := range m.Players {
+			spawns[j] = [2]float64{p.X, p.Y}
+		}
+		if m.Wind != want.wind || sum != want.heightSum || !reflect.DeepEqual(spawns, want.spawns) {
+			t.Fatalf("round %d: wind %v, height sum %d, spawns %v", round, m.Wind, sum, spawns)
+		}
+	}
+}
+
+func TestSameSeedSameMatch(t *testing.T) {
+	configs := []PlayerConfig{
+		{ID: "a", Name: "A", Kind: "human", Enabled: true},
+		{ID: "b", Name: "B", Kind: "human", Enabled: true},
+	}
+	run := func(seed uint64) *Engine {
+		e := New(DefaultSettings(), configs, 640, 360, seed)
+		e.SetShopDone("a", true)
+		e.SetShopDone("b", true)
+		return e
+	}
+	first, second := run(99), run(99)
+	if !reflect.DeepEqual(first.Terrain.Mask, second.Terrain.Mask) || first.Match.Wind != second.Match.Wind {
+		t.Fatal("same seed built different battlefields")
+	}
+	if first.Match.Players[0].X != second.Match.Players[0].X {
+		t.Fatal("same seed placed tanks differently")
+	}
+}
@@ -0,0 +1,934 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+)
+
+// projectileTick collects what one tick of projectile updates leaves behind.
+type projectileTick struct {
+	survivors []Projectile
+	spawned   []Projectile
+	// settle is set when the terrain was cut this tick.
+	settle bool
+}
+
+// stepProjectiles advances every projectile one tick, as the projectile loop
+// of stepSimulation does. Staged projectiles (napalm burns, delayed blasts,
+// diggers, sandhogs and rollers) run their own step; everything else flies.
+func (e *Engine) stepProjectiles() *projectileTick {
+	tick := &projectileTick{}
+	for _, p := range e.Runtime.Projectiles {
+		switch p.ProjectileType {
+		case "delayed-blast":
+			e.stepDelayedBlast(tick, p)
+		case "napalm-burn":
+			e.stepNapalmBurn(tick, p)
+		case "digger":
+			e.stepDigger(tick, p)
+		case "sandhog":
+			e.stepSandhog(tick, p)
+		case "roller":
+			e.stepRoller(tick, p)
+		default:
+			e.stepFlight(tick, p)
+		}
+	}
+	e.Runtime.Projectiles = append(tick.survivors, tick.spawned...)
+	if e.Runtime.Projectiles == nil {
+		e.Runtime.Projectiles = []Projectile{}
+	}
+	return tick
+}
+
+func (e *Engine) stepDelayedBlast(tick *projectileTick, p Projectile) {
+	p.TTL -= FixedDT
+	if p.TTL > 0 {
+		tick.survivors = append(tick.survivors, p)
+		return
+	}
+	radius := orDefault(p.EffectRadius, 35)
+	e.weaponImpactFx(p.WeaponID, p.X, p.Y, radius, p.Color)
+	e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: p.X, Y: p.Y, Radius: radius, Duration: 0.35})
+	e.applyDamageAt(p.X, p.Y, radius, orDefault(p.EffectDamage, 40)*10, p.SplitDepth)
+}
+
+// stepNapalmBurn burns the ground under a napalm drop every 0.18s until it
+// goes out.
+func (e *Engine) stepNapalmBurn(tick *projectileTick, p Projectile) {
+	radius := orDefault(p.EffectRadius, 14)
+	hot := p.WeaponID == "hot-napalm"
+	p.TTL -= FixedDT
+	p.Timer += FixedDT
+	for p.Timer >= 0.18 {
+		e.applyDamageAt(p.X, p.Y, radius, orDefault(p.EffectDamage, 7)*10, p.SplitDepth)
+		if hot {
+			e.Terrain.Scorch(p.X, p.Y+1, 8, 1.25, e.rng)
+		} else {
+			e.Terrain.Scorch(p.X, p.Y+1, 6, 1, e.rng)
+		}
+		p.Timer -= 0.18
+	}
+	if e.rng.Float64() > 0.3 {
+		e.pushFx(Explosion{
+			X:      p.X + (e.rng.Float64()*2-1)*4,
+			Y:      p.Y - e.rng.Float64()*6,
+			Radius: math.Max(7, radius*0.55),
+			Life:   0.16,
+			Kind:   "fire",
+		})
+	}
+	if p.TTL > 0 {
+		tick.survivors = append(tick.survivors, p)
+	}
+}
+
+// diggerTier sizes a digger's tunnel.
+type diggerTier struct {
+	core, side          float64
+	lateral, lateralAlt int
+	vertical, loopSteps int
+	jitterX, jitterY    float64
+	maxDown             float64
+	minDown             int
+	trailLife           float64
+}
+
+func diggerTierFor(weaponID string) diggerTier {
+	switch weaponID {
+	case "heavy-digger":
+		return diggerTier{core: 3.1, side: 2.2, lateral: 7, lateralAlt: 5, vertical: 1, loopSteps: 9, jitterX: 0.2, jitterY: 0.1, maxDown: 0.95, minDown: 1, trailLife: 0.16}
+	case "baby-digger":
+		return diggerTier{core: 1.5, side: 1.1, lateral: 3, lateralAlt: 2, vertical: 1, loopSteps: 4, jitterX: 0.42, jitterY: 0.24, maxDown: 1.08, trailLife: 0.1}
+	}
+	return diggerTier{core: 2.35, side: 1.65, lateral: 6, lateralAlt: 4, vertical: 2, loopSteps: 7, jitterX: 0.3, jitterY: 0.18, maxDown: 1.08, trailLife: 0.14}
+}
+
+// stepDigger wanders a digger downwards and sideways through the ground,
+// carving as it goes.
+func (e *Engine) stepDigger(tick *projectileTick, p Projectile) {
+	t := e.Terrain
+	tier := diggerTierFor(p.WeaponID)
+	x, y := p.X, p.Y
+	dirX, dirY := p.VX, p.VY
+	if math.Abs(dirX)+math.Abs(dirY) < 0.001 {
+		a := math.Mod(float64(p.Seed)*0.73, math.Pi*2)
+		dirX, dirY = math.Cos(a)*1.2, math.Sin(a)*0.25
+	}
+	sideSign := 1.0
+	if dirX < 0 {
+		sideSign = -1
+	}
+	for i := 0; i < tier.loopSteps; i++ {
+		// Head somewhere between level and straight down, on the digger's side.
+		targetA := e.rng.Float64() * tier.maxDown
+		if sideSign < 0 {
+			targetA = math.Pi - e.rng.Float64()*tier.maxDown
+		}
+		blend := 0.5
+		if e.rng.Float64() < 0.55 {
+			blend = 0.7
+		}
+		dirX = dirX*blend + math.Cos(targetA)*(1.25-blend) + (e.rng.Float64()*2-1)*tier.jitterX
+		dirY = dirY*blend + math.Sin(targetA)*(1.25-blend) + (e.rng.Float64()*2-1)*tier.jitterY
+		if dirY < 0 {
+			dirY *= -0.35
+		}
+		if y > float64(t.Height)*0.7 {
+			dirY *= 0.35
+			dirY -= 0.12 + e.rng.Float64()*0.18
+		}
+		mag := math.Max(0.001, math.Hypot(dirX, dirY))
+		speed := tier.lateralAlt
+		if e.rng.Float64() < 0.6 {
+			speed = tier.lateral
+		}
+		stepX := int(jsRound(dirX / mag * float64(speed)))
+		stepY := int(jsRound(dirY / mag * float64(speed)))
+		stepY = max(stepY, tier.minDown)
+		if e.rng.Float64() < 0.16 {
+			stepY += tier.vertical
+		}
+		if stepX == 0 && stepY == 0 {
+			stepX = int(sideSign)
+		}
+		x = clamp(x+float64(stepX), 1, float64(t.Width-2))
+		y = clamp(y+float64(stepY), 1, float64(t.Height-2))
+		t.CarveCrater(x, y, tier.core, false)
+		tick.settle = true
+		e.Runtime.DeferredSettlePending = true
+		if i%2 == 0 {
+			t.CarveCrater(clamp(x+sign(float64(stepX)), 1, float64(t.Width-2)), y, tier.side, false)
+		}
+		e.Runtime.Trails = append(e.Runtime.Trails, Trail{
+			X1:      x,
+			Y1:      y,
+			X2:      x + (e.rng.Float64()*2 - 1),
+			Y2:      y + (e.rng.Float64()*2 - 1),
+			OwnerID: p.OwnerID,
+			Life:    tier.trailLife,
+			Color:   "#d8c38d",
+		})
+	}
+	p.TTL -= FixedDT
+	if p.TTL > 0 && y < float64(t.Height)*0.86 {
+		p.X, p.Y, p.VX, p.VY = x, y, dirX, dirY
+		tick.survivors = append(tick.survivors, p)
+	}
+}
+
+// sandhogTier sizes a sandhog's tunnel.
+type sandhogTier struct {
+	loops              int
+	fast, slow         int
+	core, sideBlast    float64
+	crack, crackExtent float64
+	crackSpread        int
+}
+
+func sandhogTierFor(weaponID string) sandhogTier {
+	switch weaponID {
+	case "heavy-sandhog":
+		return sandhogTier{loops: 12, fast: 7, slow: 5, core: 2.2, sideBlast: 5.2, crack: 1.5, crackSpread: 5}
+	case "baby-sandhog":
+		return sandhogTier{loops: 8, fast: 4, slow: 3, core: 1.55, sideBlast: 3.8, crack: 1.2, crackSpread: 4}
+	}
+	return sandhogTier{loops: 10, fast: 6, slow: 4, core: 1.9, sideBlast: 4.6, crack: 1.5, crackSpread: 4}
+}
+
+// solidNear finds ground at or below (sx, sy), looking a little to either
+// side, for a sandhog to tunnel into.
+func (t *Terrain) solidNear(sx, sy float64) (int, int, bool) {
+	baseX := clampInt(int(jsRound(sx)), 1, t.Width-2)
+	baseY := clampInt(int(jsRound(sy)), 1, t.Height-2)
+	if t.solid(baseX, baseY) {
+		return baseX, baseY, true
+	}
+	for dy := 1; dy <= 14; dy++ {
+		yy := clampInt(baseY+dy, 1, t.Height-2)
+		if t.solid(baseX, yy) {
+			return baseX, yy, true
+		}
+		for dx := 1; dx <= 5; dx++ {
+			if xl := clampInt(baseX-dx, 1, t.Width-2); t.solid(xl, yy) {
+				return xl, yy, true
+			}
+			if xr := clampInt(baseX+dx, 1, t.Width-2); t.solid(xr, yy) {
+				return xr, yy, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// stepSandhog runs a sandhog through the ground in straight runs with sharp
+// turns, cracking the ground beside its tunnel.
+func (e *Engine) stepSandhog(tick *projectileTick, p Projectile) {
+	t := e.Terrain
+	tier := sandhogTierFor(p.WeaponID)
+	x, y := p.X, p.Y
+	dirX, dirY := p.VX, p.VY
+	segmentLeft := p.State
+	if math.Abs(dirX)+math.Abs(dirY) < 0.001 {
+		dirX, dirY = 1, 0.35
+		if e.rng.Float64() < 0.5 {
+			dirX = -1
+		}
+	}
+	turnSign := func() float64 {
+		if e.rng.Float64() < 0.5 {
+			return 1
+		}
+		return -1
+	}
+	for i := 0; i < tier.loops; i++ {
+		if segmentLeft <= 0 {
+			turn := e.rng.Float64()
+			angle := math.Atan2(dirY, dirX)
+			switch {
+			case turn < 0.45:
+				angle += turnSign() * (math.Pi / 4)
+			case turn < 0.78:
+				angle += turnSign() * (math.Pi / 2)
+			case turn < 0.9:
+				angle += turnSign() * (3 * math.Pi / 4)
+			}
+			dirX, dirY = math.Cos(angle), math.Sin(angle)
+			segmentLeft = 7 + e.rng.Intn(10)
+		}
+
+		mag := math.Max(0.001, math.Hypot(dirX, dirY))
+		speedX, speedY := tier.slow, tier.slow
+		if e.rng.Float64() < 0.68 {
+			speedX = tier.fast
+		}
+		if e.rng.Float64() < 0.62 {
+			speedY = tier.fast - 1
+		}
+		stepX := jsRound(dirX / mag * float64(speedX))
+		stepY := jsRound(dirY / mag * float64(speedY))
+		sx, sy, ok := t.solidNear(clamp(x+stepX, 1, float64(t.Width-2)), clamp(y+stepY, 1, float64(t.Height-2)))
+		if !ok {
+			dirY = math.Abs(dirY) + 0.35
+			segmentLeft = 0
+			continue
+		}
+		x, y = float64(sx), float64(sy)
+		t.CarveCrater(x, y, tier.core, false)
+		tick.settle = true
+		e.Runtime.DeferredSettlePending = true
+
+		if i%2 == 0 {
+			nMag := math.Max(0.001, math.Hypot(stepX, stepY))
+			nx, ny := -stepY/nMag, stepX/nMag
+			side := -turnSign()
+			crackLen := 6 + e.rng.Intn(tier.crackSpread)
+			for k := 1; k <= crackLen; k++ {
+				cx := clampInt(int(jsRound(x+nx*side*float64(k))), 1, t.Width-2)
+				cy := clampInt(int(jsRound(y+ny*side*float64(k))), 1, t.Height-2)
+				if t.solid(cx, cy) {
+					t.CarveCrater(float64(cx), float64(cy), tier.crack, false)
+				}
+			}
+		}
+		if i%3 == 0 && e.rng.Float64() < 0.72 {
+			t.CarveCrater(x, y, tier.sideBlast, false)
+			e.pushFx(Explosion{
+				X:      x + (e.rng.Float64()*2-1)*2,
+				Y:      y + (e.rng.Float64()*2-1)*2,
+				Radius: 4,
+				Life:   0.1,
+				Kind:   "simple",
+				Color:  "#e6c47a",
+			})
+		}
+		if i%2 == 0 {
+			e.Runtime.Trails = append(e.Runtime.Trails, Trail{
+				X1:      x,
+				Y1:      y,
+				X2:      x + (e.rng.Float64()*2 - 1),
+				Y2:      y + (e.rng.Float64()*2 - 1),
+				OwnerID: p.OwnerID,
+				Life:    0.16,
+				Color:   "#f0cf87",
+			})
+		}
+		segmentLeft--
+
+		// Bounce off the edges to keep tunnelling through the map.
+		if x <= 2 || x >= float64(t.Width-3) {
+			dirX = -dirX
+			segmentLeft = min(segmentLeft, 2)
+		}
+		if y <= 2 {
+			dirY = math.Abs(dirY) + 0.35
+			segmentLeft = min(segmentLeft, 1)
+		} else if y >= float64(t.Height-3) {
+			dirY = -math.Abs(dirY)
+			segmentLeft = min(segmentLeft, 2)
+		}
+	}
+	p.TTL -= FixedDT
+	if p.TTL > 0 {
+		p.X, p.Y, p.VX, p.VY, p.State = x, y, dirX, dirY, segmentLeft
+		tick.survivors = append(tick.survivors, p)
+	}
+}
+
+// stepRoller rolls a roller one pixel downhill along the surface. It goes
+// off when it can roll no further, reaches a tank or runs out of time.
+func (e *Engine) stepRoller(tick *projectileTick, p Projectile) {
+	t := e.Terrain
+	dir := p.Direction
+	if dir == 0 {
+		dir = 1
+		if p.VX < 0 {
+			dir = -1
+		}
+	}
+	currentX := clampInt(int(jsRound(p.X)), 2, t.Width-3)
+	x, y := float64(currentX), float64(t.Heights[currentX]-3)
+	stable := false
+	stepX := clampInt(int(jsRound(x+dir)), 2, t.Width-3)
+	if stepY := float64(t.Heights[stepX] - 3); stepY >= y-0.2 {
+		x, y, stable = float64(stepX), stepY, true
+	}
+
+	for i := range e.Match.Players {
+		sp := e.Match.Players[i]
+		if !sp.Alive || sp.Config.ID == p.OwnerID || sp.ShieldType == ShieldNone || sp.ShieldType == "mag-deflector" {
+			continue
+		}
+		if math.Hypot(x-sp.X, y-sp.Y) > shieldDomeRadius {
+			continue
+		}
+		e.Match.Players[i] = DegradeShield(sp)
+		if sp.ShieldType == "bouncy" {
+			e.pushFx(Explosion{X: sp.X, Y: sp.Y - 10, Radius: shieldDomeRadius, Life: 0.25, Color: "#bb66ff", Kind: "simple"})
+			p.Direction = -dir
+			p.TTL -= FixedDT
+			tick.survivors = append(tick.survivors, p)
+		} else {
+			e.pushFx(Explosion{X: sp.X, Y: sp.Y - 10, Radius: shieldDomeRadius, Life: 0.25, Color: shieldColor(sp.ShieldType), Kind: "simple"})
+		}
+		return
+	}
+
+	e.Runtime.Trails = append(e.Runtime.Trails, Trail{X1: p.X, Y1: p.Y, X2: x, Y2: y, OwnerID: p.OwnerID, Life: 0.35, Color: "#eeeeee"})
+	p.TTL -= FixedDT
+	if stable && !e.hitsTank(p.OwnerID, x, y, 7, 6) && p.TTL > 0 {
+		p.X, p.Y, p.Direction = x, y, dir
+		tick.survivors = append(tick.survivors, p)
+		return
+	}
+	w := WeaponByID(p.WeaponID)
+	e.weaponImpactFx(w.ID, x, y, w.BlastRadius, p.Color)
+	e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: x, Y: y, Radius: w.BlastRadius, Duration: 0.35})
+	e.applyDamageAt(x, y, w.BlastRadius, w.Damage*10, p.SplitDepth)
+}
+
+// stepFlight moves a shell through the air in sub-steps, bending it round
+// mag deflectors, stopping or bouncing it on shield domes and setting off
+// its impact when it hits ground or a tank.
+func (e *Engine) stepFlight(tick *projectileTick, projectile Projectile) {
+	p := projectile
+	collided, lost := false, false
+	dt := FixedDT / projectileSubSteps * projectileTimeScale
+	var magHits map[string]bool
+	for s := 0; s < projectileSubSteps; s++ {
+		before := p
+		p = StepProjectile(p, dt, e.Match.Settings.Gravity, e.Match.Wind)
+		sx, sy, tracedHit := e.firstSolidOnSegment(before.X, before.Y, p.X, p.Y)
+		if tracedHit {
+			p.X, p.Y = sx, sy
+		}
+		e.Runtime.Trails = append(e.Runtime.Trails, Trail{X1: before.X, Y1: before.Y, X2: p.X, Y2: p.Y, OwnerID: p.OwnerID, Life: trailLife(p.WeaponID), Color: p.Color})
+
+		if p.ProjectileType == "mirv-carrier" && before.VY < 0 && p.VY >= 0 {
+			e.splitMirv(tick, p)
+			collided = true
+			break
+		}
+
+		for i := range e.Match.Players {
+			sp := e.Match.Players[i]
+			if !sp.Alive || sp.Config.ID == p.OwnerID || sp.ShieldType != "mag-deflector" {
+				continue
+			}
+			dx, dy := p.X-sp.X, p.Y-sp.Y
+			dist := math.Hypot(dx, dy)
+			if dist <= 0 || dist > magDeflectorInfluenceRadius {
+				continue
+			}
+			// Nudge the position as well as the velocity so the push shows at once.
+			accel := magDeflectorForce / math.Max(dist, 5)
+			dvx, dvy := dx/dist*accel*dt, dy/dist*accel*dt
+			p.VX += dvx
+			p.VY += dvy
+			p.X += dvx * dt
+			p.Y += dvy * dt
+			if dist <= shieldDomeRadius && !magHits[sp.Config.ID] {
+				if magHits == nil {
+					magHits = map[string]bool{}
+				}
+				magHits[sp.Config.ID] = true
+				e.Match.Players[i] = DegradeShield(sp)
+				e.pushFx(Explosion{X: sp.X, Y: sp.Y - 10, Radius: shieldDomeRadius, Life: 0.2, Color: "#ffdd00", Kind: "simple"})
+			}
+		}
+
+		absorbed, bounced := e.shieldDome(&p)
+		if absorbed {
+			collided = true
+		}
+		if absorbed || bounced {
+			break
+		}
+
+		if p.Y >= float64(e.Terrain.Height) {
+			lost, collided = true, true
+			break
+		}
+		forced := false
+		if p.X < 0 || p.X >= float64(e.Terrain.Width) || p.Y < -float64(e.Terrain.Height)*0.5 {
+			p.X = clamp(p.X, 0, float64(e.Terrain.Width-1))
+			p.Y = clamp(p.Y, 0, float64(e.Terrain.Height-1))
+			forced = true
+		}
+		hitTerrain := tracedHit || forced || e.Terrain.SolidAt(p.X, p.Y)
+		if !hitTerrain && !e.hitsTank(p.OwnerID, p.X, p.Y, 6, 5) {
+			continue
+		}
+		e.impact(tick, p)
+		collided = true
+		break
+	}
+	if lost {
+		return
+	}
+
+	funky, mirv := e.Runtime.FunkySequence, e.Runtime.MirvSequence
+	switch {
+	case !collided && p.TTL > 0:
+		tick.survivors = append(tick.survivors, p)
+	case projectile.ProjectileType == "funky-child" && funky != nil:
+		if !collided {
+			// A bomblet that burns out in the air still goes off where it is.
+			ix, iy := e.impactPoint(p.X, p.Y)
+			e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: ix, Y: iy, Radius: 30, Duration: 0.35})
+			e.pushFx(Explosion{X: ix, Y: iy, Radius: 28, Life: 0.5, Kind: "funky-side", Paused: funky.Stage == "collecting", Tag: funky.SideTag})
+			e.applyDamageAt(ix, iy, 30, 260, p.SplitDepth)
+		}
+		funky.ResolvedSides++
+	case projectile.ProjectileType == "mirv-child" && mirv != nil:
+		if !collided {
+			ix, iy := e.impactPoint(p.X, p.Y)
+			w := WeaponByID(p.WeaponID)
+			radius := effectiveBlastRadius(w)
+			e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: ix, Y: iy, Radius: radius, Duration: 0.35, DeferSettle: true})
+			e.mirvBurst(w.ID, ix, iy, radius, mirv)
+			e.applyDamageAt(ix, iy, radius, w.Damage*10, p.SplitDepth)
+		}
+		mirv.Resolved++
+	}
+}
+
+// shieldDome stops p on the first enemy shield dome it is inside, or
+// reflects it off a bouncy one. Mag deflectors are handled separately.
+func (e *Engine) shieldDome(p *Projectile) (absorbed, bounced bool) {
+	for i := range e.Match.Players {
+		sp := e.Match.Players[i]
+		if !sp.Alive || sp.Config.ID == p.OwnerID || sp.ShieldType == ShieldNone || sp.ShieldType == "mag-deflector" {
+			continue
+		}
+		dx, dy := p.X-sp.X, p.Y-sp.Y
+		dist := math.Hypot(dx, dy)
+		if dist > shieldDomeRadius {
+			continue
+		}
+		e.Match.Players[i] = DegradeShield(sp)
+		if sp.ShieldType != "bouncy" {
+			e.pushFx(Explosion{X: sp.X, Y: sp.Y - 10, Radius: shieldDomeRadius, Life: 0.25, Color: shieldColor(sp.ShieldType), Kind: "simple"})
+			return true, false
+		}
+		n := dist
+		if n == 0 {
+			n = 1
+		}
+		nx, ny := dx/n, dy/n
+		dot := p.VX*nx + p.VY*ny
+		p.VX -= 2 * dot * nx
+		p.VY -= 2 * dot * ny
+		p.X = sp.X + nx*(shieldDomeRadius+2)
+		p.Y = sp.Y + ny*(shieldDomeRadius+2)
+		e.pushFx(Explosion{X: sp.X, Y: sp.Y - 10, Radius: shieldDomeRadius, Life: 0.25, Color: "#bb66ff", Kind: "simple"})
+		return false, true
+	}
+	return false, false
+}
+
+func shieldColor(shieldType string) string {
+	if shieldType == "heavy" {
+		return "#ffffff"
+	}
+	return "#88aaff"
+}
+
+// impact sets off what p does when it lands at (p.X, p.Y), as the impact
+// branches of stepSimulation do.
+func (e *Engine) impact(tick *projectileTick, p Projectile) {
+	w := WeaponByID(p.WeaponID)
+	spec := runtimeSpecFor(w.ID)
+	x, y := e.impactPoint(p.X, p.Y)
+
+	switch spec.impactMode {
+	case "mirv":
+		if p.ProjectileType == "mirv-carrier" {
+			p.X, p.Y = x, y
+			e.splitMirv(tick, p)
+			return
+		}
+	case "roller":
+		direction := 1.0
+		if p.VX < 0 {
+			direction = -1
+		}
+		tick.spawned = append(tick.spawned, Projectile{
+			X:              x,
+			Y:              y,
+			VX:             p.VX,
+			OwnerID:        p.OwnerID,
+			WeaponID:       w.ID,
+			TTL:            orDefault(spec.rollerTTL, 3),
+			SplitDepth:     p.SplitDepth,
+			ProjectileType: "roller",
+			Direction:      direction,
+		})
+		e.pushFx(Explosion{X: x, Y: y, Radius: 7, Life: 0.14, Kind: "burst", Color: "#d9d9d9"})
+		return
+	case "digger":
+		duration := orDefault(spec.diggerDuration, 2)
+		count := 3
+		switch w.ID {
+		case "baby-digger":
+			count = 2
+		case "heavy-digger":
+			count = 4
+		}
+		for i := 0; i < count; i++ {
+			spread := (float64(i) - float64(count-1)/2) * 0.18
+			tick.spawned = append(tick.spawned, Projectile{
+				X:              x,
+				Y:              y,
+				VX:             p.VX*(0.9+float64(i)*0.08) + spread*18,
+				VY:             p.VY*(0.34+float64(i)*0.035) + math.Abs(spread)*7,
+				OwnerID:        p.OwnerID,
+				WeaponID:       w.ID,
+				TTL:            duration * (0.8 + float64(i+1)/float64(count+1)*0.65),
+				ProjectileType: "digger",
+				Seed:           i,
+			})
+		}
+		e.pushFx(Explosion{X: x, Y: y, Radius: 10, Life: 0.2, Kind: "sand", Color: "#cfba88"})
+		return
+	case "sandhog":
+		tick.spawned = append(tick.spawned, Projectile{
+			X:              x,
+			Y:              y,
+			VX:             p.VX * 1.15,
+			VY:             p.VY * 0.82,
+			OwnerID:        p.OwnerID,
+			WeaponID:       w.ID,
+			TTL:            orDefault(spec.sandhogDuration, 1.5),
+			ProjectileType: "sandhog",
+			Seed:           1,
+		})
+		e.pushFx(Explosion{X: x, Y: y, Radius: 12, Life: 0.2, Kind: "sand", Color: "#d9bc7c"})
+		return
+	case "funky":
+		if p.SplitDepth == 0 {
+			count := spec.funkyChildCount
+			if count == 0 {
+				count = 6
+			}
+			sideTag := "funky-side-" + strconv.Itoa(e.fxID())
+			p.X, p.Y = x, y
+			tick.spawned = append(tick.spawned, e.spawnFunkyChildren(p, count)...)
+			e.Runtime.FunkySequence = &FunkySequence{
+				Stage:         "collecting",
+				ExpectedSides: count,
+				SideTag:       sideTag,
+				CentralX:      x,
+				CentralY:      y,
+				OwnerID:       p.OwnerID,
+				SplitDepth:    p.SplitDepth,
+				EffectRadius:  w.BlastRadius,
+				EffectDamage:  w.Damage,
+			}
+			e.pushFx(Explosion{X: x, Y: y, Radius: 12, Life: 0.18, Kind: "funky", Color: "#ff8d3f"})
+			return
+		}
+	case "sand":
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditAddDirt, X: x, Y: y, Radius: 46, Duration: 0.55, Amount: 22})
+		e.weaponImpactFx(w.ID, x, y, 45, "#d8c386")
+		return
+	case "riot-bomb":
+		rings, ringStart := 3, 6.0
+		switch w.ID {
+		case "heavy-riot-bomb":
+			rings, ringStart = 5, 8
+		case "riot-bomb":
+			rings, ringStart = 4, 7
+		}
+		for ring := 0; ring < rings; ring++ {
+			distance := ringStart + float64(ring)*7
+			nodes := 7 + ring*2
+			for i := 0; i < nodes; i++ {
+				a := math.Pi * 2 * float64(i) / float64(nodes)
+				e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: x + math.Cos(a)*distance, Y: y + math.Sin(a)*distance, Radius: 3.8 + float64(ring)*0.5, Duration: 0.36})
+			}
+		}
+		e.weaponImpactFx(w.ID, x, y, w.BlastRadius, "#bb58ff")
+		return
+	case "riot-blast":
+		direction := 1.0
+		if p.VX < 0 {
+			direction = -1
+		}
+		e.riotBlast(x, y, direction, riotConeFor(w.ID == "riot-charge", false))
+		return
+	case "leapfrog":
+		// A leapfrog goes off three times: where it lands, then two short hops.
+		hop := max(0, p.State)
+		radius := math.Max(10, w.BlastRadius-float64(hop*2))
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: x, Y: y, Radius: radius, Duration: 0.35})
+		e.weaponImpactFx(w.ID, x, y, radius, "#f4f4f4")
+		e.applyDamageAt(x, y, radius, math.Max(14, w.Damage-float64(hop*4))*10, p.SplitDepth)
+		if hop < 2 {
+			dir := 1.0
+			if p.VX < 0 {
+				dir = -1
+			}
+			speed, lift := 88.0, 102.0
+			if hop > 0 {
+				speed, lift = 78, 92
+			}
+			tick.spawned = append(tick.spawned, Projectile{
+				X:              clamp(x+dir*2, 1, float64(e.Terrain.Width-2)),
+				Y:              math.Max(2, y-2),
+				VX:             dir * speed,
+				VY:             -lift,
+				OwnerID:        p.OwnerID,
+				WeaponID:       w.ID,
+				TTL:            1.8,
+				ProjectileType: "ballistic",
+				Color:          p.Color,
+				State:          hop + 1,
+			})
+		}
+		return
+	case "tracer":
+		// Tracers only draw their path: no blast, no crater, no damage.
+		if w.ID == "smoke-tracer" {
+			e.pushFx(Explosion{X: x, Y: y, Radius: 5, Life: 0.18, Kind: "sand", Color: "#9f9f9f"})
+		}
+		return
+	case "dirt":
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditAddDisk, X: x, Y: y, Radius: 112, Duration: 0.72, DeferSettle: true})
+		e.pushFx(Explosion{X: x, Y: y, Radius: 94, Life: 0.88, Kind: "sand", Color: "#d8bf86"})
+		return
+	case "liquid":
+		e.Terrain.AddLiquidDirt(x, y, 140, 9000, false)
+		e.Runtime.DeferredSettlePending = true
+		tick.settle = true
+		e.pushFx(Explosion{X: x, Y: y, Radius: 84, Life: 0.78, Kind: "sand", Color: "#d7bb81"})
+		return
+	case "napalm":
+		e.napalm(tick, p, w, spec, x, y)
+		return
+	}
+
+	radius := effectiveBlastRadius(w)
+	damage := w.Damage * 10
+	if p.ProjectileType == "funky-child" {
+		radius, damage = 30, 260
+	}
+	switch {
+	case p.ProjectileType == "mirv-child":
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: x, Y: y, Radius: radius, Duration: 0.35, DeferSettle: true})
+	case w.TerrainEffect == "tunnel":
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditTunnel, X: x, Y: y, Radius: math.Max(3, radius*0.2), Duration: 0.55, Length: 9})
+	case w.TerrainEffect == "crater":
+		// Nukes leave the ground hanging until their fireball is gone.
+		nuke := w.ID == "baby-nuke" || w.ID == "nuke"
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: x, Y: y, Radius: radius, Duration: 0.35, DeferSettle: nuke})
+	}
+	funky, mirv := e.Runtime.FunkySequence, e.Runtime.MirvSequence
+	switch {
+	case p.ProjectileType == "funky-child" && funky != nil:
+		e.pushFx(Explosion{X: x, Y: y, Radius: 28, Life: 0.5, Kind: "funky-side", Paused: funky.Stage == "collecting", Tag: funky.SideTag})
+	case p.ProjectileType == "mirv-child" && mirv != nil:
+		e.mirvBurst(w.ID, x, y, radius, mirv)
+	default:
+		e.weaponImpactFx(w.ID, x, y, radius, p.Color)
+	}
+	e.applyDamageAt(x, y, radius, damage, p.SplitDepth)
+}
+
+// napalm splashes burning fuel around (x, y) and leaves drops burning on the
+// surface.
+func (e *Engine) napalm(tick *projectileTick, p Projectile, w Weapon, spec runtimeSpec, x, y float64) {
+	t := e.Terrain
+	hot := w.ID == "hot-napalm"
+	patches := 3
+	if hot {
+		patches = 5
+	}
+	for i := 0; i < patches; i++ {
+		px := x + (e.rng.Float64()*2-1)*(8+float64(i)*10)
+		tx := clampInt(int(jsRound(px)), 2, t.Width-3)
+		ty := float64(t.Heights[tx] - 2)
+		fx := Explosion{X: float64(tx), Y: ty, Radius: 4 + e.rng.Float64()*2, Life: 0.8, Kind: "fuel-pool", Color: "#ffae4a"}
+		scorchRadius, strength := 11.0, 1.0
+		if hot {
+			fx.Radius, fx.Life, fx.Color = 6+e.rng.Float64()*3, 1.1, "#ff8b2b"
+			scorchRadius, strength = 15, 1.2
+		}
+		e.pushFx(fx)
+		t.Scorch(float64(tx), ty+1, scorchRadius, strength, e.rng)
+	}
+	drops := spec.napalmDrops
+	if drops == 0 {
+		drops = 12
+	}
+	for i := 0; i < drops; i++ {
+		a := math.Pi * 2 * float64(i) / float64(drops)
+		tx := clampInt(int(jsRound(x+math.Cos(a)*(8+float64(i)*1.1))), 2, t.Width-3)
+		tick.spawned = append(tick.spawned, Projectile{
+			X:              float64(tx),
+			Y:              float64(t.Heights[tx] - 3),
+			OwnerID:        p.OwnerID,
+			WeaponID:       w.ID,
+			TTL:            orDefault(spec.napalmTTL, 2.8),
+			ProjectileType: "napalm-burn",
+			EffectRadius:   orDefault(spec.napalmRadius, 14),
+			EffectDamage:   orDefault(spec.napalmDamage, 7),
+		})
+	}
+	e.weaponImpactFx(w.ID, x, y, w.BlastRadius, "#ffc933")
+	strength := 1.05
+	if hot {
+		strength = 1.35
+	}
+	t.Scorch(x, y, math.Max(10, w.BlastRadius*0.6), strength, e.rng)
+	e.applyDamageAt(x, y, math.Max(12, w.BlastRadius*0.55), w.Damage*6, p.SplitDepth)
+}
+
+// splitMirv starts a MIRV sequence and releases the carrier's warheads
+// from where it is.
+func (e *Engine) splitMirv(tick *projectileTick, p Projectile) {
+	count := runtimeSpecFor(p.WeaponID).mirvChildCount
+	if count == 0 {
+		count = 5
+	}
+	e.Runtime.MirvSequence = &MirvSequence{
+		Stage:    "collecting",
+		Expected: count,
+		Tag:      "mirv-" + strconv.Itoa(e.fxID()),
+		WeaponID: p.WeaponID,
+	}
+	e.Runtime.DeferredSettlePending = true
+	tick.spawned = append(tick.spawned, e.spawnMirvChildren(p, count)...)
+}
+
+// mirvBurst shows a warhead's blast, held until the whole MIRV has landed.
+func (e *Engine) mirvBurst(weaponID string, x, y, radius float64, seq *MirvSequence) {
+	life := 0.48
+	if weaponID == "death-head" {
+		life = 0.62
+	}
+	e.pushFx(Explosion{X: x, Y: y, Radius: math.Max(22, radius), Life: life, Kind: "mirv", Paused: seq.Stage == "collecting", Tag: seq.Tag, Seed: e.rng.Intn(1000000)})
+}
+
+var (
+	mirvColors       = []string{"#d9ff5a", "#9eff4b", "#74ff61", "#89ffbe", "#83ffd8", "#b2ff6f"}
+	funkyChildColors = []string{"#ff0000", "#ff9f1a", "#ffe64d", "#00ff5a", "#00b2ff", "#354dff"}
+)
+
+func (e *Engine) spawnMirvChildren(parent Projectile, count int) []Projectile {
+	offset := 6.5
+	if parent.WeaponID == "death-head" {
+		offset = 8.5
+	}
+	speed := parent.VX - offset*float64(count)/2
+	out := make([]Projectile, count)
+	for i := range out {
+		out[i] = Projectile{
+			X:              parent.X,
+			Y:              parent.Y,
+			VX:             speed,
+			VY:             -74 + e.rng.Float64()*14 - float64(i)*0.7,
+			OwnerID:        parent.OwnerID,
+			WeaponID:       parent.WeaponID,
+			TTL:            5.6,
+			ProjectileType: "mirv-child",
+			SplitDepth:     parent.SplitDepth + 1,
+			Color:          mirvColors[i%len(mirvColors)],
+		}
+		speed += offset
+	}
+	return out
+}
+
+func (e *Engine) spawnFunkyChildren(parent Projectile, count int) []Projectile {
+	out := make([]Projectile, count)
+	for i := range out {
+		radians := (20 + e.rng.Float64()*140) * math.Pi / 180
+		speed := 95 + e.rng.Float64()*170
+		out[i] = Projectile{
+			X:              parent.X + math.Cos(radians)*5,
+			Y:              parent.Y - math.Sin(radians)*5,
+			VX:             math.Cos(radians) * speed,
+			VY:             -math.Sin(radians) * speed,
+			OwnerID:        parent.OwnerID,
+			WeaponID:       parent.WeaponID,
+			TTL:            3.6,
+			SplitDepth:     parent.SplitDepth + 1,
+			ProjectileType: "funky-child",
+			Color:          funkyChildColors[i%len(funkyChildColors)],
+		}
+	}
+	return out
+}
+
+func (e *Engine) firstSolidOnSegment(x1, y1, x2, y2 float64) (float64, float64, bool) {
+	dx, dy := x2-x1, y2-y1
+	steps := max(1, int(math.Ceil(math.Max(math.Abs(dx), math.Abs(dy))*1.5)))
+	for i := 1; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		sx, sy := x1+dx*t, y1+dy*t
+		if e.Terrain.SolidAt(sx, sy) {
+			return sx, sy, true
+		}
+	}
+	return 0, 0, false
+}
+
+// impactPoint mirrors resolveTerrainImpactPoint: the ground pixel in the
+// column at or below (xRaw, yRaw), else the nearest one above it.
+func (e *Engine) impactPoint(xRaw, yRaw float64) (float64, float64) {
+	t := e.Terrain
+	x := clampInt(int(math.Floor(xRaw)), 0, t.Width-1)
+	y := clampInt(int(math.Floor(yRaw)), 0, t.Height-1)
+	if t.solid(x, y) {
+		return float64(x), float64(y)
+	}
+	for yy := y; yy < t.Height; yy++ {
+		if t.solid(x, yy) {
+			return float64(x), float64(yy)
+		}
+	}
+	for yy := y; yy >= 0; yy-- {
+		if t.solid(x, yy) {
+			return float64(x), float64(yy)
+		}
+	}
+	return float64(x), float64(y)
+}
+
+func trailLife(weaponID string) float64 {
+	switch weaponID {
+	case "smoke-tracer":
+		return 2.6
+	case "tracer":
+		return 1.8
+	}
+	return 0.85
+}
+
+// hitsTank reports whether (x, y) is within reach of an enemy tank.
+func (e *Engine) hitsTank(ownerID string, x, y, reachX, reachY float64) bool {
+	for _, pl := range e.Match.Players {
+		if pl.Alive && pl.Config.ID != ownerID && math.Abs(pl.X-x) < reachX && math.Abs(pl.Y-y) < reachY {
+			return true
+		}
+	}
+	return false
+}
+
+func orDefault(v, fallback float64) float64 {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
+
+func sign(v float64) float64 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package engine
+
+// ShieldItem describes a purchasable shield, as in SHIELD_ITEMS.
+type ShieldItem struct {
+	ID                string
+	Name              string
+	ShieldType        string
+	InitialStrength   float64
+	DegradationPerHit float64
+}
+
+var ShieldItems = []ShieldItem{
+	{ID: "regular-shield", Name: "Regular Shield", ShieldType: "regular", InitialStrength: 1000, DegradationPerHit: 200},
+	{ID: "heavy-shield", Name: "Heavy Shield", ShieldType: "heavy", InitialStrength: 1000, DegradationPerHit: 100},
+	{ID: "bouncy-shield", Name: "Bouncy Shield", ShieldType: "bouncy", InitialStrength: 1000, DegradationPerHit: 200},
+	{ID: "mag-deflector", Name: "Mag Deflector", ShieldType: "mag-deflector", InitialStrength: 1000, DegradationPerHit: 200},
+}
+
+var autoDefensePriority = []string{"heavy-shield", "bouncy-shield", "mag-deflector", "regular-shield"}
+
+func shieldByID(id string) *ShieldItem {
+	for i := range ShieldItems {
+		if ShieldItems[i].ID == id {
+			return &ShieldItems[i]
+		}
+	}
+	return nil
+}
+
+func shieldByType(shieldType string) *ShieldItem {
+	for i := range ShieldItems {
+		if ShieldItems[i].ShieldType == shieldType {
+			return &ShieldItems[i]
+		}
+	}
+	return nil
+}
+
+// ActivateShieldFromInventory consumes one shieldID and raises it.
+func ActivateShieldFromInventory(p Player, shieldID string) Player {
+	has := p.Inventory[shieldID]
+	def := shieldByID(shieldID)
+	if has <= 0 || def == nil {
+		return p
+	}
+	p.Inventory = p.Inventory.with(shieldID, has-1)
+	p.Shield = def.InitialStrength
+	p.ShieldType = def.ShieldType
+	return p
+}
+
+// DegradeShield wears the active shield down by one hit.
+func DegradeShield(p Player) Player {
+	def := shieldByType(p.ShieldType)
+	if def == nil {
+		return p
+	}
+	next := clamp(p.Shield-def.DegradationPerHit, 0, 1000)
+	if next <= 0 {
+		p.Shield = 0
+		p.ShieldType = ShieldNone
+		return p
+	}
+	p.Shield = next
+	return p
+}
+
+// AutoActivateShieldAtRoundStart raises the strongest owned shield when the
+// player carries an auto-defense unit.
+func AutoActivateShieldAtRoundStart(p Player) Player {
+	if p.Inventory["auto-defense"] <= 0 {
+		return p
+	}
+	for _, id := range autoDefensePriority {
+		if p.Inventory[id] > 0 {
+			return ActivateShieldFromInventory(p, id)
+		}
+	}
+	return p
+}
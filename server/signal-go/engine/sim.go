@@ -0,0 +1,821 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	shieldDomeRadius            = 20
+	magDeflectorInfluenceRadius = 80
+	magDeflectorForce           = 200000
+	projectileSubSteps          = 4
+	projectileTimeScale         = 2.2
+	maxTrails                   = 2400
+	recentExplosionWindow       = 12
+)
+
+// View is the screen the clients should show.
+const (
+	ViewShop   = "shop"
+	ViewBattle = "battle"
+)
+
+// Engine is an authoritative, headless match. It is not safe for concurrent
+// use; callers serialise access.
+type Engine struct {
+	Match    MatchState
+	Terrain  *Terrain
+	Runtime  Runtime
+	View     string
+	ShopDone map[string]bool
+	Message  string
+	// Map, when set, is the map every battle is cut from instead of the
+	// settings' terrain preset. Set it before the first battle starts.
+	Map *Terrain
+
+	// seed is the match seed; rng draws for the current round from its
+	// RoundSeed.
+	seed     uint64
+	rng      *PRNG
+	nextFxID int
+	angleAcc float64
+	powerAcc float64
+	moveAcc  float64
+	// aiWait is how long the active bot has been on its turn.
+	aiWait float64
+}
+
+// Snapshot is the game.snapshot payload the browser host would send.
+type Snapshot struct {
+	RoomID             string          `json:"roomId"`
+	Tick               int             `json:"tick"`
+	View               string          `json:"view"`
+	ShopIndex          *int            `json:"shopIndex,omitempty"`
+	ShopDoneByPlayerID map[string]bool `json:"shopDoneByPlayerId,omitempty"`
+	Match              MatchState      `json:"match"`
+	Runtime            Runtime         `json:"runtime"`
+	Message            string          `json:"message"`
+	Terrain            *TerrainPayload `json:"terrain,omitempty"`
+}
+
+// New starts a match in the shop, the same way a browser host does for LAN
+// games. Everything random in the match is drawn from seed.
+func New(s Settings, configs []PlayerConfig, width, height int, seed uint64) *Engine {
+	rng := NewPRNG(RoundSeed(seed, 1))
+	m, terrain := InitMatch(s.Normalize(), configs, width, height, rng)
+	e := &Engine{
+		Match:    m,
+		Terrain:  terrain,
+		Runtime:  newRuntime(),
+		View:     ViewShop,
+		ShopDone: make(map[string]bool, len(m.Players)),
+		Message:  "Match started",
+		seed:     seed,
+		rng:      rng,
+	}
+	for _, p := range m.Players {
+		// Bots don't shop, so they never hold up the battle.
+		e.ShopDone[p.Config.ID] = p.Config.Kind == "ai"
+	}
+	return e
+}
+
+// Snapshot captures the current state for broadcast.
+func (e *Engine) Snapshot(roomID string, tick int, includeTerrain bool) Snapshot {
+	snap := Snapshot{
+		RoomID:  roomID,
+		Tick:    tick,
+		View:    e.View,
+		Match:   e.Match,
+		Runtime: e.Runtime,
+		Message: e.Message,
+	}
+	if e.View == ViewShop {
+		zero := 0
+		snap.ShopIndex = &zero
+		snap.ShopDoneByPlayerID = e.ShopDone
+	}
+	if includeTerrain {
+		payload := e.Terrain.Payload()
+		snap.Terrain = &payload
+	}
+	return snap
+}
+
+// Buy applies a shop purchase for playerID.
+func (e *Engine) Buy(playerID, weaponID string) error {
+	return e.shop(playerID, weaponID, CheckBuy, BuyWeapon)
+}
+
+// Sell applies a shop sale for playerID.
+func (e *Engine) Sell(playerID, weaponID string) error {
+	return e.shop(playerID, weaponID, CheckSell, SellWeapon)
+}
+
+func (e *Engine) shop(playerID, weaponID string, check func(Player, string) error, apply func(Player, string) Player) error {
+	i := e.Match.PlayerIndex(playerID)
+	if e.View != ViewShop || i < 0 || e.ShopDone[playerID] {
+		return ErrShopClosed
+	}
+	if err := check(e.Match.Players[i], weaponID); err != nil {
+		return err
+	}
+	e.Match.Players[i] = apply(e.Match.Players[i], weaponID)
+	return nil
+}
+
+// SetShopDone records a player leaving the shop; once everyone is done the
+// battle begins.
+func (e *Engine) SetShopDone(playerID string, done bool) {
+	if e.View != ViewShop || e.Match.PlayerIndex(playerID) < 0 {
+		return
+	}
+	e.ShopDone[playerID] = done
+	for _, p := range e.Match.Players {
+		if !e.ShopDone[p.Config.ID] {
+			return
+		}
+	}
+	e.startBattle()
+}
+
+func (e *Engine) startBattle() {
+	m := e.Match
+	e.rng = NewPRNG(RoundSeed(e.seed, m.RoundIndex))
+	e.Terrain = e.battleTerrain()
+	players := make([]Player, len(m.Players))
+	for i, p := range m.Players {
+		p.Alive = true
+		p.HP = 100
+		p.MaxPower = 1000
+		p.Power = 200
+		p.Fuel = math.Max(0, float64(p.Inventory["fuel"]))
+		players[i] = p
+	}
+	m.Players = players
+	m = PlacePlayers(m, e.Terrain, e.rng)
+	for i := range m.Players {
+		m.Players[i] = AutoActivateShieldAtRoundStart(m.Players[i])
+	}
+	m.Phase = PhaseAim
+	m.ActivePlayerID = m.Players[0].Config.ID
+	e.Match = m
+	e.Runtime = newRuntime()
+	e.View = ViewBattle
+	e.ShopDone = map[string]bool{}
+	e.resetAccumulators()
+	e.Message = "Battle!"
+}
+
+func (e *Engine) resetAccumulators() {
+	e.angleAcc, e.powerAcc, e.moveAcc, e.aiWait = 0, 0, 0, 0
+}
+
+// SkipTurn passes the turn without firing.
+func (e *Engine) SkipTurn() {
+	if e.View != ViewBattle || e.Match.Phase != PhaseAim {
+		return
+	}
+	e.resetAccumulators()
+	e.Match = NextActivePlayer(e.Match, e.rng)
+}
+
+// ApplyInput feeds one input frame from playerID. Only the active human
+// player's input has any effect, as on the browser host.
+func (e *Engine) ApplyInput(playerID string, in Input, deltaMs float64) {
+	if e.View != ViewBattle || e.Match.Phase != PhaseAim || e.Match.ActivePlayerID != playerID {
+		return
+	}
+	active := e.Match.ActivePlayer()
+	if active == nil || active.Config.Kind == "ai" {
+		return
+	}
+	if in.WeaponCycle != 0 {
+		active.SelectedWeaponID = pickNextWeapon(*active, in.WeaponCycle, e.Match.Settings.FreeFireMode)
+	}
+	if in.FirePressed {
+		e.fire(e.Match.PlayerIndex(playerID))
+		return
+	}
+	e.applyHeldAim(active, in, math.Min(250, deltaMs)/1000)
+}
+
+func (e *Engine) applyHeldAim(active *Player, in Input, dt float64) {
+	s := e.Match.Settings
+	moveDirection := boolInt(in.MoveRight) - boolInt(in.MoveLeft)
+	if moveDirection != 0 && active.Fuel > 0 {
+		e.moveAcc += dt * math.Max(6, s.PowerAdjustHz*0.85)
+		for e.moveAcc >= 1 && active.Fuel > 0 {
+			nextX := clamp(math.Round(active.X+float64(moveDirection)), 4, float64(e.Terrain.Width-5))
+			if nextX == active.X {
+				break
+			}
+			seatedY, ok := solveTankSeatedY(e.Terrain, nextX, active.Y)
+			if !ok {
+				break
+			}
+			active.X, active.Y = nextX, seatedY
+			active.Fuel = math.Max(0, active.Fuel-1)
+			active.Inventory = active.Inventory.with("fuel", int(math.Floor(active.Fuel)))
+			active.FallDistance = 0
+			e.moveAcc--
+		}
+	} else {
+		e.moveAcc = 0
+	}
+
+	angleDirection := boolInt(in.Left) - boolInt(in.Right)
+	angleStep := 1.0
+	if in.Alt && angleDirection != 0 {
+		angleStep = 10
+	}
+	if angleDirection != 0 {
+		e.angleAcc += dt * s.PowerAdjustHz
+		for e.angleAcc >= 1 {
+			active.Angle = clamp(active.Angle+float64(angleDirection)*angleStep, 2, 178)
+			e.angleAcc--
+		}
+	} else {
+		e.angleAcc = 0
+	}
+
+	if in.PowerSet != nil {
+		active.Power = clamp(*in.PowerSet, 0, active.MaxPower)
+		return
+	}
+	fastDirection := boolInt(in.FastUp) - boolInt(in.FastDown)
+	arrowDirection := boolInt(in.Up) - boolInt(in.Down)
+	altDirection := 0
+	if in.Alt {
+		altDirection = arrowDirection
+	}
+	powerDirection, powerStep := arrowDirection, 1.0
+	if fastDirection != 0 {
+		powerDirection, powerStep = fastDirection, 10
+	} else if altDirection != 0 {
+		powerDirection, powerStep = altDirection, 10
+	}
+	if powerDirection != 0 {
+		e.powerAcc += dt * s.PowerAdjustHz
+		for e.powerAcc >= 1 {
+			active.Power = clamp(active.Power+float64(powerDirection)*powerStep, 0, active.MaxPower)
+			e.powerAcc--
+		}
+	} else {
+		e.powerAcc = 0
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fire mirrors fireWeapon in App.tsx.
+func (e *Engine) fire(index int) {
+	shooter := e.Match.Players[index]
+	w := WeaponByID(shooter.SelectedWeaponID)
+	ammo := shooter.Inventory[w.ID]
+	freeFire := e.Match.Settings.FreeFireMode
+	if !freeFire && ammo <= 0 {
+		fallback := StarterWeaponID
+		if shooter.Inventory[StarterWeaponID] <= 0 {
+			fallback = pickNextWeapon(shooter, 1, freeFire)
+		}
+		e.Match.Players[index].SelectedWeaponID = fallback
+		e.Message = fmt.Sprintf("%s is out of %s", shooter.Config.Name, w.Name)
+		return
+	}
+	if !freeFire {
+		shooter.Inventory = shooter.Inventory.with(w.ID, max(0, ammo-1))
+		if shooter.Inventory[w.ID] <= 0 && shooter.Inventory[StarterWeaponID] > 0 {
+			shooter.SelectedWeaponID = StarterWeaponID
+		}
+	}
+	e.resetAccumulators()
+
+	if w.ID == "riot-blast" || w.ID == "riot-charge" {
+		// Riot charges blast a cone straight out of the barrel.
+		direction := 1.0
+		if math.Cos(shooter.Angle*math.Pi/180) < 0 {
+			direction = -1
+		}
+		e.riotBlast(shooter.X+direction*6, shooter.Y-3, direction, riotConeFor(w.ID == "riot-charge", true))
+		e.Match.Players[index] = shooter
+		e.Match.Phase = PhaseProjectile
+		e.Message = fmt.Sprintf("%s fired %s", shooter.Config.Name, w.Name)
+		return
+	}
+
+	if w.ProjectileCount <= 0 {
+		if w.ID == "battery" {
+			shooter.HP = clamp(shooter.HP+32, 0, 100)
+		}
+		if item := shieldByID(w.ID); item != nil {
+			shooter.Shield = item.InitialStrength
+			shooter.ShieldType = item.ShieldType
+		}
+		if w.ID == "fuel" {
+			shooter.Fuel = clamp(shooter.Fuel+100, 0, 1000)
+		}
+		e.Match.Players[index] = shooter
+		e.Match = NextActivePlayer(e.Match, e.rng)
+		e.Message = fmt.Sprintf("%s used %s", shooter.Config.Name, w.Name)
+		return
+	}
+
+	projectileType := runtimeSpecFor(w.ID).launchType
+	color := ""
+	switch {
+	case w.ID == "ton-of-dirt" || w.ID == "liquid-dirt":
+		color = "#ff5b5b"
+	case w.ID == "tracer":
+		color = "#0f0f0f"
+	case w.ID == "smoke-tracer":
+		color = "#9f9f9f"
+	case projectileType == "mirv-carrier":
+		color = "#ffe95a"
+	}
+	for _, angle := range SpreadAngles(shooter.Angle, w.ProjectileCount, w.SpreadDeg) {
+		vx, vy := ToVelocity(angle, shooter.Power)
+		e.Runtime.Projectiles = append(e.Runtime.Projectiles, Projectile{
+			X:              shooter.X,
+			Y:              shooter.Y - 4,
+			VX:             vx,
+			VY:             vy,
+			OwnerID:        shooter.Config.ID,
+			WeaponID:       w.ID,
+			TTL:            9,
+			ProjectileType: projectileType,
+			Color:          color,
+		})
+	}
+	e.Match.Players[index] = shooter
+	e.Match.Phase = PhaseProjectile
+	e.Message = fmt.Sprintf("%s fired %s", shooter.Config.Name, w.Name)
+}
+
+// riotCone sizes the fan of small craters a riot weapon cuts. A charge is
+// smaller than a blast, and a cone fired from the barrel is shaped a little
+// differently from one a landing shell sets off.
+type riotCone struct {
+	rings                int
+	points               int
+	ringStart, ringStep  float64
+	life, lifeStep       float64
+	crater               float64
+	duration, durationUp float64
+}
+
+func riotConeFor(charge, fromBarrel bool) riotCone {
+	switch {
+	case charge && fromBarrel:
+		return riotCone{rings: 3, points: 6, ringStart: 8, ringStep: 6, life: 0.24, lifeStep: 0.05, crater: 2.8, duration: 0.24, durationUp: 0.06}
+	case fromBarrel:
+		return riotCone{rings: 5, points: 8, ringStart: 10, ringStep: 8, life: 0.3, lifeStep: 0.05, crater: 3.6, duration: 0.32, durationUp: 0.06}
+	case charge:
+		return riotCone{rings: 3, points: 6, ringStart: 8, ringStep: 6, life: 0.24, lifeStep: 0.06, crater: 2.8, duration: 0.28}
+	}
+	return riotCone{rings: 4, points: 8, ringStart: 10, ringStep: 8, life: 0.28, lifeStep: 0.06, crater: 3.5, duration: 0.34}
+}
+
+// riotBlast queues the cone of craters in front of (x, y), facing direction.
+func (e *Engine) riotBlast(x, y, direction float64, cone riotCone) {
+	for ring := 0; ring < cone.rings; ring++ {
+		ringRadius := cone.ringStart + float64(ring)*cone.ringStep
+		life := cone.life + float64(ring)*cone.lifeStep
+		e.pushFx(Explosion{X: x, Y: y, Radius: ringRadius, Life: life, Kind: "riot-blast", Color: "#bb58ff", Direction: direction})
+		points := cone.points + ring
+		for i := 0; i < points; i++ {
+			t := float64(i) / float64(max(1, points-1))
+			angle := (t - 0.5) * (math.Pi / 2)
+			if direction < 0 {
+				angle += math.Pi
+			}
+			e.enqueueTerrainEdit(TerrainEdit{
+				Mode:     EditCrater,
+				X:        x + math.Cos(angle)*ringRadius,
+				Y:        y + math.Sin(angle)*ringRadius,
+				Radius:   cone.crater + float64(ring)*0.2,
+				Duration: cone.duration + float64(ring)*cone.durationUp,
+			})
+		}
+	}
+}
+
+func (e *Engine) enqueueTerrainEdit(edit TerrainEdit) {
+	e.Runtime.TerrainEdits = append(e.Runtime.TerrainEdits, edit)
+}
+
+// Step advances the simulation by FixedDT, as stepSimulation does. While
+// terrain edits are animating nothing else moves.
+func (e *Engine) Step() {
+	if e.View != ViewBattle || e.Match.Phase == PhaseMatchEnd {
+		return
+	}
+	rt := &e.Runtime
+	if len(rt.TerrainEdits) > 0 {
+		e.stepTerrainEdits()
+		return
+	}
+
+	tick := e.stepProjectiles()
+	if tick.settle && len(rt.Projectiles) == 0 && len(rt.TerrainEdits) == 0 && !rt.DeferredSettlePending {
+		e.Terrain.Settle()
+		e.groundPlayers()
+	}
+	if rt.DeferredSettlePending && !e.deferringSettle() && len(rt.TerrainEdits) == 0 {
+		e.Terrain.Settle()
+		rt.DeferredSettlePending = false
+		e.groundPlayers()
+	}
+	e.decayEffects()
+	e.stepFunkySequence()
+	e.stepMirvSequence()
+	if len(rt.TerrainEdits) > 0 {
+		return
+	}
+
+	e.stepFalling()
+	alive := 0
+	var survivor *Player
+	for i := range e.Match.Players {
+		if e.Match.Players[i].Alive {
+			alive++
+			survivor = &e.Match.Players[i]
+		}
+	}
+	if alive <= 1 {
+		if survivor != nil {
+			e.Message = fmt.Sprintf("%s wins round %d", survivor.Config.Name, e.Match.RoundIndex)
+		} else {
+			e.Message = "Round draw"
+		}
+		e.endRound()
+		return
+	}
+
+	if e.Match.Phase == PhaseProjectile && len(rt.Projectiles) == 0 && !e.anyAirborne() && rt.FunkySequence == nil && rt.MirvSequence == nil {
+		e.Match.Phase = PhaseResolve
+		e.Match = NextActivePlayer(e.Match, e.rng)
+	}
+	e.stepAI()
+	e.Terrain.EnsureFloorIntegrity()
+}
+
+// deferringSettle reports whether something still in flight wants the
+// terrain left unsettled: tunnellers, MIRV warheads, cluster sequences and
+// nuke fireballs.
+func (e *Engine) deferringSettle() bool {
+	rt := &e.Runtime
+	if rt.FunkySequence != nil || rt.MirvSequence != nil || e.nukeBurning() {
+		return true
+	}
+	for _, p := range rt.Projectiles {
+		switch p.ProjectileType {
+		case "digger", "sandhog", "mirv-child":
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) nukeBurning() bool {
+	for _, fx := range e.Runtime.Explosions {
+		if fx.Kind == "nuke" {
+			return true
+		}
+	}
+	return false
+}
+
+// stepTerrainEdits advances every queued edit by one tick, applying the
+// share of it that is now due, and settles the terrain once the queue drains.
+func (e *Engine) stepTerrainEdits() {
+	rt := &e.Runtime
+	t := e.Terrain
+	settle, touchedDeferred := false, false
+	var next []TerrainEdit
+	for _, edit := range rt.TerrainEdits {
+		elapsed := edit.Elapsed + FixedDT
+		progress := clamp(elapsed/math.Max(0.05, edit.Duration), 0, 1)
+		size := edit.Length
+		if size == 0 {
+			size = edit.Radius
+		}
+		totalSteps := max(1, int(jsRound(size*1.6)))
+		targetSteps := min(totalSteps, max(edit.AppliedSteps, int(math.Floor(progress*float64(totalSteps)))))
+		for applied := edit.AppliedSteps; applied < targetSteps; applied++ {
+			stepN := float64(applied + 1)
+			switch edit.Mode {
+			case EditCrater:
+				t.CarveCrater(edit.X, edit.Y, math.Max(1, edit.Radius*stepN/float64(totalSteps)), false)
+			case EditAddDirt:
+				amount := edit.Amount
+				if amount == 0 {
+					amount = 1
+				}
+				t.AddDirt(edit.X, edit.Y, edit.Radius, max(1, int(jsRound(amount/float64(totalSteps)))), false, e.rng)
+			case EditAddDisk:
+				t.AddDirtDisk(edit.X, edit.Y, math.Max(1, edit.Radius*stepN/float64(totalSteps)), false)
+			default:
+				length := math.Max(1, edit.Length)
+				from := int(math.Floor(length * float64(applied) / float64(totalSteps)))
+				to := int(math.Floor(length * stepN / float64(totalSteps)))
+				for i := from; i < to; i++ {
+					t.CarveCrater(edit.X, edit.Y+float64(i*2), edit.Radius, false)
+				}
+				if from >= to {
+					continue
+				}
+			}
+			settle = true
+			touchedDeferred = touchedDeferred || edit.DeferSettle
+		}
+		if progress < 1 {
+			edit.Elapsed = elapsed
+			edit.AppliedSteps = targetSteps
+			next = append(next, edit)
+		}
+	}
+	if next == nil {
+		next = []TerrainEdit{}
+	}
+	rt.TerrainEdits = next
+	if touchedDeferred {
+		rt.DeferredSettlePending = true
+	}
+	if len(rt.TerrainEdits) == 0 && settle {
+		if !rt.DeferredSettlePending {
+			t.Settle()
+		} else if !e.deferringSettle() {
+			t.Settle()
+			rt.DeferredSettlePending = false
+		}
+	}
+	e.groundPlayers()
+	e.decayEffects()
+}
+
+// stepFunkySequence plays a funky bomb out: once every bomblet has landed
+// their bursts are released, and when those are done the central blast goes
+// off.
+func (e *Engine) stepFunkySequence() {
+	seq := e.Runtime.FunkySequence
+	if seq == nil {
+		return
+	}
+	switch seq.Stage {
+	case "collecting":
+		if seq.ResolvedSides >= seq.ExpectedSides {
+			seq.Stage = "side-animate"
+			e.releaseFx(seq.SideTag)
+		}
+	case "side-animate":
+		if e.fxPlaying(seq.SideTag, "funky-side") {
+			return
+		}
+		seq.Stage = "central"
+		radius := effectiveBlastRadius(WeaponByID("baby-nuke"))
+		e.pushFx(Explosion{X: seq.CentralX, Y: seq.CentralY, Radius: radius, Life: 1.7, Kind: "nuke", Seed: e.rng.Intn(1000000), Tag: "funky-central"})
+		e.enqueueTerrainEdit(TerrainEdit{Mode: EditCrater, X: seq.CentralX, Y: seq.CentralY, Radius: radius, Duration: 0.62})
+		e.applyDamageAt(seq.CentralX, seq.CentralY, seq.EffectRadius, seq.EffectDamage*10, seq.SplitDepth)
+	default:
+		if e.fxPlaying("funky-central", "") {
+			return
+		}
+		e.settleAfterSequence()
+		e.Runtime.FunkySequence = nil
+	}
+}
+
+// stepMirvSequence releases a MIRV's bursts once every warhead has landed
+// and ends the sequence when they have played.
+func (e *Engine) stepMirvSequence() {
+	seq := e.Runtime.MirvSequence
+	if seq == nil {
+		return
+	}
+	if seq.Stage == "collecting" {
+		if seq.Resolved >= seq.Expected {
+			seq.Stage = "animating"
+			e.releaseFx(seq.Tag)
+		}
+		return
+	}
+	if e.fxPlaying(seq.Tag, "mirv") {
+		return
+	}
+	e.settleAfterSequence()
+	e.Runtime.MirvSequence = nil
+}
+
+func (e *Engine) settleAfterSequence() {
+	if len(e.Runtime.TerrainEdits) > 0 {
+		return
+	}
+	e.Terrain.Settle()
+	e.Runtime.DeferredSettlePending = false
+	e.groundPlayers()
+}
+
+func (e *Engine) releaseFx(tag string) {
+	for i := range e.Runtime.Explosions {
+		if e.Runtime.Explosions[i].Tag == tag {
+			e.Runtime.Explosions[i].Paused = false
+		}
+	}
+}
+
+// fxPlaying reports whether an effect tagged tag, of kind kind if that is
+// set, is still on screen.
+func (e *Engine) fxPlaying(tag, kind string) bool {
+	for _, fx := range e.Runtime.Explosions {
+		if fx.Tag == tag && (kind == "" || fx.Kind == kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// battleTerrain builds the next battle's terrain from the round's rng.
+func (e *Engine) battleTerrain() *Terrain {
+	if e.Map != nil {
+		return TerrainFromMap(e.Map, e.Match.Width, e.Match.Height, e.rng)
+	}
+	return GenerateTerrain(e.Match.Width, e.Match.Height, e.Match.Settings.TerrainPreset, e.rng)
+}
+
+func (e *Engine) endRound() {
+	e.Match.Phase = PhaseRoundEnd
+	e.rng = NewPRNG(RoundSeed(e.seed, e.Match.RoundIndex+1))
+	e.Match = ApplyRoundEnd(e.Match, e.rng)
+	if e.Match.Phase == PhaseMatchEnd {
+		return
+	}
+	e.Terrain = e.battleTerrain()
+	e.Match = PlacePlayers(e.Match, e.Terrain, e.rng)
+	e.Runtime = newRuntime()
+	e.resetAccumulators()
+}
+
+func (e *Engine) anyAirborne() bool {
+	for _, p := range e.Match.Players {
+		if p.Alive && resolveTankGroundY(e.Terrain, p.X, p.Y)-p.Y > 0.75 {
+			return true
+		}
+	}
+	return false
+}
+
+// groundPlayers snaps tanks onto terrain that has shifted a little under
+// them, leaving longer drops to stepFalling.
+func (e *Engine) groundPlayers() {
+	t := e.Terrain
+	for i := range e.Match.Players {
+		p := &e.Match.Players[i]
+		if !p.Alive {
+			continue
+		}
+		groundY := resolveTankGroundY(t, p.X, p.Y)
+		embedded := tankBodyOverlapCountAt(t, clampInt(int(math.Floor(p.X)), 0, t.Width-1), p.Y) > 2
+		if p.Y >= groundY-0.5 && (!embedded || p.Y <= groundY+0.5) {
+			continue
+		}
+		if groundY > p.Y+12 {
+			continue
+		}
+		p.Y = groundY
+		p.FallDistance = 0
+	}
+}
+
+// decayEffects ages explosions, except paused ones, and fading trails.
+func (e *Engine) decayEffects() {
+	explosions := e.Runtime.Explosions[:0]
+	for _, fx := range e.Runtime.Explosions {
+		if !fx.Paused {
+			fx.Life -= FixedDT
+		}
+		if fx.Life > 0 {
+			explosions = append(explosions, fx)
+		}
+	}
+	e.Runtime.Explosions = explosions
+	if e.Match.Settings.ShotTraces {
+		return
+	}
+	trails := e.Runtime.Trails[:0]
+	for _, t := range e.Runtime.Trails {
+		t.Life -= FixedDT * 1.6
+		if t.Life > 0 {
+			trails = append(trails, t)
+		}
+	}
+	if len(trails) > maxTrails {
+		trails = trails[len(trails)-maxTrails:]
+	}
+	e.Runtime.Trails = trails
+}
+
+// stepFalling drops unsupported tanks and applies landing damage.
+func (e *Engine) stepFalling() {
+	for i := range e.Match.Players {
+		p := &e.Match.Players[i]
+		if !p.Alive {
+			continue
+		}
+		groundY := resolveTankGroundY(e.Terrain, p.X, p.Y)
+		fallDist := groundY - p.Y
+		chutes := p.Inventory["parachute"]
+		if fallDist <= 0 {
+			if p.FallDistance == 0 {
+				continue
+			}
+			e.land(p, groundY, p.FallDistance, chutes > 0, chutes > 0 && p.FallDistance > 16)
+			continue
+		}
+		usingParachute := chutes > 0 && p.FallDistance+fallDist > 18
+		speed := 260.0
+		if usingParachute {
+			speed = 62
+		}
+		step := math.Min(fallDist, speed*FixedDT)
+		if p.Y+step >= groundY {
+			e.land(p, groundY, p.FallDistance+step, usingParachute, usingParachute)
+			continue
+		}
+		p.Y += step
+		p.FallDistance += step
+	}
+}
+
+func (e *Engine) land(p *Player, groundY, fall float64, chute, consumeChute bool) {
+	if chute {
+		fall *= 0.25
+	}
+	hp := clamp(p.HP-math.Max(0, fall-8)*0.32, 0, 100)
+	p.Y = groundY
+	e.setHP(p, hp)
+	if consumeChute {
+		p.Inventory = p.Inventory.with("parachute", max(0, p.Inventory["parachute"]-1))
+	}
+	p.FallDistance = 0
+}
+
+func (e *Engine) setHP(p *Player, hp float64) {
+	if p.Alive && hp <= 0 {
+		e.tankDeathFx(*p)
+	}
+	p.HP = hp
+	p.MaxPower = maxPowerForHP(hp)
+	p.Power = clamp(p.Power, 0, p.MaxPower)
+	p.Alive = hp > 0
+}
+
+// applyDamageAt mirrors applyDamageAt in stepSimulation.
+func (e *Engine) applyDamageAt(x, y, blastRadius, weaponDamage float64, splitDepth int) {
+	if splitDepth > 0 {
+		weaponDamage *= 0.8
+	}
+	for i := range e.Match.Players {
+		p := &e.Match.Players[i]
+		if !p.Alive {
+			continue
+		}
+		recent := e.Runtime.Explosions
+		if len(recent) > recentExplosionWindow {
+			recent = recent[len(recent)-recentExplosionWindow:]
+		}
+		secondary := 0.0
+		for _, fx := range recent {
+			if d := math.Hypot(p.X-fx.X, p.Y-fx.Y); d <= fx.Radius {
+				secondary += (fx.Radius - d) / math.Max(1, fx.Radius) * 8
+			}
+		}
+		dmg := ComputeExplosionDamage(DamageInput{
+			Dist:            math.Hypot(p.X-x, p.Y-y),
+			BlastRadius:     blastRadius,
+			WeaponDamage:    weaponDamage,
+			SecondaryDamage: secondary,
+			Shield:          p.Shield,
+			Armor:           p.Armor,
+		})
+		if dmg.HPLoss <= 0 {
+			continue
+		}
+		p.Shield = dmg.NextShield
+		p.Armor = dmg.NextArmor
+		e.setHP(p, clamp(p.HP-dmg.HPLoss, 0, 100))
+	}
+}
+
+func effectiveBlastRadius(w Weapon) float64 {
+	if w.ID == "baby-nuke" || w.ID == "nuke" {
+		return jsRound(w.BlastRadius * 1.12)
+	}
+	return w.BlastRadius
+}
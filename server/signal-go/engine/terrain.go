@@ -0,0 +1,537 @@
+package engine
+
+import (
+	"encoding/base64"
+	"math"
+	"sort"
+)
+
+// FloorThickness is the indestructible floor at the bottom of the field.
+const FloorThickness = 1
+
+// Terrain mirrors TerrainState. Mask holds one byte per pixel, row-major,
+// 1 for solid ground.
+type Terrain struct {
+	Width        int
+	Height       int
+	Revision     int
+	Heights      []int
+	Mask         []byte
+	ColorIndices []byte
+	ColorPalette [][3]int
+}
+
+// TerrainPayload is the wire form from stateCodec.ts.
+type TerrainPayload struct {
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	Revision        int      `json:"revision"`
+	Heights         []int    `json:"heights"`
+	MaskB64         string   `json:"maskB64"`
+	ColorIndicesB64 string   `json:"colorIndicesB64,omitempty"`
+	ColorPalette    [][3]int `json:"colorPalette,omitempty"`
+}
+
+// Payload encodes t the way encodeTerrain does.
+func (t *Terrain) Payload() TerrainPayload {
+	out := TerrainPayload{
+		Width:        t.Width,
+		Height:       t.Height,
+		Revision:     t.Revision,
+		Heights:      t.Heights,
+		MaskB64:      base64.StdEncoding.EncodeToString(t.Mask),
+		ColorPalette: t.ColorPalette,
+	}
+	if t.ColorIndices != nil {
+		out.ColorIndicesB64 = base64.StdEncoding.EncodeToString(t.ColorIndices)
+	}
+	return out
+}
+
+func floorTop(height int) int {
+	return height - FloorThickness
+}
+
+func (t *Terrain) solid(x, y int) bool {
+	if x < 0 || x >= t.Width || y < 0 || y >= t.Height {
+		return false
+	}
+	return t.Mask[y*t.Width+x] == 1
+}
+
+// SolidAt reports whether the pixel containing (x, y) is ground.
+func (t *Terrain) SolidAt(x, y float64) bool {
+	return t.solid(int(math.Floor(x)), int(math.Floor(y)))
+}
+
+func (t *Terrain) stampFloor() {
+	for y := floorTop(t.Height); y < t.Height; y++ {
+		row := t.Mask[y*t.Width : (y+1)*t.Width]
+		for i := range row {
+			row[i] = 1
+		}
+	}
+}
+
+func (t *Terrain) rebuildHeights() {
+	t.stampFloor()
+	if len(t.Heights) != t.Width {
+		t.Heights = make([]int, t.Width)
+	}
+	for x := 0; x < t.Width; x++ {
+		y := 0
+		for y < t.Height && t.Mask[y*t.Width+x] == 0 {
+			y++
+		}
+		t.Heights[x] = y
+	}
+}
+
+func (t *Terrain) settleMask() {
+	for x := 0; x < t.Width; x++ {
+		solids := 0
+		for y := 0; y < t.Height; y++ {
+			solids += int(t.Mask[y*t.Width+x])
+		}
+		top := t.Height - solids
+		for y := 0; y < t.Height; y++ {
+			if y >= top {
+				t.Mask[y*t.Width+x] = 1
+			} else {
+				t.Mask[y*t.Width+x] = 0
+			}
+		}
+	}
+	t.stampFloor()
+}
+
+func (t *Terrain) touched() {
+	t.Revision++
+	t.rebuildHeights()
+}
+
+// EnsureFloorIntegrity restores any missing floor pixels.
+func (t *Terrain) EnsureFloorIntegrity() {
+	for y := floorTop(t.Height); y < t.Height; y++ {
+		for x := 0; x < t.Width; x++ {
+			if t.Mask[y*t.Width+x] != 1 {
+				t.touched()
+				return
+			}
+		}
+	}
+}
+
+// Settle drops every floating pixel to the bottom of its column.
+func (t *Terrain) Settle() {
+	t.settleMask()
+	t.touched()
+}
+
+// CarveCrater removes a disc of ground, optionally settling what is left.
+func (t *Terrain) CarveCrater(cx, cy, radius float64, settle bool) {
+	r2 := radius * radius
+	minX := max(0, int(math.Floor(cx-radius)))
+	maxX := min(t.Width-1, int(math.Ceil(cx+radius)))
+	minY := max(0, int(math.Floor(cy-radius)))
+	maxY := min(t.Height-1, int(math.Ceil(cy+radius)))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			dx := float64(x) - cx
+			dy := float64(y) - cy
+			if dx*dx+dy*dy <= r2 {
+				t.Mask[y*t.Width+x] = 0
+			}
+		}
+	}
+	if settle {
+		t.settleMask()
+	}
+	t.touched()
+}
+
+// CarveTunnel digs a vertical run of small craters below (x, y).
+func (t *Terrain) CarveTunnel(x, y float64, length int, radius float64, settle bool) {
+	for i := 0; i < length; i++ {
+		t.CarveCrater(x, y+float64(i*2), radius, false)
+	}
+	if settle {
+		t.Settle()
+	}
+}
+
+// AddDirt piles dirt in a cone centred on cx.
+func (t *Terrain) AddDirt(cx, cy, radius float64, amount int, settle bool, rng *PRNG) {
+	minX := max(0, int(math.Floor(cx-radius)))
+	maxX := min(t.Width-1, int(math.Ceil(cx+radius)))
+	for x := minX; x <= maxX; x++ {
+		influence := math.Max(0, 1-math.Abs(float64(x)-cx)/math.Max(1, radius))
+		raiseBy := int(math.Max(0, math.Round(influence*float64(amount))))
+		if raiseBy <= 0 {
+			continue
+		}
+		top := t.Heights[x]
+		for i := 0; i < raiseBy; i++ {
+			if y := top - 1 - i; y >= 0 {
+				t.Mask[y*t.Width+x] = 1
+			}
+		}
+		if rng.Float64() < influence*0.5 {
+			scatterY := max(0, int(math.Floor(cy-rng.Float64()*radius*0.8)))
+			t.Mask[scatterY*t.Width+x] = 1
+		}
+	}
+	if settle {
+		t.settleMask()
+	}
+	t.touched()
+}
+
+// AddDirtDisk fills a disc with ground.
+func (t *Terrain) AddDirtDisk(cx, cy, radius float64, settle bool) {
+	r2 := radius * radius
+	minX := max(0, int(math.Floor(cx-radius)))
+	maxX := min(t.Width-1, int(math.Ceil(cx+radius)))
+	minY := max(0, int(math.Floor(cy-radius)))
+	maxY := min(t.Height-1, int(math.Ceil(cy+radius)))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			dx := float64(x) - cx
+			dy := float64(y) - cy
+			if dx*dx+dy*dy <= r2 {
+				t.Mask[y*t.Width+x] = 1
+			}
+		}
+	}
+	if settle {
+		t.settleMask()
+	}
+	t.touched()
+}
+
+// AddLiquidDirt floods the lowest reachable air cells around the impact with
+// a fixed volume of dirt.
+func (t *Terrain) AddLiquidDirt(cx, cy float64, rangeX, maxCells int, settle bool) {
+	startX := clampInt(int(math.Floor(cx)), 0, t.Width-1)
+	startY := clampInt(int(math.Floor(cy))-1, 0, t.Height-1)
+	for startY > 0 && t.Mask[startY*t.Width+startX] == 1 {
+		startY--
+	}
+	minX := max(0, startX-rangeX)
+	maxX := min(t.Width-1, startX+rangeX)
+	type cell struct{ x, y int }
+	queue := []cell{{startX, startY}}
+	visited := make([]bool, t.Width*t.Height)
+	var basin []cell
+	for len(queue) > 0 {
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if c.x < minX || c.x > maxX || c.y < 0 || c.y >= t.Height {
+			continue
+		}
+		idx := c.y*t.Width + c.x
+		if visited[idx] || t.Mask[idx] == 1 {
+			continue
+		}
+		visited[idx] = true
+		basin = append(basin, c)
+		queue = append(queue, cell{c.x + 1, c.y}, cell{c.x - 1, c.y}, cell{c.x, c.y + 1}, cell{c.x, c.y - 1})
+	}
+	if len(basin) == 0 {
+		return
+	}
+	sort.SliceStable(basin, func(i, j int) bool {
+		if basin[i].y != basin[j].y {
+			return basin[i].y > basin[j].y
+		}
+		return abs(basin[i].x-startX) < abs(basin[j].x-startX)
+	})
+	budget := max(1, min(maxCells, len(basin)))
+	for _, c := range basin[:budget] {
+		t.Mask[c.y*t.Width+c.x] = 1
+	}
+	if settle {
+		t.settleMask()
+	}
+	t.touched()
+}
+
+// Scorch darkens the ground in a disc around (cx, cy), most strongly at the
+// centre, the way burning napalm blackens it. It only recolours, so terrain
+// without colours is left alone.
+func (t *Terrain) Scorch(cx, cy, radius, strength float64, rng *PRNG) {
+	if t.ColorIndices == nil || len(t.ColorPalette) == 0 {
+		return
+	}
+	scorch := scorchMap(t.ColorPalette)
+	r2 := radius * radius
+	minX := max(0, int(math.Floor(cx-radius)))
+	maxX := min(t.Width-1, int(math.Ceil(cx+radius)))
+	minY := max(0, int(math.Floor(cy-radius)))
+	maxY := min(t.Height-1, int(math.Ceil(cy+radius)))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			dx := float64(x) - cx
+			dy := float64(y) - cy
+			d2 := dx*dx + dy*dy
+			if d2 > r2 {
+				continue
+			}
+			idx := y*t.Width + x
+			if t.Mask[idx] != 1 {
+				continue
+			}
+			influence := 1 - d2/math.Max(1, r2)
+			if rng.Float64() > influence*0.9*strength {
+				continue
+			}
+			t.ColorIndices[idx] = scorch[t.ColorIndices[idx]&0x0f]
+		}
+	}
+	t.Revision++
+}
+
+func luminance(rgb [3]int) float64 {
+	return float64(rgb[0])*0.2126 + float64(rgb[1])*0.7152 + float64(rgb[2])*0.0722
+}
+
+// scorchMap maps each palette index to the palette colour closest to two
+// thirds of its brightness, as getScorchMap does.
+func scorchMap(palette [][3]int) [16]byte {
+	var out [16]byte
+	for i := range out {
+		current := palette[0]
+		if i < len(palette) {
+			current = palette[i]
+		}
+		currentLum := luminance(current)
+		best, bestScore := i, math.Inf(1)
+		for j := 0; j < min(16, len(palette)); j++ {
+			lum := luminance(palette[j])
+			if lum >= currentLum*0.92 {
+				continue
+			}
+			if score := math.Abs(lum - currentLum*0.65); score < bestScore {
+				best, bestScore = j, score
+			}
+		}
+		out[i] = byte(best)
+	}
+	return out
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// TerrainFromHeights builds a mask from per-column surface heights.
+func TerrainFromHeights(width, height int, heights []int) *Terrain {
+	t := &Terrain{Width: width, Height: height, Mask: make([]byte, width*height), Heights: make([]int, width)}
+	for x := 0; x < width; x++ {
+		h := height - 1
+		if x < len(heights) {
+			h = heights[x]
+		}
+		top := clampInt(h, 0, height-1)
+		for y := top; y < height; y++ {
+			t.Mask[y*width+x] = 1
+		}
+		t.Heights[x] = min(top, floorTop(height))
+	}
+	t.stampFloor()
+	return t
+}
+
+func fractalHeight(x, width int, base float64, preset string) float64 {
+	t := float64(x) / float64(width)
+	hills := math.Sin(t*math.Pi*2)*0.15 + math.Sin(t*math.Pi*7.2+0.3)*0.08
+	crinkles := math.Sin(t*math.Pi*22+2.3) * 0.02
+	noise := (math.Sin(t*130.13) + math.Sin(t*53.7) + math.Sin(t*11.9)) * 0.01
+	switch preset {
+	case "canyon":
+		canyon := math.Exp(-math.Pow((t-0.5)*5.5, 2)) * 0.25
+		return base + hills + crinkles + noise - canyon
+	case "islands":
+		islandMask := math.Max(0, math.Sin(t*math.Pi*3.8-0.7)) * 0.22
+		return base + hills*0.4 + islandMask - 0.06
+	}
+	return base + hills + crinkles + noise
+}
+
+// GenerateTerrain mirrors generateTerrain. Presets the engine cannot build
+// procedurally ("random", "mtn") pick one of the procedural shapes.
+func GenerateTerrain(width, height int, preset string, rng *PRNG) *Terrain {
+	switch preset {
+	case "rolling", "canyon", "islands":
+	default:
+		preset = []string{"rolling", "canyon", "islands"}[rng.Intn(3)]
+	}
+	raw := make([]float64, width)
+	lo := math.Floor(float64(height) * 0.28)
+	hi := math.Floor(float64(height) * 0.9)
+	for x := range raw {
+		raw[x] = math.Max(lo, math.Min(hi, math.Floor(float64(height)*fractalHeight(x, width, 0.6, preset))))
+	}
+	return TerrainFromHeights(width, height, SmoothTerrainHeights(raw, height))
+}
+
+// TerrainFromMap mirrors terrainFromParsedMtn: it fits a random crop of a
+// map, given at its own size, to the field, moves its average height into
+// the usual band and carries the map's colors over.
+func TerrainFromMap(src *Terrain, width, height int, rng *PRNG) *Terrain {
+	cropX, cropW := pickMapCrop(src.Width, src.Height, width, height, rng)
+	sourceMax := max(1, src.Height-1)
+	targetMax := max(1, height-1)
+	sourceColumn := func(x int) (sx, top int) {
+		sx = cropX + x*cropW/width
+		if sx < len(src.Heights) {
+			return sx, src.Heights[sx]
+		}
+		return sx, sourceMax
+	}
+	projected := make([]float64, width)
+	for x := range projected {
+		_, top := sourceColumn(x)
+		projected[x] = jsRound(float64(top) / float64(sourceMax) * float64(targetMax))
+	}
+	shifted := normalizeAverageHeight(projected, height, rng)
+	t := TerrainFromHeights(width, height, smoothHeights(shifted, height, 0, 1, false))
+	t.ColorIndices = make([]byte, width*height)
+	t.ColorPalette = src.ColorPalette
+	pixel := func(sx, sy int, fallback byte) byte {
+		if sx < 0 || sx >= src.Width || sy < 0 || sy >= src.Height || src.ColorIndices == nil {
+			return fallback
+		}
+		return src.ColorIndices[sy*src.Width+sx]
+	}
+	for x := 0; x < width; x++ {
+		sx, sourceTop := sourceColumn(x)
+		targetTop := t.Heights[x]
+		targetDepth := max(1, height-1-targetTop)
+		sourceDepth := max(1, src.Height-1-sourceTop)
+		for y := targetTop; y < height; y++ {
+			sy := min(src.Height-1, sourceTop+(y-targetTop)*sourceDepth/targetDepth)
+			idx := pixel(sx, sy, 0)
+			if idx == 0 {
+				idx = pixel(sx, min(src.Height-1, sourceTop+1), 2)
+			}
+			t.ColorIndices[y*width+x] = idx & 0x0F
+		}
+	}
+	return t
+}
+
+// pickMapCrop mirrors pickMtnCropForTarget; crops always keep the full
+// height.
+func pickMapCrop(sourceW, sourceH, targetW, targetH int, rng *PRNG) (x, w int) {
+	aspect := float64(targetW) / float64(max(1, targetH))
+	minAspectWidth := int(jsRound(float64(sourceH) * aspect))
+	floor := min(sourceW, 64)
+	minCropW := clampInt(max(minAspectWidth, int(jsRound(float64(sourceW)*0.58))), floor, sourceW)
+	w = clampInt(int(jsRound(float64(minCropW)+float64(sourceW-minCropW)*rng.Float64())), floor, sourceW)
+	if maxX := max(0, sourceW-w); maxX > 0 {
+		x = int(math.Floor(rng.Float64() * float64(maxX+1)))
+	}
+	return x, w
+}
+
+// normalizeAverageHeight mirrors normalizeAverageHeightToBand.
+func normalizeAverageHeight(heights []float64, terrainHeight int, rng *PRNG) []float64 {
+	if len(heights) == 0 {
+		return heights
+	}
+	sum := 0.0
+	for _, h := range heights {
+		sum += h
+	}
+	targetMin := jsRound(float64(terrainHeight) * 0.4)
+	targetMax := jsRound(float64(terrainHeight) * 0.65)
+	delta := jsRound(targetMin+(targetMax-targetMin)*rng.Float64()) - sum/float64(len(heights))
+	out := make([]float64, len(heights))
+	for i, h := range heights {
+		out[i] = clamp(jsRound(h+delta), 0, float64(terrainHeight-1))
+	}
+	return out
+}
+
+// jsRound rounds halves up like Math.round.
+func jsRound(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
+
+func movingAverage(values []float64, radius int) []float64 {
+	out := make([]float64, len(values))
+	for i := range values {
+		sum, count := 0.0, 0
+		for dx := -radius; dx <= radius; dx++ {
+			x := i + dx
+			if x < 0 || x >= len(values) {
+				continue
+			}
+			sum += values[x]
+			count++
+		}
+		out[i] = sum / float64(max(1, count))
+	}
+	return out
+}
+
+func applySlopeLimit(values []float64, maxDelta float64) {
+	for i := 1; i < len(values); i++ {
+		values[i] = clamp(values[i], values[i-1]-maxDelta, values[i-1]+maxDelta)
+	}
+	for i := len(values) - 2; i >= 0; i-- {
+		values[i] = clamp(values[i], values[i+1]-maxDelta, values[i+1]+maxDelta)
+	}
+}
+
+// SmoothTerrainHeights mirrors smoothTerrainHeights with default options.
+func SmoothTerrainHeights(raw []float64, terrainHeight int) []int {
+	return smoothHeights(raw, terrainHeight, 0.3, 0.86, true)
+}
+
+// smoothHeights is smoothTerrainHeights with its minTopRatio, maxTopRatio
+// and breakPlateaus options; maxSlopeDelta is always the default.
+func smoothHeights(raw []float64, terrainHeight int, minTopRatio, maxTopRatio float64, breakPlateaus bool) []int {
+	avgWide := movingAverage(raw, 4)
+	avgTight := movingAverage(raw, 2)
+	minTop := math.Floor(float64(terrainHeight) * minTopRatio)
+	maxTop := math.Floor(float64(terrainHeight) * maxTopRatio)
+	limited := make([]float64, len(raw))
+	for i, v := range raw {
+		limited[i] = clamp(v*0.5+avgTight[i]*0.33+avgWide[i]*0.17, minTop, maxTop)
+	}
+	applySlopeLimit(limited, 5)
+
+	out := make([]int, len(limited))
+	for i, v := range limited {
+		out[i] = int(math.Round(clamp(v, minTop, maxTop)))
+	}
+	if !breakPlateaus {
+		return out
+	}
+	const runThreshold = 6
+	for i := 0; i < len(out); {
+		j := i + 1
+		for j < len(out) && out[j] == out[i] {
+			j++
+		}
+		if j-i >= runThreshold {
+			for k := i + 1; k < j-1; k++ {
+				delta := 0
+				switch (k - i) % 4 {
+				case 0:
+					delta = -1
+				case 2:
+					delta = 1
+				}
+				out[k] = clampInt(out[k]+delta, int(minTop), int(maxTop))
+			}
+		}
+		i = j
+	}
+	return out
+}
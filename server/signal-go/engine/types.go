@@ -0,0 +1,273 @@
+package engine
+
+import "fmt"
+
+// Phase is the match state machine position, as MatchPhase in game.ts.
+type Phase string
+
+const (
+	PhaseAim        Phase = "aim"
+	PhaseProjectile Phase = "projectile"
+	PhaseResolve    Phase = "resolve"
+	PhaseRoundEnd   Phase = "roundEnd"
+	PhaseMatchEnd   Phase = "matchEnd"
+)
+
+const ShieldNone = "none"
+
+// Settings mirrors GameSettings.
+type Settings struct {
+	RoundsToWin      int      `json:"roundsToWin"`
+	Gravity          float64  `json:"gravity"`
+	WindMode         string   `json:"windMode"`
+	TerrainPreset    string   `json:"terrainPreset"`
+	CashStart        int      `json:"cashStart"`
+	TurnTimeLimitSec *float64 `json:"turnTimeLimitSec"`
+	RetroPalette     bool     `json:"retroPalette"`
+	PowerAdjustHz    float64  `json:"powerAdjustHz"`
+	FreeFireMode     bool     `json:"freeFireMode"`
+	TankColorTrails  bool     `json:"tankColorTrails"`
+	ShotTraces       bool     `json:"shotTraces"`
+}
+
+// DefaultSettings returns DEFAULT_SETTINGS.
+func DefaultSettings() Settings {
+	return Settings{
+		RoundsToWin:     5,
+		Gravity:         260,
+		WindMode:        "off",
+		TerrainPreset:   "random",
+		CashStart:       200000,
+		RetroPalette:    true,
+		PowerAdjustHz:   15,
+		TankColorTrails: true,
+	}
+}
+
+// Normalize clamps settings into playable ranges like normalizeSettings.
+func (s Settings) Normalize() Settings {
+	s.Gravity = clamp(s.Gravity, 160, 420)
+	s.RoundsToWin = clampInt(s.RoundsToWin, 1, 9)
+	s.CashStart = clampInt(s.CashStart, 0, 500000)
+	s.PowerAdjustHz = clamp(s.PowerAdjustHz, 2, 40)
+	return s
+}
+
+// Turn timer bounds, as the settings screen offers them.
+const (
+	MinTurnTimeLimitSec = 5
+	MaxTurnTimeLimitSec = 60
+)
+
+// Validate rejects settings a client could not have produced from the
+// settings screen: unknown modes and presets and turn timers out of range.
+// Values that Normalize clamps are left to it.
+func (s Settings) Validate() error {
+	switch s.WindMode {
+	case "off", "constant", "changing":
+	default:
+		return fmt.Errorf("unknown wind mode %q", s.WindMode)
+	}
+	switch s.TerrainPreset {
+	case "rolling", "canyon", "islands", "random", "mtn":
+	default:
+		return fmt.Errorf("unknown terrain preset %q", s.TerrainPreset)
+	}
+	if t := s.TurnTimeLimitSec; t != nil && (*t < MinTurnTimeLimitSec || *t > MaxTurnTimeLimitSec) {
+		return fmt.Errorf("turn timer must be off or between %d and %d seconds", MinTurnTimeLimitSec, MaxTurnTimeLimitSec)
+	}
+	return nil
+}
+
+// PlayerConfig mirrors PlayerConfig.
+type PlayerConfig struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	AILevel    string `json:"aiLevel"`
+	ColorIndex int    `json:"colorIndex"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// Inventory maps weapon ids to owned quantity.
+type Inventory map[string]int
+
+// with returns a copy of inv with id set to n, so players can be treated as
+// values the way the TS code spreads them.
+func (inv Inventory) with(id string, n int) Inventory {
+	out := make(Inventory, len(inv)+1)
+	for k, v := range inv {
+		out[k] = v
+	}
+	out[id] = n
+	return out
+}
+
+// Player mirrors PlayerState.
+type Player struct {
+	Config           PlayerConfig `json:"config"`
+	Cash             int          `json:"cash"`
+	Armor            float64      `json:"armor"`
+	Shield           float64      `json:"shield"`
+	ShieldType       string       `json:"shieldType"`
+	Fuel             float64      `json:"fuel"`
+	Inventory        Inventory    `json:"inventory"`
+	Alive            bool         `json:"alive"`
+	Score            int          `json:"score"`
+	HP               float64      `json:"hp"`
+	MaxPower         float64      `json:"maxPower"`
+	X                float64      `json:"x"`
+	Y                float64      `json:"y"`
+	FallDistance     float64      `json:"fallDistance"`
+	Angle            float64      `json:"angle"`
+	Power            float64      `json:"power"`
+	SelectedWeaponID string       `json:"selectedWeaponId"`
+}
+
+// MatchState mirrors MatchState.
+type MatchState struct {
+	Settings       Settings `json:"settings"`
+	Players        []Player `json:"players"`
+	RoundIndex     int      `json:"roundIndex"`
+	Wind           float64  `json:"wind"`
+	ActivePlayerID string   `json:"activePlayerId"`
+	Phase          Phase    `json:"phase"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+}
+
+// Projectile mirrors ProjectileState. The staged types (rollers, diggers,
+// sandhogs, napalm burns and delayed blasts) keep their progress in the
+// optional fields, as in the TS.
+type Projectile struct {
+	X              float64 `json:"x"`
+	Y              float64 `json:"y"`
+	VX             float64 `json:"vx"`
+	VY             float64 `json:"vy"`
+	OwnerID        string  `json:"ownerId"`
+	WeaponID       string  `json:"weaponId"`
+	TTL            float64 `json:"ttl"`
+	SplitDepth     int     `json:"splitDepth"`
+	Color          string  `json:"color,omitempty"`
+	ProjectileType string  `json:"projectileType,omitempty"`
+	EffectRadius   float64 `json:"effectRadius,omitempty"`
+	EffectDamage   float64 `json:"effectDamage,omitempty"`
+	Direction      float64 `json:"direction,omitempty"`
+	State          int     `json:"state,omitempty"`
+	Timer          float64 `json:"timer,omitempty"`
+	Seed           int     `json:"seed,omitempty"`
+}
+
+// Explosion is a visual effect entry in the runtime, sized like the TS fx.
+// Paused effects hold their life until the cluster sequence they are tagged
+// with releases them.
+type Explosion struct {
+	ID         int     `json:"id"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Radius     float64 `json:"radius"`
+	Life       float64 `json:"life"`
+	MaxLife    float64 `json:"maxLife"`
+	Color      string  `json:"color,omitempty"`
+	Kind       string  `json:"kind,omitempty"`
+	BeamHeight float64 `json:"beamHeight,omitempty"`
+	Seed       int     `json:"seed,omitempty"`
+	Direction  float64 `json:"direction,omitempty"`
+	Paused     bool    `json:"paused,omitempty"`
+	Tag        string  `json:"tag,omitempty"`
+}
+
+// Trail is a rendered projectile path segment.
+type Trail struct {
+	X1      float64 `json:"x1"`
+	Y1      float64 `json:"y1"`
+	X2      float64 `json:"x2"`
+	Y2      float64 `json:"y2"`
+	OwnerID string  `json:"ownerId"`
+	Life    float64 `json:"life"`
+	Color   string  `json:"color,omitempty"`
+}
+
+// Terrain edit modes.
+const (
+	EditCrater  = "crater"
+	EditTunnel  = "tunnel"
+	EditAddDirt = "addDirt"
+	EditAddDisk = "addDisk"
+)
+
+// TerrainEdit is a deformation applied a little at a time over Duration, so
+// craters open and dirt piles up on screen instead of all at once.
+type TerrainEdit struct {
+	Mode         string  `json:"mode"`
+	X            float64 `json:"x"`
+	Y            float64 `json:"y"`
+	Radius       float64 `json:"radius"`
+	Length       float64 `json:"length,omitempty"`
+	Amount       float64 `json:"amount,omitempty"`
+	DeferSettle  bool    `json:"deferSettle,omitempty"`
+	Duration     float64 `json:"duration"`
+	Elapsed      float64 `json:"elapsed"`
+	AppliedSteps int     `json:"appliedSteps"`
+}
+
+// FunkySequence tracks a funky bomb: its bomblets land, their bursts play
+// out, then the central blast goes off.
+type FunkySequence struct {
+	Stage         string  `json:"stage"`
+	ExpectedSides int     `json:"expectedSides"`
+	ResolvedSides int     `json:"resolvedSides"`
+	SideTag       string  `json:"sideTag"`
+	CentralX      float64 `json:"centralX"`
+	CentralY      float64 `json:"centralY"`
+	OwnerID       string  `json:"ownerId"`
+	SplitDepth    int     `json:"splitDepth"`
+	EffectRadius  float64 `json:"effectRadius"`
+	EffectDamage  float64 `json:"effectDamage"`
+}
+
+// MirvSequence holds a MIRV's bursts until every warhead has landed.
+type MirvSequence struct {
+	Stage    string `json:"stage"`
+	Expected int    `json:"expected"`
+	Resolved int    `json:"resolved"`
+	Tag      string `json:"tag"`
+	WeaponID string `json:"weaponId"`
+}
+
+// Runtime mirrors the RuntimeState the browser host broadcasts.
+type Runtime struct {
+	Projectiles           []Projectile   `json:"projectiles"`
+	Explosions            []Explosion    `json:"explosions"`
+	Trails                []Trail        `json:"trails"`
+	TerrainEdits          []TerrainEdit  `json:"terrainEdits"`
+	DeferredSettlePending bool           `json:"deferredSettlePending"`
+	FunkySequence         *FunkySequence `json:"funkySequence"`
+	MirvSequence          *MirvSequence  `json:"mirvSequence"`
+}
+
+func newRuntime() Runtime {
+	return Runtime{
+		Projectiles:  []Projectile{},
+		Explosions:   []Explosion{},
+		Trails:       []Trail{},
+		TerrainEdits: []TerrainEdit{},
+	}
+}
+
+// Input mirrors GameInputPayload.input.
+type Input struct {
+	MoveLeft         bool     `json:"moveLeft"`
+	MoveRight        bool     `json:"moveRight"`
+	Alt              bool     `json:"alt"`
+	Left             bool     `json:"left"`
+	Right            bool     `json:"right"`
+	Up               bool     `json:"up"`
+	Down             bool     `json:"down"`
+	FastUp           bool     `json:"fastUp"`
+	FastDown         bool     `json:"fastDown"`
+	FirePressed      bool     `json:"firePressed"`
+	WeaponCycle      int      `json:"weaponCycle"`
+	ToggleShieldMenu bool     `json:"toggleShieldMenu"`
+	PowerSet         *float64 `json:"powerSet"`
+}
@@ -0,0 +1,163 @@
+package engine
+
+import "math"
+
+// Weapon mirrors WeaponDef in the TS catalog.
+type Weapon struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	Price           int     `json:"price"`
+	PackPrice       int     `json:"packPrice"`
+	PackQty         int     `json:"packQty"`
+	Category        string  `json:"category"`
+	Damage          float64 `json:"damage"`
+	BlastRadius     float64 `json:"blastRadius"`
+	ProjectileCount int     `json:"projectileCount"`
+	SpreadDeg       float64 `json:"spreadDeg"`
+	Special         string  `json:"special"`
+	TerrainEffect   string  `json:"terrainEffect"`
+	UnlockTier      int     `json:"unlockTier"`
+}
+
+// StarterWeaponID is the free, unlimited weapon every tank starts with.
+const StarterWeaponID = "baby-missile"
+
+func weapon(id, name string, packPrice, packQty int, category string, damage, blastRadius float64, projectileCount int, spreadDeg float64, special, terrainEffect string, unlockTier int) Weapon {
+	return Weapon{
+		ID:              id,
+		Name:            name,
+		Price:           max(1, int(math.Floor(float64(packPrice)/float64(packQty)))),
+		PackPrice:       packPrice,
+		PackQty:         packQty,
+		Category:        category,
+		Damage:          damage,
+		BlastRadius:     blastRadius,
+		ProjectileCount: projectileCount,
+		SpreadDeg:       spreadDeg,
+		Special:         special,
+		TerrainEffect:   terrainEffect,
+		UnlockTier:      unlockTier,
+	}
+}
+
+// Weapons is the WEAPONS catalog from WeaponCatalog.ts, in the same order.
+var Weapons = []Weapon{
+	weapon("baby-missile", "Baby Missile", 0, 1, "weapons", 24, 10, 1, 0, "normal", "crater", 0),
+	weapon("missile", "Missile", 1200, 10, "weapons", 32, 14, 1, 0, "normal", "crater", 0),
+	weapon("riot-charge", "Riot Charge", 1851, 10, "weapons", 16, 8, 1, 0, "normal", "crater", 0),
+	weapon("riot-blast", "Riot Blast", 3606, 5, "weapons", 24, 12, 1, 0, "normal", "crater", 0),
+	weapon("riot-bomb", "Riot Bomb", 3662, 5, "weapons", 30, 16, 1, 0, "normal", "crater", 1),
+	weapon("heavy-riot-bomb", "Heavy Riot Bomb", 3179, 2, "weapons", 42, 22, 1, 0, "normal", "crater", 1),
+	weapon("baby-nuke", "Baby Nuke", 20000, 3, "weapons", 90, 60, 1, 0, "nuke", "crater", 1),
+	weapon("nuke", "Nuke", 40000, 1, "weapons", 130, 100, 1, 0, "nuke", "crater", 2),
+	weapon("leapfrog", "LeapFrog", 8022, 2, "weapons", 30, 16, 1, 0, "normal", "crater", 1),
+	weapon("sand-bomb", "Sand Bomb", 5000, 1, "earthworks", 0, 0, 1, 0, "normal", "none", 0),
+	weapon("ton-of-dirt", "Ton of Dirt", 4847, 2, "earthworks", 0, 0, 1, 0, "normal", "none", 1),
+	weapon("liquid-dirt", "Liquid Dirt", 3467, 5, "earthworks", 0, 0, 1, 0, "normal", "none", 1),
+	weapon("baby-roller", "Baby Roller", 7000, 10, "weapons", 26, 15, 1, 0, "roller", "crater", 1),
+	weapon("roller", "Roller", 13000, 5, "weapons", 42, 30, 1, 0, "roller", "crater", 1),
+	weapon("heavy-roller", "Heavy Roller", 20000, 2, "weapons", 66, 55, 1, 0, "roller", "crater", 2),
+	weapon("baby-digger", "Baby Digger", 2000, 10, "earthworks", 0, 10, 1, 0, "drill", "tunnel", 0),
+	weapon("digger", "Digger", 4000, 5, "earthworks", 0, 16, 1, 0, "drill", "tunnel", 0),
+	weapon("heavy-digger", "Heavy Digger", 6000, 2, "earthworks", 0, 22, 1, 0, "drill", "tunnel", 1),
+	weapon("baby-sandhog", "Baby Sandhog", 6899, 10, "earthworks", 0, 10, 1, 0, "drill", "tunnel", 1),
+	weapon("sandhog", "Sandhog", 11830, 5, "earthworks", 0, 16, 1, 0, "drill", "tunnel", 1),
+	weapon("heavy-sandhog", "Heavy Sandhog", 16822, 2, "earthworks", 0, 22, 1, 0, "drill", "tunnel", 2),
+	weapon("funky-bomb", "Funky Bomb", 30000, 1, "weapons", 55, 60, 1, 0, "cluster", "crater", 2),
+	weapon("napalm", "Napalm", 10000, 1, "weapons", 24, 24, 1, 0, "napalm", "burn", 1),
+	weapon("hot-napalm", "Hot Napalm", 20000, 1, "weapons", 35, 34, 1, 0, "napalm", "burn", 2),
+	weapon("tracer", "Tracer", 7, 20, "weapons", 0, 0, 1, 0, "normal", "none", 0),
+	weapon("smoke-tracer", "Smoke Tracer", 475, 10, "weapons", 0, 0, 1, 0, "normal", "none", 0),
+	weapon("mirv", "MIRV", 35000, 1, "weapons", 50, 25, 1, 0, "cluster", "crater", 2),
+	weapon("death-head", "Death's Head", 90000, 1, "weapons", 90, 55, 1, 0, "cluster", "crater", 3),
+	weapon("regular-shield", "Regular Shield", 20000, 1, "misc", 0, 0, 0, 0, "normal", "none", 0),
+	weapon("heavy-shield", "Heavy Shield", 35000, 1, "misc", 0, 0, 0, 0, "normal", "none", 1),
+	weapon("bouncy-shield", "Bouncy Shield", 30000, 1, "misc", 0, 0, 0, 0, "normal", "none", 1),
+	weapon("mag-deflector", "Mag Deflector", 40000, 1, "misc", 0, 0, 0, 0, "normal", "none", 2),
+	weapon("parachute", "Parachute", 2000, 1, "misc", 0, 0, 0, 0, "normal", "none", 0),
+	weapon("battery", "Battery", 4500, 1, "misc", 0, 0, 0, 0, "normal", "none", 0),
+	weapon("auto-defense", "Auto Defense", 5000, 1, "misc", 0, 0, 0, 0, "normal", "none", 1),
+	weapon("fuel", "Fuel", 10000, 100, "misc", 0, 0, 0, 0, "normal", "none", 0),
+}
+
+// LookupWeapon finds a catalog entry by id.
+func LookupWeapon(id string) (Weapon, bool) {
+	for _, w := range Weapons {
+		if w.ID == id {
+			return w, true
+		}
+	}
+	return Weapon{}, false
+}
+
+// WeaponByID falls back to the first catalog entry like getWeaponById.
+func WeaponByID(id string) Weapon {
+	if w, ok := LookupWeapon(id); ok {
+		return w
+	}
+	return Weapons[0]
+}
+
+// runtimeSpec mirrors WeaponRuntimeSpec in runtimeSpecs.ts: how a weapon
+// launches and what its impact sets off.
+type runtimeSpec struct {
+	launchType      string
+	impactMode      string
+	mirvChildCount  int
+	rollerTTL       float64
+	diggerDuration  float64
+	sandhogDuration float64
+	funkyChildCount int
+	napalmDrops     int
+	napalmTTL       float64
+	napalmRadius    float64
+	napalmDamage    float64
+}
+
+var runtimeSpecs = map[string]runtimeSpec{
+	"baby-missile":    {impactMode: "default"},
+	"missile":         {impactMode: "default"},
+	"riot-charge":     {impactMode: "riot-blast"},
+	"riot-blast":      {impactMode: "riot-blast"},
+	"riot-bomb":       {impactMode: "riot-bomb"},
+	"heavy-riot-bomb": {impactMode: "riot-bomb"},
+	"baby-nuke":       {impactMode: "default"},
+	"nuke":            {impactMode: "default"},
+	"leapfrog":        {impactMode: "leapfrog"},
+	"tracer":          {impactMode: "tracer"},
+	"smoke-tracer":    {impactMode: "tracer"},
+	"sand-bomb":       {impactMode: "sand"},
+	"ton-of-dirt":     {impactMode: "dirt"},
+	"liquid-dirt":     {impactMode: "liquid"},
+
+	"baby-roller":  {impactMode: "roller", rollerTTL: 2.7},
+	"roller":       {impactMode: "roller", rollerTTL: 3.1},
+	"heavy-roller": {impactMode: "roller", rollerTTL: 3.6},
+
+	"baby-digger":   {impactMode: "digger", diggerDuration: 0.45},
+	"digger":        {impactMode: "digger", diggerDuration: 0.7},
+	"heavy-digger":  {impactMode: "digger", diggerDuration: 0.85},
+	"baby-sandhog":  {impactMode: "sandhog", sandhogDuration: 0.6},
+	"sandhog":       {impactMode: "sandhog", sandhogDuration: 1.05},
+	"heavy-sandhog": {impactMode: "sandhog", sandhogDuration: 1.9},
+
+	"funky-bomb": {impactMode: "funky", funkyChildCount: 6},
+	"napalm":     {impactMode: "napalm", napalmDrops: 12, napalmTTL: 2.8, napalmRadius: 14, napalmDamage: 7},
+	"hot-napalm": {impactMode: "napalm", napalmDrops: 18, napalmTTL: 3.7, napalmRadius: 20, napalmDamage: 11},
+
+	"mirv":       {launchType: "mirv-carrier", impactMode: "mirv", mirvChildCount: 5},
+	"death-head": {launchType: "mirv-carrier", impactMode: "mirv", mirvChildCount: 9},
+}
+
+// runtimeSpecFor falls back to a plain ballistic shell like
+// getWeaponRuntimeSpec.
+func runtimeSpecFor(weaponID string) runtimeSpec {
+	spec, ok := runtimeSpecs[weaponID]
+	if !ok {
+		spec = runtimeSpec{impactMode: "default"}
+	}
+	if spec.launchType == "" {
+		spec.launchType = "ballistic"
+	}
+	return spec
+}
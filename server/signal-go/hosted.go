@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"scorched-signal-go/engine"
+)
+
+const (
+	hostedFieldWidth      = 1920
+	hostedFieldHeight     = 1080
+	hostedSnapshotEvery   = 50 * time.Millisecond
+	hostedTerrainMinEvery = 250 * time.Millisecond
+	hostedTerrainMaxEvery = 2 * time.Second
+)
+
+// hostedMatch runs the authoritative simulation for a server-hosted room and
+// broadcasts game.snapshot the way a browser host would.
+type hostedMatch struct {
+	room   *room
+	roomID string
+	stop   chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	engine *engine.Engine
+	tick   int
+	dirty  bool
+}
+
+func newHostedMatch(r *room) *hostedMatch {
+	configs := make([]engine.PlayerConfig, 0, len(r.Players))
+	for _, pl := range r.Players {
+		if pl.Spectator {
+			continue
+		}
+		cfg := engine.PlayerConfig{
+			ID:         pl.PeerID,
+			Name:       pl.Name,
+			Kind:       "human",
+			AILevel:    "normal",
+			ColorIndex: pl.ColorIndex,
+			Enabled:    true,
+		}
+		if pl.Bot {
+			cfg.Kind, cfg.AILevel = "ai", pl.AILevel
+		}
+		configs = append(configs, cfg)
+	}
+	return &hostedMatch{
+		room:   r,
+		roomID: r.RoomID,
+		stop:   make(chan struct{}),
+		engine: engine.New(r.Settings, configs, hostedFieldWidth, hostedFieldHeight, r.seed),
+	}
+}
+
+// run steps the engine at the fixed rate until the match ends or the room
+// goes away.
+func (h *hostedMatch) run() {
+	stepEvery := time.Second / 60
+	ticker := time.NewTicker(stepEvery)
+	defer ticker.Stop()
+	lastSent := time.Time{}
+	lastTerrain := time.Time{}
+	lastRevision := -1
+	lastView := ""
+	for {
+		select {
+		case <-h.stop:
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			h.engine.Step()
+			h.tick++
+			ended := h.engine.Match.Phase == engine.PhaseMatchEnd
+			if !h.dirty && !ended && now.Sub(lastSent) < hostedSnapshotEvery {
+				h.mu.Unlock()
+				continue
+			}
+			revision := h.engine.Terrain.Revision
+			includeTerrain := h.engine.View != lastView ||
+				now.Sub(lastTerrain) >= hostedTerrainMaxEvery ||
+				(revision != lastRevision && now.Sub(lastTerrain) >= hostedTerrainMinEvery)
+			data, err := json.Marshal(h.engine.Snapshot(h.roomID, h.tick, includeTerrain))
+			lastView = h.engine.View
+			h.dirty = false
+			h.mu.Unlock()
+			if err != nil {
+				continue
+			}
+			lastSent = now
+			if includeTerrain {
+				lastTerrain = now
+				lastRevision = revision
+			}
+			if !h.broadcast(data) {
+				return
+			}
+			if ended {
+				h.finish()
+				return
+			}
+		}
+	}
+}
+
+// broadcast sends a snapshot to everyone in the room. It reports false once
+// the room no longer runs this match.
+func (h *hostedMatch) broadcast(data json.RawMessage) bool {
+	snap, _ := decodeSnapshot(data)
+	current := false
+	h.room.do(func() {
+		r := h.room
+		if r.hosted != h {
+			return
+		}
+		current = true
+		r.lastSnapshot = data
+		r.LastActive = time.Now().UnixMilli()
+		fanOutSnapshot(r.recipients(), r.prepareSnapshot(snap))
+		r.recorder.record(replayRecordSnapshot, data)
+		r.trackTurn(snap.state)
+	})
+	return current
+}
+
+// finish ends the room's match with the engine's final scores.
+func (h *hostedMatch) finish() {
+	h.mu.Lock()
+	scores := make(map[string]int, len(h.engine.Match.Players))
+	for _, pl := range h.engine.Match.Players {
+		scores[pl.Config.ID] = pl.Score
+	}
+	h.mu.Unlock()
+	h.room.do(func() {
+		if h.room.hosted == h {
+			h.room.endMatch(scores)
+		}
+	})
+}
+
+// skipTurn passes peerID's turn if it is still theirs to take.
+func (h *hostedMatch) skipTurn(peerID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.engine.Match.ActivePlayerID == peerID {
+		h.engine.SkipTurn()
+		h.dirty = true
+	}
+}
+
+func (h *hostedMatch) close() {
+	h.once.Do(func() { close(h.stop) })
+}
+
+// apply routes a player action into the engine, returning why it was refused.
+func (h *hostedMatch) apply(peerID, msgType string, raw json.RawMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch msgType {
+	case "game.input":
+		var in struct {
+			Input   engine.Input `json:"input"`
+			DeltaMs float64      `json:"deltaMs"`
+		}
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return err
+		}
+		h.engine.ApplyInput(peerID, in.Input, in.DeltaMs)
+	case "shop.buy", "shop.sell":
+		var in struct {
+			WeaponID string `json:"weaponId"`
+		}
+		_ = json.Unmarshal(raw, &in)
+		apply := h.engine.Buy
+		if msgType == "shop.sell" {
+			apply = h.engine.Sell
+		}
+		if err := apply(peerID, in.WeaponID); err != nil {
+			return err
+		}
+	case "shop.done":
+		var in struct {
+			Done bool `json:"done"`
+		}
+		_ = json.Unmarshal(raw, &in)
+		h.engine.SetShopDone(peerID, in.Done)
+	}
+	h.dirty = true
+	return nil
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bufio"
+	crand "crypto/rand"
 	"crypto/sha1"
 	"embed"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -27,39 +29,84 @@ import (
 	"sync/atomic"
 	"syscall"
 	"time"
+
+	"scorched-signal-go/engine"
 )
 
 const (
-	roomTTL           = 5 * time.Minute
-	maxPlayersDefault = 10
-	wsMagic           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	roomTTL = 5 * time.Minute
+	// maxPlayersDefault is also the most seats a room can have, one per
+	// tank color.
+	maxPlayersDefault  = tankColorCount
+	maxSpectators      = 16
+	resumeGraceDefault = 30 * time.Second
+	replayDirDefault   = "replays"
+	// closeHandshakeTimeout bounds how long a closing connection waits for
+	// the other side's close frame.
+	closeHandshakeTimeout = 2 * time.Second
+	wsMagic               = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 )
 
 //go:embed web
 var embeddedFiles embed.FS
 
 type peer struct {
-	id      string
-	conn    net.Conn
-	writeMu sync.Mutex
-	closed  atomic.Bool
+	// ident is the peer id. resumeSession swaps it for the resumed one while
+	// the read loop, the writer and rooms may be reading it.
+	ident  atomic.Pointer[string]
+	conn   net.Conn
+	closed atomic.Bool
+	// queue feeds the writer goroutine, which owns every write to conn.
+	queue    chan outbound
+	stopOnce sync.Once
+	// writerDone is closed once the writer has exited and conn is closed.
+	writerDone chan struct{}
+	// pendingSnap is the newest snapshot not yet written; snapQueued says a
+	// marker for it is already in queue, and missedTerrain that a snapshot
+	// it replaced carried terrain.
+	snapMu        sync.Mutex
+	pendingSnap   roomSnapshot
+	snapQueued    bool
+	missedTerrain bool
+	// snapshots is the baseline for binary snapshot deltas.
+	snapshots snapshotBaseline
+	// deflate compresses outgoing messages when permessage-deflate was
+	// negotiated; only the writer touches it.
+	deflate *deflater
+	// The writer pings every heartbeat.every; pingSeq is the last ping sent
+	// and pongSeq the last one answered. latencyMs is the last round trip,
+	// or -1 before the first pong.
+	heartbeat  heartbeat
+	pingSeq    atomic.Uint64
+	pongSeq    atomic.Uint64
+	pingSentAt atomic.Int64
+	latencyMs  atomic.Int64
 }
 
 type player struct {
-	PeerID string `json:"peerId"`
-	Name   string `json:"name"`
-	Ready  bool   `json:"ready"`
-	IsHost bool   `json:"isHost"`
-}
-
-type room struct {
-	RoomID     string   `json:"roomId"`
-	RoomName   string   `json:"roomName"`
-	Status     string   `json:"status"`
-	MaxPlayers int      `json:"maxPlayers"`
-	CreatedAt  int64    `json:"createdAt"`
-	LastActive int64    `json:"lastActiveAt"`
-	Players    []player `json:"players"`
+	PeerID    string `json:"peerId"`
+	Name      string `json:"name"`
+	Ready     bool   `json:"ready"`
+	IsHost    bool   `json:"isHost"`
+	Connected bool   `json:"connected"`
+	// Spectator players watch the match without taking a seat; they don't
+	// count toward MaxPlayers and can't send game actions.
+	Spectator bool `json:"spectator"`
+	// Bot seats are AI players the host added; AILevel is their difficulty.
+	// They take a seat but have no connection.
+	Bot     bool   `json:"bot,omitempty"`
+	AILevel string `json:"aiLevel,omitempty"`
+	// AFK players let afkTimeoutLimit turns in a row run out. Their turns
+	// are skipped until they ready up or act again.
+	AFK bool `json:"afk,omitempty"`
+	// ColorIndex is the player's tank color in TANK_COLORS, handed out by
+	// the server so no two seats share one. Spectators hold noColor.
+	ColorIndex int `json:"colorIndex"`
+	// LatencyMs is the last heartbeat round trip, filled in by room.state.
+	LatencyMs *int64 `json:"latencyMs,omitempty"`
+	// ip is the remote address the player last connected from, kept so a
+	// ban still applies while they are away.
+	ip string
 }
 
 type envelope struct {
@@ -69,13 +116,37 @@ type envelope struct {
 }
 
 type server struct {
-	mu         sync.Mutex
+	// mu guards the routing tables below. Everything about a room beyond
+	// which peers sit in it belongs to the room's own goroutine.
+	mu         sync.RWMutex
 	peers      map[string]*peer
 	rooms      map[string]*room
 	peerToRoom map[string]string
-	peerSeq    uint64
-	startTime  time.Time
-	webRoot    fs.FS
+	// codes maps each live room's join code to its room id.
+	codes       map[string]string
+	peerSeq     uint64
+	startTime   time.Time
+	webRoot     fs.FS
+	resumeGrace time.Duration
+	// replayDir is where recorded matches are kept; empty turns recording
+	// off.
+	replayDir string
+	// maps is the map library hosts pick from.
+	maps *mapLibrary
+	// uploadOrigins are the origins beyond this server and loopback whose
+	// pages may upload maps.
+	uploadOrigins []string
+	// turnSecond is how long one second of a room's turn limit lasts; tests
+	// shorten it.
+	turnSecond time.Duration
+	// maxMessageSize caps a reassembled client message.
+	maxMessageSize int
+	deflate        deflateConfig
+	heartbeat      heartbeat
+	// resume tokens map to the peer id whose room slot they can reclaim.
+	resumeTokens map[string]string
+	peerTokens   map[string]string
+	graceTimers  map[string]*time.Timer
 }
 
 func newServer() *server {
@@ -84,11 +155,22 @@ func newServer() *server {
 		log.Fatalf("failed to mount embedded web assets: %v", err)
 	}
 	return &server{
-		peers:      make(map[string]*peer),
-		rooms:      make(map[string]*room),
-		peerToRoom: make(map[string]string),
-		startTime:  time.Now(),
-		webRoot:    web,
+		peers:          make(map[string]*peer),
+		rooms:          make(map[string]*room),
+		peerToRoom:     make(map[string]string),
+		codes:          make(map[string]string),
+		startTime:      time.Now(),
+		webRoot:        web,
+		resumeGrace:    resumeGraceDefault,
+		turnSecond:     time.Second,
+		replayDir:      replayDirDefault,
+		maps:           newMapLibrary(mapsDirDefault),
+		maxMessageSize: maxMessageSizeDefault,
+		deflate:        defaultDeflateConfig(),
+		heartbeat:      heartbeat{every: heartbeatEveryDefault, misses: heartbeatMissesDefault},
+		resumeTokens:   make(map[string]string),
+		peerTokens:     make(map[string]string),
+		graceTimers:    make(map[string]*time.Timer),
 	}
 }
 
@@ -109,6 +191,53 @@ func (s *server) makeRoomID() string {
 	return "room-" + randPart + tail
 }
 
+// remoteIP is the host part of conn's remote address, or "" if it has none.
+func remoteIP(conn net.Conn) string {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// findRoom looks a room up by id or by join code.
+func (s *server) findRoom(idOrCode string) *room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r := s.rooms[idOrCode]; r != nil {
+		return r
+	}
+	return s.rooms[s.codes[normalizeRoomCode(idOrCode)]]
+}
+
+// issueResumeTokenLocked replaces any token held by peerID with a fresh one.
+func (s *server) issueResumeTokenLocked(peerID string) string {
+	s.revokeResumeTokenLocked(peerID)
+	buf := make([]byte, 18)
+	if _, err := crand.Read(buf); err != nil {
+		log.Printf("resume token generation failed: %v", err)
+		return ""
+	}
+	token := hex.EncodeToString(buf)
+	s.resumeTokens[token] = peerID
+	s.peerTokens[peerID] = token
+	return token
+}
+
+func (s *server) revokeResumeTokenLocked(peerID string) {
+	if token, ok := s.peerTokens[peerID]; ok {
+		delete(s.resumeTokens, token)
+		delete(s.peerTokens, peerID)
+	}
+	if t := s.graceTimers[peerID]; t != nil {
+		t.Stop()
+		delete(s.graceTimers, peerID)
+	}
+}
+
 func (p *peer) send(msgType string, payload any, requestID string) {
 	if p == nil || p.closed.Load() {
 		return
@@ -121,25 +250,16 @@ func (p *peer) send(msgType string, payload any, requestID string) {
 	if err != nil {
 		return
 	}
-	_ = p.writeText(data)
+	p.writeText(data)
 }
 
 func (p *peer) sendError(code, message, requestID string) {
 	p.send("error", map[string]any{"code": code, "message": message}, requestID)
 }
 
-func (p *peer) writeText(payload []byte) error {
-	p.writeMu.Lock()
-	defer p.writeMu.Unlock()
-	if p.closed.Load() {
-		return net.ErrClosed
-	}
-	return writeWSFrame(p.conn, 0x1, payload)
-}
-
 func (s *server) health() map[string]any {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return map[string]any{
 		"ok":        true,
 		"rooms":     len(s.rooms),
@@ -148,111 +268,218 @@ func (s *server) health() map[string]any {
 	}
 }
 
-func (s *server) listOpenRooms() []map[string]any {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	list := make([]map[string]any, 0, len(s.rooms))
+// listOpenRooms reads each room's published summary rather than asking the
+// rooms themselves.
+func (s *server) listOpenRooms() []roomSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]roomSummary, 0, len(s.rooms))
 	for _, r := range s.rooms {
-		if r.Status != "lobby" || len(r.Players) >= r.MaxPlayers {
+		sum := r.summary.Load()
+		if sum == nil || sum.Unlisted || sum.Locked {
 			continue
 		}
-		hostName := "Host"
-		for _, pl := range r.Players {
-			if pl.IsHost {
-				hostName = pl.Name
-				break
-			}
+		// Lobbies with a free seat can be joined; matches in progress can
+		// still be watched.
+		open := sum.Status == "lobby" && sum.Players < sum.MaxPlayers
+		watchable := sum.Status == "in-game" && sum.Spectators < maxSpectators
+		if !open && !watchable {
+			continue
 		}
-		list = append(list, map[string]any{
-			"roomId":     r.RoomID,
-			"roomName":   r.RoomName,
-			"hostName":   hostName,
-			"players":    len(r.Players),
-			"maxPlayers": r.MaxPlayers,
-			"status":     r.Status,
-		})
+		list = append(list, *sum)
 	}
 	return list
 }
 
-func (s *server) roomState(r *room) map[string]any {
-	players := make([]player, len(r.Players))
-	copy(players, r.Players)
-	return map[string]any{
-		"roomId":     r.RoomID,
-		"roomName":   r.RoomName,
-		"status":     r.Status,
-		"maxPlayers": r.MaxPlayers,
-		"players":    players,
+// announceLatency sends a lobby the new ping of one of its players.
+func (s *server) announceLatency(p *peer) {
+	r := s.roomOf(p.id())
+	if r == nil {
+		return
 	}
+	r.do(func() {
+		if r.Status == "lobby" && r.conns[p.id()] == p {
+			r.broadcastState()
+		}
+	})
 }
 
+func (s *server) roomOf(peerID string) *room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rooms[s.peerToRoom[peerID]]
+}
+
+// removePeer closes the peer's connection and gives up its room slot for good.
 func (s *server) removePeer(peerID string) {
 	s.mu.Lock()
 	p := s.peers[peerID]
 	delete(s.peers, peerID)
-	roomID, hasRoom := s.peerToRoom[peerID]
-	delete(s.peerToRoom, peerID)
+	s.mu.Unlock()
 	if p != nil {
-		p.closed.Store(true)
-		_ = p.conn.Close()
+		p.closeWith(closeNormal, "left room")
 	}
-	if !hasRoom {
-		s.mu.Unlock()
-		return
-	}
-	r, ok := s.rooms[roomID]
-	if !ok {
+	s.releaseSlot(peerID)
+}
+
+// dropPeer runs when a connection's read loop ends. Peers that are seated in
+// a room keep their slot for the resume grace period; everyone else is
+// removed straight away.
+func (s *server) dropPeer(p *peer) {
+	s.mu.Lock()
+	peerID := p.id()
+	p.shutdown()
+	if s.peers[peerID] != p {
+		// The slot was already resumed on a newer connection.
 		s.mu.Unlock()
 		return
 	}
-	r.Players = filterPlayers(r.Players, peerID)
-	r.LastActive = time.Now().UnixMilli()
-	if len(r.Players) == 0 {
-		delete(s.rooms, roomID)
+	delete(s.peers, peerID)
+	r := s.rooms[s.peerToRoom[peerID]]
+	if r == nil || s.resumeGrace <= 0 || s.peerTokens[peerID] == "" {
+		r = s.unseatLocked(peerID)
 		s.mu.Unlock()
+		s.leaveRoom(r, peerID)
 		return
 	}
-	hasHost := false
-	for i := range r.Players {
-		if r.Players[i].IsHost {
-			hasHost = true
-			break
+	s.graceTimers[peerID] = time.AfterFunc(s.resumeGrace, func() {
+		s.expireSession(peerID)
+	})
+	s.mu.Unlock()
+
+	seated := false
+	r.do(func() {
+		pl := findPlayer(r, peerID)
+		if pl == nil {
+			return
+		}
+		seated = true
+		if r.conns[peerID] != p {
+			// Resumed on a newer connection in the meantime.
+			return
+		}
+		pl.Connected = false
+		delete(r.conns, peerID)
+		r.LastActive = time.Now().UnixMilli()
+		recipients := r.recipients()
+		event := map[string]any{"peerId": peerID, "roomId": r.RoomID, "graceSec": int(s.resumeGrace.Seconds())}
+		for _, rp := range recipients {
+			rp.send("peer.disconnected", event, "")
+		}
+		// A browser-hosted match stops while its host is away, so the host
+		// role moves on now rather than when the grace period ends. The old
+		// host comes back as a guest if it resumes.
+		if pl.IsHost && r.Status == "in-game" && !r.ServerHosted && r.replay == nil {
+			if next := findPlayer(r, r.nextHost()); next != nil && next.Connected {
+				announceHostMigration(recipients, r.promoteHost(next.PeerID, "host_disconnected"))
+			}
 		}
+		broadcastRoomState(recipients, r.state())
+	})
+	if !seated {
+		s.releaseSlot(peerID)
 	}
-	if !hasHost {
-		r.Players[0].IsHost = true
+}
+
+// expireSession releases a disconnected peer's slot once its grace period
+// runs out without a session.resume.
+func (s *server) expireSession(peerID string) {
+	s.mu.Lock()
+	if _, reconnected := s.peers[peerID]; reconnected {
+		s.mu.Unlock()
+		return
 	}
-	recipients := s.roomRecipientsLocked(r)
-	state := s.roomState(r)
+	delete(s.graceTimers, peerID)
+	r := s.unseatLocked(peerID)
 	s.mu.Unlock()
-	s.broadcastRoomState(recipients, state)
+	s.leaveRoom(r, peerID)
 }
 
-func filterPlayers(players []player, peerID string) []player {
-	out := players[:0]
-	for _, p := range players {
-		if p.PeerID != peerID {
-			out = append(out, p)
+// resumeSession rebinds p to the room slot identified by a resume token,
+// taking over the old peer id so the rest of the room sees the same player.
+func (s *server) resumeSession(p *peer, token, requestID string) {
+	s.mu.RLock()
+	oldID, ok := s.resumeTokens[token]
+	r := s.rooms[s.peerToRoom[oldID]]
+	_, seated := s.peerToRoom[p.id()]
+	s.mu.RUnlock()
+	if !ok || r == nil {
+		p.sendError("session_expired", "Session can no longer be resumed", requestID)
+		return
+	}
+	if seated && p.id() != oldID {
+		p.sendError("forbidden", "Leave the current room before resuming a session", requestID)
+		return
+	}
+	resumed := false
+	r.do(func() {
+		pl := findPlayer(r, oldID)
+		if pl == nil {
+			return
+		}
+		s.mu.Lock()
+		if s.resumeTokens[token] != oldID {
+			// The grace period ran out while we were queued.
+			s.mu.Unlock()
+			return
+		}
+		stale := s.peers[oldID]
+		if id := p.id(); id != oldID {
+			delete(s.peers, id)
+			p.setID(oldID)
+		}
+		s.peers[oldID] = p
+		newToken := s.issueResumeTokenLocked(oldID)
+		s.mu.Unlock()
+		if stale != nil && stale != p {
+			stale.closeWith(closeSessionReplaced, "session resumed elsewhere")
+		}
+		resumed = true
+		r.conns[oldID] = p
+		pl.Connected = true
+		pl.ip = remoteIP(p.conn)
+		r.LastActive = time.Now().UnixMilli()
+		recipients := r.recipients()
+		state := r.state()
+		p.snapshots.reset()
+		p.send("session.resumed", map[string]any{"selfPeerId": oldID, "room": state, "resumeToken": newToken}, requestID)
+		event := map[string]any{"peerId": oldID, "roomId": r.RoomID}
+		for _, rp := range recipients {
+			if rp != p {
+				rp.send("peer.reconnected", event, "")
+			}
 		}
+		broadcastRoomState(recipients, state)
+		// Like a late arrival, a resumed peer redraws from the latest picture.
+		if r.Status == "in-game" && r.lastFrame.raw != nil {
+			fanOutSnapshot([]*peer{p}, r.lastFrame)
+		}
+	})
+	if !resumed {
+		p.sendError("session_expired", "Session can no longer be resumed", requestID)
 	}
-	return out
 }
 
-func (s *server) roomRecipientsLocked(r *room) []*peer {
-	result := make([]*peer, 0, len(r.Players))
-	for _, pl := range r.Players {
-		if p := s.peers[pl.PeerID]; p != nil && !p.closed.Load() {
-			result = append(result, p)
-		}
-	}
-	return result
+// releaseSlot removes peerID from its room for good.
+func (s *server) releaseSlot(peerID string) {
+	s.mu.Lock()
+	r := s.unseatLocked(peerID)
+	s.mu.Unlock()
+	s.leaveRoom(r, peerID)
+}
+
+// unseatLocked revokes peerID's session and routing, returning the room it
+// still has to be removed from.
+func (s *server) unseatLocked(peerID string) *room {
+	s.revokeResumeTokenLocked(peerID)
+	r := s.rooms[s.peerToRoom[peerID]]
+	delete(s.peerToRoom, peerID)
+	return r
 }
 
-func (s *server) broadcastRoomState(recipients []*peer, roomState map[string]any) {
-	payload := map[string]any{"room": roomState}
-	for _, p := range recipients {
-		p.send("room.state", payload, "")
+func (s *server) leaveRoom(r *room, peerID string) {
+	if r != nil {
+		r.do(func() { s.removePlayer(r, peerID) })
 	}
 }
 
@@ -325,12 +552,17 @@ func getInt(m map[string]any, key string, fallback int) int {
 }
 
 func (s *server) handleMessage(peerID string, env envelope) {
-	payload := parsePayload(env.Payload)
+	// Snapshots are relayed as raw JSON and can be large, so skip the
+	// generic parse for them.
+	var payload map[string]any
+	if env.Type != "game.snapshot" {
+		payload = parsePayload(env.Payload)
+	}
 	requestID := env.RequestID
 
-	s.mu.Lock()
+	s.mu.RLock()
 	p := s.peers[peerID]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if p == nil {
 		return
 	}
@@ -353,29 +585,69 @@ func (s *server) handleMessage(peerID string, env envelope) {
 		if maxPlayers > maxPlayersDefault {
 			maxPlayers = maxPlayersDefault
 		}
+		var req struct {
+			Settings json.RawMessage `json:"settings"`
+		}
+		_ = json.Unmarshal(env.Payload, &req)
+		settings, err := mergeSettings(engine.DefaultSettings(), req.Settings)
+		if err != nil {
+			p.sendError("bad_request", "Invalid settings: "+err.Error(), requestID)
+			return
+		}
+		var password *roomPassword
+		if plain := getString(payload, "password", ""); plain != "" {
+			if len(plain) > maxPasswordLength {
+				p.sendError("bad_request", fmt.Sprintf("Password is longer than %d bytes", maxPasswordLength), requestID)
+				return
+			}
+			if password, err = newRoomPassword(plain); err != nil {
+				p.sendError("internal", "Could not set room password", requestID)
+				return
+			}
+		}
 
+		roomID := s.makeRoomID()
+		s.mu.Lock()
+		code := s.claimRoomCodeLocked(roomID)
+		s.mu.Unlock()
 		r := &room{
-			RoomID:     s.makeRoomID(),
+			RoomID:     roomID,
+			Code:       code,
 			RoomName:   roomName,
 			Status:     "lobby",
 			MaxPlayers: maxPlayers,
 			CreatedAt:  time.Now().UnixMilli(),
 			LastActive: time.Now().UnixMilli(),
+			turnSecond: s.turnSecond,
+			replayDir:  s.replayDir,
+			maps:       s.maps,
 			Players: []player{{
-				PeerID: peerID,
-				Name:   hostName,
-				Ready:  true,
-				IsHost: true,
+				PeerID:     peerID,
+				Name:       hostName,
+				Ready:      true,
+				IsHost:     true,
+				Connected:  true,
+				ColorIndex: 0,
+				ip:         remoteIP(p.conn),
 			}},
+			ServerHosted: getBool(payload, "serverHosted"),
+			Unlisted:     getBool(payload, "unlisted"),
+			Record:       getBool(payload, "record") && s.replayDir != "",
+			Settings:     settings,
+			password:     password,
+			conns:        map[string]*peer{peerID: p},
 		}
+		state := r.state()
+		p.snapshots.reset()
+		r.start()
 
 		s.mu.Lock()
 		s.peerToRoom[peerID] = r.RoomID
 		s.rooms[r.RoomID] = r
-		state := s.roomState(r)
+		token := s.issueResumeTokenLocked(peerID)
 		s.mu.Unlock()
 
-		p.send("room.created", map[string]any{"selfPeerId": peerID, "room": state}, requestID)
+		p.send("room.created", map[string]any{"selfPeerId": peerID, "room": state, "resumeToken": token}, requestID)
 		return
 
 	case "room.join":
@@ -384,198 +656,162 @@ func (s *server) handleMessage(peerID string, env envelope) {
 		if len(name) > 16 {
 			name = name[:16]
 		}
-		s.mu.Lock()
-		r := s.rooms[roomID]
-		if r == nil {
-			s.mu.Unlock()
-			p.send("room.not_found", map[string]any{"roomId": roomID}, requestID)
-			return
-		}
-		if len(r.Players) >= r.MaxPlayers {
-			cur, max := len(r.Players), r.MaxPlayers
-			s.mu.Unlock()
-			p.send("room.full", map[string]any{"roomId": roomID, "currentPlayers": cur, "maxPlayers": max}, requestID)
-			return
-		}
-		if r.Status != "lobby" {
-			s.mu.Unlock()
-			p.sendError("forbidden", "Match already started", requestID)
-			return
+		req := joinRequest{
+			name:      name,
+			spectator: getBool(payload, "spectator"),
+			password:  getString(payload, "password", ""),
 		}
-		if name == "" {
-			name = fmt.Sprintf("Player%d", len(r.Players)+1)
+		r := s.findRoom(roomID)
+		if r == nil || !r.do(func() { s.joinRoom(r, p, req, requestID) }) {
+			p.send("room.not_found", map[string]any{"roomId": roomID}, requestID)
 		}
-		s.peerToRoom[peerID] = roomID
-		r.Players = append(r.Players, player{PeerID: peerID, Name: name, Ready: false, IsHost: false})
-		r.LastActive = time.Now().UnixMilli()
-		state := s.roomState(r)
-		recipients := s.roomRecipientsLocked(r)
-		s.mu.Unlock()
+		return
 
-		p.send("room.joined", map[string]any{"selfPeerId": peerID, "room": state}, requestID)
-		s.broadcastRoomState(recipients, state)
+	case "replay.play":
+		s.playReplay(p, getString(payload, "replayId", ""), getString(payload, "playerName", ""), requestID)
 		return
 
 	case "room.leave":
 		s.removePeer(peerID)
 		return
-	}
-
-	s.mu.Lock()
-	roomID := s.peerToRoom[peerID]
-	r := s.rooms[roomID]
-	if r == nil {
-		s.mu.Unlock()
-		p.sendError("room_not_found", "Room no longer exists", requestID)
-		return
-	}
-	playerIdx := -1
-	for i := range r.Players {
-		if r.Players[i].PeerID == peerID {
-			playerIdx = i
-			break
-		}
-	}
-	if playerIdx < 0 {
-		s.mu.Unlock()
-		p.sendError("forbidden", "Unknown player", requestID)
-		return
-	}
-	pl := &r.Players[playerIdx]
 
-	switch env.Type {
-	case "peer.ready":
-		pl.Ready = getBool(payload, "ready")
-		r.LastActive = time.Now().UnixMilli()
-		recipients := s.roomRecipientsLocked(r)
-		state := s.roomState(r)
-		s.mu.Unlock()
-		s.broadcastRoomState(recipients, state)
+	case "session.resume":
+		s.resumeSession(p, getString(payload, "resumeToken", ""), requestID)
 		return
 
-	case "peer.rename":
-		name := getString(payload, "name", "")
-		if len(name) > 16 {
-			name = name[:16]
-		}
-		if name == "" {
-			name = fmt.Sprintf("Player%d", playerIdx+1)
-		}
-		pl.Name = name
-		r.LastActive = time.Now().UnixMilli()
-		recipients := s.roomRecipientsLocked(r)
-		state := s.roomState(r)
-		eventRecipients := append([]*peer(nil), recipients...)
-		s.mu.Unlock()
-		for _, rp := range eventRecipients {
-			rp.send("peer.rename", map[string]any{"peerId": peerID, "roomId": roomID, "name": pl.Name}, "")
-		}
-		s.broadcastRoomState(recipients, state)
-		return
-
-	case "chat.msg":
-		text := getString(payload, "text", "")
-		if text == "" {
-			s.mu.Unlock()
+	case "snapshot.mode":
+		mode := getString(payload, "mode", "json")
+		if mode != "json" && mode != "binary" {
+			p.sendError("bad_request", "Unknown snapshot mode: "+mode, requestID)
 			return
 		}
-		if len(text) > 200 {
-			text = text[:200]
-		}
-		recipients := s.roomRecipientsLocked(r)
-		name := pl.Name
-		s.mu.Unlock()
-		msgPayload := map[string]any{"roomId": roomID, "peerId": peerID, "name": name, "text": text, "at": time.Now().UnixMilli()}
-		for _, rp := range recipients {
-			rp.send("chat.msg", msgPayload, "")
-		}
+		p.snapshots.mu.Lock()
+		p.snapshots.enabled = mode == "binary"
+		p.snapshots.seq, p.snapshots.body, p.snapshots.terrain = 0, nil, nil
+		p.snapshots.mu.Unlock()
+		p.send("snapshot.mode", map[string]any{"mode": mode, "version": snapshotWireVersion}, requestID)
 		return
 
-	case "match.start":
-		if !pl.IsHost {
-			s.mu.Unlock()
-			p.sendError("forbidden", "Only host can start match", requestID)
-			return
-		}
-		forceStart := getBool(payload, "forceStart")
-		readyCount := 0
-		for _, rp := range r.Players {
-			if rp.Ready {
-				readyCount++
-			}
-		}
-		if !forceStart && readyCount < 2 {
-			s.mu.Unlock()
-			p.sendError("bad_request", "Need at least 2 ready players", requestID)
-			return
+	case "snapshot.resync":
+		p.snapshots.reset()
+		var frame roomSnapshot
+		if r := s.roomOf(peerID); r != nil {
+			r.do(func() {
+				if r.Status == "in-game" {
+					frame = r.lastFrame
+				}
+			})
 		}
-		r.Status = "in-game"
-		r.LastActive = time.Now().UnixMilli()
-		recipients := s.roomRecipientsLocked(r)
-		state := s.roomState(r)
-		s.mu.Unlock()
-		startPayload := map[string]any{"roomId": roomID, "startedAt": time.Now().UnixMilli()}
-		for _, rp := range recipients {
-			rp.send("match.start", startPayload, "")
+		if frame.raw != nil {
+			fanOutSnapshot([]*peer{p}, frame)
 		}
-		s.broadcastRoomState(recipients, state)
 		return
+	}
 
-	case "game.input", "shop.buy", "shop.sell", "shop.done":
-		if pl.IsHost {
-			s.mu.Unlock()
-			p.sendError("forbidden", "Host should apply actions locally", requestID)
+	r := s.roomOf(peerID)
+	if r == nil || !r.do(func() { s.handleRoomMessage(r, p, env, payload) }) {
+		p.sendError("room_not_found", "Room no longer exists", requestID)
+	}
+}
+
+// joinRequest is what a room.join asks for.
+type joinRequest struct {
+	name      string
+	spectator bool
+	password  string
+}
+
+// joinRoom seats p in r. It runs on r's goroutine.
+func (s *server) joinRoom(r *room, p *peer, req joinRequest, requestID string) {
+	peerID, name, spectator := p.id(), req.name, req.spectator
+	ip := remoteIP(p.conn)
+	if r.bannedPeers[peerID] || r.bannedIPs[ip] {
+		p.sendError("banned", "You are banned from this room", requestID)
+		return
+	}
+	if r.Locked {
+		p.sendError("room_locked", "Room is locked", requestID)
+		return
+	}
+	if r.password != nil {
+		if req.password == "" {
+			p.send("room.auth_required", map[string]any{"roomId": r.RoomID}, requestID)
 			return
 		}
-		var hostPeerID string
-		for _, rp := range r.Players {
-			if rp.IsHost {
-				hostPeerID = rp.PeerID
-				break
-			}
-		}
-		hostPeer := s.peers[hostPeerID]
-		s.mu.Unlock()
-		if hostPeer == nil {
-			p.sendError("room_not_found", "Host unavailable", requestID)
+		if !r.password.matches(req.password) {
+			p.send("room.auth_failed", map[string]any{"roomId": r.RoomID}, requestID)
 			return
 		}
-		switch env.Type {
-		case "game.input":
-			hostPeer.send("game.input", map[string]any{"peerId": peerID, "data": payload}, "")
-		case "shop.buy", "shop.sell":
-			weaponID := getString(payload, "weaponId", "")
-			hostPeer.send(env.Type, map[string]any{"peerId": peerID, "roomId": roomID, "weaponId": weaponID}, "")
-		case "shop.done":
-			hostPeer.send("shop.done", map[string]any{"peerId": peerID, "roomId": roomID, "done": getBool(payload, "done")}, "")
-		}
+	}
+	// Anyone arriving after the match started can only watch it.
+	if r.Status != "lobby" {
+		spectator = true
+	}
+	seated, watching := r.counts()
+	if spectator && watching >= maxSpectators {
+		p.send("room.full", map[string]any{"roomId": r.RoomID, "currentSpectators": watching, "maxSpectators": maxSpectators}, requestID)
+		return
+	}
+	if !spectator && seated >= r.MaxPlayers {
+		p.send("room.full", map[string]any{"roomId": r.RoomID, "currentPlayers": seated, "maxPlayers": r.MaxPlayers}, requestID)
 		return
+	}
+	if name == "" {
+		name = fmt.Sprintf("Player%d", len(r.Players)+1)
+	}
+	s.mu.Lock()
+	s.peerToRoom[peerID] = r.RoomID
+	token := s.issueResumeTokenLocked(peerID)
+	s.mu.Unlock()
+	p.snapshots.reset()
+	color := noColor
+	if !spectator {
+		color = r.freeColor()
+	}
+	r.Players = append(r.Players, player{PeerID: peerID, Name: name, Ready: false, IsHost: false, Connected: true, Spectator: spectator, ColorIndex: color, ip: ip})
+	r.conns[peerID] = p
+	r.LastActive = time.Now().UnixMilli()
+	state := r.state()
 
-	case "game.snapshot":
-		if !pl.IsHost {
-			s.mu.Unlock()
-			p.sendError("forbidden", "Only host can send snapshots", requestID)
+	p.send("room.joined", map[string]any{"selfPeerId": peerID, "room": state, "resumeToken": token}, requestID)
+	broadcastRoomState(r.recipients(), state)
+	// A late arrival starts from the latest picture instead of waiting for
+	// the next turn.
+	if r.Status == "in-game" && r.lastFrame.raw != nil {
+		fanOutSnapshot([]*peer{p}, r.lastFrame)
+	}
+}
+
+// closeAllPeers sends every connection a close frame and waits, at most
+// closeHandshakeTimeout, for the writers to flush them.
+func (s *server) closeAllPeers(code int, reason string) {
+	s.mu.RLock()
+	peers := make([]*peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.RUnlock()
+	for _, p := range peers {
+		p.closeWith(code, reason)
+		p.shutdown()
+	}
+	deadline := time.After(closeHandshakeTimeout)
+	for _, p := range peers {
+		select {
+		case <-p.writerDone:
+		case <-deadline:
 			return
 		}
-		recipients := make([]*peer, 0, len(r.Players))
-		for _, rp := range r.Players {
-			if rp.PeerID == peerID {
-				continue
-			}
-			if target := s.peers[rp.PeerID]; target != nil {
-				recipients = append(recipients, target)
-			}
-		}
-		r.LastActive = time.Now().UnixMilli()
-		s.mu.Unlock()
-		for _, rp := range recipients {
-			rp.send("game.snapshot", payload, "")
-		}
-		return
 	}
+}
 
-	s.mu.Unlock()
-	p.sendError("bad_request", "Unknown type: "+env.Type, requestID)
+// expired reports whether a room has emptied or sat idle past roomTTL. A
+// paused replay sends nothing, so it stays open while anyone is watching.
+func (r *room) expired(now int64) bool {
+	if r.replay != nil && len(r.conns) > 0 {
+		return false
+	}
+	return len(r.Players) == 0 || now-r.LastActive > roomTTL.Milliseconds()
 }
 
 func (s *server) cleanupExpiredRooms(stop <-chan struct{}) {
@@ -585,16 +821,26 @@ func (s *server) cleanupExpiredRooms(stop <-chan struct{}) {
 		select {
 		case <-ticker.C:
 			now := time.Now().UnixMilli()
-			s.mu.Lock()
-			for roomID, r := range s.rooms {
-				if len(r.Players) == 0 || now-r.LastActive > roomTTL.Milliseconds() {
+			s.mu.RLock()
+			rooms := make([]*room, 0, len(s.rooms))
+			for _, r := range s.rooms {
+				rooms = append(rooms, r)
+			}
+			s.mu.RUnlock()
+			for _, r := range rooms {
+				r.do(func() {
+					if !r.expired(now) {
+						return
+					}
+					s.mu.Lock()
 					for _, pl := range r.Players {
 						delete(s.peerToRoom, pl.PeerID)
+						s.revokeResumeTokenLocked(pl.PeerID)
 					}
-					delete(s.rooms, roomID)
-				}
+					s.mu.Unlock()
+					s.deleteRoom(r)
+				})
 			}
-			s.mu.Unlock()
 		case <-stop:
 			return
 		}
@@ -607,8 +853,18 @@ func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.TrimSpace(r.Header.Get("Sec-WebSocket-Version")) != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
 	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
-	if key == "" {
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
 		http.Error(w, "bad websocket key", http.StatusBadRequest)
 		return
 	}
@@ -627,140 +883,77 @@ func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
 
 	hash := sha1.Sum([]byte(key + wsMagic))
 	accept := base64.StdEncoding.EncodeToString(hash[:])
-	resp := strings.Join([]string{
+	lines := []string{
 		"HTTP/1.1 101 Switching Protocols",
 		"Upgrade: websocket",
 		"Connection: Upgrade",
 		"Sec-WebSocket-Accept: " + accept,
-		"",
-		"",
-	}, "\r\n")
+	}
+	deflateParams, extensions, compress := negotiateDeflate(s.deflate, strings.Join(r.Header.Values("Sec-WebSocket-Extensions"), ","))
+	if compress {
+		lines = append(lines, "Sec-WebSocket-Extensions: "+extensions)
+	}
+	resp := strings.Join(append(lines, "", ""), "\r\n")
 	if _, err := conn.Write([]byte(resp)); err != nil {
 		_ = conn.Close()
 		return
 	}
 
 	peerID := s.makePeerID()
-	p := &peer{id: peerID, conn: conn}
+	var deflate *deflater
+	var inflate *inflater
+	if compress {
+		deflate = newDeflater(deflateParams, s.deflate.threshold)
+		inflate = newInflater(deflateParams)
+	}
+	p := newPeer(peerID, conn, deflate, s.heartbeat)
 	s.mu.Lock()
 	s.peers[peerID] = p
 	s.mu.Unlock()
 
 	go func() {
-		defer s.removePeer(peerID)
-		reader := rw.Reader
+		defer s.dropPeer(p)
+		reader := newWSReader(rw.Reader, s.maxMessageSize, inflate)
 		for {
-			opcode, payload, err := readWSFrame(reader)
+			opcode, payload, err := reader.readMessage()
 			if err != nil {
-				if !isExpectedConnClose(err) {
-					log.Printf("ws read error (%s): %v", peerID, err)
+				var ce *closeError
+				if errors.As(err, &ce) {
+					p.closeWith(ce.code, ce.reason)
+				} else if !isExpectedConnClose(err) {
+					log.Printf("ws read error (%s): %v", p.id(), err)
 				}
 				return
 			}
 			switch opcode {
-			case 0x8:
+			case opClose:
+				code := closeNoStatus
+				if len(payload) >= 2 {
+					code = int(binary.BigEndian.Uint16(payload))
+				}
+				p.closeWith(code, "")
 				return
-			case 0x9:
-				_ = p.writePong()
-			case 0x1:
+			case opPing:
+				p.writePong(payload)
+			case opPong:
+				if p.notePong(payload) {
+					s.announceLatency(p)
+				}
+			case opText:
 				var env envelope
 				if err := json.Unmarshal(payload, &env); err != nil {
 					p.sendError("bad_request", "Invalid JSON payload", "")
 					continue
 				}
-				s.handleMessage(peerID, env)
+				// The id changes when the connection resumes an older session.
+				s.handleMessage(p.id(), env)
+			case opBinary:
+				p.sendError("bad_request", "Binary frames are only sent by the server", "")
 			}
 		}
 	}()
 }
 
-func (p *peer) writePong() error {
-	p.writeMu.Lock()
-	defer p.writeMu.Unlock()
-	if p.closed.Load() {
-		return net.ErrClosed
-	}
-	return writeWSFrame(p.conn, 0xA, nil)
-}
-
-func writeWSFrame(w io.Writer, opcode byte, payload []byte) error {
-	header := []byte{0x80 | opcode, 0}
-	length := len(payload)
-	switch {
-	case length < 126:
-		header[1] = byte(length)
-	case length <= 65535:
-		header[1] = 126
-		ext := make([]byte, 2)
-		binary.BigEndian.PutUint16(ext, uint16(length))
-		header = append(header, ext...)
-	default:
-		header[1] = 127
-		ext := make([]byte, 8)
-		binary.BigEndian.PutUint64(ext, uint64(length))
-		header = append(header, ext...)
-	}
-	if _, err := w.Write(header); err != nil {
-		return err
-	}
-	if length > 0 {
-		_, err := w.Write(payload)
-		return err
-	}
-	return nil
-}
-
-func readWSFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
-	head := make([]byte, 2)
-	if _, err = io.ReadFull(r, head); err != nil {
-		return 0, nil, err
-	}
-	opcode = head[0] & 0x0F
-	masked := (head[1] & 0x80) != 0
-	lengthCode := int(head[1] & 0x7F)
-	length := 0
-
-	switch lengthCode {
-	case 126:
-		ext := make([]byte, 2)
-		if _, err = io.ReadFull(r, ext); err != nil {
-			return 0, nil, err
-		}
-		length = int(binary.BigEndian.Uint16(ext))
-	case 127:
-		ext := make([]byte, 8)
-		if _, err = io.ReadFull(r, ext); err != nil {
-			return 0, nil, err
-		}
-		n := binary.BigEndian.Uint64(ext)
-		if n > math.MaxInt32 {
-			return 0, nil, fmt.Errorf("frame too large")
-		}
-		length = int(n)
-	default:
-		length = lengthCode
-	}
-
-	mask := make([]byte, 4)
-	if masked {
-		if _, err = io.ReadFull(r, mask); err != nil {
-			return 0, nil, err
-		}
-	}
-	payload = make([]byte, length)
-	if length > 0 {
-		if _, err = io.ReadFull(r, payload); err != nil {
-			return 0, nil, err
-		}
-	}
-	if masked {
-		for i := 0; i < len(payload); i++ {
-			payload[i] ^= mask[i%4]
-		}
-	}
-	return opcode, payload, nil
-}
-
 func headerContainsToken(value, token string) bool {
 	for _, part := range strings.Split(value, ",") {
 		if strings.EqualFold(strings.TrimSpace(part), token) {
@@ -774,7 +967,8 @@ func isExpectedConnClose(err error) bool {
 	if err == io.EOF || strings.Contains(strings.ToLower(err.Error()), "closed network connection") {
 		return true
 	}
-	return false
+	// The client went away while we were writing to it.
+	return errors.Is(err, io.ErrClosedPipe) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
 }
 
 func (s *server) serveStatic(w http.ResponseWriter, r *http.Request) {
@@ -852,6 +1046,57 @@ func main() {
 	addr := net.JoinHostPort(host, port)
 
 	s := newServer()
+	if raw := strings.TrimSpace(os.Getenv("RESUME_GRACE_SEC")); raw != "" {
+		sec, err := strconv.Atoi(raw)
+		if err != nil || sec < 0 {
+			log.Fatalf("invalid RESUME_GRACE_SEC: %q", raw)
+		}
+		s.resumeGrace = time.Duration(sec) * time.Second
+	}
+	if raw := strings.TrimSpace(os.Getenv("HEARTBEAT_SEC")); raw != "" {
+		sec, err := strconv.Atoi(raw)
+		if err != nil || sec < 0 {
+			log.Fatalf("invalid HEARTBEAT_SEC: %q", raw)
+		}
+		s.heartbeat.every = time.Duration(sec) * time.Second
+	}
+	if raw := strings.TrimSpace(os.Getenv("HEARTBEAT_MISSES")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid HEARTBEAT_MISSES: %q", raw)
+		}
+		s.heartbeat.misses = n
+	}
+	if raw := strings.TrimSpace(os.Getenv("REPLAY_DIR")); raw == "off" {
+		s.replayDir = ""
+	} else if raw != "" {
+		s.replayDir = raw
+	}
+	if raw := strings.TrimSpace(os.Getenv("MAPS_DIR")); raw == "off" {
+		s.maps = newMapLibrary("")
+	} else if raw != "" {
+		s.maps = newMapLibrary(raw)
+	}
+	for _, origin := range strings.Split(os.Getenv("UPLOAD_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			s.uploadOrigins = append(s.uploadOrigins, origin)
+		}
+	}
+	if raw := strings.TrimSpace(os.Getenv("WS_DEFLATE")); raw != "" {
+		s.deflate.enabled = raw != "0"
+	}
+	if raw := strings.TrimSpace(os.Getenv("WS_DEFLATE_THRESHOLD")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid WS_DEFLATE_THRESHOLD: %q", raw)
+		}
+		s.deflate.threshold = n
+	}
+	if raw := strings.TrimSpace(os.Getenv("WS_DEFLATE_CONTEXT_TAKEOVER")); raw != "" {
+		if err := s.deflate.parseContextTakeover(raw); err != nil {
+			log.Fatalf("invalid WS_DEFLATE_CONTEXT_TAKEOVER: %v", err)
+		}
+	}
 	stopCleanup := make(chan struct{})
 	go s.cleanupExpiredRooms(stopCleanup)
 
@@ -861,6 +1106,12 @@ func main() {
 		_ = json.NewEncoder(w).Encode(s.health())
 	})
 	mux.HandleFunc("/ws", s.handleWS)
+	mux.HandleFunc("/join/", s.handleJoinLink)
+	mux.HandleFunc("/replays", s.handleReplays)
+	mux.HandleFunc("/replays/", s.handleReplays)
+	mux.HandleFunc("/api/maps", s.handleMaps)
+	mux.HandleFunc("/api/maps/", s.handleMaps)
+	mux.HandleFunc("/api/rooms/", s.handleRoomMap)
 	mux.HandleFunc("/", s.serveStatic)
 
 	httpServer := &http.Server{
@@ -887,6 +1138,7 @@ func main() {
 	case sig := <-sigCh:
 		log.Printf("received signal: %s", sig)
 		close(stopCleanup)
+		s.closeAllPeers(closeGoingAway, "server shutting down")
 		_ = httpServer.Close()
 	case err := <-errCh:
 		if err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,761 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	s := newServer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWS)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func dialTestClient(t *testing.T, ts *httptest.Server) *testClient {
+	t.Helper()
+	c, _ := dialTestClientWith(t, ts, "")
+	return c
+}
+
+// dialTestClientWith performs the upgrade with extra request header lines and
+// returns the handshake response too.
+func dialTestClientWith(t *testing.T, ts *httptest.Server, extraHeaders string) (*testClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req := "GET /ws HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + extraHeaders + "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("handshake write: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("handshake read: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	return &testClient{t: t, conn: conn, reader: reader}, resp
+}
+
+// writeFrame sends a single masked client frame.
+func (c *testClient) writeFrame(first byte, payload []byte) {
+	c.t.Helper()
+	header := []byte{first, 0x80}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] |= byte(n)
+	case n <= 65535:
+		header[1] |= 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] |= 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	mask := []byte{0x1f, 0x2e, 0x3d, 0x4c}
+	header = append(header, mask...)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := c.conn.Write(append(header, masked...)); err != nil {
+		c.t.Fatalf("write frame: %v", err)
+	}
+}
+
+func (c *testClient) send(msgType string, payload any) {
+	c.t.Helper()
+	data, err := json.Marshal(map[string]any{"type": msgType, "payload": payload, "requestId": "req-" + msgType})
+	if err != nil {
+		c.t.Fatalf("marshal: %v", err)
+	}
+	c.writeFrame(0x81, data)
+}
+
+// readFrame returns the next raw server frame.
+func (c *testClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	n := int(head[1] & 0x7F)
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		_, _ = io.ReadFull(c.reader, ext)
+		n = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, _ = io.ReadFull(c.reader, ext)
+		n = int(binary.BigEndian.Uint64(ext))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		c.t.Fatalf("read payload: %v", err)
+	}
+	return head[0], payload
+}
+
+// expect reads messages until one of msgType arrives.
+func (c *testClient) expect(msgType string) map[string]any {
+	c.t.Helper()
+	for i := 0; i < 32; i++ {
+		first, data := c.readFrame()
+		if first&0x0F != 0x1 {
+			continue
+		}
+		var env struct {
+			Type    string         `json:"type"`
+			Payload map[string]any `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			c.t.Fatalf("unmarshal %q: %v", data, err)
+		}
+		if env.Type == msgType {
+			return env.Payload
+		}
+	}
+	c.t.Fatalf("never received %s", msgType)
+	return nil
+}
+
+func createRoom(t *testing.T, ts *httptest.Server, hostName string) (*testClient, map[string]any) {
+	t.Helper()
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Test", "hostName": hostName, "maxPlayers": 4})
+	return host, host.expect("room.created")
+}
+
+func joinRoom(t *testing.T, ts *httptest.Server, roomID, name string) (*testClient, map[string]any) {
+	t.Helper()
+	c := dialTestClient(t, ts)
+	c.send("room.join", map[string]any{"roomId": roomID, "playerName": name})
+	return c, c.expect("room.joined")
+}
+
+func roomIDOf(payload map[string]any) string {
+	return payload["room"].(map[string]any)["roomId"].(string)
+}
+
+func TestSessionResumeKeepsSlot(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	token := joined["resumeToken"].(string)
+	if token == "" {
+		t.Fatal("missing resume token")
+	}
+
+	_ = guest.conn.Close()
+	if got := host.expect("peer.disconnected"); got["peerId"] != guestID {
+		t.Fatalf("peer.disconnected for %v, want %s", got["peerId"], guestID)
+	}
+
+	again := dialTestClient(t, ts)
+	again.send("session.resume", map[string]any{"resumeToken": token})
+	resumed := again.expect("session.resumed")
+	if resumed["selfPeerId"] != guestID {
+		t.Fatalf("resumed as %v, want %s", resumed["selfPeerId"], guestID)
+	}
+	if resumed["resumeToken"] == token {
+		t.Fatal("resume token was not rotated")
+	}
+	if got := host.expect("peer.reconnected"); got["peerId"] != guestID {
+		t.Fatalf("peer.reconnected for %v, want %s", got["peerId"], guestID)
+	}
+
+	stale := dialTestClient(t, ts)
+	stale.send("session.resume", map[string]any{"resumeToken": token})
+	if got := stale.expect("error"); got["code"] != "session_expired" {
+		t.Fatalf("reused token error = %v", got["code"])
+	}
+}
+
+func TestSessionGraceExpiryReleasesSlot(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.resumeGrace = 50 * time.Millisecond
+	host, created := createRoom(t, ts, "Host")
+	guest, _ := joinRoom(t, ts, roomIDOf(created), "Guest")
+	host.expect("room.state")
+
+	_ = guest.conn.Close()
+	host.expect("peer.disconnected")
+	state := host.expect("room.state")
+	for {
+		players := state["room"].(map[string]any)["players"].([]any)
+		if len(players) == 1 {
+			return
+		}
+		state = host.expect("room.state")
+	}
+}
+
+func TestHostMigrationHandsOffSnapshot(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.resumeGrace = 0
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 42})
+	guest.expect("game.snapshot")
+
+	_ = host.conn.Close()
+	migrated := guest.expect("host.migrated")
+	if migrated["hostPeerId"] != guestID {
+		t.Fatalf("new host = %v, want %s", migrated["hostPeerId"], guestID)
+	}
+	snapshot, ok := migrated["snapshot"].(map[string]any)
+	if !ok || snapshot["tick"] != float64(42) {
+		t.Fatalf("snapshot = %v", migrated["snapshot"])
+	}
+}
+
+func TestHostDroppingMidMatchMigratesBeforeGraceEnds(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	hostID := created["selfPeerId"].(string)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 7})
+	guest.expect("game.snapshot")
+
+	_ = host.conn.Close()
+	migrated := guest.expect("host.migrated")
+	if migrated["hostPeerId"] != guestID || migrated["reason"] != "host_disconnected" {
+		t.Fatalf("host.migrated = %v", migrated)
+	}
+	if snapshot, ok := migrated["snapshot"].(map[string]any); !ok || snapshot["tick"] != float64(7) {
+		t.Fatalf("snapshot = %v", migrated["snapshot"])
+	}
+
+	// The old host keeps its seat and comes back as a guest.
+	again := dialTestClient(t, ts)
+	again.send("session.resume", map[string]any{"resumeToken": created["resumeToken"]})
+	resumed := again.expect("session.resumed")
+	for _, pl := range resumed["room"].(map[string]any)["players"].([]any) {
+		if entry := pl.(map[string]any); entry["peerId"] == hostID && entry["isHost"] != false {
+			t.Fatalf("old host seat = %v", entry)
+		}
+	}
+	if snap := again.expect("game.snapshot"); snap["tick"] != float64(7) {
+		t.Fatalf("resumed snapshot = %v", snap)
+	}
+}
+
+func TestHostVoteNeedsMajority(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	a, joinedA := joinRoom(t, ts, roomID, "A")
+	b, _ := joinRoom(t, ts, roomID, "B")
+	candidate := joinedA["selfPeerId"].(string)
+
+	b.send("host.vote", map[string]any{"peerId": candidate})
+	if tally := host.expect("host.vote"); tally["votes"] != float64(1) || tally["needed"] != float64(2) {
+		t.Fatalf("tally = %v", tally)
+	}
+	a.send("host.vote", map[string]any{"peerId": candidate})
+	if got := host.expect("host.migrated"); got["hostPeerId"] != candidate || got["reason"] != "vote" {
+		t.Fatalf("host.migrated = %v", got)
+	}
+}
+
+func TestServerHostedRoomRunsMatch(t *testing.T) {
+	_, ts := newTestServer(t)
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Hosted", "hostName": "Host", "serverHosted": true})
+	created := host.expect("room.created")
+	if created["room"].(map[string]any)["serverHosted"] != true {
+		t.Fatalf("room = %v", created["room"])
+	}
+	roomID := roomIDOf(created)
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+	if snap := guest.expect("game.snapshot"); snap["view"] != "shop" || snap["terrain"] == nil {
+		t.Fatalf("first snapshot view = %v terrain = %v", snap["view"], snap["terrain"] != nil)
+	}
+
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 1})
+	if got := host.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("client snapshot error = %v", got["code"])
+	}
+	host.send("shop.done", map[string]any{"roomId": roomID, "done": true})
+	guest.send("shop.done", map[string]any{"roomId": roomID, "done": true})
+	for i := 0; i < 32; i++ {
+		if snap := guest.expect("game.snapshot"); snap["view"] == "battle" {
+			return
+		}
+	}
+	t.Fatal("match never left the shop")
+}
+
+func TestShopRequestsAreValidated(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := guest.expect("error"); got["code"] != "shop_closed" {
+		t.Fatalf("buy before snapshot = %v", got["code"])
+	}
+
+	host.send("game.snapshot", map[string]any{
+		"roomId": roomID,
+		"view":   "shop",
+		"match": map[string]any{"players": []any{
+			map[string]any{"config": map[string]any{"id": guestID}, "cash": 1500, "inventory": map[string]any{}},
+		}},
+	})
+	guest.expect("game.snapshot")
+
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "nuke"})
+	if got := guest.expect("error"); got["code"] != "insufficient_funds" {
+		t.Fatalf("nuke buy = %v", got["code"])
+	}
+	guest.send("shop.sell", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := guest.expect("error"); got["code"] != "insufficient_stock" {
+		t.Fatalf("missile sell = %v", got["code"])
+	}
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := host.expect("shop.buy"); got["peerId"] != guestID || got["weaponId"] != "missile" {
+		t.Fatalf("forwarded buy = %v", got)
+	}
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := guest.expect("error"); got["code"] != "insufficient_funds" {
+		t.Fatalf("second missile buy = %v", got["code"])
+	}
+
+	// A snapshot from before the host applied the buy doesn't undo it.
+	shopSnapshot := func(cash int, inventory map[string]any) {
+		host.send("game.snapshot", map[string]any{
+			"roomId": roomID,
+			"view":   "shop",
+			"match": map[string]any{"players": []any{
+				map[string]any{"config": map[string]any{"id": guestID}, "cash": cash, "inventory": inventory},
+			}},
+		})
+		guest.expect("game.snapshot")
+	}
+	shopSnapshot(1500, map[string]any{})
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := guest.expect("error"); got["code"] != "insufficient_funds" {
+		t.Fatalf("missile buy after a stale snapshot = %v", got["code"])
+	}
+	shopSnapshot(300, map[string]any{"missile": 10})
+	shopSnapshot(2000, map[string]any{"missile": 10})
+	guest.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := host.expect("shop.buy"); got["weaponId"] != "missile" {
+		t.Fatalf("buy once the first was applied = %v", got)
+	}
+}
+
+func TestMatchEndReturnsToLobbyAndRematch(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	hostID := created["selfPeerId"].(string)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	if got := host.expect("error"); got["code"] != "bad_request" {
+		t.Fatalf("match.start mid-match = %v", got)
+	}
+
+	guest.send("match.end", map[string]any{"roomId": roomID})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest match.end error = %v", got)
+	}
+	host.send("match.end", map[string]any{"roomId": roomID, "scores": []any{
+		map[string]any{"peerId": hostID, "score": 1},
+		map[string]any{"peerId": guestID, "score": 3},
+	}})
+	ended := guest.expect("match.end")
+	results := ended["results"].([]any)
+	if ended["winnerPeerId"] != guestID || results[0].(map[string]any)["peerId"] != guestID || results[1].(map[string]any)["score"] != float64(1) {
+		t.Fatalf("match.end = %v", ended)
+	}
+	state := guest.expect("room.state")["room"].(map[string]any)
+	if state["status"] != "lobby" {
+		t.Fatalf("status = %v", state["status"])
+	}
+	for _, pl := range state["players"].([]any) {
+		if pl.(map[string]any)["ready"] != false {
+			t.Fatalf("player still ready: %v", pl)
+		}
+	}
+	host.send("room.list.request", map[string]any{})
+	if rooms := host.expect("room.list.response")["rooms"].([]any); len(rooms) != 1 {
+		t.Fatalf("room not listed after match end: %v", rooms)
+	}
+
+	host.send("match.rematch", map[string]any{"roomId": roomID})
+	if got := guest.expect("match.start"); got["rematch"] != true {
+		t.Fatalf("rematch start = %v", got)
+	}
+	host.send("match.end", map[string]any{"roomId": roomID})
+	guest.expect("match.end")
+	joinRoom(t, ts, roomID, "Late")
+	host.send("match.rematch", map[string]any{"roomId": roomID})
+	if got := host.expect("error"); got["code"] != "bad_request" {
+		t.Fatalf("rematch with a changed roster = %v", got)
+	}
+}
+
+func TestLateJoinerSpectates(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 7})
+	guest.expect("game.snapshot")
+
+	watcher, joined := joinRoom(t, ts, roomID, "Late")
+	watcherID := joined["selfPeerId"].(string)
+	for _, pl := range joined["room"].(map[string]any)["players"].([]any) {
+		if entry := pl.(map[string]any); entry["peerId"] == watcherID && entry["spectator"] != true {
+			t.Fatalf("late joiner is not a spectator: %v", entry)
+		}
+	}
+	if got := watcher.expect("game.snapshot"); got["tick"] != float64(7) {
+		t.Fatalf("cached snapshot = %v", got)
+	}
+
+	watcher.send("game.input", map[string]any{"roomId": roomID, "action": "fire"})
+	if got := watcher.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("spectator game.input = %v", got)
+	}
+	watcher.send("shop.buy", map[string]any{"roomId": roomID, "weaponId": "missile"})
+	if got := watcher.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("spectator shop.buy = %v", got)
+	}
+	watcher.send("chat.msg", map[string]any{"roomId": roomID, "text": "gl"})
+	if got := host.expect("chat.msg"); got["peerId"] != watcherID {
+		t.Fatalf("chat.msg = %v", got)
+	}
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 8})
+	if got := watcher.expect("game.snapshot"); got["tick"] != float64(8) {
+		t.Fatalf("relayed snapshot = %v", got)
+	}
+
+	host.send("room.list.request", map[string]any{})
+	rooms := host.expect("room.list.response")["rooms"].([]any)
+	if len(rooms) != 1 {
+		t.Fatalf("in-game room not listed for watching: %v", rooms)
+	}
+	if sum := rooms[0].(map[string]any); sum["players"] != float64(2) || sum["spectators"] != float64(1) {
+		t.Fatalf("summary = %v", sum)
+	}
+}
+
+func TestSpectatorsLeftAloneAreSentBack(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.resumeGrace = 0
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	watcher := dialTestClient(t, ts)
+	watcher.send("room.join", map[string]any{"roomId": roomID, "playerName": "Watcher", "spectator": true})
+	watcher.expect("room.joined")
+
+	_ = host.conn.Close()
+	if got := watcher.expect("room.closed"); got["roomId"] != roomID {
+		t.Fatalf("room.closed = %v", got)
+	}
+	watcher.send("room.list.request", map[string]any{})
+	if rooms := watcher.expect("room.list.response")["rooms"].([]any); len(rooms) != 0 {
+		t.Fatalf("closed room still listed: %v", rooms)
+	}
+}
+
+func TestPasswordProtectedUnlistedRoom(t *testing.T) {
+	_, ts := newTestServer(t)
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Private", "hostName": "Host", "password": "hunter2", "unlisted": true})
+	created := host.expect("room.created")
+	roomID := roomIDOf(created)
+	if state := created["room"].(map[string]any); state["passwordProtected"] != true || state["unlisted"] != true {
+		t.Fatalf("room state = %v", state)
+	}
+
+	guest := dialTestClient(t, ts)
+	guest.send("room.list.request", map[string]any{})
+	if rooms := guest.expect("room.list.response")["rooms"].([]any); len(rooms) != 0 {
+		t.Fatalf("unlisted room shown: %v", rooms)
+	}
+	guest.send("room.join", map[string]any{"roomId": roomID, "playerName": "Guest"})
+	guest.expect("room.auth_required")
+	guest.send("room.join", map[string]any{"roomId": roomID, "playerName": "Guest", "password": "hunter3"})
+	guest.expect("room.auth_failed")
+	guest.send("room.join", map[string]any{"roomId": roomID, "playerName": "Guest", "password": "hunter2"})
+	guest.expect("room.joined")
+}
+
+func TestHostKicksBansAndLocks(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+
+	guest.send("room.kick", map[string]any{"peerId": created["selfPeerId"]})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest kick = %v", got)
+	}
+	host.send("room.kick", map[string]any{"peerId": guestID})
+	if got := guest.expect("room.kicked"); got["reason"] != "kicked" {
+		t.Fatalf("room.kicked = %v", got)
+	}
+	for {
+		players := host.expect("room.state")["room"].(map[string]any)["players"].([]any)
+		if len(players) == 1 {
+			break
+		}
+	}
+	guest.send("chat.msg", map[string]any{"text": "still here?"})
+	if got := guest.expect("error"); got["code"] != "room_not_found" {
+		t.Fatalf("kicked peer chat = %v", got)
+	}
+
+	guest.send("room.join", map[string]any{"roomId": roomID, "playerName": "Guest"})
+	guestID = guest.expect("room.joined")["selfPeerId"].(string)
+	host.send("room.ban", map[string]any{"peerId": guestID})
+	if got := guest.expect("room.kicked"); got["reason"] != "banned" {
+		t.Fatalf("room.kicked = %v", got)
+	}
+	again := dialTestClient(t, ts)
+	again.send("room.join", map[string]any{"roomId": roomID, "playerName": "Sneaky"})
+	if got := again.expect("error"); got["code"] != "banned" {
+		t.Fatalf("join from a banned address = %v", got)
+	}
+
+	host.send("room.lock", map[string]any{"locked": true})
+	for host.expect("room.state")["room"].(map[string]any)["locked"] != true {
+	}
+	host.send("room.list.request", map[string]any{})
+	if rooms := host.expect("room.list.response")["rooms"].([]any); len(rooms) != 0 {
+		t.Fatalf("locked room listed: %v", rooms)
+	}
+}
+
+func TestHostOwnsRoomSettings(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	if settings := created["room"].(map[string]any)["settings"].(map[string]any); settings["terrainPreset"] != "random" {
+		t.Fatalf("default settings = %v", settings)
+	}
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+
+	guest.send("room.settings.update", map[string]any{"settings": map[string]any{"roundsToWin": 2}})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest settings update = %v", got)
+	}
+	host.send("room.settings.update", map[string]any{"settings": map[string]any{"windMode": "hurricane"}})
+	if got := host.expect("error"); got["code"] != "bad_request" {
+		t.Fatalf("invalid settings = %v", got)
+	}
+	host.send("room.settings.update", map[string]any{"settings": map[string]any{"terrainPreset": "canyon", "roundsToWin": 30, "turnTimeLimitSec": 20}})
+	var settings map[string]any
+	for settings == nil || settings["terrainPreset"] != "canyon" {
+		settings = guest.expect("room.state")["room"].(map[string]any)["settings"].(map[string]any)
+	}
+	if settings["roundsToWin"] != float64(9) || settings["gravity"] != float64(260) {
+		t.Fatalf("settings not clamped and merged: %v", settings)
+	}
+
+	guest.send("room.list.request", map[string]any{})
+	sum := guest.expect("room.list.response")["rooms"].([]any)[0].(map[string]any)["settings"].(map[string]any)
+	if sum["terrainPreset"] != "canyon" || sum["roundsToWin"] != float64(9) || sum["turnTimeLimitSec"] != float64(20) {
+		t.Fatalf("room list settings = %v", sum)
+	}
+}
+
+func TestHostAddsBotsToTheLineup(t *testing.T) {
+	_, ts := newTestServer(t)
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Bots", "hostName": "Host", "maxPlayers": 3})
+	roomID := roomIDOf(host.expect("room.created"))
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+
+	guest.send("room.ai.add", map[string]any{"aiLevel": "hard"})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest adding a bot = %v", got)
+	}
+	host.send("room.ai.add", map[string]any{"aiLevel": "impossible"})
+	if got := host.expect("error"); got["code"] != "bad_request" {
+		t.Fatalf("unknown level = %v", got)
+	}
+	host.send("room.ai.add", map[string]any{"aiLevel": "hard"})
+	var bot map[string]any
+	for bot == nil {
+		for _, pl := range guest.expect("room.state")["room"].(map[string]any)["players"].([]any) {
+			if entry := pl.(map[string]any); entry["bot"] == true {
+				bot = entry
+			}
+		}
+	}
+	if bot["aiLevel"] != "hard" {
+		t.Fatalf("bot = %v", bot)
+	}
+	host.send("room.ai.add", map[string]any{})
+	if got := host.expect("room.full"); got["currentPlayers"] != float64(3) {
+		t.Fatalf("room.full = %v", got)
+	}
+
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	roster := guest.expect("match.start")["roster"].([]any)
+	if len(roster) != 3 {
+		t.Fatalf("roster = %v", roster)
+	}
+	if last := roster[2].(map[string]any); last["kind"] != "ai" || last["peerId"] != bot["peerId"] {
+		t.Fatalf("bot missing from roster: %v", roster)
+	}
+}
+
+func TestRoomWithOnlyBotsCloses(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.resumeGrace = 0
+	host, created := createRoom(t, ts, "Host")
+	host.send("room.ai.add", map[string]any{})
+	host.expect("room.state")
+	host.send("room.leave", map[string]any{})
+	roomID := roomIDOf(created)
+	deadline := time.Now().Add(2 * time.Second)
+	for s.findRoom(roomID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("room kept alive by its bot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerAssignsUniqueColors(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	colorOf := func(state map[string]any, peerID string) any {
+		for _, pl := range state["room"].(map[string]any)["players"].([]any) {
+			if entry := pl.(map[string]any); entry["peerId"] == peerID {
+				return entry["colorIndex"]
+			}
+		}
+		return nil
+	}
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	if got := colorOf(joined, guestID); got != float64(1) {
+		t.Fatalf("guest color = %v", got)
+	}
+
+	guest.send("peer.color", map[string]any{"colorIndex": 0})
+	if got := guest.expect("error"); got["code"] != "color_taken" {
+		t.Fatalf("taken color = %v", got)
+	}
+	guest.send("peer.color", map[string]any{"colorIndex": 5})
+	for colorOf(host.expect("room.state"), guestID) != float64(5) {
+	}
+
+	second, joined := joinRoom(t, ts, roomID, "Second")
+	if got := colorOf(joined, joined["selfPeerId"].(string)); got != float64(1) {
+		t.Fatalf("second color = %v, want the first free slot", got)
+	}
+	secondID := joined["selfPeerId"].(string)
+	for colorOf(host.expect("room.state"), secondID) == nil {
+	}
+	second.send("room.leave", map[string]any{})
+	for colorOf(host.expect("room.state"), secondID) != nil {
+	}
+	_, joined = joinRoom(t, ts, roomID, "Third")
+	if got := colorOf(joined, joined["selfPeerId"].(string)); got != float64(1) {
+		t.Fatalf("third color = %v, want the slot freed by Second", got)
+	}
+}
+
+func TestRoomHasNoMoreSeatsThanColors(t *testing.T) {
+	_, ts := newTestServer(t)
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Crowd", "hostName": "Host", "maxPlayers": 10})
+	created := host.expect("room.created")
+	if got := created["room"].(map[string]any)["maxPlayers"]; got != float64(tankColorCount) {
+		t.Fatalf("maxPlayers = %v, want %d", got, tankColorCount)
+	}
+	roomID := roomIDOf(created)
+	var last map[string]any
+	for i := 2; i <= tankColorCount; i++ {
+		_, last = joinRoom(t, ts, roomID, "Guest"+strconv.Itoa(i))
+	}
+	colors := map[any]bool{}
+	for _, pl := range last["room"].(map[string]any)["players"].([]any) {
+		colors[pl.(map[string]any)["colorIndex"]] = true
+	}
+	if len(colors) != tankColorCount || colors[float64(noColor)] {
+		t.Fatalf("colors = %v, want %d distinct", colors, tankColorCount)
+	}
+
+	// The ninth player is turned away rather than given a color in use.
+	ninth := dialTestClient(t, ts)
+	ninth.send("room.join", map[string]any{"roomId": roomID, "playerName": "Ninth"})
+	if got := ninth.expect("room.full"); got["maxPlayers"] != float64(tankColorCount) {
+		t.Fatalf("room.full = %v", got)
+	}
+	host.send("room.ai.add", map[string]any{"aiLevel": "easy"})
+	host.expect("room.full")
+}
+
+func TestMatchStartCarriesSeed(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	start := guest.expect("match.start")
+	seed, _ := start["seed"].(string)
+	if _, err := strconv.ParseUint(seed, 16, 64); err != nil || len(seed) != 16 {
+		t.Fatalf("match.start seed = %q", seed)
+	}
+	host.send("match.end", map[string]any{"roomId": roomID, "scores": []any{}})
+	if end := guest.expect("match.end"); end["seed"] != seed {
+		t.Fatalf("match.end seed = %v, want %s", end["seed"], seed)
+	}
+}
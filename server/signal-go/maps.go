@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"scorched-signal-go/engine"
+	"scorched-signal-go/mtn"
+)
+
+//go:embed maps/builtin/*.MTN
+var builtinMapFiles embed.FS
+
+const (
+	mapsDirDefault = "maps"
+	// mapMaxBytes caps a custom map file; the largest bundled one is 140 KB.
+	mapMaxBytes   = 4 << 20
+	mapThumbWidth = 192
+)
+
+// mapIDPattern is what a map id may look like. Custom maps take the id of
+// their file name, so files with other names are skipped.
+var mapIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// gameMap is one map of the library, as listed at /api/maps.
+type gameMap struct {
+	ID string `json:"id"`
+	// Source is "builtin" for the maps the server ships with, "custom" for
+	// those found in its maps directory and "upload" for one a host sent
+	// their room.
+	Source       string `json:"source"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	// FileURL is where an uploaded map's file can be fetched.
+	FileURL string `json:"fileUrl,omitempty"`
+
+	file  *mtn.File
+	thumb []byte
+	// data and contentType are an uploaded map's file as it was sent.
+	data        []byte
+	contentType string
+	// size and modTime tell when a custom map's file has changed.
+	size    int64
+	modTime time.Time
+}
+
+func newGameMap(id, source string, data []byte) (*gameMap, error) {
+	f, err := mtn.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := renderMapThumbnail(f)
+	if err != nil {
+		return nil, err
+	}
+	return &gameMap{
+		ID:           id,
+		Source:       source,
+		Width:        f.Width,
+		Height:       f.Height,
+		ThumbnailURL: "/api/maps/" + id + ".png",
+		file:         f,
+		thumb:        thumb,
+	}, nil
+}
+
+// terrain returns the map at its own size, as match.start sends it.
+func (m *gameMap) terrain() *engine.Terrain {
+	return m.file.Terrain()
+}
+
+// renderMapThumbnail draws the map mapThumbWidth wide, or at its own size if
+// it is narrower, with the sky left transparent.
+func renderMapThumbnail(f *mtn.File) ([]byte, error) {
+	w := min(f.Width, mapThumbWidth)
+	h := max(1, f.Height*w/f.Width)
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for ty := 0; ty < h; ty++ {
+		sy := ty * f.Height / h
+		for tx := 0; tx < w; tx++ {
+			sx := tx * f.Width / w
+			if sy < f.Top(sx) {
+				continue
+			}
+			c := f.Palette[f.Pixel(sx, sy)&0x0F]
+			img.SetNRGBA(tx, ty, color.NRGBA{R: c[0], G: c[1], B: c[2], A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// builtinMaps parses the embedded maps once for every server.
+var builtinMaps = sync.OnceValue(func() []*gameMap {
+	entries, err := builtinMapFiles.ReadDir("maps/builtin")
+	if err != nil {
+		log.Fatalf("failed to read embedded maps: %v", err)
+	}
+	out := make([]*gameMap, 0, len(entries))
+	for _, entry := range entries {
+		data, err := builtinMapFiles.ReadFile(path.Join("maps/builtin", entry.Name()))
+		if err != nil {
+			log.Fatalf("failed to read embedded map %s: %v", entry.Name(), err)
+		}
+		m, err := newGameMap(strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())), "builtin", data)
+		if err != nil {
+			log.Fatalf("embedded map %s: %v", entry.Name(), err)
+		}
+		out = append(out, m)
+	}
+	return out
+})
+
+// mapLibrary is the embedded maps plus the .MTN files in dir. dir is
+// rescanned whenever the library is listed at /api/maps, so maps can be added
+// while the server runs; looking a map up only reads what the last scan
+// found. It is safe for concurrent use.
+type mapLibrary struct {
+	dir string
+
+	mu     sync.RWMutex
+	custom map[string]*gameMap
+}
+
+func newMapLibrary(dir string) *mapLibrary {
+	l := &mapLibrary{dir: dir, custom: make(map[string]*gameMap)}
+	l.refresh()
+	return l
+}
+
+// list rescans dir and returns every map, built-in ones first, each group by
+// id.
+func (l *mapLibrary) list() []*gameMap {
+	builtin := builtinMaps()
+	if l == nil {
+		return builtin
+	}
+	l.refresh()
+	l.mu.RLock()
+	custom := make([]*gameMap, 0, len(l.custom))
+	for _, m := range l.custom {
+		custom = append(custom, m)
+	}
+	l.mu.RUnlock()
+	sort.Slice(custom, func(a, b int) bool { return custom[a].ID < custom[b].ID })
+	return append(append([]*gameMap{}, builtin...), custom...)
+}
+
+// find returns the map with id as of the last scan, or nil.
+func (l *mapLibrary) find(id string) *gameMap {
+	if id == "" {
+		return nil
+	}
+	for _, m := range builtinMaps() {
+		if m.ID == id {
+			return m
+		}
+	}
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.custom[id]
+}
+
+// refresh rereads dir, parsing only files that are new or changed. Files that
+// fail to parse, or take a built-in map's id, are left out. Parsing happens
+// outside the lock so lookups are never held up by it.
+func (l *mapLibrary) refresh() {
+	if l.dir == "" {
+		return
+	}
+	l.mu.RLock()
+	known := l.custom
+	l.mu.RUnlock()
+	seen := make(map[string]*gameMap)
+	if entries, err := os.ReadDir(l.dir); err == nil {
+		taken := make(map[string]bool)
+		for _, m := range builtinMaps() {
+			taken[m.ID] = true
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			id := strings.TrimSuffix(name, filepath.Ext(name))
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(name), ".mtn") || !mapIDPattern.MatchString(id) || taken[id] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.Size() > mapMaxBytes {
+				continue
+			}
+			if m := known[id]; m != nil && m.size == info.Size() && m.modTime.Equal(info.ModTime()) {
+				seen[id] = m
+				taken[id] = true
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(l.dir, name))
+			if err != nil {
+				continue
+			}
+			m, err := newGameMap(id, "custom", data)
+			if err != nil {
+				log.Printf("map %s skipped: %v", name, err)
+				continue
+			}
+			m.size, m.modTime = info.Size(), info.ModTime()
+			seen[id] = m
+			taken[id] = true
+		}
+	}
+	l.mu.Lock()
+	l.custom = seen
+	l.mu.Unlock()
+}
+
+// handleMaps lists the map library at /api/maps and serves thumbnails at
+// /api/maps/ID.png.
+func (s *server) handleMaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The lobby may be served from elsewhere, such as the dev server.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/maps"), "/")
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"maps": s.maps.list()})
+		return
+	}
+	id, ok := strings.CutSuffix(name, ".png")
+	m := s.maps.find(id)
+	if !ok || m == nil {
+		http.Error(w, "no such map", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=300")
+	_, _ = w.Write(m.thumb)
+}
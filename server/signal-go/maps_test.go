@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tinyMap is a valid two-column .MTN file.
+func tinyMap() []byte {
+	out := []byte{'M', 'T', 0xEF, 0xBE, 0x00, 0x01}
+	for _, w := range []uint16{2, 4, 0, 0, 16, 0, 0, 0, 0} {
+		out = binary.LittleEndian.AppendUint16(out, w)
+	}
+	out = append(out, make([]byte, 48)...)
+	out = binary.LittleEndian.AppendUint16(out, 3)
+	out = append(out, 0x12, 0x30)
+	out = binary.LittleEndian.AppendUint16(out, 4)
+	return append(out, 0x45, 0x67)
+}
+
+func TestMapLibraryServesBuiltinAndCustomMaps(t *testing.T) {
+	s, _ := newTestServer(t)
+	dir := t.TempDir()
+	s.maps = newMapLibrary(dir)
+	for name, data := range map[string][]byte{
+		"valley.mtn": tinyMap(),
+		"broken.MTN": []byte("not a map"),
+		"ICE001.MTN": tinyMap(),
+		"notes.txt":  tinyMap(),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := httptest.NewRecorder()
+	s.handleMaps(list, httptest.NewRequest(http.MethodGet, "/api/maps", nil))
+	var listing struct {
+		Maps []gameMap `json:"maps"`
+	}
+	if err := json.Unmarshal(list.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("listing = %s (%v)", list.Body.String(), err)
+	}
+	sources := map[string]string{}
+	for _, m := range listing.Maps {
+		sources[m.ID] = m.Source
+	}
+	if len(listing.Maps) != len(builtinMaps())+1 || sources["valley"] != "custom" || sources["ICE001"] != "builtin" {
+		t.Fatalf("maps = %+v", listing.Maps)
+	}
+	ice := listing.Maps[0]
+	if ice.ID != "ICE001" || ice.Width != 712 || ice.Height != 175 || ice.ThumbnailURL != "/api/maps/ICE001.png" {
+		t.Fatalf("first map = %+v", ice)
+	}
+
+	thumb := httptest.NewRecorder()
+	s.handleMaps(thumb, httptest.NewRequest(http.MethodGet, ice.ThumbnailURL, nil))
+	img, err := png.Decode(bytes.NewReader(thumb.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("thumbnail: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != mapThumbWidth || b.Dy() != 175*mapThumbWidth/712 {
+		t.Fatalf("thumbnail size = %v", b)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Fatal("sky is not transparent")
+	}
+
+	// Maps dropped in while the server runs show up once the library is
+	// listed again; looking one up doesn't rescan.
+	if err := os.WriteFile(filepath.Join(dir, "ridge.MTN"), tinyMap(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s.maps.find("ridge") != nil {
+		t.Fatal("lookup rescanned the maps directory")
+	}
+	s.handleMaps(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/maps", nil))
+	if s.maps.find("ridge") == nil {
+		t.Fatal("new map not found")
+	}
+	for _, path := range []string{"/api/maps/broken.png", "/api/maps/valley", "/api/maps/missing.png"} {
+		got := httptest.NewRecorder()
+		s.handleMaps(got, httptest.NewRequest(http.MethodGet, path, nil))
+		if got.Code != http.StatusNotFound {
+			t.Fatalf("%s status = %d", path, got.Code)
+		}
+	}
+}
+
+func TestHostPicksMapSentAtMatchStart(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	host.send("room.map", map[string]any{"mapId": "NOPE"})
+	if got := host.expect("error"); got["code"] != "map_not_found" {
+		t.Fatalf("unknown map error = %v", got)
+	}
+	host.send("room.map", map[string]any{"mapId": "ROCK006"})
+	for host.expect("room.state")["room"].(map[string]any)["mapId"] != "ROCK006" {
+	}
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+	guest.send("room.map", map[string]any{"mapId": "ICE001"})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest map error = %v", got)
+	}
+
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	start := guest.expect("match.start")
+	picked, ok := start["map"].(map[string]any)
+	if !ok || picked["id"] != "ROCK006" {
+		t.Fatalf("match.start map = %v", start["map"])
+	}
+	terrain := picked["terrain"].(map[string]any)
+	if terrain["width"] != float64(419) || terrain["height"] != float64(221) || len(terrain["heights"].([]any)) != 419 {
+		t.Fatalf("terrain = %v x %v", terrain["width"], terrain["height"])
+	}
+	if len(terrain["colorPalette"].([]any)) != 16 || terrain["colorIndicesB64"] == "" {
+		t.Fatal("terrain lost its colors")
+	}
+}
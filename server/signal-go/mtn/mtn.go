@@ -0,0 +1,185 @@
+// Package mtn reads Scorched Earth .MTN terrain files. It is a port of
+// parseMtn in src/utils/mtn.js and decodes the same files to the same
+// columns and pixels, so the server can check and serve maps itself.
+//
+// A file is laid out as
+//
+//	"MT"      signature
+//	u16       0xBEEF marker, in either byte order
+//	u16 BE    version
+//	9 x u16   header words; the first is the width
+//	16 x RGB  palette
+//	columns, left to right:
+//	  u16     nibble count
+//	  nibbles palette indices from the top of the ground down, packed
+//	          high nibble first and padded to a whole byte
+//
+// The height isn't stored directly; it is inferred from the header words and
+// the tallest column the way the browser does.
+package mtn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"scorched-signal-go/engine"
+)
+
+const (
+	headerOffset  = 6
+	paletteOffset = 24
+	dataOffset    = 72
+	// MaxDimension bounds width and height. The bundled maps are at most
+	// 1483 wide and 295 tall; the browser parser itself accepts heights up
+	// to 4095 + 1.
+	MaxDimension = 4096
+	// SkyIndex is the palette index of pixels above a column's ground.
+	SkyIndex = 0
+)
+
+// File is a decoded .MTN file.
+type File struct {
+	Version     int
+	Width       int
+	Height      int
+	HeaderWords [9]uint16
+	Palette     [16][3]uint8
+	// Columns holds each column's palette indices from the top of its ground
+	// to the bottom of the map.
+	Columns [][]uint8
+	// BytesRead is where the column data ended; TrailingBytes is what
+	// followed it.
+	BytesRead     int
+	TrailingBytes int
+}
+
+// Parse decodes an .MTN file. It fails on anything parseMtn rejects, and on
+// maps larger than MaxDimension either way.
+func Parse(data []byte) (*File, error) {
+	if len(data) < dataOffset {
+		return nil, fmt.Errorf("invalid MTN file: expected at least %d bytes, got %d", dataOffset, len(data))
+	}
+	if data[0] != 'M' || data[1] != 'T' {
+		return nil, errors.New(`invalid MTN signature: missing "MT" prefix`)
+	}
+	if binary.LittleEndian.Uint16(data[2:]) != 0xBEEF && binary.BigEndian.Uint16(data[2:]) != 0xBEEF {
+		return nil, fmt.Errorf("invalid MTN signature marker: expected 0xBEEF, got %#x", binary.BigEndian.Uint16(data[2:]))
+	}
+	f := &File{Version: int(binary.BigEndian.Uint16(data[4:]))}
+	for i := range f.HeaderWords {
+		f.HeaderWords[i] = binary.LittleEndian.Uint16(data[headerOffset+i*2:])
+	}
+	f.Width = int(f.HeaderWords[0])
+	if f.Width <= 0 || f.Width > MaxDimension {
+		return nil, fmt.Errorf("invalid MTN width in header: %d", f.Width)
+	}
+	for i := range f.Palette {
+		copy(f.Palette[i][:], data[paletteOffset+i*3:])
+	}
+
+	f.Columns = make([][]uint8, f.Width)
+	offset, tallest := dataOffset, 0
+	for x := range f.Columns {
+		if offset+2 > len(data) {
+			return nil, fmt.Errorf("unexpected EOF while reading column length at x=%d", x)
+		}
+		n := int(binary.LittleEndian.Uint16(data[offset:]))
+		offset += 2
+		packed := (n + 1) / 2
+		if offset+packed > len(data) {
+			return nil, fmt.Errorf("unexpected EOF while reading column data at x=%d", x)
+		}
+		column := make([]uint8, n)
+		for i := range column {
+			b := data[offset+i/2]
+			if i%2 == 0 {
+				column[i] = b >> 4
+			} else {
+				column[i] = b & 0x0F
+			}
+		}
+		offset += packed
+		f.Columns[x] = column
+		tallest = max(tallest, n)
+	}
+	f.Height = inferHeightMinusOne(f.HeaderWords, tallest) + 1
+	if f.Height > MaxDimension {
+		return nil, fmt.Errorf("invalid MTN height: %d", f.Height)
+	}
+	f.BytesRead, f.TrailingBytes = offset, len(data)-offset
+	return f, nil
+}
+
+// inferHeightMinusOne picks the header word that best fits the tallest
+// column as the height less one, falling back to the column itself.
+func inferHeightMinusOne(words [9]uint16, tallest int) int {
+	best, bestDist := -1, 0
+	for _, w := range words[1:] {
+		v := int(w)
+		if v < tallest-1 || v > 4095 {
+			continue
+		}
+		dist := v + 1 - tallest
+		if dist < 0 {
+			dist = -dist
+		}
+		if best < 0 || dist < bestDist {
+			best, bestDist = v, dist
+		}
+	}
+	if best < 0 {
+		return max(0, tallest-1)
+	}
+	return best
+}
+
+// Top returns the first row of column x's ground; Height if it has none.
+func (f *File) Top(x int) int {
+	return f.Height - len(f.Columns[x])
+}
+
+// Pixel returns the palette index at (x, y), SkyIndex above the ground.
+// Columns taller than the map lose their top rows.
+func (f *File) Pixel(x, y int) uint8 {
+	column := f.Columns[x]
+	if i := y - f.Top(x); i >= 0 && i < len(column) {
+		return column[i]
+	}
+	return SkyIndex
+}
+
+// Pixels returns every pixel, row-major.
+func (f *File) Pixels() []byte {
+	out := make([]byte, f.Width*f.Height)
+	for x := 0; x < f.Width; x++ {
+		for y := max(0, f.Top(x)); y < f.Height; y++ {
+			out[y*f.Width+x] = f.Pixel(x, y)
+		}
+	}
+	return out
+}
+
+// Terrain returns the map at its own size as terrain: each column is solid
+// from its top down and keeps its palette indices.
+func (f *File) Terrain() *engine.Terrain {
+	t := &engine.Terrain{
+		Width:        f.Width,
+		Height:       f.Height,
+		Heights:      make([]int, f.Width),
+		Mask:         make([]byte, f.Width*f.Height),
+		ColorIndices: f.Pixels(),
+		ColorPalette: make([][3]int, len(f.Palette)),
+	}
+	for x := 0; x < f.Width; x++ {
+		top := max(0, f.Top(x))
+		t.Heights[x] = top
+		for y := top; y < f.Height; y++ {
+			t.Mask[y*f.Width+x] = 1
+		}
+	}
+	for i, c := range f.Palette {
+		t.ColorPalette[i] = [3]int{int(c[0]), int(c[1]), int(c[2])}
+	}
+	return t
+}
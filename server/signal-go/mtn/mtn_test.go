@@ -0,0 +1,164 @@
+package mtn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// bundledDir holds the maps the browser ships.
+const bundledDir = "../../../src/assets/mtn"
+
+// smallFile is the two-column map from src/utils/mtn.test.ts.
+func smallFile() []byte {
+	out := []byte{'M', 'T', 0xEF, 0xBE, 0x00, 0x01}
+	for _, w := range []uint16{2, 4, 0, 0, 16, 0, 0, 0, 0} {
+		out = binary.LittleEndian.AppendUint16(out, w)
+	}
+	for i := byte(0); i < 16; i++ {
+		out = append(out, i, i+1, i+2)
+	}
+	out = binary.LittleEndian.AppendUint16(out, 3)
+	out = append(out, 0x12, 0x30)
+	out = binary.LittleEndian.AppendUint16(out, 4)
+	return append(out, 0x45, 0x67)
+}
+
+func TestParseSmallFile(t *testing.T) {
+	f, err := Parse(smallFile())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if f.Width != 2 || f.Height != 5 || f.Version != 1 || f.TrailingBytes != 0 {
+		t.Fatalf("file = %dx%d v%d, %d trailing", f.Width, f.Height, f.Version, f.TrailingBytes)
+	}
+	if want := [][]uint8{{1, 2, 3}, {4, 5, 6, 7}}; !reflect.DeepEqual(f.Columns, want) {
+		t.Fatalf("columns = %v, want %v", f.Columns, want)
+	}
+	if want := []byte{0, 0, 0, 4, 1, 5, 2, 6, 3, 7}; !bytes.Equal(f.Pixels(), want) {
+		t.Fatalf("pixels = %v, want %v", f.Pixels(), want)
+	}
+	if f.Palette[15] != [3]uint8{15, 16, 17} {
+		t.Fatalf("palette[15] = %v", f.Palette[15])
+	}
+
+	terrain := f.Terrain()
+	if !reflect.DeepEqual(terrain.Heights, []int{2, 1}) {
+		t.Fatalf("heights = %v", terrain.Heights)
+	}
+	if want := []byte{0, 0, 0, 1, 1, 1, 1, 1, 1, 1}; !bytes.Equal(terrain.Mask, want) {
+		t.Fatalf("mask = %v, want %v", terrain.Mask, want)
+	}
+}
+
+func TestParseRejectsBrokenFiles(t *testing.T) {
+	valid := smallFile()
+	tests := map[string][]byte{
+		"short":       valid[:71],
+		"signature":   append([]byte("XT"), valid[2:]...),
+		"marker":      append([]byte{'M', 'T', 0x12, 0x34}, valid[4:]...),
+		"zero width":  append(append([]byte{}, valid[:6]...), append([]byte{0, 0}, valid[8:]...)...),
+		"column size": valid[:73],
+		"column data": valid[:len(valid)-1],
+		"wide":        append(append([]byte{}, valid[:6]...), append([]byte{0x01, 0x10}, valid[8:]...)...),
+	}
+	// A single column taller than any header word fits leaves the height to
+	// the column, which is past MaxDimension.
+	tall := append([]byte{}, valid[:6]...)
+	tall = append(tall, 1, 0)
+	tall = append(tall, valid[8:72]...)
+	tall = binary.LittleEndian.AppendUint16(tall, 5000)
+	tests["tall"] = append(tall, make([]byte, 2500)...)
+	for name, data := range tests {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: Parse succeeded", name)
+		}
+	}
+}
+
+// TestParityWithBrowserParser checks the bundled maps against what parseMtn
+// made of them, recorded in testdata/parity.json by testdata/parity.mjs.
+func TestParityWithBrowserParser(t *testing.T) {
+	data, err := os.ReadFile("testdata/parity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []struct {
+		Name          string       `json:"name"`
+		Version       int          `json:"version"`
+		Width         int          `json:"width"`
+		Height        int          `json:"height"`
+		HeaderWords   [9]uint16    `json:"headerWords"`
+		Palette       [16][3]uint8 `json:"palette"`
+		BytesRead     int          `json:"bytesRead"`
+		TrailingBytes int          `json:"trailingBytes"`
+		ColumnsSHA256 string       `json:"columnsSha256"`
+		PixelsSHA256  string       `json:"pixelsSha256"`
+	}
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(bundledDir); err != nil {
+		t.Skipf("bundled maps not found: %v", err)
+	}
+	for _, w := range want {
+		raw, err := os.ReadFile(filepath.Join(bundledDir, w.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", w.Name, err)
+		}
+		if f.Version != w.Version || f.Width != w.Width || f.Height != w.Height || f.HeaderWords != w.HeaderWords ||
+			f.Palette != w.Palette || f.BytesRead != w.BytesRead || f.TrailingBytes != w.TrailingBytes {
+			t.Fatalf("%s: header differs from the browser parser", w.Name)
+		}
+		columns := sha256.New()
+		for _, column := range f.Columns {
+			columns.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(column))))
+			columns.Write(column)
+		}
+		if got := hex.EncodeToString(columns.Sum(nil)); got != w.ColumnsSHA256 {
+			t.Fatalf("%s: columns differ from the browser parser", w.Name)
+		}
+		pixels := sha256.Sum256(f.Pixels())
+		if got := hex.EncodeToString(pixels[:]); got != w.PixelsSHA256 {
+			t.Fatalf("%s: pixels differ from the browser parser", w.Name)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(smallFile())
+	if paths, _ := filepath.Glob(filepath.Join(bundledDir, "*.MTN")); len(paths) > 0 {
+		raw, err := os.ReadFile(paths[0])
+		if err == nil {
+			f.Add(raw)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := Parse(data)
+		if err != nil {
+			return
+		}
+		if file.Width > MaxDimension || file.Height > MaxDimension || file.BytesRead+file.TrailingBytes != len(data) {
+			t.Fatalf("parsed %dx%d, read %d + %d of %d bytes", file.Width, file.Height, file.BytesRead, file.TrailingBytes, len(data))
+		}
+		terrain := file.Terrain()
+		if len(terrain.Heights) != file.Width || len(terrain.Mask) != file.Width*file.Height || len(terrain.ColorIndices) != len(terrain.Mask) {
+			t.Fatalf("terrain shape %d heights, %d mask for %dx%d", len(terrain.Heights), len(terrain.Mask), file.Width, file.Height)
+		}
+		for x, top := range terrain.Heights {
+			if top < 0 || top > file.Height {
+				t.Fatalf("column %d top %d outside 0..%d", x, top, file.Height)
+			}
+		}
+	})
+}
@@ -0,0 +1,32 @@
+// Regenerates parity.json from the browser parser:
+//
+//	node server/signal-go/mtn/testdata/parity.mjs > server/signal-go/mtn/testdata/parity.json
+//
+// run from the repository root.
+import { createHash } from 'node:crypto';
+import { readdirSync, readFileSync } from 'node:fs';
+import { parseMtn } from '../../../../src/utils/mtn.js';
+
+const dir = new URL('../../../../src/assets/mtn/', import.meta.url);
+const files = [];
+for (const name of readdirSync(dir).filter((f) => f.endsWith('.MTN')).sort()) {
+  const parsed = parseMtn(readFileSync(new URL(name, dir)));
+  // Each column is its u16 LE length then one byte per nibble.
+  const columns = createHash('sha256');
+  for (const column of parsed.columns) {
+    columns.update(Uint8Array.from([column.length & 0xff, column.length >> 8, ...column]));
+  }
+  files.push({
+    name,
+    version: parsed.version,
+    width: parsed.width,
+    height: parsed.height,
+    headerWords: parsed.headerWords,
+    palette: parsed.palette,
+    bytesRead: parsed.bytesRead,
+    trailingBytes: parsed.trailingBytes,
+    columnsSha256: columns.digest('hex'),
+    pixelsSha256: createHash('sha256').update(Uint8Array.from(parsed.pixels.flat())).digest('hex'),
+  });
+}
+console.log(`[\n${files.map((f) => `  ${JSON.stringify(f)}`).join(',\n')}\n]`);
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// peerQueueSize is how many messages may wait for a slow peer before it
+	// is disconnected. Snapshots only ever take one slot.
+	peerQueueSize = 256
+	// writeTimeout bounds a single frame write to a stalled connection.
+	writeTimeout = 10 * time.Second
+
+	heartbeatEveryDefault  = 10 * time.Second
+	heartbeatMissesDefault = 3
+	// latencyReportStep is how far a peer's latency has to move before the
+	// lobby is sent a fresh room.state.
+	latencyReportStep = 5
+)
+
+// heartbeat is how often the writer pings a peer and how many pings in a row
+// may go unanswered before the peer is dropped. A zero every disables it.
+type heartbeat struct {
+	every  time.Duration
+	misses int
+}
+
+// outbound is one entry in a peer's write queue.
+type outbound struct {
+	opcode  byte
+	payload []byte
+	// snapshot stands in for the peer's pending snapshot, encoded when the
+	// writer reaches it.
+	snapshot bool
+	// stop ends the writer once everything ahead of it is written.
+	stop bool
+}
+
+// newPeer wraps an upgraded connection and starts its writer. Nothing else
+// writes to conn, so a stalled client only ever holds up its own queue.
+func newPeer(id string, conn net.Conn, deflate *deflater, hb heartbeat) *peer {
+	p := &peer{
+		conn:       conn,
+		deflate:    deflate,
+		heartbeat:  hb,
+		queue:      make(chan outbound, peerQueueSize),
+		writerDone: make(chan struct{}),
+	}
+	p.setID(id)
+	p.latencyMs.Store(-1)
+	go p.writeLoop()
+	return p
+}
+
+func (p *peer) id() string { return *p.ident.Load() }
+
+func (p *peer) setID(id string) { p.ident.Store(&id) }
+
+func (p *peer) writeText(payload []byte) {
+	p.enqueue(outbound{opcode: opText, payload: payload})
+}
+
+func (p *peer) writeBinary(payload []byte) {
+	p.enqueue(outbound{opcode: opBinary, payload: payload})
+}
+
+func (p *peer) writePong(payload []byte) {
+	p.enqueue(outbound{opcode: opPong, payload: payload})
+}
+
+// enqueue hands msg to the writer without blocking. A peer whose queue is
+// full has fallen too far behind to catch up and is disconnected.
+func (p *peer) enqueue(msg outbound) {
+	if p.closed.Load() {
+		return
+	}
+	select {
+	case p.queue <- msg:
+	default:
+		if !p.closed.Swap(true) {
+			log.Printf("peer %s: outbound queue full, disconnecting", p.id())
+		}
+		_ = p.conn.Close()
+	}
+}
+
+// queueSnapshot makes snap the peer's pending snapshot. A snapshot that is
+// still waiting is replaced rather than queued behind, so a lagging peer
+// skips to the latest state.
+func (p *peer) queueSnapshot(snap roomSnapshot) {
+	p.snapMu.Lock()
+	if p.snapQueued && (p.pendingSnap.carriesTerrain || p.missedTerrain) {
+		p.missedTerrain = true
+	}
+	p.pendingSnap = snap
+	queued := p.snapQueued
+	p.snapQueued = true
+	p.snapMu.Unlock()
+	if !queued {
+		p.enqueue(outbound{snapshot: true})
+	}
+}
+
+// closeWith starts or answers the close handshake. The read loop keeps
+// running until the other side's close frame arrives or the deadline
+// passes, then the connection is torn down by dropPeer.
+func (p *peer) closeWith(code int, reason string) {
+	if p.closed.Swap(true) {
+		return
+	}
+	select {
+	case p.queue <- outbound{opcode: opClose, payload: closePayload(code, reason)}:
+	default:
+		_ = p.conn.Close()
+	}
+}
+
+// shutdown lets the writer finish what is already queued, including any
+// close frame, and then close the connection.
+func (p *peer) shutdown() {
+	p.closed.Store(true)
+	p.stopOnce.Do(func() {
+		select {
+		case p.queue <- outbound{stop: true}:
+		default:
+			_ = p.conn.Close()
+		}
+	})
+}
+
+func (p *peer) writeLoop() {
+	defer close(p.writerDone)
+	defer func() {
+		p.closed.Store(true)
+		_ = p.conn.Close()
+	}()
+	var ticks <-chan time.Time
+	if p.heartbeat.every > 0 {
+		ticker := time.NewTicker(p.heartbeat.every)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	missed := 0
+	for {
+		var msg outbound
+		select {
+		case msg = <-p.queue:
+		case <-ticks:
+			if p.pingSeq.Load() != p.pongSeq.Load() {
+				missed++
+			} else {
+				missed = 0
+			}
+			if missed >= p.heartbeat.misses {
+				log.Printf("peer %s missed %d heartbeats, dropping", p.id(), missed)
+				return
+			}
+			msg = outbound{opcode: opPing, payload: p.nextPing()}
+		}
+		if msg.stop {
+			return
+		}
+		opcode, payload := msg.opcode, msg.payload
+		if msg.snapshot {
+			p.snapMu.Lock()
+			snap, missedTerrain := p.pendingSnap, p.missedTerrain
+			p.pendingSnap, p.snapQueued, p.missedTerrain = roomSnapshot{}, false, false
+			p.snapMu.Unlock()
+			var err error
+			if opcode, payload, err = snap.encodeFor(&p.snapshots, missedTerrain); err != nil {
+				continue
+			}
+		}
+		timeout := writeTimeout
+		if opcode == opClose {
+			timeout = closeHandshakeTimeout
+		}
+		_ = p.conn.SetWriteDeadline(time.Now().Add(timeout))
+		if err := p.writeFrame(opcode, payload); err != nil {
+			if !isExpectedConnClose(err) {
+				log.Printf("ws write error (%s): %v", p.id(), err)
+			}
+			return
+		}
+		if opcode == opClose {
+			_ = p.conn.SetReadDeadline(time.Now().Add(closeHandshakeTimeout))
+		}
+	}
+}
+
+// nextPing records a new outstanding ping and returns its payload, the
+// sequence number the pong has to echo.
+func (p *peer) nextPing() []byte {
+	seq := p.pingSeq.Load() + 1
+	p.pingSentAt.Store(time.Now().UnixNano())
+	p.pingSeq.Store(seq)
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+// notePong matches a pong against the outstanding ping and updates the
+// measured latency. It reports whether the latency changed enough to be
+// worth announcing.
+func (p *peer) notePong(payload []byte) bool {
+	if len(payload) != 8 {
+		return false
+	}
+	seq := binary.BigEndian.Uint64(payload)
+	if seq == 0 || seq != p.pingSeq.Load() {
+		// Unsolicited, or answering a ping we already gave up on.
+		return false
+	}
+	rtt := time.Duration(time.Now().UnixNano() - p.pingSentAt.Load())
+	p.pongSeq.Store(seq)
+	ms := rtt.Milliseconds()
+	prev := p.latencyMs.Swap(ms)
+	return prev < 0 || ms-prev >= latencyReportStep || prev-ms >= latencyReportStep
+}
+
+// writeFrame writes one message, compressing data frames when
+// permessage-deflate was negotiated.
+func (p *peer) writeFrame(opcode byte, payload []byte) error {
+	if p.deflate != nil && (opcode == opText || opcode == opBinary) {
+		if compressed, ok := p.deflate.compress(payload); ok {
+			return writeWSFrameRSV(p.conn, rsv1, opcode, compressed)
+		}
+	}
+	return writeWSFrame(p.conn, opcode, payload)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func pipePeer(t *testing.T, hb heartbeat) (*peer, *testClient) {
+	t.Helper()
+	server, client := net.Pipe()
+	p := newPeer("peer-1", server, nil, hb)
+	t.Cleanup(func() {
+		p.shutdown()
+		_ = client.Close()
+	})
+	return p, &testClient{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+func TestSnapshotsCoalesceLatestWins(t *testing.T) {
+	p, c := pipePeer(t, heartbeat{})
+	// net.Pipe writes block until read, so the writer stalls on this message
+	// while the snapshots pile up behind it.
+	p.writeText([]byte(`{"type":"chat.msg"}`))
+	for tick := 1; tick <= 5; tick++ {
+		raw, _ := json.Marshal(map[string]any{"tick": tick})
+		p.queueSnapshot(roomSnapshot{seq: uint32(tick), raw: raw, frames: &snapshotFrames{}})
+	}
+	p.writeText([]byte(`{"type":"room.state"}`))
+
+	if got := c.expect("chat.msg"); got != nil {
+		t.Fatalf("chat payload = %v", got)
+	}
+	if got := c.expect("game.snapshot"); got["tick"] != float64(5) {
+		t.Fatalf("first snapshot written = %v, want the latest", got)
+	}
+	c.expect("room.state")
+}
+
+func TestQueueOverflowDisconnects(t *testing.T) {
+	p, _ := pipePeer(t, heartbeat{})
+	for i := 0; i < peerQueueSize+2; i++ {
+		p.writeText([]byte(`{"type":"chat.msg"}`))
+	}
+	if !p.closed.Load() {
+		t.Fatal("peer still open after its queue overflowed")
+	}
+	select {
+	case <-p.writerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer did not exit")
+	}
+}
+
+func TestShutdownFlushesCloseFrame(t *testing.T) {
+	p, c := pipePeer(t, heartbeat{})
+	p.writeText([]byte(`{"type":"chat.msg"}`))
+	p.closeWith(closeGoingAway, "bye")
+	p.shutdown()
+	p.writeText([]byte(`{"type":"late"}`))
+	c.expect("chat.msg")
+	if code := c.expectClose(); code != closeGoingAway {
+		t.Fatalf("close code = %d", code)
+	}
+	select {
+	case <-p.writerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer did not exit")
+	}
+}
+
+func TestCoalescedSnapshotKeepsSkippedTerrain(t *testing.T) {
+	r := &room{RoomID: "r"}
+	p, c := pipePeer(t, heartbeat{})
+	p.writeText([]byte(`{"type":"chat.msg"}`))
+	for tick, terrain := range []json.RawMessage{testTerrainJSON(8, 4, 1, -1), nil} {
+		fields := map[string]any{"tick": tick + 1}
+		if terrain != nil {
+			fields["terrain"] = terrain
+		}
+		raw, _ := json.Marshal(fields)
+		snap, err := decodeSnapshot(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.queueSnapshot(r.prepareSnapshot(snap))
+	}
+	c.expect("chat.msg")
+	got := c.expect("game.snapshot")
+	terrain, _ := got["terrain"].(map[string]any)
+	if got["tick"] != float64(2) || terrain["width"] != float64(8) {
+		t.Fatalf("coalesced snapshot = %v", got)
+	}
+}
+
+func TestMissedHeartbeatsDropPeer(t *testing.T) {
+	p, c := pipePeer(t, heartbeat{every: 20 * time.Millisecond, misses: 2})
+	// Read the pings but never answer them.
+	go func() { _, _ = io.Copy(io.Discard, c.conn) }()
+	select {
+	case <-p.writerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("silent peer was not dropped")
+	}
+	if !p.closed.Load() {
+		t.Fatal("dropped peer not marked closed")
+	}
+}
+
+func TestPongLatencyShownInRoomState(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.heartbeat = heartbeat{every: 50 * time.Millisecond, misses: 10}
+	host, _ := createRoom(t, ts, "Host")
+	for i := 0; ; i++ {
+		if i == 32 {
+			t.Fatal("never pinged")
+		}
+		if first, data := host.readFrame(); first == 0x80|opPing {
+			host.writeFrame(0x80|opPong, data)
+			break
+		}
+	}
+	players := host.expect("room.state")["room"].(map[string]any)["players"].([]any)
+	if _, ok := players[0].(map[string]any)["latencyMs"].(float64); !ok {
+		t.Fatalf("players = %v", players)
+	}
+}
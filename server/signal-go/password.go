@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+const (
+	maxPasswordLength = 64
+	passwordSaltSize  = 16
+)
+
+// roomPassword is a salted SHA-256 of a room's join password. The plain
+// password is never kept.
+type roomPassword struct {
+	salt []byte
+	hash [sha256.Size]byte
+}
+
+func newRoomPassword(plain string) (*roomPassword, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &roomPassword{salt: salt, hash: saltedHash(salt, plain)}, nil
+}
+
+// matches reports whether plain is the room's password, in constant time.
+func (rp *roomPassword) matches(plain string) bool {
+	got := saltedHash(rp.salt, plain)
+	return subtle.ConstantTimeCompare(got[:], rp.hash[:]) == 1
+}
+
+func saltedHash(salt []byte, plain string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(plain))
+	var out [sha256.Size]byte
+	h.Sum(out[:0])
+	return out
+}
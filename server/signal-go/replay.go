@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"scorched-signal-go/engine"
+)
+
+// A replay file (.screp) is a gzip stream of
+//
+//	"SCRP"   magic
+//	u8       version (replayFormatVersion)
+//	uvarint  len, header JSON (replayHeader)
+//	records until EOF:
+//	  uvarint  milliseconds since the previous record
+//	  u8       kind (replayRecord*)
+//	  uvarint  len, payload JSON
+//
+// Snapshot records hold the game.snapshot payload exactly as it was relayed,
+// terrain included, so playback can feed them to the live snapshot decoder.
+
+const (
+	replayMagic         = "SCRP"
+	replayFormatVersion = 1
+	replayExt           = ".screp"
+	// replayMaxBytes caps the payload bytes one recording may hold; past it
+	// the rest of the match goes unrecorded.
+	replayMaxBytes = 64 << 20
+)
+
+const (
+	replayRecordSnapshot byte = 1
+	// replayRecordInput payloads are {"peerId", "data"}, as relayed to the host.
+	replayRecordInput byte = 2
+	// replayRecordEnd closes a recording; its payload is the match.end payload,
+	// or {} if the room went away first.
+	replayRecordEnd byte = 3
+)
+
+// replayIDPattern is what a replay id may look like, so ids from URLs can't
+// reach outside the replay directory.
+var replayIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// replayHeader describes the recorded match.
+type replayHeader struct {
+	Version      int             `json:"version"`
+	RoomID       string          `json:"roomId"`
+	RoomName     string          `json:"roomName"`
+	ServerHosted bool            `json:"serverHosted"`
+	Seed         string          `json:"seed"`
+	Settings     engine.Settings `json:"settings"`
+	Players      []rosterEntry   `json:"players"`
+	StartedAt    int64           `json:"startedAt"`
+}
+
+// replayRecord is one recorded message, At after the start of the match.
+type replayRecord struct {
+	At      time.Duration
+	Kind    byte
+	Payload json.RawMessage
+}
+
+// replayRecorder streams a match to disk. It is written to from the room's
+// goroutine only. A recording is kept under a temporary name until finish
+// renames it into place.
+type replayRecorder struct {
+	id      string
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	gz      *gzip.Writer
+	last    time.Time
+	written int
+	full    bool
+}
+
+func newReplayRecorder(dir string, header replayHeader) (*replayRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("%s-%d", header.RoomID, header.StartedAt)
+	path := filepath.Join(dir, id+replayExt)
+	file, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+	rec := &replayRecorder{id: id, path: path, file: file, last: time.UnixMilli(header.StartedAt)}
+	rec.buf = bufio.NewWriter(file)
+	rec.gz = gzip.NewWriter(rec.buf)
+	head, err := json.Marshal(header)
+	if err == nil {
+		out := append([]byte(replayMagic), replayFormatVersion)
+		out = binary.AppendUvarint(out, uint64(len(head)))
+		_, err = rec.gz.Write(append(out, head...))
+	}
+	if err != nil {
+		rec.abort()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// record appends one message. Errors end the recording quietly; a replay is
+// never worth interrupting the match for.
+func (rec *replayRecorder) record(kind byte, payload []byte) {
+	if rec == nil || rec.full {
+		return
+	}
+	if rec.written+len(payload) > replayMaxBytes {
+		log.Printf("replay %s: size limit reached, recording stopped", rec.id)
+		rec.full = true
+		return
+	}
+	if err := rec.write(kind, payload); err != nil {
+		log.Printf("replay %s: %v", rec.id, err)
+		rec.full = true
+	}
+}
+
+func (rec *replayRecorder) write(kind byte, payload []byte) error {
+	now := time.Now()
+	delta := max(0, now.Sub(rec.last).Milliseconds())
+	rec.last = now
+	out := binary.AppendUvarint(nil, uint64(delta))
+	out = append(out, kind)
+	out = binary.AppendUvarint(out, uint64(len(payload)))
+	if _, err := rec.gz.Write(append(out, payload...)); err != nil {
+		return err
+	}
+	rec.written += len(payload)
+	return nil
+}
+
+// finish writes the end record and moves the file into place.
+func (rec *replayRecorder) finish(endPayload any) {
+	if rec == nil {
+		return
+	}
+	data, err := json.Marshal(endPayload)
+	if err != nil {
+		data = []byte("{}")
+	}
+	err = errors.Join(rec.write(replayRecordEnd, data), rec.gz.Close(), rec.buf.Flush(), rec.file.Close())
+	if err == nil {
+		err = os.Rename(rec.path+".part", rec.path)
+	}
+	if err != nil {
+		log.Printf("replay %s: %v", rec.id, err)
+		_ = os.Remove(rec.path + ".part")
+	}
+}
+
+// abort drops the recording.
+func (rec *replayRecorder) abort() {
+	if rec == nil {
+		return
+	}
+	_ = rec.file.Close()
+	_ = os.Remove(rec.path + ".part")
+}
+
+// startRecording opens the replay of a match starting now, if the room
+// records.
+func (r *room) startRecording(startedAt int64) {
+	r.stopRecording(nil)
+	if !r.Record || r.replayDir == "" {
+		return
+	}
+	rec, err := newReplayRecorder(r.replayDir, replayHeader{
+		Version:      replayFormatVersion,
+		RoomID:       r.RoomID,
+		RoomName:     r.RoomName,
+		ServerHosted: r.ServerHosted,
+		Seed:         formatSeed(r.seed),
+		Settings:     r.Settings,
+		Players:      r.roster(),
+		StartedAt:    startedAt,
+	})
+	if err != nil {
+		log.Printf("room %s: match not recorded: %v", r.RoomID, err)
+		return
+	}
+	r.recorder = rec
+}
+
+// stopRecording finishes the running replay; end is the match.end payload,
+// or nil if the match was cut short.
+func (r *room) stopRecording(end any) {
+	if r.recorder == nil {
+		return
+	}
+	if end == nil {
+		end = map[string]any{}
+	}
+	r.recorder.finish(end)
+	r.recorder = nil
+}
+
+// recordInput adds a relayed game.input to the running replay.
+func (r *room) recordInput(peerID string, payload json.RawMessage) {
+	if r.recorder == nil {
+		return
+	}
+	data, err := json.Marshal(map[string]any{"peerId": peerID, "data": payload})
+	if err == nil {
+		r.recorder.record(replayRecordInput, data)
+	}
+}
+
+// openReplay checks the magic and version of a replay and returns its header,
+// leaving rd at the first record.
+func openReplay(rd io.Reader) (replayHeader, *bufio.Reader, error) {
+	var header replayHeader
+	gz, err := gzip.NewReader(rd)
+	if err != nil {
+		return header, nil, err
+	}
+	br := bufio.NewReader(gz)
+	lead := make([]byte, len(replayMagic)+1)
+	if _, err := io.ReadFull(br, lead); err != nil {
+		return header, nil, err
+	}
+	if string(lead[:len(replayMagic)]) != replayMagic {
+		return header, nil, errors.New("not a replay file")
+	}
+	if lead[len(replayMagic)] != replayFormatVersion {
+		return header, nil, fmt.Errorf("unsupported replay version %d", lead[len(replayMagic)])
+	}
+	head, err := readReplayChunk(br)
+	if err != nil {
+		return header, nil, err
+	}
+	if err := json.Unmarshal(head, &header); err != nil {
+		return header, nil, err
+	}
+	return header, br, nil
+}
+
+// readReplay decodes a whole replay.
+func readReplay(rd io.Reader) (replayHeader, []replayRecord, error) {
+	header, br, err := openReplay(rd)
+	if err != nil {
+		return header, nil, err
+	}
+	var records []replayRecord
+	var at time.Duration
+	for {
+		delta, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return header, records, nil
+		}
+		if err != nil {
+			return header, records, err
+		}
+		kind, err := br.ReadByte()
+		if err != nil {
+			return header, records, err
+		}
+		payload, err := readReplayChunk(br)
+		if err != nil {
+			return header, records, err
+		}
+		at += time.Duration(delta) * time.Millisecond
+		records = append(records, replayRecord{At: at, Kind: kind, Payload: payload})
+	}
+}
+
+func readReplayChunk(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > replayMaxBytes {
+		return nil, errors.New("replay record too large")
+	}
+	out := make([]byte, n)
+	_, err = io.ReadFull(br, out)
+	return out, err
+}
+
+// replayInfo is one entry of the /replays listing.
+type replayInfo struct {
+	ID        string        `json:"id"`
+	RoomName  string        `json:"roomName"`
+	Seed      string        `json:"seed"`
+	Players   []rosterEntry `json:"players"`
+	StartedAt int64         `json:"startedAt"`
+	Size      int64         `json:"size"`
+}
+
+// listReplays reads the header of every finished replay, newest first.
+func listReplays(dir string) []replayInfo {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	out := make([]replayInfo, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), replayExt)
+		if !ok || entry.IsDir() || !replayIDPattern.MatchString(id) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		header, _, err := openReplay(f)
+		_ = f.Close()
+		if err != nil {
+			continue
+		}
+		out = append(out, replayInfo{
+			ID:        id,
+			RoomName:  header.RoomName,
+			Seed:      header.Seed,
+			Players:   header.Players,
+			StartedAt: header.StartedAt,
+			Size:      info.Size(),
+		})
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].StartedAt > out[b].StartedAt })
+	return out
+}
+
+// handleReplays lists recorded matches at /replays and serves one file at
+// /replays/ID.
+func (s *server) handleReplays(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.replayDir == "" {
+		http.Error(w, "recording is off on this server", http.StatusNotFound)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/replays"), "/"), replayExt)
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"replays": listReplays(s.replayDir)})
+		return
+	}
+	if !replayIDPattern.MatchString(id) {
+		http.Error(w, "no such replay", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(filepath.Join(s.replayDir, id+replayExt))
+	if err != nil {
+		http.Error(w, "no such replay", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "no such replay", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+replayExt+`"`)
+	http.ServeContent(w, r, id+replayExt, info.ModTime(), f)
+}
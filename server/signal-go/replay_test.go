@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordedMatchIsListedAndDownloadable(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.replayDir = t.TempDir()
+	host := dialTestClient(t, ts)
+	host.send("room.create", map[string]any{"roomName": "Recorded", "hostName": "Host", "maxPlayers": 4, "record": true})
+	created := host.expect("room.created")
+	if created["room"].(map[string]any)["record"] != true {
+		t.Fatalf("room not recording: %v", created)
+	}
+	roomID := roomIDOf(created)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	seed := guest.expect("match.start")["seed"]
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 1, "view": "battle"})
+	guest.expect("game.snapshot")
+	// The host applies its own inputs, so the server rejects them and they
+	// stay out of the replay.
+	host.send("game.input", map[string]any{"roomId": roomID, "input": map[string]any{"firePressed": true}})
+	if got := host.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("host input = %v", got)
+	}
+	guest.send("game.input", map[string]any{"roomId": roomID, "input": map[string]any{"firePressed": true}})
+	host.expect("game.input")
+	host.send("match.end", map[string]any{"roomId": roomID, "scores": []any{map[string]any{"peerId": guestID, "score": 2}}})
+	guest.expect("match.end")
+
+	list := httptest.NewRecorder()
+	s.handleReplays(list, httptest.NewRequest(http.MethodGet, "/replays", nil))
+	var listing struct {
+		Replays []replayInfo `json:"replays"`
+	}
+	if err := json.Unmarshal(list.Body.Bytes(), &listing); err != nil || len(listing.Replays) != 1 {
+		t.Fatalf("listing = %s (%v)", list.Body.String(), err)
+	}
+	info := listing.Replays[0]
+	if info.RoomName != "Recorded" || info.Seed != seed || len(info.Players) != 2 {
+		t.Fatalf("replay info = %+v", info)
+	}
+
+	file := httptest.NewRecorder()
+	s.handleReplays(file, httptest.NewRequest(http.MethodGet, "/replays/"+info.ID, nil))
+	if file.Code != http.StatusOK {
+		t.Fatalf("download status = %d", file.Code)
+	}
+	header, records, err := readReplay(file.Body)
+	if err != nil {
+		t.Fatalf("read replay: %v", err)
+	}
+	if header.RoomID != roomID || header.Version != replayFormatVersion {
+		t.Fatalf("header = %+v", header)
+	}
+	var kinds []byte
+	for _, rec := range records {
+		kinds = append(kinds, rec.Kind)
+	}
+	if string(kinds) != string([]byte{replayRecordSnapshot, replayRecordInput, replayRecordEnd}) {
+		t.Fatalf("record kinds = %v", kinds)
+	}
+	var input struct {
+		PeerID string `json:"peerId"`
+	}
+	if err := json.Unmarshal(records[1].Payload, &input); err != nil || input.PeerID != guestID {
+		t.Fatalf("input record = %s", records[1].Payload)
+	}
+	var end struct {
+		WinnerPeerID string `json:"winnerPeerId"`
+	}
+	if err := json.Unmarshal(records[2].Payload, &end); err != nil || end.WinnerPeerID != guestID {
+		t.Fatalf("end record = %s", records[2].Payload)
+	}
+
+	for _, path := range []string{"/replays/..%2Fgo.mod", "/replays/missing"} {
+		got := httptest.NewRecorder()
+		s.handleReplays(got, httptest.NewRequest(http.MethodGet, path, nil))
+		if got.Code != http.StatusNotFound {
+			t.Fatalf("%s status = %d", path, got.Code)
+		}
+	}
+}
+
+// writeTestReplay saves a replay of snapshots with the given ticks, one
+// every step, followed by an end record one step later.
+func writeTestReplay(t *testing.T, dir, id string, step time.Duration, ticks ...int) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	head, _ := json.Marshal(replayHeader{Version: replayFormatVersion, RoomID: "old-room", RoomName: "Recorded", Players: []rosterEntry{{PeerID: "a"}, {PeerID: "b"}}})
+	out := append([]byte(replayMagic), replayFormatVersion)
+	out = binary.AppendUvarint(out, uint64(len(head)))
+	out = append(out, head...)
+	record := func(delta time.Duration, kind byte, payload []byte) {
+		out = binary.AppendUvarint(out, uint64(delta.Milliseconds()))
+		out = append(out, kind)
+		out = binary.AppendUvarint(out, uint64(len(payload)))
+		out = append(out, payload...)
+	}
+	for i, tick := range ticks {
+		snap := map[string]any{"roomId": "old-room", "tick": tick, "view": "battle"}
+		delta := step
+		if i == 0 {
+			delta = 0
+			snap["terrain"] = map[string]any{"width": 2, "height": 1, "heights": []int{1, 1}, "maskB64": "AQE="}
+		}
+		payload, _ := json.Marshal(snap)
+		record(delta, replayRecordSnapshot, payload)
+	}
+	record(step, replayRecordEnd, []byte("{}"))
+	if _, err := gz.Write(out); err != nil || gz.Close() != nil {
+		t.Fatalf("write replay: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+replayExt), buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write replay: %v", err)
+	}
+}
+
+func TestReplayPlaysBackAsReadOnlyRoom(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.replayDir = t.TempDir()
+	writeTestReplay(t, s.replayDir, "old-room-1", 40*time.Millisecond, 1, 2, 3)
+
+	viewer := dialTestClient(t, ts)
+	viewer.send("replay.play", map[string]any{"replayId": "missing"})
+	if got := viewer.expect("error"); got["code"] != "replay_not_found" {
+		t.Fatalf("missing replay error = %v", got)
+	}
+	viewer.send("replay.play", map[string]any{"replayId": "old-room-1", "playerName": "Viewer"})
+	joined := viewer.expect("room.joined")
+	roomID := roomIDOf(joined)
+	replay := joined["room"].(map[string]any)["replay"].(map[string]any)
+	if replay["durationMs"] != float64(120) || replay["paused"] != false {
+		t.Fatalf("replay state = %v", replay)
+	}
+	for _, want := range []float64{1, 2, 3} {
+		snap := viewer.expect("game.snapshot")
+		if snap["tick"] != want || snap["roomId"] != roomID {
+			t.Fatalf("snapshot = %v, want tick %v in %s", snap, want, roomID)
+		}
+	}
+	ended := func(state map[string]any) bool {
+		return state["room"].(map[string]any)["replay"].(map[string]any)["ended"] == true
+	}
+	for !ended(viewer.expect("room.state")) {
+	}
+
+	// Others join as spectators and can only watch.
+	guest, guestJoined := joinRoom(t, ts, roomID, "Guest")
+	if self := guestJoined["room"].(map[string]any)["players"].([]any)[1].(map[string]any); self["spectator"] != true {
+		t.Fatalf("guest seat = %v", self)
+	}
+	if snap := guest.expect("game.snapshot"); snap["tick"] != float64(3) {
+		t.Fatalf("late snapshot = %v", snap)
+	}
+	guest.send("replay.control", map[string]any{"action": "pause"})
+	if got := guest.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("guest control error = %v", got)
+	}
+	viewer.send("room.settings.update", map[string]any{"settings": map[string]any{"roundsToWin": 2}})
+	if got := viewer.expect("error"); got["code"] != "forbidden" {
+		t.Fatalf("settings error = %v", got)
+	}
+	viewer.send("replay.control", map[string]any{"action": "speed", "speed": 20})
+	if got := viewer.expect("error"); got["code"] != "bad_request" {
+		t.Fatalf("speed error = %v", got)
+	}
+
+	// Seeking shows the frame at that time, after the terrain it builds on.
+	// A peer that falls behind gets the terrain folded into the newer frame.
+	viewer.send("replay.control", map[string]any{"action": "seek", "atMs": 50})
+	sawTerrain := false
+	for {
+		snap := guest.expect("game.snapshot")
+		sawTerrain = sawTerrain || snap["terrain"] != nil
+		if snap["tick"] == float64(2) {
+			break
+		}
+	}
+	if !sawTerrain {
+		t.Fatal("seek sent no terrain")
+	}
+	viewer.send("replay.control", map[string]any{"action": "speed", "speed": 2})
+	for {
+		state := guest.expect("room.state")["room"].(map[string]any)["replay"].(map[string]any)
+		if state["speed"] == float64(2) {
+			break
+		}
+	}
+	// The replay ended paused, so it stays on the sought frame until resumed.
+	viewer.send("replay.control", map[string]any{"action": "resume"})
+	if snap := guest.expect("game.snapshot"); snap["tick"] != float64(3) {
+		t.Fatalf("resumed snapshot = %v", snap)
+	}
+
+	// A replay left paused outlives the idle timeout while anyone watches.
+	r := s.findRoom(roomID)
+	var expired bool
+	r.do(func() { expired = r.expired(r.LastActive + 2*roomTTL.Milliseconds()) })
+	if expired {
+		t.Fatal("watched replay room expired")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	replaySpeedMin = 0.25
+	replaySpeedMax = 8
+)
+
+// replayRoomMessages are the room messages a replay room still takes; it is
+// read-only otherwise.
+var replayRoomMessages = map[string]bool{
+	"peer.rename":    true,
+	"chat.msg":       true,
+	"replay.control": true,
+}
+
+// replayFrame is one recorded snapshot, retargeted at the replay room.
+type replayFrame struct {
+	at         time.Duration
+	raw        json.RawMessage
+	hasTerrain bool
+}
+
+// replayPlayback plays a recording back into a room by re-emitting its
+// snapshots at their recorded times. It is only touched on the room's
+// goroutine.
+type replayPlayback struct {
+	id       string
+	frames   []replayFrame
+	duration time.Duration
+	// next is the index of the next frame due.
+	next int
+	// position is where playback stood at anchor; while playing, it moves on
+	// at speed times the wall clock from there.
+	position time.Duration
+	anchor   time.Time
+	speed    float64
+	paused   bool
+	// gen changes whenever playback is paused, moved or changes speed, so
+	// frames scheduled before do nothing.
+	gen   int
+	timer *time.Timer
+}
+
+// loadReplayPlayback reads replay id from dir for playback in room roomID.
+func loadReplayPlayback(dir, id, roomID string) (*replayPlayback, replayHeader, error) {
+	f, err := os.Open(filepath.Join(dir, id+replayExt))
+	if err != nil {
+		return nil, replayHeader{}, err
+	}
+	defer f.Close()
+	header, records, err := readReplay(f)
+	if err != nil {
+		return nil, header, err
+	}
+	pb := &replayPlayback{id: id, speed: 1, anchor: time.Now()}
+	for _, rec := range records {
+		pb.duration = max(pb.duration, rec.At)
+		if rec.Kind != replayRecordSnapshot {
+			continue
+		}
+		raw, hasTerrain, err := retargetSnapshot(rec.Payload, roomID)
+		if err != nil {
+			continue
+		}
+		pb.frames = append(pb.frames, replayFrame{at: rec.At, raw: raw, hasTerrain: hasTerrain})
+	}
+	return pb, header, nil
+}
+
+// retargetSnapshot rewrites a recorded snapshot's roomId, since clients drop
+// snapshots meant for another room.
+func retargetSnapshot(raw json.RawMessage, roomID string) (json.RawMessage, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, false, err
+	}
+	fields["roomId"], _ = json.Marshal(roomID)
+	_, hasTerrain := fields["terrain"]
+	out, err := json.Marshal(fields)
+	return out, hasTerrain, err
+}
+
+// clock returns the playback position now.
+func (pb *replayPlayback) clock() time.Duration {
+	if pb.paused {
+		return pb.position
+	}
+	at := pb.position + time.Duration(float64(time.Since(pb.anchor))*pb.speed)
+	return min(at, pb.duration)
+}
+
+// state is the replay entry of room.state.
+func (pb *replayPlayback) state() map[string]any {
+	at := pb.clock()
+	return map[string]any{
+		"replayId":   pb.id,
+		"positionMs": at.Milliseconds(),
+		"durationMs": pb.duration.Milliseconds(),
+		"paused":     pb.paused,
+		"speed":      pb.speed,
+		"ended":      at >= pb.duration,
+	}
+}
+
+// playReplay opens a replay as a new read-only room with p watching as its
+// host, and starts playing it.
+func (s *server) playReplay(p *peer, replayID, name, requestID string) {
+	if s.replayDir == "" || !replayIDPattern.MatchString(replayID) {
+		p.sendError("replay_not_found", "No such replay", requestID)
+		return
+	}
+	if len(name) > 16 {
+		name = name[:16]
+	}
+	if name == "" {
+		name = "Viewer1"
+	}
+	roomID := s.makeRoomID()
+	pb, header, err := loadReplayPlayback(s.replayDir, replayID, roomID)
+	if err != nil {
+		p.sendError("replay_not_found", "Could not open replay: "+err.Error(), requestID)
+		return
+	}
+
+	s.mu.Lock()
+	code := s.claimRoomCodeLocked(roomID)
+	s.mu.Unlock()
+	r := &room{
+		RoomID:       roomID,
+		Code:         code,
+		RoomName:     "Replay: " + header.RoomName,
+		Status:       "in-game",
+		MaxPlayers:   len(header.Players),
+		CreatedAt:    time.Now().UnixMilli(),
+		LastActive:   time.Now().UnixMilli(),
+		ServerHosted: header.ServerHosted,
+		Settings:     header.Settings,
+		Players: []player{{
+			PeerID:     p.id(),
+			Name:       name,
+			IsHost:     true,
+			Connected:  true,
+			Spectator:  true,
+			ColorIndex: noColor,
+			ip:         remoteIP(p.conn),
+		}},
+		replay: pb,
+		conns:  map[string]*peer{p.id(): p},
+	}
+	state := r.state()
+	p.snapshots.reset()
+	r.start()
+
+	s.mu.Lock()
+	s.peerToRoom[p.id()] = roomID
+	s.rooms[roomID] = r
+	token := s.issueResumeTokenLocked(p.id())
+	s.mu.Unlock()
+
+	p.send("room.joined", map[string]any{"selfPeerId": p.id(), "room": state, "resumeToken": token}, requestID)
+	r.do(func() { r.seekReplay(0) })
+}
+
+// controlReplay applies a replay.control from the room's host: pause,
+// resume, seek to atMs or set the playback speed.
+func (r *room) controlReplay(p *peer, pl *player, payload map[string]any, requestID string) {
+	if !pl.IsHost {
+		p.sendError("forbidden", "Only host can control playback", requestID)
+		return
+	}
+	pb := r.replay
+	switch action := getString(payload, "action", ""); action {
+	case "pause":
+		pb.position, pb.anchor, pb.paused = pb.clock(), time.Now(), true
+		r.stopReplay()
+	case "resume":
+		if pb.clock() >= pb.duration {
+			pb.paused = false
+			r.seekReplay(0)
+			return
+		}
+		pb.position, pb.anchor, pb.paused = pb.clock(), time.Now(), false
+		r.scheduleReplay()
+	case "seek":
+		r.seekReplay(time.Duration(getInt(payload, "atMs", 0)) * time.Millisecond)
+		return
+	case "speed":
+		speed, ok := payload["speed"].(float64)
+		if !ok || speed < replaySpeedMin || speed > replaySpeedMax {
+			p.sendError("bad_request", "Speed must be between 0.25 and 8", requestID)
+			return
+		}
+		pb.position, pb.anchor, pb.speed = pb.clock(), time.Now(), speed
+		r.scheduleReplay()
+	default:
+		p.sendError("bad_request", "Unknown replay action: "+action, requestID)
+		return
+	}
+	r.broadcastState()
+}
+
+// seekReplay moves playback to at and shows the frame recorded there, after
+// the latest terrain before it so the picture is whole.
+func (r *room) seekReplay(at time.Duration) {
+	pb := r.replay
+	at = min(max(at, 0), pb.duration)
+	next := sort.Search(len(pb.frames), func(i int) bool { return pb.frames[i].at > at })
+	if next > 0 {
+		terrainAt := next - 1
+		for terrainAt > 0 && !pb.frames[terrainAt].hasTerrain {
+			terrainAt--
+		}
+		if terrainAt < next-1 {
+			r.emitReplayFrame(terrainAt)
+		}
+		r.emitReplayFrame(next - 1)
+	}
+	pb.next, pb.position, pb.anchor = next, at, time.Now()
+	r.scheduleReplay()
+	r.broadcastState()
+}
+
+// scheduleReplay times the next frame, or stops at the end of the recording.
+func (r *room) scheduleReplay() {
+	r.stopReplay()
+	pb := r.replay
+	if pb.paused {
+		return
+	}
+	if pb.next >= len(pb.frames) {
+		if pb.clock() >= pb.duration {
+			pb.position, pb.paused = pb.duration, true
+			return
+		}
+		// Play on to the end record so the room shows the replay ended.
+		r.afterReplay(pb.duration, func() {
+			pb.position, pb.paused = pb.duration, true
+			r.broadcastState()
+		})
+		return
+	}
+	r.afterReplay(pb.frames[pb.next].at, r.stepReplay)
+}
+
+// afterReplay runs fn on the room's goroutine once playback reaches at,
+// unless playback is paused, moved or changes speed first.
+func (r *room) afterReplay(at time.Duration, fn func()) {
+	pb := r.replay
+	gen := pb.gen
+	wait := time.Duration(float64(at-pb.clock()) / pb.speed)
+	pb.timer = time.AfterFunc(max(wait, 0), func() {
+		r.do(func() {
+			if r.replay == pb && pb.gen == gen {
+				fn()
+			}
+		})
+	})
+}
+
+// stepReplay emits every frame that is due and schedules the next one.
+func (r *room) stepReplay() {
+	pb := r.replay
+	at := pb.clock()
+	for pb.next < len(pb.frames) && pb.frames[pb.next].at <= at {
+		r.emitReplayFrame(pb.next)
+		pb.next++
+	}
+	r.scheduleReplay()
+	if pb.paused {
+		r.broadcastState()
+	}
+}
+
+func (r *room) emitReplayFrame(i int) {
+	frame := r.replay.frames[i]
+	snap, _ := decodeSnapshot(frame.raw)
+	r.lastSnapshot = frame.raw
+	r.LastActive = time.Now().UnixMilli()
+	fanOutSnapshot(r.recipients(), r.prepareSnapshot(snap))
+}
+
+// stopReplay cancels the scheduled frame.
+func (r *room) stopReplay() {
+	if r.replay == nil {
+		return
+	}
+	if r.replay.timer != nil {
+		r.replay.timer.Stop()
+		r.replay.timer = nil
+	}
+	r.replay.gen++
+}
@@ -0,0 +1,1126 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"scorched-signal-go/engine"
+)
+
+// Each room is owned by its own goroutine. Commands reach it through do, so
+// rooms never wait on each other and the server lock only guards routing.
+
+type room struct {
+	RoomID string `json:"roomId"`
+	// Code is the short join code, unique among live rooms.
+	Code       string   `json:"code"`
+	RoomName   string   `json:"roomName"`
+	Status     string   `json:"status"`
+	MaxPlayers int      `json:"maxPlayers"`
+	CreatedAt  int64    `json:"createdAt"`
+	LastActive int64    `json:"lastActiveAt"`
+	Players    []player `json:"players"`
+	// ServerHosted rooms run the match on the server instead of in the host's
+	// browser.
+	ServerHosted bool `json:"serverHosted"`
+	// Unlisted rooms are left out of room.list but can be joined by id.
+	Unlisted bool `json:"unlisted"`
+	// Locked rooms turn away every new join.
+	Locked bool `json:"locked"`
+	// Record rooms save each match as a replay in replayDir.
+	Record bool `json:"record"`
+	// MapID is the map the host picked from the server's library; matches
+	// are played on it whatever the terrain preset.
+	MapID string `json:"mapId"`
+	// Settings are the game settings the next match is played with. Only the
+	// host changes them, through room.settings.update.
+	Settings engine.Settings `json:"settings"`
+
+	// password, when set, has to be given with room.join.
+	password *roomPassword
+	// botSeq numbers the bots added to the room.
+	botSeq int
+	// bannedPeers and bannedIPs are refused by room.join for as long as the
+	// room lives.
+	bannedPeers map[string]bool
+	bannedIPs   map[string]bool
+	// hosted is the running simulation of a server-hosted match.
+	hosted *hostedMatch
+	// lastSnapshot is the most recent game.snapshot payload from the host,
+	// handed to whoever takes over as host.
+	lastSnapshot json.RawMessage
+	// hostVotes maps voter peer id to the peer id they want as host.
+	hostVotes map[string]string
+	// economy checks guest shop requests against the host's snapshots.
+	economy roomEconomy
+	// snapSeq numbers snapshots for binary deltas; snapTerrain is the latest
+	// terrain and lastFrame the latest snapshot, used for resyncs.
+	snapSeq     uint32
+	snapTerrain *wireTerrain
+	lastFrame   roomSnapshot
+	// conns are the live connections of seated players, by peer id.
+	conns map[string]*peer
+	// turn times the active player's turn when the settings set a limit.
+	// turnSecond is how long one second of that limit lasts.
+	turn       turnClock
+	turnSecond time.Duration
+	// recorder writes the running match's replay while Record is on.
+	replayDir string
+	recorder  *replayRecorder
+	// seed is the running match's seed, sent with match.start; everything
+	// random in the match derives from it.
+	seed uint64
+	// maps is the server's map library; upload is a map the host sent the
+	// room over HTTP, kept for as long as the room lives.
+	maps   *mapLibrary
+	upload *gameMap
+	// replay plays a recorded match back; such rooms are read-only.
+	replay *replayPlayback
+	// lastRoster is who played the match that just ended, kept for
+	// match.rematch until the next match starts.
+	lastRoster []string
+
+	inbox    chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+	// summary is the room.list entry, republished after every command so
+	// listing never has to wait on the room.
+	summary atomic.Pointer[roomSummary]
+}
+
+// roomSummary is a room as shown in room.list.response.
+type roomSummary struct {
+	RoomID       string `json:"roomId"`
+	Code         string `json:"code"`
+	RoomName     string `json:"roomName"`
+	HostName     string `json:"hostName"`
+	Players      int    `json:"players"`
+	MaxPlayers   int    `json:"maxPlayers"`
+	Spectators   int    `json:"spectators"`
+	Status       string `json:"status"`
+	ServerHosted bool   `json:"serverHosted"`
+	// Replay rooms play a recorded match back for spectators.
+	Replay bool `json:"replay"`
+	// PasswordProtected rooms answer room.join with room.auth_required
+	// until a password is given.
+	PasswordProtected bool `json:"passwordProtected"`
+	Unlisted          bool `json:"-"`
+	Locked            bool `json:"-"`
+	// Settings is enough of the game settings to pick a room by.
+	Settings settingsSummary `json:"settings"`
+}
+
+// settingsSummary is the part of a room's settings shown in the room list.
+type settingsSummary struct {
+	TerrainPreset string `json:"terrainPreset"`
+	RoundsToWin   int    `json:"roundsToWin"`
+	WindMode      string `json:"windMode"`
+	FreeFireMode  bool   `json:"freeFireMode"`
+	// TurnTimeLimitSec is left out when turns are untimed.
+	TurnTimeLimitSec int `json:"turnTimeLimitSec,omitempty"`
+}
+
+// matchResult is one line of the final scoreboard sent with match.end.
+type matchResult struct {
+	PeerID string `json:"peerId"`
+	Name   string `json:"name"`
+	Score  int    `json:"score"`
+}
+
+// hostMigration describes a host handoff to announce to the room.
+type hostMigration struct {
+	roomID         string
+	previousHostID string
+	newHostID      string
+	reason         string
+	snapshot       json.RawMessage
+}
+
+// start launches the room's goroutine. Nothing else may touch r's state
+// afterwards except through do.
+func (r *room) start() {
+	r.inbox = make(chan func())
+	r.done = make(chan struct{})
+	if r.conns == nil {
+		r.conns = make(map[string]*peer)
+	}
+	r.publish()
+	go r.run()
+}
+
+func (r *room) run() {
+	for {
+		select {
+		case fn := <-r.inbox:
+			fn()
+			r.publish()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// do runs fn on the room's goroutine and waits for it. It reports false,
+// without running fn, once the room has been deleted. fn must not call do
+// on the same room.
+func (r *room) do(fn func()) bool {
+	ran := false
+	finished := make(chan struct{})
+	cmd := func() {
+		defer close(finished)
+		select {
+		case <-r.done:
+		default:
+			fn()
+			ran = true
+		}
+	}
+	select {
+	case r.inbox <- cmd:
+	case <-r.done:
+		return false
+	}
+	<-finished
+	return ran
+}
+
+// stop ends the room's goroutine once the current command returns.
+func (r *room) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+func (r *room) publish() {
+	seated, watching := r.counts()
+	next := roomSummary{
+		RoomID:       r.RoomID,
+		Code:         r.Code,
+		RoomName:     r.RoomName,
+		HostName:     "Host",
+		Players:      seated,
+		MaxPlayers:   r.MaxPlayers,
+		Spectators:   watching,
+		Status:       r.Status,
+		ServerHosted: r.ServerHosted,
+		Replay:       r.replay != nil,
+
+		PasswordProtected: r.password != nil,
+		Unlisted:          r.Unlisted,
+		Locked:            r.Locked,
+		Settings: settingsSummary{
+			TerrainPreset: r.Settings.TerrainPreset,
+			RoundsToWin:   r.Settings.RoundsToWin,
+			WindMode:      r.Settings.WindMode,
+			FreeFireMode:  r.Settings.FreeFireMode,
+		},
+	}
+	if t := r.Settings.TurnTimeLimitSec; t != nil {
+		next.Settings.TurnTimeLimitSec = int(*t)
+	}
+	for _, pl := range r.Players {
+		if pl.IsHost {
+			next.HostName = pl.Name
+			break
+		}
+	}
+	if cur := r.summary.Load(); cur == nil || *cur != next {
+		r.summary.Store(&next)
+	}
+}
+
+// counts returns how many seats are taken and how many spectators watch.
+func (r *room) counts() (seated, watching int) {
+	for _, pl := range r.Players {
+		if pl.Spectator {
+			watching++
+		} else {
+			seated++
+		}
+	}
+	return seated, watching
+}
+
+func (r *room) state() map[string]any {
+	players := make([]player, len(r.Players))
+	copy(players, r.Players)
+	for i := range players {
+		if p := r.conns[players[i].PeerID]; p != nil {
+			if ms := p.latencyMs.Load(); ms >= 0 {
+				players[i].LatencyMs = &ms
+			}
+		}
+	}
+	state := map[string]any{
+		"roomId":       r.RoomID,
+		"code":         r.Code,
+		"roomName":     r.RoomName,
+		"status":       r.Status,
+		"maxPlayers":   r.MaxPlayers,
+		"players":      players,
+		"serverHosted": r.ServerHosted,
+		"unlisted":     r.Unlisted,
+		"locked":       r.Locked,
+		"record":       r.Record,
+		"mapId":        r.MapID,
+		"settings":     r.Settings,
+
+		"passwordProtected": r.password != nil,
+	}
+	if r.replay != nil {
+		state["replay"] = r.replay.state()
+	}
+	if r.upload != nil {
+		state["upload"] = r.upload
+	}
+	return state
+}
+
+// recipients returns the live connections of everyone seated in r.
+func (r *room) recipients() []*peer {
+	result := make([]*peer, 0, len(r.Players))
+	for _, pl := range r.Players {
+		if p := r.conns[pl.PeerID]; p != nil && !p.closed.Load() {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (r *room) broadcastState() {
+	broadcastRoomState(r.recipients(), r.state())
+}
+
+func broadcastRoomState(recipients []*peer, roomState map[string]any) {
+	payload := map[string]any{"room": roomState}
+	for _, p := range recipients {
+		p.send("room.state", payload, "")
+	}
+}
+
+// deleteRoom forgets r and stops anything still running for it. It runs on
+// r's goroutine.
+func (s *server) deleteRoom(r *room) {
+	s.mu.Lock()
+	if s.rooms[r.RoomID] == r {
+		delete(s.rooms, r.RoomID)
+		s.releaseRoomCodeLocked(r)
+	}
+	s.mu.Unlock()
+	if r.hosted != nil {
+		r.hosted.close()
+		r.hosted = nil
+	}
+	r.stopTurn()
+	r.stopRecording(nil)
+	r.stopReplay()
+	r.stop()
+}
+
+// removePlayer gives up peerID's seat, deleting the room once it is empty
+// and handing the host role on if needed.
+func (s *server) removePlayer(r *room, peerID string) {
+	wasHost := false
+	if pl := findPlayer(r, peerID); pl != nil {
+		wasHost = pl.IsHost
+	}
+	r.Players = filterPlayers(r.Players, peerID)
+	delete(r.conns, peerID)
+	r.LastActive = time.Now().UnixMilli()
+	// Replay rooms have nobody seated and last while anyone watches.
+	if r.humanSeats() == 0 && (r.replay == nil || len(r.Players) == 0) {
+		s.closeRoom(r, "no_players")
+		return
+	}
+	clearHostVotes(r, peerID)
+	recipients := r.recipients()
+	if wasHost {
+		migration := r.promoteHost(r.nextHost(), "host_left")
+		migration.previousHostID = peerID
+		announceHostMigration(recipients, migration)
+	}
+	broadcastRoomState(recipients, r.state())
+}
+
+const (
+	// tankColorCount is len(TANK_COLORS) in game.ts.
+	tankColorCount = 8
+	noColor        = -1
+)
+
+// freeColor returns the first tank color no seated player holds, or noColor
+// if every one is taken. Rooms have no more seats than colors, so a player
+// taking a seat always finds one.
+func (r *room) freeColor() int {
+	var used [tankColorCount]bool
+	for _, pl := range r.Players {
+		if pl.ColorIndex >= 0 && pl.ColorIndex < tankColorCount {
+			used[pl.ColorIndex] = true
+		}
+	}
+	for i, taken := range used {
+		if !taken {
+			return i
+		}
+	}
+	return noColor
+}
+
+// humanSeats counts the seated players that are people rather than bots.
+func (r *room) humanSeats() int {
+	n := 0
+	for _, pl := range r.Players {
+		if !pl.Spectator && !pl.Bot {
+			n++
+		}
+	}
+	return n
+}
+
+// closeRoom deletes r once nobody is left to play, sending any remaining
+// spectators back to the lobby list.
+func (s *server) closeRoom(r *room, reason string) {
+	recipients := r.recipients()
+	s.mu.Lock()
+	for _, pl := range r.Players {
+		s.unseatLocked(pl.PeerID)
+	}
+	s.mu.Unlock()
+	for _, rp := range recipients {
+		rp.send("room.closed", map[string]any{"roomId": r.RoomID, "reason": reason}, "")
+	}
+	r.Players = nil
+	s.deleteRoom(r)
+}
+
+// mergeSettings applies a partial settings object on top of base, then
+// validates and normalizes the result. base is left untouched.
+func mergeSettings(base engine.Settings, raw json.RawMessage) (engine.Settings, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return base, nil
+	}
+	next := base
+	if t := base.TurnTimeLimitSec; t != nil {
+		// Decoding into a shared pointer would change base too.
+		v := *t
+		next.TurnTimeLimitSec = &v
+	}
+	if err := json.Unmarshal(raw, &next); err != nil {
+		return base, err
+	}
+	if err := next.Validate(); err != nil {
+		return base, err
+	}
+	return next.Normalize(), nil
+}
+
+// kickPlayer tells targetID it was removed, then unseats it. The connection
+// stays open so the peer can go on to another room.
+func (s *server) kickPlayer(r *room, targetID, reason string) {
+	if conn := r.conns[targetID]; conn != nil {
+		conn.send("room.kicked", map[string]any{"roomId": r.RoomID, "reason": reason}, "")
+	}
+	s.mu.Lock()
+	s.unseatLocked(targetID)
+	s.mu.Unlock()
+	s.removePlayer(r, targetID)
+}
+
+// nextHost picks who takes over from a host who is leaving: the first
+// connected player who can host, else the first who can at all.
+func (r *room) nextHost() string {
+	successor := ""
+	for _, rp := range r.Players {
+		if rp.IsHost || (rp.Spectator && r.replay == nil) || rp.Bot {
+			continue
+		}
+		if successor == "" {
+			successor = rp.PeerID
+		}
+		if rp.Connected {
+			return rp.PeerID
+		}
+	}
+	return successor
+}
+
+// promoteHost moves the host role to newHostID and returns the handoff to
+// announce.
+func (r *room) promoteHost(newHostID, reason string) *hostMigration {
+	m := &hostMigration{roomID: r.RoomID, newHostID: newHostID, reason: reason}
+	if r.Status == "in-game" {
+		m.snapshot = r.lastSnapshot
+	}
+	for i := range r.Players {
+		if r.Players[i].IsHost {
+			m.previousHostID = r.Players[i].PeerID
+		}
+		r.Players[i].IsHost = r.Players[i].PeerID == newHostID
+	}
+	r.hostVotes = nil
+	r.LastActive = time.Now().UnixMilli()
+	return m
+}
+
+// announceHostMigration tells the room about a new host. Only the promoted
+// host receives the cached snapshot it needs to resume as match authority.
+func announceHostMigration(recipients []*peer, m *hostMigration) {
+	for _, rp := range recipients {
+		payload := map[string]any{
+			"roomId":         m.roomID,
+			"hostPeerId":     m.newHostID,
+			"previousHostId": m.previousHostID,
+			"reason":         m.reason,
+		}
+		if rp.id() == m.newHostID && len(m.snapshot) > 0 {
+			payload["snapshot"] = m.snapshot
+		}
+		rp.send("host.migrated", payload, "")
+	}
+}
+
+// clearHostVotes drops every vote cast by or for peerID.
+func clearHostVotes(r *room, peerID string) {
+	delete(r.hostVotes, peerID)
+	for voter, candidate := range r.hostVotes {
+		if candidate == peerID {
+			delete(r.hostVotes, voter)
+		}
+	}
+}
+
+// findMap returns the room's uploaded map or the library map with id, or nil.
+func (r *room) findMap(id string) *gameMap {
+	if r.upload != nil && r.upload.ID == id {
+		return r.upload
+	}
+	return r.maps.find(id)
+}
+
+func findPlayer(r *room, peerID string) *player {
+	for i := range r.Players {
+		if r.Players[i].PeerID == peerID {
+			return &r.Players[i]
+		}
+	}
+	return nil
+}
+
+func filterPlayers(players []player, peerID string) []player {
+	out := players[:0]
+	for _, p := range players {
+		if p.PeerID != peerID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// handleRoomMessage runs a message from a seated player on r's goroutine.
+func (s *server) handleRoomMessage(r *room, p *peer, env envelope, payload map[string]any) {
+	peerID, roomID, requestID := p.id(), r.RoomID, env.RequestID
+	playerIdx := -1
+	for i := range r.Players {
+		if r.Players[i].PeerID == peerID {
+			playerIdx = i
+			break
+		}
+	}
+	if playerIdx < 0 {
+		p.sendError("forbidden", "Unknown player", requestID)
+		return
+	}
+	pl := &r.Players[playerIdx]
+	if r.replay != nil && !replayRoomMessages[env.Type] {
+		p.sendError("forbidden", "Replay rooms are read-only", requestID)
+		return
+	}
+
+	switch env.Type {
+	case "peer.ready", "match.start", "match.end", "match.rematch", "host.vote",
+		"game.input", "shop.buy", "shop.sell", "shop.done", "game.snapshot":
+		if pl.Spectator {
+			p.sendError("forbidden", "Spectators can only watch", requestID)
+			return
+		}
+	}
+
+	switch env.Type {
+	case "peer.ready":
+		pl.Ready = getBool(payload, "ready")
+		if pl.Ready {
+			r.markPresent(pl)
+		}
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "peer.rename":
+		name := getString(payload, "name", "")
+		if len(name) > 16 {
+			name = name[:16]
+		}
+		if name == "" {
+			name = fmt.Sprintf("Player%d", playerIdx+1)
+		}
+		pl.Name = name
+		r.LastActive = time.Now().UnixMilli()
+		recipients := r.recipients()
+		for _, rp := range recipients {
+			rp.send("peer.rename", map[string]any{"peerId": peerID, "roomId": roomID, "name": pl.Name}, "")
+		}
+		broadcastRoomState(recipients, r.state())
+
+	case "peer.spectate":
+		spectate := getBool(payload, "spectator")
+		if spectate == pl.Spectator {
+			r.broadcastState()
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("forbidden", "Match already started", requestID)
+			return
+		}
+		seated, watching := r.counts()
+		if spectate && (pl.IsHost || watching >= maxSpectators) {
+			p.sendError("forbidden", "Cannot spectate from this seat", requestID)
+			return
+		}
+		if !spectate && seated >= r.MaxPlayers {
+			p.send("room.full", map[string]any{"roomId": roomID, "currentPlayers": seated, "maxPlayers": r.MaxPlayers}, requestID)
+			return
+		}
+		pl.Spectator = spectate
+		pl.ColorIndex = noColor
+		if !spectate {
+			pl.ColorIndex = r.freeColor()
+		}
+		pl.Ready = false
+		clearHostVotes(r, peerID)
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.kick", "room.ban":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can remove players", requestID)
+			return
+		}
+		targetID := getString(payload, "peerId", "")
+		target := findPlayer(r, targetID)
+		if target == nil || targetID == peerID {
+			p.sendError("bad_request", "Target is not another player in this room", requestID)
+			return
+		}
+		reason := "kicked"
+		if env.Type == "room.ban" {
+			reason = "banned"
+			if r.bannedPeers == nil {
+				r.bannedPeers = make(map[string]bool)
+				r.bannedIPs = make(map[string]bool)
+			}
+			r.bannedPeers[targetID] = true
+			if target.ip != "" {
+				r.bannedIPs[target.ip] = true
+			}
+		}
+		s.kickPlayer(r, targetID, reason)
+
+	case "room.settings.update":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can change settings", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "Settings are fixed once the match starts", requestID)
+			return
+		}
+		var req struct {
+			Settings json.RawMessage `json:"settings"`
+		}
+		_ = json.Unmarshal(env.Payload, &req)
+		next, err := mergeSettings(r.Settings, req.Settings)
+		if err != nil {
+			p.sendError("bad_request", "Invalid settings: "+err.Error(), requestID)
+			return
+		}
+		r.Settings = next
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.ai.add":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can add bots", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "Bots can only join in the lobby", requestID)
+			return
+		}
+		level := getString(payload, "aiLevel", "normal")
+		if level != "easy" && level != "normal" && level != "hard" {
+			p.sendError("bad_request", "Unknown AI level: "+level, requestID)
+			return
+		}
+		if seated, _ := r.counts(); seated >= r.MaxPlayers {
+			p.send("room.full", map[string]any{"roomId": roomID, "currentPlayers": seated, "maxPlayers": r.MaxPlayers}, requestID)
+			return
+		}
+		r.botSeq++
+		name := getString(payload, "name", "")
+		if len(name) > 16 {
+			name = name[:16]
+		}
+		if name == "" {
+			name = fmt.Sprintf("CPU %d", r.botSeq)
+		}
+		r.Players = append(r.Players, player{
+			PeerID:     fmt.Sprintf("bot-%d", r.botSeq),
+			ColorIndex: r.freeColor(),
+			Name:       name,
+			Ready:      true,
+			Connected:  true,
+			Bot:        true,
+			AILevel:    level,
+		})
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.ai.remove":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can remove bots", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "Bots can only leave in the lobby", requestID)
+			return
+		}
+		botID := getString(payload, "peerId", "")
+		if bot := findPlayer(r, botID); bot == nil || !bot.Bot {
+			p.sendError("bad_request", "No such bot in this room", requestID)
+			return
+		}
+		r.Players = filterPlayers(r.Players, botID)
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "peer.color":
+		if pl.Spectator {
+			p.sendError("forbidden", "Spectators have no tank", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "Colors are fixed once the match starts", requestID)
+			return
+		}
+		color := getInt(payload, "colorIndex", -1)
+		if color < 0 || color >= tankColorCount {
+			p.sendError("bad_request", fmt.Sprintf("Color must be 0-%d", tankColorCount-1), requestID)
+			return
+		}
+		for _, other := range r.Players {
+			if other.PeerID != peerID && other.ColorIndex == color {
+				p.sendError("color_taken", other.Name+" already has that color", requestID)
+				return
+			}
+		}
+		pl.ColorIndex = color
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.lock":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can lock the room", requestID)
+			return
+		}
+		r.Locked = getBool(payload, "locked")
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.record":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can record the room", requestID)
+			return
+		}
+		if r.replayDir == "" {
+			p.sendError("bad_request", "Recording is off on this server", requestID)
+			return
+		}
+		// The change applies from the next match.
+		r.Record = getBool(payload, "record")
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "room.map":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can pick the map", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "The map is fixed once the match starts", requestID)
+			return
+		}
+		mapID := getString(payload, "mapId", "")
+		if mapID != "" && r.findMap(mapID) == nil {
+			p.sendError("map_not_found", "No such map: "+mapID, requestID)
+			return
+		}
+		r.MapID = mapID
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+
+	case "chat.msg":
+		text := getString(payload, "text", "")
+		if text == "" {
+			return
+		}
+		if len(text) > 200 {
+			text = text[:200]
+		}
+		msgPayload := map[string]any{"roomId": roomID, "peerId": peerID, "name": pl.Name, "text": text, "at": time.Now().UnixMilli()}
+		for _, rp := range r.recipients() {
+			rp.send("chat.msg", msgPayload, "")
+		}
+
+	case "replay.control":
+		if r.replay == nil {
+			p.sendError("bad_request", "Room is not playing a replay", requestID)
+			return
+		}
+		r.controlReplay(p, pl, payload, requestID)
+
+	case "match.start":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can start match", requestID)
+			return
+		}
+		if r.Status != "lobby" {
+			p.sendError("bad_request", "Match already in progress", requestID)
+			return
+		}
+		forceStart := getBool(payload, "forceStart")
+		readyCount := 0
+		for _, rp := range r.Players {
+			if rp.Ready {
+				readyCount++
+			}
+		}
+		if !forceStart && readyCount < 2 {
+			p.sendError("bad_request", "Need at least 2 ready players", requestID)
+			return
+		}
+		r.startMatch(false)
+
+	case "match.end":
+		if r.ServerHosted {
+			p.sendError("forbidden", "Server runs this match", requestID)
+			return
+		}
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can end match", requestID)
+			return
+		}
+		if r.Status != "in-game" {
+			p.sendError("bad_request", "No match in progress", requestID)
+			return
+		}
+		scores := make(map[string]int)
+		if list, ok := payload["scores"].([]any); ok {
+			for _, item := range list {
+				if entry, ok := item.(map[string]any); ok {
+					scores[getString(entry, "peerId", "")] = getInt(entry, "score", 0)
+				}
+			}
+		}
+		r.endMatch(scores)
+
+	case "match.rematch":
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can start a rematch", requestID)
+			return
+		}
+		if r.Status != "lobby" || r.lastRoster == nil {
+			p.sendError("bad_request", "No finished match to rematch", requestID)
+			return
+		}
+		if !r.sameRoster() {
+			p.sendError("bad_request", "Players changed since the last match", requestID)
+			return
+		}
+		r.startMatch(true)
+
+	case "game.input", "shop.buy", "shop.sell", "shop.done":
+		if env.Type == "game.input" {
+			if r.markPresent(pl) {
+				r.broadcastState()
+			}
+		}
+		if r.ServerHosted {
+			if r.hosted == nil {
+				p.sendError("forbidden", "Match is not running", requestID)
+				return
+			}
+			if err := r.hosted.apply(peerID, env.Type, env.Payload); err != nil {
+				p.sendError(shopErrorCode(err), err.Error(), requestID)
+				return
+			}
+			// Only inputs that reached the match go in the replay.
+			if env.Type == "game.input" {
+				r.recordInput(peerID, env.Payload)
+			}
+			return
+		}
+		if pl.IsHost {
+			p.sendError("forbidden", "Host should apply actions locally", requestID)
+			return
+		}
+		var hostPeer *peer
+		for _, rp := range r.Players {
+			if rp.IsHost {
+				hostPeer = r.conns[rp.PeerID]
+				break
+			}
+		}
+		if hostPeer == nil {
+			p.sendError("room_not_found", "Host unavailable", requestID)
+			return
+		}
+		weaponID := getString(payload, "weaponId", "")
+		switch env.Type {
+		case "game.input":
+			hostPeer.send("game.input", map[string]any{"peerId": peerID, "data": payload}, "")
+			r.recordInput(peerID, env.Payload)
+		case "shop.buy", "shop.sell":
+			if err := r.economy.apply(peerID, env.Type, weaponID); err != nil {
+				p.sendError(shopErrorCode(err), err.Error(), requestID)
+				return
+			}
+			hostPeer.send(env.Type, map[string]any{"peerId": peerID, "roomId": roomID, "weaponId": weaponID}, "")
+		case "shop.done":
+			hostPeer.send("shop.done", map[string]any{"peerId": peerID, "roomId": roomID, "done": getBool(payload, "done")}, "")
+		}
+
+	case "host.vote":
+		candidateID := getString(payload, "peerId", "")
+		candidate := findPlayer(r, candidateID)
+		if candidate == nil || !candidate.Connected || candidate.Spectator || candidate.Bot {
+			p.sendError("bad_request", "Candidate is not a connected player", requestID)
+			return
+		}
+		if candidate.IsHost {
+			p.sendError("bad_request", "Candidate is already host", requestID)
+			return
+		}
+		if r.hostVotes == nil {
+			r.hostVotes = make(map[string]string)
+		}
+		r.hostVotes[peerID] = candidateID
+		votes, voters := 0, 0
+		for _, rp := range r.Players {
+			if !rp.Connected || rp.Spectator || rp.Bot {
+				continue
+			}
+			voters++
+			if r.hostVotes[rp.PeerID] == candidateID {
+				votes++
+			}
+		}
+		needed := voters/2 + 1
+		r.LastActive = time.Now().UnixMilli()
+		recipients := r.recipients()
+		if votes < needed {
+			tally := map[string]any{"roomId": roomID, "voterPeerId": peerID, "candidatePeerId": candidateID, "votes": votes, "needed": needed}
+			for _, rp := range recipients {
+				rp.send("host.vote", tally, "")
+			}
+			return
+		}
+		announceHostMigration(recipients, r.promoteHost(candidateID, "vote"))
+		broadcastRoomState(recipients, r.state())
+
+	case "game.snapshot":
+		if r.ServerHosted {
+			p.sendError("forbidden", "Server runs this match", requestID)
+			return
+		}
+		if !pl.IsHost {
+			p.sendError("forbidden", "Only host can send snapshots", requestID)
+			return
+		}
+		r.relayHostSnapshot(peerID, append(json.RawMessage(nil), env.Payload...))
+
+	default:
+		p.sendError("bad_request", "Unknown type: "+env.Type, requestID)
+	}
+}
+
+// startMatch moves the room in game and tells everyone. A rematch is flagged
+// so clients can skip straight back in.
+func (r *room) startMatch(rematch bool) {
+	r.Status = "in-game"
+	r.lastSnapshot = nil
+	r.lastFrame = roomSnapshot{}
+	r.snapTerrain = nil
+	r.economy = roomEconomy{}
+	r.lastRoster = nil
+	r.resetTurns()
+	r.seed = newMatchSeed()
+	startedAt := time.Now().UnixMilli()
+	r.startRecording(startedAt)
+	r.LastActive = startedAt
+	// A picked map that has since left the library falls back to the
+	// terrain preset.
+	var terrain *engine.Terrain
+	if picked := r.findMap(r.MapID); picked != nil {
+		terrain = picked.terrain()
+	} else if r.MapID != "" {
+		log.Printf("room %s: map %s is gone, playing without it", r.RoomID, r.MapID)
+	}
+	var hosted *hostedMatch
+	if r.ServerHosted {
+		if r.hosted != nil {
+			r.hosted.close()
+		}
+		hosted = newHostedMatch(r)
+		hosted.engine.Map = terrain
+		r.hosted = hosted
+	}
+	recipients := r.recipients()
+	startPayload := map[string]any{
+		"roomId":    r.RoomID,
+		"startedAt": startedAt,
+		"roster":    r.roster(),
+		"seed":      formatSeed(r.seed),
+	}
+	if rematch {
+		startPayload["rematch"] = true
+	}
+	if terrain != nil {
+		startPayload["map"] = map[string]any{"id": r.MapID, "terrain": terrain.Payload()}
+	}
+	for _, rp := range recipients {
+		rp.send("match.start", startPayload, "")
+	}
+	broadcastRoomState(recipients, r.state())
+	if hosted != nil {
+		go hosted.run()
+	}
+}
+
+// newMatchSeed draws a 64-bit match seed.
+func newMatchSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// formatSeed writes a seed as 16 hex digits; JSON numbers can't hold 64 bits
+// for JS clients.
+func formatSeed(seed uint64) string {
+	return fmt.Sprintf("%016x", seed)
+}
+
+// rosterEntry is one player of the lineup sent with match.start.
+type rosterEntry struct {
+	PeerID  string `json:"peerId"`
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	AILevel string `json:"aiLevel,omitempty"`
+}
+
+// roster lists who plays the match, bots included, in seat order.
+func (r *room) roster() []rosterEntry {
+	out := make([]rosterEntry, 0, len(r.Players))
+	for _, pl := range r.Players {
+		if pl.Spectator {
+			continue
+		}
+		entry := rosterEntry{PeerID: pl.PeerID, Name: pl.Name, Kind: "human"}
+		if pl.Bot {
+			entry.Kind, entry.AILevel = "ai", pl.AILevel
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// endMatch puts the room back in the lobby with everyone unready and sends
+// the final scores. Players missing from scores count as zero.
+func (r *room) endMatch(scores map[string]int) {
+	results := make([]matchResult, 0, len(r.Players))
+	r.lastRoster = make([]string, 0, len(r.Players))
+	for i := range r.Players {
+		pl := &r.Players[i]
+		pl.Ready = false
+		pl.AFK = false
+		if pl.Spectator {
+			continue
+		}
+		results = append(results, matchResult{PeerID: pl.PeerID, Name: pl.Name, Score: scores[pl.PeerID]})
+		r.lastRoster = append(r.lastRoster, pl.PeerID)
+	}
+	sort.SliceStable(results, func(a, b int) bool { return results[a].Score > results[b].Score })
+	if r.hosted != nil {
+		r.hosted.close()
+		r.hosted = nil
+	}
+	r.Status = "lobby"
+	r.resetTurns()
+	r.lastSnapshot = nil
+	r.lastFrame = roomSnapshot{}
+	r.snapTerrain = nil
+	r.economy = roomEconomy{}
+	r.LastActive = time.Now().UnixMilli()
+
+	payload := map[string]any{"roomId": r.RoomID, "results": results, "endedAt": time.Now().UnixMilli(), "seed": formatSeed(r.seed)}
+	if len(results) == 1 || (len(results) > 1 && results[0].Score > results[1].Score) {
+		payload["winnerPeerId"] = results[0].PeerID
+	}
+	r.stopRecording(payload)
+	recipients := r.recipients()
+	for _, rp := range recipients {
+		rp.send("match.end", payload, "")
+	}
+	broadcastRoomState(recipients, r.state())
+}
+
+// sameRoster reports whether the room still seats exactly the players of the
+// last match.
+func (r *room) sameRoster() bool {
+	if seated, _ := r.counts(); len(r.lastRoster) != seated {
+		return false
+	}
+	for _, id := range r.lastRoster {
+		if pl := findPlayer(r, id); pl == nil || pl.Spectator {
+			return false
+		}
+	}
+	return true
+}
+
+// relayHostSnapshot caches a host snapshot and forwards it to the rest of
+// the room.
+func (r *room) relayHostSnapshot(hostID string, raw json.RawMessage) {
+	snap, _ := decodeSnapshot(raw)
+	r.lastSnapshot = raw
+	r.economy.trackSnapshot(snap.state)
+	frame := r.prepareSnapshot(snap)
+	recipients := make([]*peer, 0, len(r.Players))
+	for _, rp := range r.Players {
+		if rp.PeerID == hostID {
+			continue
+		}
+		if target := r.conns[rp.PeerID]; target != nil {
+			recipients = append(recipients, target)
+		}
+	}
+	r.LastActive = time.Now().UnixMilli()
+	fanOutSnapshot(recipients, frame)
+	r.recorder.record(replayRecordSnapshot, raw)
+	r.trackTurn(snap.state)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBusyRoomDoesNotBlockOthers(t *testing.T) {
+	s, ts := newTestServer(t)
+	_, busy := createRoom(t, ts, "Busy")
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest, _ := joinRoom(t, ts, roomID, "Guest")
+
+	release := make(chan struct{})
+	defer close(release)
+	s.mu.RLock()
+	stuck := s.rooms[roomIDOf(busy)]
+	s.mu.RUnlock()
+	go stuck.do(func() { <-release })
+
+	host.send("chat.msg", map[string]any{"text": "still here"})
+	if got := guest.expect("chat.msg"); got["text"] != "still here" {
+		t.Fatalf("chat = %v", got)
+	}
+	host.send("room.list.request", map[string]any{})
+	if rooms := host.expect("room.list.response")["rooms"].([]any); len(rooms) != 2 {
+		t.Fatalf("listed %d rooms while one was busy", len(rooms))
+	}
+}
+
+func TestDeletedRoomRefusesCommands(t *testing.T) {
+	s := newServer()
+	r := &room{RoomID: "r"}
+	r.start()
+	s.rooms[r.RoomID] = r
+	if !r.do(func() { s.deleteRoom(r) }) {
+		t.Fatal("command on a live room did not run")
+	}
+	if r.do(func() { t.Error("ran on a deleted room") }) {
+		t.Fatal("do reported success on a deleted room")
+	}
+	if len(s.rooms) != 0 {
+		t.Fatal("room still registered")
+	}
+}
+
+// discardConn is a connection to a peer that reads everything instantly.
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (discardConn) Close() error                     { return nil }
+func (discardConn) SetDeadline(time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
+func (discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)} }
+
+func benchPeer(s *server) string {
+	p := newPeer(s.makePeerID(), discardConn{}, nil, heartbeat{})
+	s.mu.Lock()
+	s.peers[p.id()] = p
+	s.mu.Unlock()
+	return p.id()
+}
+
+func benchMessage(msgType string, payload any) envelope {
+	raw, _ := json.Marshal(payload)
+	return envelope{Type: msgType, Payload: raw}
+}
+
+// BenchmarkSnapshotRelay relays host snapshots to three guests per room, with
+// every room's host sending in parallel. Rooms run on their own goroutines,
+// so ns/op (per snapshot) should fall as rooms are added until every core is
+// busy; compare with -cpu 1,4,8.
+func BenchmarkSnapshotRelay(b *testing.B) {
+	players := make([]map[string]any, 4)
+	for i := range players {
+		players[i] = map[string]any{"id": fmt.Sprintf("peer-%d", i), "cash": 1000, "hp": 100, "x": i * 200, "y": 600, "angle": 45, "power": 700}
+	}
+	snapshot := benchMessage("game.snapshot", map[string]any{"tick": 1, "view": "battle", "match": map[string]any{"wind": 3}, "players": players})
+
+	for _, rooms := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("rooms=%d", rooms), func(b *testing.B) {
+			s := newServer()
+			hosts := make([]string, rooms)
+			for i := range hosts {
+				hosts[i] = benchPeer(s)
+				s.handleMessage(hosts[i], benchMessage("room.create", map[string]any{"hostName": "Host"}))
+				roomID := s.roomOf(hosts[i]).RoomID
+				for g := 0; g < 3; g++ {
+					s.handleMessage(benchPeer(s), benchMessage("room.join", map[string]any{"roomId": roomID}))
+				}
+				s.handleMessage(hosts[i], benchMessage("match.start", map[string]any{"forceStart": true}))
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for i, hostID := range hosts {
+				n := b.N / rooms
+				if i < b.N%rooms {
+					n++
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						s.handleMessage(hostID, snapshot)
+					}
+				}()
+			}
+			wg.Wait()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "snapshots/s")
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Join codes are short enough to read out across a room. The alphabet
+// leaves out 0/O, 1/I/L and U/V so codes survive being spoken or
+// handwritten.
+const (
+	roomCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTWXYZ"
+	roomCodeLength   = 4
+	// roomCodeAttempts is how many random codes are tried at one length
+	// before moving to the next.
+	roomCodeAttempts = 32
+)
+
+// claimRoomCodeLocked picks a code no live room holds and reserves it for
+// roomID. Codes grow to five characters only when four-character ones are
+// crowded.
+func (s *server) claimRoomCodeLocked(roomID string) string {
+	for length := roomCodeLength; ; length++ {
+		for i := 0; i < roomCodeAttempts; i++ {
+			code := randomRoomCode(length)
+			if _, taken := s.codes[code]; !taken {
+				s.codes[code] = roomID
+				return code
+			}
+		}
+	}
+}
+
+// releaseRoomCodeLocked frees r's code for reuse.
+func (s *server) releaseRoomCodeLocked(r *room) {
+	if r.Code != "" && s.codes[r.Code] == r.RoomID {
+		delete(s.codes, r.Code)
+	}
+}
+
+func randomRoomCode(length int) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = roomCodeAlphabet[rand.Intn(len(roomCodeAlphabet))]
+	}
+	return string(b)
+}
+
+// normalizeRoomCode accepts codes typed in any case, with stray spaces or
+// dashes.
+func normalizeRoomCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// handleJoinLink redirects an invite link such as /join/K7QP into the UI
+// with that room picked.
+func (s *server) handleJoinLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := normalizeRoomCode(strings.TrimPrefix(r.URL.Path, "/join/"))
+	s.mu.RLock()
+	_, live := s.codes[code]
+	s.mu.RUnlock()
+	if !live {
+		http.Error(w, "no room with that code", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/?join="+url.QueryEscape(code), http.StatusFound)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinByCodeAndInviteLink(t *testing.T) {
+	s, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	code := created["room"].(map[string]any)["code"].(string)
+	if len(code) != roomCodeLength || strings.Trim(code, roomCodeAlphabet) != "" {
+		t.Fatalf("code = %q", code)
+	}
+
+	guest := dialTestClient(t, ts)
+	guest.send("room.join", map[string]any{"roomId": strings.ToLower(code), "playerName": "Guest"})
+	if got := guest.expect("room.joined"); roomIDOf(got) != roomIDOf(created) {
+		t.Fatalf("joined %v, want %s", roomIDOf(got), roomIDOf(created))
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleJoinLink(rec, httptest.NewRequest(http.MethodGet, "/join/"+code, nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/?join="+code {
+		t.Fatalf("invite link = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	host.send("room.leave", map[string]any{})
+	guest.send("room.leave", map[string]any{})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.RLock()
+		_, held := s.codes[code]
+		s.mu.RUnlock()
+		if !held {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("code still held after the room was deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = httptest.NewRecorder()
+	s.handleJoinLink(rec, httptest.NewRequest(http.MethodGet, "/join/"+code, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("released code link = %d", rec.Code)
+	}
+}
+
+func TestRoomCodesStayUnique(t *testing.T) {
+	s := newServer()
+	seen := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		code := s.claimRoomCodeLocked(fmt.Sprintf("room-%d", i))
+		if seen[code] {
+			t.Fatalf("code %s handed out twice", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Binary snapshot frames (WebSocket opcode 0x2) are sent to peers that opt in
+// with snapshot.mode. Each frame is
+//
+//	u8  version (snapshotWireVersion)
+//	u8  flags
+//	u32 seq      little endian
+//	u32 baseSeq  little endian, 0 for keyframes
+//	uvarint len, body section
+//	terrain section, if snapFlagTerrain
+//
+// The body is the snapshot JSON without its "terrain" field. In a keyframe it
+// is sent as is; otherwise it is a list of copy/insert ops against the body of
+// frame baseSeq. Terrain travels as raw arrays instead of base64 and is only
+// sent when it differs from what the peer already has, as a full keyframe or
+// as runs of changed cells.
+const snapshotWireVersion = 1
+
+const (
+	snapFlagKeyframe        = 1 << 0
+	snapFlagTerrain         = 1 << 1
+	snapFlagTerrainKeyframe = 1 << 2
+)
+
+const (
+	deltaOpCopy   = 0
+	deltaOpInsert = 1
+
+	deltaBlockSize = 16
+	// runMergeGap joins changed runs separated by fewer unchanged cells than
+	// this, as the run header costs more than the cells.
+	runMergeGap = 8
+)
+
+// wireTerrain is a decoded TerrainPayload. Values are never mutated once
+// built, so baselines can hold them by pointer.
+type wireTerrain struct {
+	width        int
+	height       int
+	revision     int
+	heights      []int
+	mask         []byte
+	colorIndices []byte
+	palette      [][3]int
+	// raw is the TerrainPayload JSON it was decoded from.
+	raw json.RawMessage
+}
+
+// roomSnapshot is one snapshot prepared for fan-out.
+type roomSnapshot struct {
+	seq uint32
+	raw json.RawMessage
+	// body is raw without the terrain field.
+	body []byte
+	// terrain is the latest terrain the room has seen, which may have come
+	// with an earlier snapshot; carriesTerrain says whether raw has it.
+	terrain        *wireTerrain
+	carriesTerrain bool
+	// frames caches encodings so peers in the same state share them.
+	frames *snapshotFrames
+}
+
+// snapshotFrames holds the encodings of one snapshot: a single JSON envelope,
+// and binary frames keyed by the baseline they were encoded against.
+type snapshotFrames struct {
+	mu   sync.Mutex
+	text []byte
+	// textTerrain is text with the room's terrain spliced back in, for JSON
+	// peers that skipped the snapshot that last carried it.
+	textTerrain []byte
+	binary      map[baselineKey][]byte
+}
+
+type baselineKey struct {
+	seq     uint32
+	terrain *wireTerrain
+}
+
+// snapshotBaseline is what a binary peer last received.
+type snapshotBaseline struct {
+	mu      sync.Mutex
+	enabled bool
+	seq     uint32
+	body    []byte
+	terrain *wireTerrain
+}
+
+// reset forces the next frame to be a keyframe.
+func (b *snapshotBaseline) reset() {
+	b.mu.Lock()
+	b.seq, b.body, b.terrain = 0, nil, nil
+	b.mu.Unlock()
+}
+
+// hostSnapshot is a game.snapshot payload decoded once for everything the
+// room does with it: fan-out, the economy, the turn clock and the replay.
+type hostSnapshot struct {
+	raw json.RawMessage
+	// body is raw without the terrain field; nil if raw didn't decode.
+	body    []byte
+	terrain *wireTerrain
+	state   snapshotState
+}
+
+// snapshotState is the part of a snapshot the room follows itself.
+type snapshotState struct {
+	View     string          `json:"view"`
+	ShopDone map[string]bool `json:"shopDoneByPlayerId"`
+	Match    struct {
+		ActivePlayerID string           `json:"activePlayerId"`
+		Phase          string           `json:"phase"`
+		Players        []snapshotPlayer `json:"players"`
+	} `json:"match"`
+}
+
+type snapshotPlayer struct {
+	Config struct {
+		ID string `json:"id"`
+	} `json:"config"`
+	Cash      float64        `json:"cash"`
+	Inventory map[string]int `json:"inventory"`
+}
+
+// decodeSnapshot separates the terrain from a snapshot payload and reads the
+// state the room follows. If it fails, the snapshot carries only raw, which
+// is still relayed as is.
+func decodeSnapshot(raw json.RawMessage) (hostSnapshot, error) {
+	snap := hostSnapshot{raw: raw}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return snap, err
+	}
+	var terrain *wireTerrain
+	if t, ok := fields["terrain"]; ok {
+		delete(fields, "terrain")
+		var err error
+		if terrain, err = decodeWireTerrain(t); err != nil {
+			return snap, err
+		}
+	}
+	for name, dst := range map[string]any{
+		"view":               &snap.state.View,
+		"shopDoneByPlayerId": &snap.state.ShopDone,
+		"match":              &snap.state.Match,
+	} {
+		if v, ok := fields[name]; ok {
+			if err := json.Unmarshal(v, dst); err != nil {
+				return hostSnapshot{raw: raw}, err
+			}
+		}
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return hostSnapshot{raw: raw}, err
+	}
+	snap.body, snap.terrain = body, terrain
+	return snap, nil
+}
+
+func decodeWireTerrain(raw json.RawMessage) (*wireTerrain, error) {
+	var payload struct {
+		Width           int      `json:"width"`
+		Height          int      `json:"height"`
+		Revision        int      `json:"revision"`
+		Heights         []int    `json:"heights"`
+		MaskB64         string   `json:"maskB64"`
+		ColorIndicesB64 string   `json:"colorIndicesB64"`
+		ColorPalette    [][3]int `json:"colorPalette"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, err
+	}
+	mask, err := base64.StdEncoding.DecodeString(payload.MaskB64)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Width <= 0 || payload.Height <= 0 || len(payload.Heights) != payload.Width || len(mask) != payload.Width*payload.Height {
+		return nil, errors.New("terrain dimensions do not match its data")
+	}
+	t := &wireTerrain{
+		raw:      raw,
+		width:    payload.Width,
+		height:   payload.Height,
+		revision: payload.Revision,
+		heights:  payload.Heights,
+		mask:     mask,
+		palette:  payload.ColorPalette,
+	}
+	if payload.ColorIndicesB64 != "" {
+		if t.colorIndices, err = base64.StdEncoding.DecodeString(payload.ColorIndicesB64); err != nil {
+			return nil, err
+		}
+		if len(t.colorIndices) != len(mask) {
+			return nil, errors.New("terrain color indices do not match its mask")
+		}
+	}
+	return t, nil
+}
+
+// prepareSnapshot numbers a snapshot for the room and folds in its terrain.
+// It runs on the room's goroutine.
+func (r *room) prepareSnapshot(snap hostSnapshot) roomSnapshot {
+	r.snapSeq++
+	if r.snapSeq == 0 {
+		r.snapSeq = 1
+	}
+	if snap.terrain != nil {
+		r.snapTerrain = snap.terrain
+	}
+	r.lastFrame = roomSnapshot{
+		seq:            r.snapSeq,
+		raw:            snap.raw,
+		body:           snap.body,
+		terrain:        r.snapTerrain,
+		carriesTerrain: snap.terrain != nil,
+		frames:         &snapshotFrames{},
+	}
+	return r.lastFrame
+}
+
+// fanOutSnapshot queues snap for every recipient. Peers encode it when their
+// writer gets to it, so one that lags skips straight to the newest snapshot.
+func fanOutSnapshot(recipients []*peer, snap roomSnapshot) {
+	for _, rp := range recipients {
+		rp.queueSnapshot(snap)
+	}
+}
+
+// encodeFor returns the message that brings baseline b up to snap, in the mode
+// the peer negotiated, and advances b. missedTerrain says the peer skipped a
+// snapshot carrying terrain, which JSON peers then get with this one.
+func (snap roomSnapshot) encodeFor(b *snapshotBaseline, missedTerrain bool) (opcode byte, data []byte, err error) {
+	frames := snap.frames
+	if frames == nil {
+		frames = &snapshotFrames{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	frames.mu.Lock()
+	defer frames.mu.Unlock()
+	if !b.enabled || snap.body == nil {
+		if missedTerrain && !snap.carriesTerrain && snap.terrain != nil && len(snap.body) > 1 {
+			if frames.textTerrain == nil {
+				// body is a marshalled object, so the field goes before its
+				// closing brace.
+				raw := append([]byte(nil), snap.body[:len(snap.body)-1]...)
+				if len(snap.body) > 2 {
+					raw = append(raw, ',')
+				}
+				raw = append(append(append(raw, `"terrain":`...), snap.terrain.raw...), '}')
+				if frames.textTerrain, err = json.Marshal(envelope{Type: "game.snapshot", Payload: raw}); err != nil {
+					return 0, nil, err
+				}
+			}
+			return opText, frames.textTerrain, nil
+		}
+		if frames.text == nil {
+			if frames.text, err = json.Marshal(envelope{Type: "game.snapshot", Payload: snap.raw}); err != nil {
+				return 0, nil, err
+			}
+		}
+		return opText, frames.text, nil
+	}
+	key := baselineKey{seq: b.seq, terrain: b.terrain}
+	frame, ok := frames.binary[key]
+	if !ok {
+		frame = encodeSnapshotFrame(b, snap)
+		if frames.binary == nil {
+			frames.binary = make(map[baselineKey][]byte)
+		}
+		frames.binary[key] = frame
+	}
+	b.seq, b.body, b.terrain = snap.seq, snap.body, snap.terrain
+	return opBinary, frame, nil
+}
+
+// encodeSnapshotFrame encodes snap against baseline b.
+func encodeSnapshotFrame(b *snapshotBaseline, snap roomSnapshot) []byte {
+	var flags byte
+	baseSeq := b.seq
+	var body []byte
+	if b.body == nil {
+		flags |= snapFlagKeyframe
+		baseSeq = 0
+		body = snap.body
+	} else {
+		body = appendBytesDelta(nil, b.body, snap.body)
+	}
+	var terrain []byte
+	if snap.terrain != nil && snap.terrain != b.terrain {
+		flags |= snapFlagTerrain
+		if canDeltaTerrain(b.terrain, snap.terrain) {
+			terrain = appendTerrainDelta(nil, b.terrain, snap.terrain)
+		} else {
+			flags |= snapFlagTerrainKeyframe
+			terrain = appendTerrainKeyframe(nil, snap.terrain)
+		}
+	}
+
+	out := make([]byte, 0, 10+binary.MaxVarintLen64+len(body)+len(terrain))
+	out = append(out, snapshotWireVersion, flags)
+	out = binary.LittleEndian.AppendUint32(out, snap.seq)
+	out = binary.LittleEndian.AppendUint32(out, baseSeq)
+	out = binary.AppendUvarint(out, uint64(len(body)))
+	out = append(out, body...)
+	return append(out, terrain...)
+}
+
+// appendBytesDelta appends ops that rebuild target from base. Matches are
+// found with a rolling hash over deltaBlockSize windows of target against
+// aligned blocks of base, so content that shifted position still copies.
+func appendBytesDelta(dst, base, target []byte) []byte {
+	const prime = 1099511628211
+	var pow uint64 = 1
+	for i := 0; i < deltaBlockSize; i++ {
+		pow *= prime
+	}
+	hashBlock := func(b []byte) uint64 {
+		var h uint64
+		for _, c := range b {
+			h = h*prime + uint64(c)
+		}
+		return h
+	}
+
+	index := make(map[uint64]int, len(base)/deltaBlockSize)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		h := hashBlock(base[i : i+deltaBlockSize])
+		if _, dup := index[h]; !dup {
+			index[h] = i
+		}
+	}
+
+	literal := 0
+	flush := func(end int) {
+		if end > literal {
+			dst = append(dst, deltaOpInsert)
+			dst = binary.AppendUvarint(dst, uint64(end-literal))
+			dst = append(dst, target[literal:end]...)
+		}
+	}
+
+	i := 0
+	var h uint64
+	if len(target) >= deltaBlockSize {
+		h = hashBlock(target[:deltaBlockSize])
+	}
+	for i+deltaBlockSize <= len(target) {
+		if at, ok := index[h]; ok && string(base[at:at+deltaBlockSize]) == string(target[i:i+deltaBlockSize]) {
+			start, from := i, at
+			for start > literal && from > 0 && base[from-1] == target[start-1] {
+				start--
+				from--
+			}
+			n := i + deltaBlockSize - start
+			for from+n < len(base) && start+n < len(target) && base[from+n] == target[start+n] {
+				n++
+			}
+			flush(start)
+			dst = append(dst, deltaOpCopy)
+			dst = binary.AppendUvarint(dst, uint64(from))
+			dst = binary.AppendUvarint(dst, uint64(n))
+			i = start + n
+			literal = i
+			if i+deltaBlockSize <= len(target) {
+				h = hashBlock(target[i : i+deltaBlockSize])
+			}
+			continue
+		}
+		if i+deltaBlockSize < len(target) {
+			h = h*prime + uint64(target[i+deltaBlockSize]) - uint64(target[i])*pow
+		}
+		i++
+	}
+	flush(len(target))
+	return dst
+}
+
+func canDeltaTerrain(base, next *wireTerrain) bool {
+	return base != nil &&
+		base.width == next.width &&
+		base.height == next.height &&
+		(base.colorIndices == nil) == (next.colorIndices == nil)
+}
+
+func appendTerrainKeyframe(dst []byte, t *wireTerrain) []byte {
+	dst = binary.AppendUvarint(dst, uint64(t.width))
+	dst = binary.AppendUvarint(dst, uint64(t.height))
+	dst = binary.AppendUvarint(dst, uint64(t.revision))
+	for _, h := range t.heights {
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(h))
+	}
+	dst = append(dst, t.mask...)
+	if t.colorIndices != nil {
+		dst = append(dst, 1)
+		dst = append(dst, t.colorIndices...)
+	} else {
+		dst = append(dst, 0)
+	}
+	return appendPalette(dst, t.palette)
+}
+
+func appendTerrainDelta(dst []byte, base, next *wireTerrain) []byte {
+	dst = binary.AppendUvarint(dst, uint64(next.revision))
+	dst = appendRuns(dst, len(next.heights), func(i int) bool { return base.heights[i] != next.heights[i] }, func(dst []byte, i int) []byte {
+		return binary.LittleEndian.AppendUint16(dst, uint16(next.heights[i]))
+	})
+	dst = appendByteRuns(dst, base.mask, next.mask)
+	if next.colorIndices != nil {
+		dst = appendByteRuns(dst, base.colorIndices, next.colorIndices)
+	}
+	return appendPalette(dst, next.palette)
+}
+
+func appendByteRuns(dst, base, next []byte) []byte {
+	return appendRuns(dst, len(next), func(i int) bool { return base[i] != next[i] }, func(dst []byte, i int) []byte {
+		return append(dst, next[i])
+	})
+}
+
+// appendRuns writes a run count followed by (offset, length, cells) for each
+// span of n cells where changed reports true.
+func appendRuns(dst []byte, n int, changed func(int) bool, cell func([]byte, int) []byte) []byte {
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < n; i++ {
+		if !changed(i) {
+			continue
+		}
+		if k := len(spans) - 1; k >= 0 && i-spans[k].end < runMergeGap {
+			spans[k].end = i + 1
+			continue
+		}
+		spans = append(spans, span{i, i + 1})
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(spans)))
+	for _, sp := range spans {
+		dst = binary.AppendUvarint(dst, uint64(sp.start))
+		dst = binary.AppendUvarint(dst, uint64(sp.end-sp.start))
+		for i := sp.start; i < sp.end; i++ {
+			dst = cell(dst, i)
+		}
+	}
+	return dst
+}
+
+func appendPalette(dst []byte, palette [][3]int) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(palette)))
+	for _, c := range palette {
+		dst = append(dst, byte(c[0]), byte(c[1]), byte(c[2]))
+	}
+	return dst
+}
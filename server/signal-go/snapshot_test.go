@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+// testSnapshotDecoder mirrors the browser's decoder in src/net/snapshotCodec.ts.
+type testSnapshotDecoder struct {
+	seq     uint32
+	body    []byte
+	terrain *wireTerrain
+}
+
+type byteReader struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (r *byteReader) uvarint() int {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.t.Fatal("bad uvarint")
+	}
+	r.buf = r.buf[n:]
+	return int(v)
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if n > len(r.buf) {
+		r.t.Fatalf("read %d bytes with %d left", n, len(r.buf))
+	}
+	out := r.buf[:n]
+	r.buf = r.buf[n:]
+	return out
+}
+
+func applyBytesDelta(t *testing.T, base, ops []byte) []byte {
+	r := &byteReader{t: t, buf: ops}
+	var out []byte
+	for len(r.buf) > 0 {
+		switch r.bytes(1)[0] {
+		case deltaOpCopy:
+			from, n := r.uvarint(), r.uvarint()
+			out = append(out, base[from:from+n]...)
+		case deltaOpInsert:
+			out = append(out, r.bytes(r.uvarint())...)
+		default:
+			t.Fatal("unknown delta op")
+		}
+	}
+	return out
+}
+
+func (d *testSnapshotDecoder) decode(t *testing.T, frame []byte) []byte {
+	t.Helper()
+	r := &byteReader{t: t, buf: frame}
+	head := r.bytes(10)
+	if head[0] != snapshotWireVersion {
+		t.Fatalf("version = %d", head[0])
+	}
+	flags := head[1]
+	seq := binary.LittleEndian.Uint32(head[2:])
+	baseSeq := binary.LittleEndian.Uint32(head[6:])
+	section := r.bytes(r.uvarint())
+	if flags&snapFlagKeyframe != 0 {
+		d.body = append([]byte(nil), section...)
+	} else {
+		if baseSeq != d.seq {
+			t.Fatalf("baseSeq = %d, have %d", baseSeq, d.seq)
+		}
+		d.body = applyBytesDelta(t, d.body, section)
+	}
+	d.seq = seq
+	if flags&snapFlagTerrain == 0 {
+		return d.body
+	}
+	next := &wireTerrain{}
+	if flags&snapFlagTerrainKeyframe != 0 {
+		next.width, next.height, next.revision = r.uvarint(), r.uvarint(), r.uvarint()
+		next.heights = make([]int, next.width)
+		for i := range next.heights {
+			next.heights[i] = int(binary.LittleEndian.Uint16(r.bytes(2)))
+		}
+		next.mask = append([]byte(nil), r.bytes(next.width*next.height)...)
+		if r.bytes(1)[0] == 1 {
+			next.colorIndices = append([]byte(nil), r.bytes(len(next.mask))...)
+		}
+	} else {
+		base := d.terrain
+		next.width, next.height, next.revision = base.width, base.height, r.uvarint()
+		next.heights = append([]int(nil), base.heights...)
+		for runs := r.uvarint(); runs > 0; runs-- {
+			at, n := r.uvarint(), r.uvarint()
+			for i := 0; i < n; i++ {
+				next.heights[at+i] = int(binary.LittleEndian.Uint16(r.bytes(2)))
+			}
+		}
+		readByteRuns := func(base []byte) []byte {
+			out := append([]byte(nil), base...)
+			for runs := r.uvarint(); runs > 0; runs-- {
+				at, n := r.uvarint(), r.uvarint()
+				copy(out[at:], r.bytes(n))
+			}
+			return out
+		}
+		next.mask = readByteRuns(base.mask)
+		if base.colorIndices != nil {
+			next.colorIndices = readByteRuns(base.colorIndices)
+		}
+	}
+	next.palette = make([][3]int, r.uvarint())
+	for i := range next.palette {
+		c := r.bytes(3)
+		next.palette[i] = [3]int{int(c[0]), int(c[1]), int(c[2])}
+	}
+	if len(r.buf) != 0 {
+		t.Fatalf("%d trailing bytes", len(r.buf))
+	}
+	d.terrain = next
+	return d.body
+}
+
+func TestBytesDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	base := make([]byte, 4000)
+	for i := range base {
+		base[i] = byte('a' + rng.Intn(26))
+	}
+	for round := 0; round < 50; round++ {
+		target := append([]byte(nil), base...)
+		for edits := rng.Intn(6); edits > 0; edits-- {
+			at := rng.Intn(len(target))
+			switch rng.Intn(3) {
+			case 0:
+				target[at] = '#'
+			case 1:
+				target = append(target[:at], append([]byte("inserted"), target[at:]...)...)
+			default:
+				end := min(len(target), at+rng.Intn(40))
+				target = append(target[:at], target[end:]...)
+			}
+		}
+		ops := appendBytesDelta(nil, base, target)
+		if got := applyBytesDelta(t, base, ops); !bytes.Equal(got, target) {
+			t.Fatalf("round %d: delta did not rebuild target", round)
+		}
+		if len(ops) > len(target)/4 {
+			t.Fatalf("round %d: delta is %d bytes for a %d byte target", round, len(ops), len(target))
+		}
+		base = target
+	}
+	if got := applyBytesDelta(t, base, appendBytesDelta(nil, base, nil)); len(got) != 0 {
+		t.Fatal("empty target not rebuilt")
+	}
+}
+
+func testTerrainJSON(width, height, revision int, hole int) json.RawMessage {
+	heights := make([]int, width)
+	mask := make([]byte, width*height)
+	for x := 0; x < width; x++ {
+		heights[x] = height / 2
+		for y := height / 2; y < height; y++ {
+			mask[y*width+x] = 1
+		}
+	}
+	if hole >= 0 {
+		mask[(height/2+1)*width+hole] = 0
+		heights[hole]++
+	}
+	data, _ := json.Marshal(map[string]any{
+		"width":        width,
+		"height":       height,
+		"revision":     revision,
+		"heights":      heights,
+		"maskB64":      base64.StdEncoding.EncodeToString(mask),
+		"colorPalette": [][3]int{{10, 20, 30}},
+	})
+	return data
+}
+
+func TestSnapshotFramesDeltaAgainstBaseline(t *testing.T) {
+	r := &room{RoomID: "r"}
+	p := &peer{}
+	p.snapshots.enabled = true
+	dec := &testSnapshotDecoder{}
+
+	send := func(tick int, terrain json.RawMessage) []byte {
+		fields := map[string]any{"roomId": "r", "tick": tick, "match": map[string]any{"wind": 3}}
+		if terrain != nil {
+			fields["terrain"] = terrain
+		}
+		raw, _ := json.Marshal(fields)
+		snap, err := decodeSnapshot(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := r.prepareSnapshot(snap)
+		p.snapshots.mu.Lock()
+		defer p.snapshots.mu.Unlock()
+		out := encodeSnapshotFrame(&p.snapshots, frame)
+		p.snapshots.seq, p.snapshots.body, p.snapshots.terrain = frame.seq, frame.body, frame.terrain
+		return out
+	}
+
+	key := send(1, testTerrainJSON(200, 100, 1, -1))
+	if key[1]&(snapFlagKeyframe|snapFlagTerrainKeyframe) != snapFlagKeyframe|snapFlagTerrainKeyframe {
+		t.Fatalf("first frame flags = %b", key[1])
+	}
+	dec.decode(t, key)
+
+	plain := send(2, nil)
+	if plain[1]&(snapFlagKeyframe|snapFlagTerrain) != 0 {
+		t.Fatalf("unchanged terrain resent, flags = %b", plain[1])
+	}
+	var got map[string]any
+	if err := json.Unmarshal(dec.decode(t, plain), &got); err != nil || got["tick"] != float64(2) {
+		t.Fatalf("decoded body = %v (%v)", got, err)
+	}
+
+	crater := send(3, testTerrainJSON(200, 100, 2, 50))
+	if crater[1]&snapFlagTerrainKeyframe != 0 || len(crater) > 100 {
+		t.Fatalf("terrain delta flags = %b size = %d", crater[1], len(crater))
+	}
+	dec.decode(t, crater)
+	want, _ := decodeWireTerrain(testTerrainJSON(200, 100, 2, 50))
+	if dec.terrain.revision != 2 || !bytes.Equal(dec.terrain.mask, want.mask) || dec.terrain.heights[50] != want.heights[50] {
+		t.Fatal("decoded terrain does not match")
+	}
+}
+
+func TestBinarySnapshotNegotiation(t *testing.T) {
+	_, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	guest := dialTestClient(t, ts)
+	guest.send("snapshot.mode", map[string]any{"mode": "binary"})
+	if got := guest.expect("snapshot.mode"); got["mode"] != "binary" {
+		t.Fatalf("snapshot.mode = %v", got)
+	}
+	guest.send("room.join", map[string]any{"roomId": roomID, "playerName": "Guest"})
+	guest.expect("room.joined")
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+
+	readBinary := func() []byte {
+		for i := 0; i < 32; i++ {
+			if first, data := guest.readFrame(); first&0x0F == 0x2 {
+				return data
+			}
+		}
+		t.Fatal("no binary frame")
+		return nil
+	}
+	dec := &testSnapshotDecoder{}
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 1, "terrain": testTerrainJSON(64, 32, 1, -1)})
+	dec.decode(t, readBinary())
+	if dec.terrain == nil || dec.terrain.width != 64 {
+		t.Fatal("keyframe missing terrain")
+	}
+	host.send("game.snapshot", map[string]any{"roomId": roomID, "tick": 2})
+	frame := readBinary()
+	if frame[1]&snapFlagKeyframe != 0 {
+		t.Fatal("second frame is a keyframe")
+	}
+	dec.decode(t, frame)
+
+	guest.send("snapshot.resync", map[string]any{})
+	if frame := readBinary(); frame[1]&(snapFlagKeyframe|snapFlagTerrainKeyframe) != snapFlagKeyframe|snapFlagTerrainKeyframe {
+		t.Fatalf("resync flags = %b", frame[1])
+	}
+}
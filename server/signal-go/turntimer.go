@@ -0,0 +1,170 @@
+package main
+
+import "time"
+
+// afkTimeoutLimit is how many turns in a row a player may let run out before
+// the room readies them out.
+const afkTimeoutLimit = 3
+
+// turnClock times the active player's turn in a room with a turn limit. It
+// follows the match through the snapshots the room relays, so it works the
+// same for browser hosts and server-hosted matches.
+type turnClock struct {
+	// activeID and phase are what the latest snapshot showed.
+	activeID string
+	phase    string
+	// deadline is when the timed turn runs out; zero while nothing is timed.
+	deadline time.Time
+	limit    time.Duration
+	// gen changes whenever a clock starts or stops, so ticks scheduled for an
+	// earlier turn do nothing.
+	gen   int
+	timer *time.Timer
+	// timeouts counts, per player, the turns in a row that ran out.
+	timeouts map[string]int
+}
+
+// trackTurn updates the turn clock from a snapshot's state. Snapshots
+// without match state leave it alone.
+func (r *room) trackTurn(snap snapshotState) {
+	if snap.Match.ActivePlayerID == "" {
+		return
+	}
+	active, phase := snap.Match.ActivePlayerID, snap.Match.Phase
+	wasAiming := r.turn.phase == "aim"
+	if wasAiming && phase != "aim" && active == r.turn.activeID && !r.turn.deadline.IsZero() {
+		// The player acted before the clock ran out.
+		delete(r.turn.timeouts, active)
+	}
+	aiming := snap.View != "shop" && phase == "aim"
+	newTurn := aiming && (!wasAiming || active != r.turn.activeID)
+	r.turn.activeID, r.turn.phase = active, phase
+	if snap.View == "shop" {
+		r.turn.phase = ""
+	}
+	switch {
+	case newTurn:
+		r.startTurn(active)
+	case !aiming:
+		r.stopTurn()
+	}
+}
+
+// startTurn starts the clock on peerID's turn. Bots aren't timed, and players
+// the room has readied out are skipped straight away.
+func (r *room) startTurn(peerID string) {
+	r.stopTurn()
+	t := r.Settings.TurnTimeLimitSec
+	if t == nil {
+		return
+	}
+	pl := findPlayer(r, peerID)
+	if pl != nil && pl.Bot {
+		return
+	}
+	if pl != nil && pl.AFK {
+		r.skipTurn(peerID)
+		return
+	}
+	r.turn.limit = time.Duration(*t * float64(r.turnSecondOrDefault()))
+	r.turn.deadline = time.Now().Add(r.turn.limit)
+	r.tickTurn(r.turn.gen)
+}
+
+// stopTurn stops the clock without skipping anyone.
+func (r *room) stopTurn() {
+	if r.turn.timer != nil {
+		r.turn.timer.Stop()
+		r.turn.timer = nil
+	}
+	r.turn.deadline = time.Time{}
+	r.turn.gen++
+}
+
+// tickTurn sends turn.timer and schedules the next tick, or ends the turn
+// once the deadline has passed.
+func (r *room) tickTurn(gen int) {
+	if gen != r.turn.gen || r.turn.deadline.IsZero() {
+		return
+	}
+	remaining := time.Until(r.turn.deadline)
+	if remaining <= 0 {
+		r.expireTurn()
+		return
+	}
+	r.sendTurnTimer(remaining)
+	next := min(r.turnSecondOrDefault(), remaining)
+	r.turn.timer = time.AfterFunc(next, func() {
+		r.do(func() { r.tickTurn(gen) })
+	})
+}
+
+func (r *room) sendTurnTimer(remaining time.Duration) {
+	payload := map[string]any{
+		"roomId":      r.RoomID,
+		"peerId":      r.turn.activeID,
+		"remainingMs": remaining.Milliseconds(),
+		"limitMs":     r.turn.limit.Milliseconds(),
+	}
+	for _, rp := range r.recipients() {
+		rp.send("turn.timer", payload, "")
+	}
+}
+
+// expireTurn skips the active player whose time ran out, readying them out
+// after afkTimeoutLimit timeouts in a row.
+func (r *room) expireTurn() {
+	peerID := r.turn.activeID
+	r.stopTurn()
+	r.sendTurnTimer(0)
+	if r.turn.timeouts == nil {
+		r.turn.timeouts = make(map[string]int)
+	}
+	r.turn.timeouts[peerID]++
+	if pl := findPlayer(r, peerID); pl != nil && !pl.AFK && r.turn.timeouts[peerID] >= afkTimeoutLimit {
+		pl.AFK = true
+		pl.Ready = false
+		r.broadcastState()
+	}
+	r.skipTurn(peerID)
+}
+
+// skipTurn passes peerID's turn: the server does it for matches it runs,
+// otherwise the host is asked to with turn.skip.
+func (r *room) skipTurn(peerID string) {
+	if r.hosted != nil {
+		r.hosted.skipTurn(peerID)
+		return
+	}
+	for _, rp := range r.Players {
+		if !rp.IsHost {
+			continue
+		}
+		if hostPeer := r.conns[rp.PeerID]; hostPeer != nil {
+			hostPeer.send("turn.skip", map[string]any{"roomId": r.RoomID, "peerId": peerID}, "")
+		}
+		return
+	}
+}
+
+// markPresent brings a readied-out player back into the turn order. It
+// reports whether the player had been readied out.
+func (r *room) markPresent(pl *player) bool {
+	delete(r.turn.timeouts, pl.PeerID)
+	wasAFK := pl.AFK
+	pl.AFK = false
+	return wasAFK
+}
+
+// resetTurns forgets the clock and the timeout counts, between matches.
+func (r *room) resetTurns() {
+	r.stopTurn()
+	r.turn = turnClock{gen: r.turn.gen}
+}
+
+func (r *room) turnSecondOrDefault() time.Duration {
+	if r.turnSecond > 0 {
+		return r.turnSecond
+	}
+	return time.Second
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTurnTimerSkipsAndReadiesOutIdlePlayers(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.turnSecond = 20 * time.Millisecond
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	host.send("room.settings.update", map[string]any{"settings": map[string]any{"turnTimeLimitSec": 5}})
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+	guestID := joined["selfPeerId"].(string)
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	guest.expect("match.start")
+
+	guestTurn := func() {
+		host.send("game.snapshot", map[string]any{"roomId": roomID, "view": "shop", "match": map[string]any{"activePlayerId": guestID, "phase": "aim"}})
+		host.send("game.snapshot", map[string]any{"roomId": roomID, "view": "battle", "match": map[string]any{"activePlayerId": guestID, "phase": "aim"}})
+	}
+	for i := 0; i < afkTimeoutLimit; i++ {
+		guestTurn()
+		if got := guest.expect("turn.timer"); got["peerId"] != guestID || got["limitMs"] != float64(100) {
+			t.Fatalf("turn.timer = %v", got)
+		}
+		if got := host.expect("turn.skip"); got["peerId"] != guestID {
+			t.Fatalf("turn.skip = %v", got)
+		}
+	}
+	afk := func(state map[string]any) any {
+		for _, pl := range state["room"].(map[string]any)["players"].([]any) {
+			if entry := pl.(map[string]any); entry["peerId"] == guestID {
+				return entry["afk"]
+			}
+		}
+		return nil
+	}
+	for afk(guest.expect("room.state")) != true {
+	}
+
+	// A readied-out player's turn is passed at once, long before any clock
+	// could run out.
+	r := s.findRoom(roomID)
+	r.do(func() { r.turnSecond = time.Hour })
+	guestTurn()
+	if got := host.expect("turn.skip"); got["peerId"] != guestID {
+		t.Fatalf("turn.skip = %v", got)
+	}
+
+	guest.send("peer.ready", map[string]any{"ready": true})
+	for afk(host.expect("room.state")) != nil {
+	}
+}
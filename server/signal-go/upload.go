@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"scorched-signal-go/mtn"
+)
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// uploadNameStrip is what a map id may not contain.
+var uploadNameStrip = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// heightmapPalette colours heightmap ground: a light crust, then darker
+// layers down to the bottom of the map.
+var heightmapPalette = func() [16][3]uint8 {
+	var p [16][3]uint8
+	p[1] = [3]uint8{0x9c, 0xc0, 0x5a}
+	for i := 2; i < len(p); i++ {
+		shade := 0xa0 - (i-2)*8
+		p[i] = [3]uint8{uint8(shade), uint8(shade * 3 / 4), uint8(shade / 2)}
+	}
+	return p
+}()
+
+// heightmapCrust is how deep the crust of a heightmap's columns goes.
+const heightmapCrust = 3
+
+// parseUploadedMap reads an uploaded .MTN file or heightmap PNG, telling the
+// two apart by their signatures, and returns the map and its content type.
+func parseUploadedMap(data []byte) (*mtn.File, string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("MT")):
+		f, err := mtn.Parse(data)
+		return f, "application/octet-stream", err
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		f, err := mapFromHeightmap(data)
+		return f, "image/png", err
+	}
+	return nil, "", errors.New("not an .MTN file or a PNG")
+}
+
+// mapFromHeightmap turns a heightmap PNG into a map of its size: the first
+// light, opaque pixel of each column from the top is its ground, and the
+// column is solid from there down.
+func mapFromHeightmap(data []byte) (*mtn.File, error) {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > mtn.MaxDimension || cfg.Height > mtn.MaxDimension {
+		return nil, fmt.Errorf("heightmap must be 1 to %d pixels each way, got %dx%d", mtn.MaxDimension, cfg.Width, cfg.Height)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	f := &mtn.File{
+		Width:   b.Dx(),
+		Height:  b.Dy(),
+		Palette: heightmapPalette,
+		Columns: make([][]uint8, b.Dx()),
+	}
+	f.HeaderWords[0], f.HeaderWords[1] = uint16(f.Width), uint16(f.Height-1)
+	for x := range f.Columns {
+		top := f.Height
+		for y := 0; y < f.Height; y++ {
+			if isHeightmapGround(img.At(b.Min.X+x, b.Min.Y+y)) {
+				top = y
+				break
+			}
+		}
+		column := make([]uint8, f.Height-top)
+		for i := range column {
+			column[i] = 1
+			if i >= heightmapCrust {
+				column[i] = uint8(2 + (i-heightmapCrust)*(len(heightmapPalette)-2)/f.Height)
+			}
+		}
+		f.Columns[x] = column
+	}
+	return f, nil
+}
+
+func isHeightmapGround(c color.Color) bool {
+	g := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return g.A >= 0x80 && color.GrayModel.Convert(color.NRGBA{R: g.R, G: g.G, B: g.B, A: 0xFF}).(color.Gray).Y >= 0x80
+}
+
+// uploadMapID makes a map id from an uploaded file's name, steering clear of
+// the ids in the library.
+func uploadMapID(name string, maps *mapLibrary) string {
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	id := uploadNameStrip.ReplaceAllString(name, "-")
+	id = strings.Trim(id, "-")
+	if len(id) > 48 {
+		id = id[:48]
+	}
+	if id == "" {
+		id = "upload"
+	}
+	if maps.find(id) != nil {
+		id += "-upload"
+	}
+	return id
+}
+
+// handleRoomMap takes a custom map for a room's lobby and serves it back.
+//
+//	POST /api/rooms/ID/map      the host uploads an .MTN file or heightmap PNG,
+//	                            with its resume token as a bearer token and the
+//	                            file name in ?name=
+//	GET  /api/rooms/ID/map      the uploaded file
+//	GET  /api/rooms/ID/map.png  its thumbnail
+//
+// The upload lives on the room until the room closes or the host uploads
+// another; it is announced as upload in room.state. Anyone may fetch it, but
+// only pages from the origins uploadOriginAllowed accepts may upload.
+func (s *server) handleRoomMap(w http.ResponseWriter, req *http.Request) {
+	roomID, name, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/api/rooms/"), "/")
+	if !ok || (name != "map" && name != "map.png") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	origin := req.Header.Get("Origin")
+	switch req.Method {
+	case http.MethodOptions:
+		if !s.uploadOriginAllowed(origin, req.Host) {
+			http.Error(w, "uploads are not accepted from this origin", http.StatusForbidden)
+			return
+		}
+		allowOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodHead:
+		// The lobby may be served from elsewhere, such as the dev server.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		s.serveRoomMap(w, roomID, name == "map.png")
+		return
+	case http.MethodPost:
+		if name != "map" {
+			break
+		}
+		if origin != "" {
+			if !s.uploadOriginAllowed(origin, req.Host) {
+				http.Error(w, "uploads are not accepted from this origin", http.StatusForbidden)
+				return
+			}
+			allowOrigin(w, origin)
+		}
+		s.uploadRoomMap(w, req, roomID)
+		return
+	}
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// uploadOriginAllowed reports whether a page at origin may upload maps to
+// the server at host: pages it serves itself, pages from the uploader's own
+// machine, such as the dev server or their own copy of the game, and those
+// listed in UPLOAD_ORIGINS.
+func (s *server) uploadOriginAllowed(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == host || slices.Contains(s.uploadOrigins, strings.TrimSuffix(origin, "/")) {
+		return true
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func allowOrigin(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+}
+
+func (s *server) serveRoomMap(w http.ResponseWriter, roomID string, thumbnail bool) {
+	var upload *gameMap
+	if r := s.findRoom(roomID); r != nil {
+		r.do(func() { upload = r.upload })
+	}
+	if upload == nil {
+		http.Error(w, "no uploaded map", http.StatusNotFound)
+		return
+	}
+	// The host may swap the upload for another at the same address.
+	w.Header().Set("Cache-Control", "no-cache")
+	if thumbnail {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(upload.thumb)
+		return
+	}
+	w.Header().Set("Content-Type", upload.contentType)
+	_, _ = w.Write(upload.data)
+}
+
+func (s *server) uploadRoomMap(w http.ResponseWriter, req *http.Request, roomID string) {
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	s.mu.RLock()
+	peerID, ok := s.resumeTokens[token]
+	s.mu.RUnlock()
+	if token == "" || !ok {
+		http.Error(w, "a session token is required", http.StatusUnauthorized)
+		return
+	}
+	r := s.findRoom(roomID)
+	if r == nil {
+		http.Error(w, "no such room", http.StatusNotFound)
+		return
+	}
+	var isHost bool
+	r.do(func() {
+		pl := findPlayer(r, peerID)
+		isHost = pl != nil && pl.IsHost
+	})
+	if !isHost {
+		http.Error(w, "only the host can upload a map", http.StatusForbidden)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, mapMaxBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, fmt.Sprintf("maps are limited to %d bytes", mapMaxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "upload failed", http.StatusBadRequest)
+		return
+	}
+	f, contentType, err := parseUploadedMap(data)
+	if err != nil {
+		http.Error(w, "invalid map: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	thumb, err := renderMapThumbnail(f)
+	if err != nil {
+		http.Error(w, "invalid map: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	upload := &gameMap{
+		ID:           uploadMapID(req.URL.Query().Get("name"), s.maps),
+		Source:       "upload",
+		Width:        f.Width,
+		Height:       f.Height,
+		ThumbnailURL: "/api/rooms/" + r.RoomID + "/map.png",
+		FileURL:      "/api/rooms/" + r.RoomID + "/map",
+		file:         f,
+		thumb:        thumb,
+		data:         data,
+		contentType:  contentType,
+	}
+
+	status, msg := http.StatusCreated, ""
+	ran := r.do(func() {
+		// The host may have changed, or the match started, while the file
+		// was read.
+		if pl := findPlayer(r, peerID); pl == nil || !pl.IsHost {
+			status, msg = http.StatusForbidden, "only the host can upload a map"
+			return
+		}
+		if r.Status != "lobby" {
+			status, msg = http.StatusConflict, "the map is fixed once the match starts"
+			return
+		}
+		r.upload = upload
+		r.MapID = upload.ID
+		r.LastActive = time.Now().UnixMilli()
+		r.broadcastState()
+	})
+	if !ran {
+		status, msg = http.StatusNotFound, "no such room"
+	}
+	if status != http.StatusCreated {
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(upload)
+}
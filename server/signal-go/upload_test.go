@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// heightmapPNG draws a heightmap whose columns reach down from tops.
+func heightmapPNG(t *testing.T, height int, tops ...int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, len(tops), height))
+	for x, top := range tops {
+		for y := top; y < height; y++ {
+			img.SetGray(x, y, color.Gray{Y: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHostUploadsMapIntoRoom(t *testing.T) {
+	s, ts := newTestServer(t)
+	host, created := createRoom(t, ts, "Host")
+	roomID := roomIDOf(created)
+	hostToken := created["resumeToken"].(string)
+	guest, joined := joinRoom(t, ts, roomID, "Guest")
+
+	upload := func(token, name string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+roomID+"/map?name="+name, bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		got := httptest.NewRecorder()
+		s.handleRoomMap(got, req)
+		return got
+	}
+	for _, tc := range []struct {
+		token string
+		body  []byte
+		want  int
+	}{
+		{"", tinyMap(), http.StatusUnauthorized},
+		{joined["resumeToken"].(string), tinyMap(), http.StatusForbidden},
+		{hostToken, make([]byte, mapMaxBytes+1), http.StatusRequestEntityTooLarge},
+		{hostToken, []byte("MT not really"), http.StatusUnprocessableEntity},
+		{hostToken, []byte("GIF89a"), http.StatusUnprocessableEntity},
+	} {
+		if got := upload(tc.token, "x.mtn", tc.body); got.Code != tc.want {
+			t.Fatalf("upload status = %d, want %d (%s)", got.Code, tc.want, got.Body.String())
+		}
+	}
+
+	// Only the server's own pages, local ones and listed origins may upload.
+	s.uploadOrigins = []string{"https://game.example"}
+	for _, tc := range []struct {
+		method, origin string
+		want           int
+	}{
+		{http.MethodOptions, "https://evil.example", http.StatusForbidden},
+		{http.MethodPost, "https://evil.example", http.StatusForbidden},
+		{http.MethodOptions, "https://game.example", http.StatusNoContent},
+		{http.MethodOptions, "http://localhost:5173", http.StatusNoContent},
+		{http.MethodOptions, "http://" + ts.Listener.Addr().String(), http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(tc.method, "/api/rooms/"+roomID+"/map?name=x.mtn", bytes.NewReader([]byte("MT not really")))
+		req.Host = ts.Listener.Addr().String()
+		req.Header.Set("Origin", tc.origin)
+		req.Header.Set("Authorization", "Bearer "+hostToken)
+		got := httptest.NewRecorder()
+		s.handleRoomMap(got, req)
+		if got.Code != tc.want {
+			t.Fatalf("%s from %s = %d, want %d", tc.method, tc.origin, got.Code, tc.want)
+		}
+		if allowed := got.Header().Get("Access-Control-Allow-Origin"); tc.want != http.StatusForbidden && allowed != tc.origin {
+			t.Fatalf("%s from %s allowed origin %q", tc.method, tc.origin, allowed)
+		}
+	}
+
+	got := upload(hostToken, "My%20Valley!.mtn", tinyMap())
+	if got.Code != http.StatusCreated {
+		t.Fatalf("upload status = %d (%s)", got.Code, got.Body.String())
+	}
+	var info gameMap
+	if err := json.Unmarshal(got.Body.Bytes(), &info); err != nil || info.ID != "My-Valley" || info.Source != "upload" || info.Width != 2 {
+		t.Fatalf("upload = %s (%v)", got.Body.String(), err)
+	}
+	for {
+		room := guest.expect("room.state")["room"].(map[string]any)
+		if announced, _ := room["upload"].(map[string]any); announced != nil && room["mapId"] == "My-Valley" {
+			if announced["fileUrl"] != "/api/rooms/"+roomID+"/map" {
+				t.Fatalf("announced upload = %v", announced)
+			}
+			break
+		}
+	}
+	file := httptest.NewRecorder()
+	s.handleRoomMap(file, httptest.NewRequest(http.MethodGet, info.FileURL, nil))
+	if !bytes.Equal(file.Body.Bytes(), tinyMap()) || file.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("fetched map = %v (%v)", file.Body.Bytes(), file.Header())
+	}
+	thumb := httptest.NewRecorder()
+	s.handleRoomMap(thumb, httptest.NewRequest(http.MethodGet, info.ThumbnailURL, nil))
+	if _, err := png.Decode(thumb.Body); err != nil {
+		t.Fatalf("thumbnail: %v", err)
+	}
+
+	// A heightmap replaces the upload; its name steers clear of the library.
+	got = upload(hostToken, "ICE001.png", heightmapPNG(t, 6, 2, 6, 0))
+	if got.Code != http.StatusCreated {
+		t.Fatalf("heightmap status = %d (%s)", got.Code, got.Body.String())
+	}
+	for host.expect("room.state")["room"].(map[string]any)["mapId"] != "ICE001-upload" {
+	}
+	host.send("match.start", map[string]any{"roomId": roomID, "forceStart": true})
+	picked := guest.expect("match.start")["map"].(map[string]any)
+	heights := picked["terrain"].(map[string]any)["heights"]
+	if picked["id"] != "ICE001-upload" || !reflect.DeepEqual(heights, []any{2.0, 6.0, 0.0}) {
+		t.Fatalf("match.start map = %v, heights %v", picked["id"], heights)
+	}
+	if got := upload(hostToken, "late.mtn", tinyMap()); got.Code != http.StatusConflict {
+		t.Fatalf("in-game upload status = %d", got.Code)
+	}
+}
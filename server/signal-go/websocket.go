@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeNoStatus        = 1005
+	closeInvalidPayload  = 1007
+	closePolicyViolation = 1008
+	closeMessageTooBig   = 1009
+	closeInternalError   = 1011
+	// closeSessionReplaced is sent to a connection whose session was resumed
+	// on a newer one.
+	closeSessionReplaced = 4000
+)
+
+const (
+	maxControlPayload     = 125
+	maxMessageSizeDefault = 16 << 20
+
+	rsv1 = 0x40
+)
+
+// closeError is a protocol failure that ends the connection with a close
+// frame carrying code and reason.
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket close %d: %s", e.code, e.reason)
+}
+
+func protocolError(reason string) error {
+	return &closeError{code: closeProtocolError, reason: reason}
+}
+
+// wsFrame is a single frame as read off the wire, already unmasked.
+type wsFrame struct {
+	fin     bool
+	rsv     byte
+	opcode  byte
+	payload []byte
+}
+
+// wsReader reads client frames and reassembles fragmented messages.
+type wsReader struct {
+	r       *bufio.Reader
+	maxSize int
+	// inflate is set when permessage-deflate was negotiated.
+	inflate *inflater
+
+	// The opcode and payload of a fragmented message in progress, and
+	// whether its first frame was marked compressed.
+	fragOpcode     byte
+	fragments      []byte
+	fragCompressed bool
+}
+
+func newWSReader(r *bufio.Reader, maxSize int, inflate *inflater) *wsReader {
+	return &wsReader{r: r, maxSize: maxSize, inflate: inflate}
+}
+
+// readFrame reads one frame, enforcing the rules that don't depend on
+// message state: masking, reserved bits, known opcodes and control frame
+// limits.
+func (wr *wsReader) readFrame() (wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(wr.r, head[:]); err != nil {
+		return wsFrame{}, err
+	}
+	f := wsFrame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0F,
+	}
+	// RSV1 marks a compressed message and is only valid on the first frame
+	// of a data message once permessage-deflate is negotiated.
+	compressedStart := f.rsv == rsv1 && wr.inflate != nil && (f.opcode == opText || f.opcode == opBinary)
+	if f.rsv != 0 && !compressedStart {
+		return f, protocolError("reserved bits set without a negotiated extension")
+	}
+	if head[1]&0x80 == 0 {
+		return f, protocolError("client frames must be masked")
+	}
+	control := f.opcode&0x8 != 0
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return f, protocolError(fmt.Sprintf("unknown opcode 0x%x", f.opcode))
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(wr.r, ext[:]); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(wr.r, ext[:]); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return f, protocolError("payload length has the high bit set")
+		}
+	}
+	if control && (!f.fin || length > maxControlPayload) {
+		return f, protocolError("control frames must be unfragmented and at most 125 bytes")
+	}
+	if length > uint64(wr.maxSize) || (!control && len(wr.fragments)+int(length) > wr.maxSize) {
+		return f, &closeError{code: closeMessageTooBig, reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(wr.r, mask[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(wr.r, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// readMessage returns the next complete message. Control frames are
+// returned as they arrive, even in the middle of a fragmented message.
+func (wr *wsReader) readMessage() (opcode byte, payload []byte, err error) {
+	for {
+		f, err := wr.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case opClose:
+			if err := validateClosePayload(f.payload); err != nil {
+				return 0, nil, err
+			}
+			return f.opcode, f.payload, nil
+		case opPing, opPong:
+			return f.opcode, f.payload, nil
+		case opContinuation:
+			if wr.fragOpcode == 0 {
+				return 0, nil, protocolError("continuation frame without a message to continue")
+			}
+			wr.fragments = append(wr.fragments, f.payload...)
+		default:
+			if wr.fragOpcode != 0 {
+				return 0, nil, protocolError("new message started before the previous one finished")
+			}
+			wr.fragOpcode = f.opcode
+			wr.fragments = f.payload
+			wr.fragCompressed = f.rsv == rsv1
+		}
+		if !f.fin {
+			continue
+		}
+		opcode, payload = wr.fragOpcode, wr.fragments
+		compressed := wr.fragCompressed
+		wr.fragOpcode, wr.fragments, wr.fragCompressed = 0, nil, false
+		if compressed {
+			if payload, err = wr.inflate.inflate(payload, wr.maxSize); err != nil {
+				return 0, nil, err
+			}
+		}
+		if opcode == opText && !utf8.Valid(payload) {
+			return 0, nil, &closeError{code: closeInvalidPayload, reason: "text message is not valid UTF-8"}
+		}
+		return opcode, payload, nil
+	}
+}
+
+// validateClosePayload checks the optional status code and reason of a
+// close frame.
+func validateClosePayload(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	if len(payload) == 1 {
+		return protocolError("close frame payload of one byte")
+	}
+	if !validCloseCode(int(binary.BigEndian.Uint16(payload))) {
+		return protocolError("invalid close code")
+	}
+	if !utf8.Valid(payload[2:]) {
+		return &closeError{code: closeInvalidPayload, reason: "close reason is not valid UTF-8"}
+	}
+	return nil
+}
+
+// validCloseCode reports whether code may appear in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// closePayload builds a close frame body, trimming the reason so the frame
+// stays within the control frame limit.
+func closePayload(code int, reason string) []byte {
+	if code == closeNoStatus {
+		return nil
+	}
+	for len(reason) > maxControlPayload-2 {
+		_, size := utf8.DecodeLastRuneInString(reason)
+		reason = reason[:len(reason)-size]
+	}
+	out := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(reason)), uint16(code))
+	return append(out, reason...)
+}
+
+// writeWSFrame writes one unmasked, unfragmented server frame.
+func writeWSFrame(w io.Writer, opcode byte, payload []byte) error {
+	return writeWSFrameRSV(w, 0, opcode, payload)
+}
+
+// writeWSFrameRSV writes a frame with the given reserved bits set.
+func writeWSFrameRSV(w io.Writer, rsv, opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | rsv | opcode
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 65535:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		_, err := w.Write(payload)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// expectClose reads until a close frame arrives and returns its status code.
+func (c *testClient) expectClose() int {
+	c.t.Helper()
+	for i := 0; i < 32; i++ {
+		first, data := c.readFrame()
+		if first&0x0F != opClose {
+			continue
+		}
+		if first&0x80 == 0 {
+			c.t.Fatal("close frame without FIN")
+		}
+		if len(data) < 2 {
+			return closeNoStatus
+		}
+		return int(binary.BigEndian.Uint16(data))
+	}
+	c.t.Fatal("never received close")
+	return 0
+}
+
+func TestFragmentedMessageIsReassembled(t *testing.T) {
+	_, ts := newTestServer(t)
+	c := dialTestClient(t, ts)
+	msg, _ := json.Marshal(map[string]any{"type": "room.list.request", "payload": map[string]any{}, "requestId": "frag"})
+	c.writeFrame(opText, msg[:5])
+	c.writeFrame(0x80|opPing, []byte("mid"))
+	c.writeFrame(opContinuation, msg[5:10])
+	c.writeFrame(0x80|opContinuation, msg[10:])
+
+	first, data := c.readFrame()
+	if first != 0x80|opPong || string(data) != "mid" {
+		t.Fatalf("ping answer = %x %q", first, data)
+	}
+	c.expect("room.list.response")
+}
+
+func TestCloseHandshakeEchoesCode(t *testing.T) {
+	_, ts := newTestServer(t)
+	c := dialTestClient(t, ts)
+	c.writeFrame(0x80|opClose, closePayload(closeGoingAway, "bye"))
+	if code := c.expectClose(); code != closeGoingAway {
+		t.Fatalf("close code = %d", code)
+	}
+}
+
+func TestProtocolViolationsClose(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(c *testClient)
+		code  int
+	}{
+		{"unmasked", func(c *testClient) {
+			_, _ = c.conn.Write([]byte{0x80 | opText, 2, '{', '}'})
+		}, closeProtocolError},
+		{"rsv bits", func(c *testClient) { c.writeFrame(0x80|0x40|opText, []byte("{}")) }, closeProtocolError},
+		{"unknown opcode", func(c *testClient) { c.writeFrame(0x80|0x3, nil) }, closeProtocolError},
+		{"stray continuation", func(c *testClient) { c.writeFrame(0x80|opContinuation, []byte("x")) }, closeProtocolError},
+		{"interleaved message", func(c *testClient) {
+			c.writeFrame(opText, []byte("{"))
+			c.writeFrame(0x80|opText, []byte("{}"))
+		}, closeProtocolError},
+		{"fragmented ping", func(c *testClient) { c.writeFrame(opPing, nil) }, closeProtocolError},
+		{"long ping", func(c *testClient) { c.writeFrame(0x80|opPing, make([]byte, 126)) }, closeProtocolError},
+		{"bad close code", func(c *testClient) { c.writeFrame(0x80|opClose, []byte{0x03, 0xED}) }, closeProtocolError},
+		{"invalid utf8", func(c *testClient) { c.writeFrame(0x80|opText, []byte{'"', 0xff, '"'}) }, closeInvalidPayload},
+		{"utf8 split across fragments", func(c *testClient) {
+			c.writeFrame(opText, []byte{'"', 0xe2, 0x82})
+			c.writeFrame(0x80|opContinuation, []byte{'"'})
+		}, closeInvalidPayload},
+		{"too big", func(c *testClient) { c.writeFrame(0x80|opBinary, make([]byte, 2048)) }, closeMessageTooBig},
+		{"too big in fragments", func(c *testClient) {
+			c.writeFrame(opBinary, make([]byte, 600))
+			c.writeFrame(0x80|opContinuation, make([]byte, 600))
+		}, closeMessageTooBig},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ts := newTestServer(t)
+			s.maxMessageSize = 1024
+			c := dialTestClient(t, ts)
+			tc.write(c)
+			if code := c.expectClose(); code != tc.code {
+				t.Fatalf("close code = %d, want %d", code, tc.code)
+			}
+		})
+	}
+}
+
+func TestValidUTF8SplitAcrossFragments(t *testing.T) {
+	_, ts := newTestServer(t)
+	c := dialTestClient(t, ts)
+	msg := []byte(`{"type":"room.list.request","payload":{"note":"€"},"requestId":"utf8"}`)
+	split := strings.Index(string(msg), "€") + 1
+	c.writeFrame(opText, msg[:split])
+	c.writeFrame(0x80|opContinuation, msg[split:])
+	c.expect("room.list.response")
+}
+
+func TestHandshakeRejectsWrongVersion(t *testing.T) {
+	_, ts := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("status = %d version = %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+	}
+}
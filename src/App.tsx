@@ -11,15 +11,16 @@ import { buyWeapon, sellWeapon } from './game/Economy';
 import { STARTER_WEAPON_ID, WEAPONS, getWeaponById } from './game/WeaponCatalog';
 import { FIXED_DT, spreadAngles, stepProjectile, toVelocity } from './engine/physics/Ballistics';
 import { applyRoundEnd, initMatch, nextActivePlayer, updatePlayer } from './game/MatchController';
+import { createMatchSeed, createRoundRandom, parseSeed } from './game/Prng';
 import { addDirt, addDirtDisk, addLiquidDirt, carveCrater, ensureFloorIntegrity, settleTerrain } from './engine/terrain/TerrainDeform';
 import { computeAIShot } from './engine/ai/AimAI';
 import { generateTerrain } from './engine/terrain/TerrainGenerator';
-import { pickRandomMtn, preloadMtnTerrains } from './engine/terrain/MtnTerrain';
+import { parsedMtnFromTerrain, pickRandomMtn, preloadMtnTerrains, terrainFromParsedMtn, type ParsedMtn } from './engine/terrain/MtnTerrain';
 import { computeExplosionDamage } from './game/Combat';
 import { getWeaponRuntimeSpec } from './game/weapons/runtimeSpecs';
 import { SHIELD_ITEMS, activateShieldFromInventory, autoActivateShieldAtRoundStart, degradeShield } from './game/Shield';
 import { decodeTerrain, encodeTerrain } from './net/stateCodec';
-import type { GameInputPayload, GameSnapshotPayload } from './net/protocol';
+import type { GameInputPayload, GameSnapshotPayload, ReplayControl, ReplayPlaybackState, RoomState, TurnTimerPayload } from './net/protocol';
 import { SignalClient } from './net/signalingClient';
 import { deriveBattlefieldSize } from './game/viewport';
 
@@ -203,6 +204,26 @@ function resolveTerrainImpactPoint(terrain: TerrainState, xRaw: number, yRaw: nu
   return { x, y };
 }
 
+// ReplayView is a replay room's playback as of receivedAt.
+type ReplayView = ReplayPlaybackState & { receivedAt: number };
+
+function replayView(state: ReplayPlaybackState | undefined): ReplayView | null {
+  return state ? { ...state, receivedAt: Date.now() } : null;
+}
+
+// replayPosition extrapolates where playback stands at now.
+function replayPosition(view: ReplayView, now: number): number {
+  if (view.paused) {
+    return view.positionMs;
+  }
+  return Math.min(view.durationMs, view.positionMs + (now - view.receivedAt) * view.speed);
+}
+
+function formatReplayTime(ms: number): string {
+  const totalSec = Math.floor(ms / 1000);
+  return `${Math.floor(totalSec / 60)}:${String(totalSec % 60).padStart(2, '0')}`;
+}
+
 function clamp(v: number, min: number, max: number): number {
   return Math.max(min, Math.min(max, v));
 }
@@ -672,18 +693,39 @@ function spawnFunkyChildren(parent: ProjectileState, count: number): ProjectileS
   return out;
 }
 
+// readInviteCode picks up the room code from an invite link, which the Go
+// server redirects to /?join=CODE, and drops it from the address bar.
+function readInviteCode(): string {
+  const params = new URLSearchParams(window.location.search);
+  const code = params.get('join')?.trim() ?? '';
+  if (code) {
+    params.delete('join');
+    const query = params.toString();
+    window.history.replaceState(null, '', `${window.location.pathname}${query ? `?${query}` : ''}${window.location.hash}`);
+  }
+  return code;
+}
+
 export default function App(): JSX.Element {
   const initialAppStateRef = useRef(loadInitialAppState());
-  const [screen, setScreen] = useState<Screen>('title');
+  const inviteCodeRef = useRef(readInviteCode());
+  const [screen, setScreen] = useState<Screen>(inviteCodeRef.current ? 'lan' : 'title');
   const [settings, setSettings] = useState(initialAppStateRef.current.settings);
   const [playerConfigs, setPlayerConfigs] = useState<PlayerConfig[]>(initialAppStateRef.current.players);
   const [match, setMatch] = useState<MatchState | null>(null);
   const [terrain, setTerrain] = useState<TerrainState | null>(null);
   const [shopIndex, setShopIndex] = useState(0);
   const [message, setMessage] = useState('');
+  const [turnTimer, setTurnTimer] = useState<TurnTimerPayload | null>(null);
+  const [selfAfk, setSelfAfk] = useState(false);
+  // replayState is set while watching a replay room; replayHost while this
+  // browser controls its playback.
+  const [replayState, setReplayState] = useState<ReplayView | null>(null);
+  const [replayHost, setReplayHost] = useState(false);
+  const [replayNow, setReplayNow] = useState(() => Date.now());
   const [winnerName, setWinnerName] = useState('');
   const [shieldMenuOpen, setShieldMenuOpen] = useState(false);
-  const [lanEntryMode, setLanEntryMode] = useState<'host' | 'join'>('host');
+  const [lanEntryMode, setLanEntryMode] = useState<'host' | 'join'>(inviteCodeRef.current ? 'join' : 'host');
   const [networkMode, setNetworkMode] = useState<NetworkMode>('offline');
   const [shopDoneByPlayerId, setShopDoneByPlayerId] = useState<Record<string, boolean>>({});
   const [viewportSize, setViewportSize] = useState<ViewportSize>({
@@ -710,6 +752,16 @@ export default function App(): JSX.Element {
   const angleTickAccumulatorRef = useRef(0);
   const movementTickAccumulatorRef = useRef(0);
   const lanSessionRef = useRef<LanSessionState | null>(null);
+  // Latest room.state of the LAN session, kept for rematches.
+  const lanRoomRef = useRef<RoomState | null>(null);
+  const lanMatchStartRef = useRef<(session: LanMatchSession) => void>(() => {});
+  // Terrain, wind and spawns draw from the current round's stream of the
+  // match seed, so a seed rebuilds the same battlefields.
+  const matchSeedRef = useRef(0n);
+  const roundRngRef = useRef<() => number>(Math.random);
+  // matchMapRef is the map a LAN room picked; battles are cut from it
+  // whatever the terrain preset.
+  const matchMapRef = useRef<{ id: string; parsed: ParsedMtn } | null>(null);
   const remoteInputQueueRef = useRef<Array<{ peerId: string; payload: GameInputPayload }>>([]);
   const predictedRuntimeRef = useRef<RuntimeState | null>(null);
   const clientPredictionAccumulatorRef = useRef(0);
@@ -973,15 +1025,26 @@ export default function App(): JSX.Element {
     }
   }, [networkMode, pushHostSnapshot, screen, viewportSize.height, viewportSize.width]);
 
+  const beginRound = useCallback((round: number) => {
+    roundRngRef.current = createRoundRandom(matchSeedRef.current, round);
+  }, []);
+
   const makeTerrainForRound = useCallback(async (
     width: number,
     height: number,
     preset: GameSettings['terrainPreset'],
     _playerCount: number,
   ): Promise<{ terrain: TerrainState; source: string }> => {
+    const picked = matchMapRef.current;
+    if (picked) {
+      return {
+        terrain: terrainFromParsedMtn(picked.parsed, width, height, roundRngRef.current),
+        source: `Map ${picked.id}`,
+      };
+    }
     if (preset === 'mtn') {
       try {
-        const picked = await pickRandomMtn(width, height);
+        const picked = await pickRandomMtn(width, height, roundRngRef.current);
         return {
           terrain: picked.terrain,
           source: `MTN ${picked.sourceName}`,
@@ -989,17 +1052,17 @@ export default function App(): JSX.Element {
       } catch (error) {
         console.warn('MTN terrain load failed, using procedural fallback.', error);
         return {
-          terrain: generateTerrain(width, height, 'rolling'),
+          terrain: generateTerrain(width, height, 'rolling', roundRngRef.current),
           source: 'Procedural fallback',
         };
       }
     }
     if (preset === 'random') {
       const proceduralChoices: Array<Exclude<GameSettings['terrainPreset'], 'random' | 'mtn'>> = ['rolling', 'canyon', 'islands'];
-      const useMtn = Math.random() < 0.5;
+      const useMtn = roundRngRef.current() < 0.5;
       if (useMtn) {
         try {
-          const picked = await pickRandomMtn(width, height);
+          const picked = await pickRandomMtn(width, height, roundRngRef.current);
           return {
             terrain: picked.terrain,
             source: `MTN ${picked.sourceName}`,
@@ -1008,14 +1071,14 @@ export default function App(): JSX.Element {
           // Fall through to procedural random selection below.
         }
       }
-      const chosen = proceduralChoices[Math.floor(Math.random() * proceduralChoices.length)] ?? 'rolling';
+      const chosen = proceduralChoices[Math.floor(roundRngRef.current() * proceduralChoices.length)] ?? 'rolling';
       return {
-        terrain: generateTerrain(width, height, chosen),
+        terrain: generateTerrain(width, height, chosen, roundRngRef.current),
         source: `Procedural ${chosen}`,
       };
     }
     return {
-      terrain: generateTerrain(width, height, preset),
+      terrain: generateTerrain(width, height, preset, roundRngRef.current),
       source: `Procedural ${preset}`,
     };
   }, []);
@@ -1058,7 +1121,7 @@ export default function App(): JSX.Element {
       const relaxedSep = Math.max(10, Math.floor(minSep * 0.6));
       let found = false;
       for (let attempt = 0; attempt < 180; attempt += 1) {
-        const candidate = clamp(Math.floor(minX + Math.random() * (maxX - minX + 1)), minX, maxX);
+        const candidate = clamp(Math.floor(minX + roundRngRef.current() * (maxX - minX + 1)), minX, maxX);
         if (!isStableSpawnX(candidate, terrainOut)) {
           continue;
         }
@@ -1094,7 +1157,7 @@ export default function App(): JSX.Element {
         }
       }
       if (best === null) {
-        best = clamp(Math.floor(minX + Math.random() * (maxX - minX + 1)), minX, maxX);
+        best = clamp(Math.floor(minX + roundRngRef.current() * (maxX - minX + 1)), minX, maxX);
       }
       picks.push(best);
     }
@@ -1194,7 +1257,7 @@ export default function App(): JSX.Element {
         shieldType: shieldItem ? shieldItem.shieldType : armedShooter.shieldType,
         fuel: clamp(armedShooter.fuel + fuelBoost, 0, 1000),
       };
-      const nextMatch = nextActivePlayer(updatePlayer(sourceMatch, updated));
+      const nextMatch = nextActivePlayer(updatePlayer(sourceMatch, updated), roundRngRef.current);
       matchRef.current = nextMatch;
       setMatch(nextMatch);
       setMessage(`${armedShooter.config.name} used ${weapon.name}`);
@@ -2620,10 +2683,18 @@ export default function App(): JSX.Element {
       } else {
         setMessage('Round draw');
       }
-      const postRound = applyRoundEnd({ ...nextMatch, phase: 'roundEnd' });
+      beginRound(nextMatch.roundIndex + 1);
+      const postRound = applyRoundEnd({ ...nextMatch, phase: 'roundEnd' }, roundRngRef.current);
       if (postRound.phase === 'matchEnd') {
         const winner = postRound.players.reduce((best, p) => (p.score > best.score ? p : best), postRound.players[0]);
         setWinnerName(winner.config.name);
+        if (networkMode === 'host') {
+          const live = lanSessionRef.current;
+          live?.client.endMatch(
+            live.roomId,
+            postRound.players.map((p) => ({ peerId: p.config.id, score: p.score })),
+          );
+        }
         matchRef.current = postRound;
         terrainRef.current = nextTerrain;
         setMatch(postRound);
@@ -2669,13 +2740,13 @@ export default function App(): JSX.Element {
     });
 
     if (runtime.projectiles.length === 0 && currentMatch.phase === 'projectile' && !hasAirbornePlayers && !runtime.funkySequence && !runtime.mirvSequence) {
-      nextMatch = nextActivePlayer({ ...nextMatch, phase: 'resolve' });
+      nextMatch = nextActivePlayer({ ...nextMatch, phase: 'resolve' }, roundRngRef.current);
     }
 
     nextTerrain = ensureFloorIntegrity(nextTerrain);
     matchRef.current = nextMatch;
     terrainRef.current = nextTerrain;
-  }, [makeTerrainForRound, networkMode, placePlayersOnTerrain, pushHostSnapshot, resetRuntime, viewportSize.height, viewportSize.width]);
+  }, [beginRound, makeTerrainForRound, networkMode, placePlayersOnTerrain, pushHostSnapshot, resetRuntime, viewportSize.height, viewportSize.width]);
 
   useEffect(() => {
     if (!match || !terrain || screen !== 'battle') {
@@ -2839,6 +2910,7 @@ export default function App(): JSX.Element {
   }, [advanceClientProjectilePrediction, applyHeldAimInput, fireWeapon, networkMode, pushHostSnapshot, screen, shieldMenuOpen, stepSimulation]);
 
   const startShopToBattle = useCallback(async (existingMatch: MatchState) => {
+    beginRound(existingMatch.roundIndex);
     const nextSize = networkMode === 'client'
       ? { width: existingMatch.width, height: existingMatch.height }
       : deriveBattlefieldSize(viewportSize.width, viewportSize.height);
@@ -2882,7 +2954,7 @@ export default function App(): JSX.Element {
       battleTerrainWarmupRemainingRef.current = BATTLE_TERRAIN_WARMUP_SNAPSHOTS;
       pushHostSnapshot(true, 'battle');
     }
-  }, [makeTerrainForRound, networkMode, placePlayersOnTerrain, pushHostSnapshot, resetRuntime, setShopDoneState, viewportSize.height, viewportSize.width]);
+  }, [beginRound, makeTerrainForRound, networkMode, placePlayersOnTerrain, pushHostSnapshot, resetRuntime, setShopDoneState, viewportSize.height, viewportSize.width]);
 
   const recreateBattleTerrain = useCallback(async () => {
     const liveMatch = matchRef.current;
@@ -2948,6 +3020,37 @@ export default function App(): JSX.Element {
     setMessage(`${active.config.name} activated ${shieldId}`);
   }, []);
 
+  const leaveLanRoom = useCallback((reason: string) => {
+    lanSessionRef.current?.client.disconnect();
+    lanSessionRef.current = null;
+    lanRoomRef.current = null;
+    matchMapRef.current = null;
+    setTurnTimer(null);
+    setSelfAfk(false);
+    setReplayState(null);
+    setReplayHost(false);
+    setNetworkMode('offline');
+    setMessage(reason);
+    setScreen('title');
+  }, []);
+
+  const controlReplay = useCallback((control: ReplayControl) => {
+    const session = lanSessionRef.current;
+    try {
+      session?.client.controlReplay(session.roomId, control);
+    } catch {
+      setMessage('Not connected');
+    }
+  }, []);
+
+  useEffect(() => {
+    if (!replayState || replayState.paused) {
+      return;
+    }
+    const id = window.setInterval(() => setReplayNow(Date.now()), 250);
+    return () => window.clearInterval(id);
+  }, [replayState]);
+
   const handleLanMatchStart = useCallback((session: LanMatchSession) => {
     const self = session.room.players.find((player) => player.peerId === session.selfPeerId);
     if (!self) {
@@ -2956,7 +3059,12 @@ export default function App(): JSX.Element {
       return;
     }
 
-    const isHost = self.isHost;
+    // In server-hosted rooms the server is match authority and every browser,
+    // including the room host's, runs as a client. Replay rooms are played
+    // back by the server the same way.
+    const isHost = self.isHost && !session.room.serverHosted && !session.room.replay;
+    setReplayState(replayView(session.room.replay));
+    setReplayHost(Boolean(session.room.replay) && self.isHost);
     lanSessionRef.current = {
       client: session.client,
       roomId: session.roomId,
@@ -2964,8 +3072,67 @@ export default function App(): JSX.Element {
       isHost,
     };
     setNetworkMode(isHost ? 'host' : 'client');
+    lanRoomRef.current = session.room;
 
     session.client.setHandlers({
+      onRoomState: (room) => {
+        lanRoomRef.current = room;
+        const liveSelf = room.players.find((player) => player.peerId === lanSessionRef.current?.selfPeerId);
+        setSelfAfk(Boolean(liveSelf?.afk));
+        setReplayState(replayView(room.replay));
+        setReplayHost(Boolean(room.replay && liveSelf?.isHost));
+      },
+      onMatchStart: (payload) => {
+        const liveSession = lanSessionRef.current;
+        const room = lanRoomRef.current;
+        if (!liveSession || !room) {
+          return;
+        }
+        lanMatchStartRef.current({
+          client: liveSession.client,
+          roomId: liveSession.roomId,
+          selfPeerId: liveSession.selfPeerId,
+          room,
+          seed: payload.seed,
+          map: payload.map,
+        });
+      },
+      onMatchEnd: (payload) => {
+        const liveSession = lanSessionRef.current;
+        if (!liveSession || payload.roomId !== liveSession.roomId) {
+          return;
+        }
+        const winner = payload.results.find((result) => result.peerId === payload.winnerPeerId);
+        setWinnerName(winner ? winner.name : 'Draw');
+        setScreen('matchEnd');
+      },
+      onRoomClosed: () => {
+        // Only spectators get here: the last player left mid-match.
+        leaveLanRoom('LAN room closed: every player left');
+      },
+      onKicked: (payload) => {
+        leaveLanRoom(payload.reason === 'banned' ? 'You were banned from the LAN room' : 'You were kicked from the LAN room');
+      },
+      onTurnTimer: (payload) => {
+        setTurnTimer(payload.remainingMs > 0 ? payload : null);
+      },
+      onTurnSkip: (payload) => {
+        // The server's turn clock ran out; only the host moves the match on.
+        const liveSession = lanSessionRef.current;
+        const liveMatch = matchRef.current;
+        if (!liveSession?.isHost || payload.roomId !== liveSession.roomId || screenRef.current !== 'battle' || !liveMatch) {
+          return;
+        }
+        if (liveMatch.phase !== 'aim' || liveMatch.activePlayerId !== payload.peerId) {
+          return;
+        }
+        const skipped = liveMatch.players.find((p) => p.config.id === payload.peerId);
+        const nextMatch = nextActivePlayer(liveMatch, roundRngRef.current);
+        matchRef.current = nextMatch;
+        setMatch(nextMatch);
+        setMessage(`${skipped?.config.name ?? 'Player'} ran out of time`);
+        pushHostSnapshot(false);
+      },
       onGameInput: (peerId, payload) => {
         if (!lanSessionRef.current?.isHost) {
           return;
@@ -3027,7 +3194,7 @@ export default function App(): JSX.Element {
           void startShopToBattle(liveMatch);
         }
       },
-      onGameSnapshot: (payload) => {
+      onGameSnapshot: (payload, binaryTerrain) => {
         const liveSession = lanSessionRef.current;
         if (!liveSession || liveSession.isHost || payload.roomId !== liveSession.roomId) {
           return;
@@ -3053,28 +3220,134 @@ export default function App(): JSX.Element {
         clientPredictionAccumulatorRef.current = 0;
         setMatch(nextMatch);
         setMessage(payload.message);
-        if (payload.terrain) {
-          const decoded = decodeTerrain(payload.terrain);
+        const decoded = binaryTerrain ?? (payload.terrain ? decodeTerrain(payload.terrain) : null);
+        if (decoded) {
           terrainRef.current = decoded;
           setTerrain(decoded);
         }
       },
+      onPeerDisconnected: (payload) => {
+        const name = lanRoomRef.current?.players.find((player) => player.peerId === payload.peerId)?.name ?? 'A player';
+        setMessage(`${name} lost connection; their seat is held for ${payload.graceSec}s`);
+      },
+      onPeerReconnected: (payload) => {
+        const name = lanRoomRef.current?.players.find((player) => player.peerId === payload.peerId)?.name ?? 'A player';
+        setMessage(`${name} reconnected`);
+      },
+      onReconnecting: (attempt) => {
+        setMessage(`Connection lost, reconnecting (attempt ${attempt})...`);
+      },
+      onSessionResumed: (payload) => {
+        lanRoomRef.current = payload.room;
+        if (payload.room.status !== 'in-game' && screenRef.current !== 'matchEnd') {
+          leaveLanRoom('The LAN match ended while you were away');
+          return;
+        }
+        const liveSession = lanSessionRef.current;
+        const liveSelf = payload.room.players.find((player) => player.peerId === liveSession?.selfPeerId);
+        if (liveSession?.isHost && !liveSelf?.isHost) {
+          // The host role moved on while this browser was away.
+          lanSessionRef.current = { ...liveSession, isHost: false };
+          setNetworkMode('client');
+          setMessage('Reconnected; another player is now the host');
+          return;
+        }
+        setMessage('Reconnected');
+        if (liveSession?.isHost) {
+          pushHostSnapshot(true, screenRef.current === 'shop' ? 'shop' : 'battle');
+        }
+      },
+      onSessionLost: () => {
+        leaveLanRoom('Lost connection to the LAN room');
+      },
+      onHostMigrated: (payload) => {
+        // Browser-hosted matches move on with whoever the server made host;
+        // server-hosted and replay rooms have no browser authority to hand.
+        const liveSession = lanSessionRef.current;
+        const room = lanRoomRef.current;
+        if (!liveSession || payload.roomId !== liveSession.roomId || room?.serverHosted || room?.replay) {
+          return;
+        }
+        const promoted = payload.hostPeerId === liveSession.selfPeerId;
+        if (promoted === liveSession.isHost) {
+          return;
+        }
+        lanSessionRef.current = { ...liveSession, isHost: promoted };
+        setNetworkMode(promoted ? 'host' : 'client');
+        if (!promoted) {
+          setMessage('Another player is now the host');
+          return;
+        }
+        const snapshot = payload.snapshot;
+        if (snapshot?.match) {
+          const nextMatch = snapshot.match as MatchState;
+          matchRef.current = nextMatch;
+          runtimeRef.current = snapshot.runtime as RuntimeState;
+          setMatch(nextMatch);
+          if (snapshot.terrain) {
+            const decoded = decodeTerrain(snapshot.terrain);
+            terrainRef.current = decoded;
+            setTerrain(decoded);
+          }
+          if (snapshot.shopDoneByPlayerId) {
+            setShopDoneState(snapshot.shopDoneByPlayerId);
+          }
+          if (typeof snapshot.shopIndex === 'number') {
+            setShopIndex(snapshot.shopIndex);
+          }
+          if (snapshot.view) {
+            setScreen(snapshot.view);
+          }
+          networkTickRef.current = snapshot.tick + 1;
+        }
+        const liveMatch = matchRef.current;
+        if (!liveMatch || !terrainRef.current) {
+          setMessage('You are now the host, but no match state reached you');
+          return;
+        }
+        // Pick up where the last host's snapshot left off: the same round's
+        // random stream, and a full snapshot with terrain to everyone.
+        beginRound(liveMatch.roundIndex);
+        predictedRuntimeRef.current = null;
+        clientPredictionAccumulatorRef.current = 0;
+        simulationAccumulatorRef.current = 0;
+        uiSyncAccumulatorRef.current = 0;
+        lastBroadcastTerrainRevisionRef.current = -1;
+        lastBroadcastTerrainRef.current = null;
+        lastBroadcastAtRef.current = 0;
+        lastBroadcastViewRef.current = '';
+        lastBroadcastPhaseRef.current = '';
+        lastBroadcastProjectileCountRef.current = 0;
+        setMessage(payload.reason === 'vote' ? 'You were voted host' : 'The host dropped out; you are now the host');
+        pushHostSnapshot(true, snapshot?.view ?? (screenRef.current === 'shop' ? 'shop' : 'battle'));
+      },
       onError: (text) => {
         setMessage(text);
       },
     });
 
+    // Everyone keeps the seed and map so whoever ends up host can play the
+    // next rounds.
+    matchSeedRef.current = parseSeed(session.seed ?? '') ?? createMatchSeed();
+    matchMapRef.current = session.map
+      ? { id: session.map.id, parsed: parsedMtnFromTerrain(decodeTerrain(session.map.terrain)) }
+      : null;
+
     if (isHost) {
-      const lanPlayers: PlayerConfig[] = session.room.players.map((player, idx) => ({
+      const lanPlayers: PlayerConfig[] = session.room.players.filter((player) => !player.spectator).map((player, idx) => ({
         id: player.peerId,
         name: player.name,
-        kind: 'human',
-        aiLevel: 'normal',
-        colorIndex: idx % 8,
+        kind: player.bot ? 'ai' : 'human',
+        aiLevel: player.aiLevel ?? 'normal',
+        colorIndex: player.colorIndex !== undefined && player.colorIndex >= 0 ? player.colorIndex : idx % 8,
         enabled: true,
       }));
       const lanViewport = deriveBattlefieldSize(viewportSize.width, viewportSize.height);
-      const seeded = initMatch(settings, lanPlayers, lanViewport.width, lanViewport.height);
+      // The room's settings win over this browser's, so everyone plays the
+      // game they saw in the lobby.
+      const roomSettings = session.room.settings ? normalizeSettings(session.room.settings) : settings;
+      beginRound(1);
+      const seeded = initMatch(roomSettings, lanPlayers, lanViewport.width, lanViewport.height, roundRngRef.current);
       const nextMatch = {
         ...seeded.match,
         activePlayerId: lanPlayers[0].id,
@@ -3087,7 +3360,8 @@ export default function App(): JSX.Element {
       resetRuntime();
       const initialDone: Record<string, boolean> = {};
       for (const p of lanPlayers) {
-        initialDone[p.id] = false;
+        // Bots have no one to press Done for them.
+        initialDone[p.id] = p.kind === 'ai';
       }
       setShopDoneState(initialDone);
       simulationAccumulatorRef.current = 0;
@@ -3111,7 +3385,9 @@ export default function App(): JSX.Element {
     setTerrain(null);
     setMessage('Waiting for host state snapshot...');
     setScreen('battle');
-  }, [allPlayersShopDone, markShopDone, pushHostSnapshot, resetRuntime, setShopDoneState, settings, startShopToBattle, viewportSize.height, viewportSize.width]);
+  }, [allPlayersShopDone, beginRound, leaveLanRoom, markShopDone, pushHostSnapshot, resetRuntime, setShopDoneState, settings, startShopToBattle, viewportSize.height, viewportSize.width]);
+
+  lanMatchStartRef.current = handleLanMatchStart;
 
   const clearLanSession = useCallback(() => {
     const live = lanSessionRef.current;
@@ -3119,6 +3395,7 @@ export default function App(): JSX.Element {
       live.client.disconnect();
     }
     lanSessionRef.current = null;
+    lanRoomRef.current = null;
     remoteInputQueueRef.current = [];
     lastBroadcastTerrainRevisionRef.current = -1;
     lastBroadcastTerrainRef.current = null;
@@ -3129,8 +3406,17 @@ export default function App(): JSX.Element {
     setNetworkMode('offline');
   }, [setShopDoneState]);
 
+  // The room host can call a rematch, even when the server runs the match.
+  const lanRoomHost = Boolean(
+    lanRoomRef.current?.players.find((player) => player.peerId === lanSessionRef.current?.selfPeerId)?.isHost,
+  );
   const activeShopPlayer = match?.players[shopIndex] ?? match?.players[0] ?? null;
   const activeBattlePlayer = match?.players.find((p) => p.config.id === match.activePlayerId);
+  // The server's turn clock only shows while that turn is still being aimed.
+  const turnSecondsLeft = turnTimer && match?.phase === 'aim' && match.activePlayerId === turnTimer.peerId
+    ? Math.ceil(turnTimer.remainingMs / 1000)
+    : null;
+  const battleMessage = turnSecondsLeft !== null ? `${message || activeBattlePlayer?.config.name || ''} - ${turnSecondsLeft}s left` : message;
   const localLanPlayerId = lanSessionRef.current?.selfPeerId ?? '';
   const localLanShopPlayer = match?.players.find((p) => p.config.id === localLanPlayerId) ?? null;
   const localLanShopDone = localLanPlayerId ? Boolean(shopDoneByPlayerId[localLanPlayerId]) : false;
@@ -3169,6 +3455,8 @@ export default function App(): JSX.Element {
       {screen === 'lan' && (
         <LanScreen
           initialMode={lanEntryMode}
+          inviteCode={inviteCodeRef.current}
+          settings={settings}
           onBack={() => setScreen('title')}
           onMatchStart={handleLanMatchStart}
         />
@@ -3197,7 +3485,10 @@ export default function App(): JSX.Element {
           onNext={async () => {
             const enabled = playerConfigs.filter((p) => p.enabled);
             const localViewport = deriveBattlefieldSize(viewportSize.width, viewportSize.height);
-            const { match: seededMatch } = initMatch(settings, enabled, localViewport.width, localViewport.height);
+            matchSeedRef.current = createMatchSeed();
+            matchMapRef.current = null;
+            beginRound(1);
+            const { match: seededMatch } = initMatch(settings, enabled, localViewport.width, localViewport.height, roundRngRef.current);
             const generated = await makeTerrainForRound(
               seededMatch.width,
               seededMatch.height,
@@ -3373,7 +3664,7 @@ export default function App(): JSX.Element {
         <BattleScreen
           match={match}
           terrain={terrain}
-          message={message}
+          message={battleMessage}
           recreateDisabled={networkMode === 'client'}
           onRecreateTerrain={() => {
             void recreateBattleTerrain();
@@ -3387,13 +3678,49 @@ export default function App(): JSX.Element {
             match: matchRef.current,
             terrain: terrainRef.current,
             runtime: networkMode === 'client' && predictedRuntimeRef.current ? predictedRuntimeRef.current : runtimeRef.current,
-            message,
+            message: battleMessage,
             localTurnNoticePlayerId: networkMode === 'offline' ? null : (lanSessionRef.current?.selfPeerId ?? null),
           })}
           onInputFrame={onBattleInputFrame}
         />
       )}
 
+      {screen === 'battle' && selfAfk && (
+        <div className="afk-banner">
+          <span>Your turns are being skipped after too many timeouts.</span>
+          <button onClick={() => {
+            const session = lanSessionRef.current;
+            session?.client.setReady(session.roomId, true);
+          }}
+          >
+            I'm Back
+          </button>
+        </div>
+      )}
+
+      {(screen === 'battle' || screen === 'shop') && replayState && (
+        <div className="replay-bar">
+          <span>
+            Replay {formatReplayTime(replayPosition(replayState, replayNow))} / {formatReplayTime(replayState.durationMs)}
+            {replayState.ended ? ' (ended)' : replayState.paused ? ' (paused)' : ''}
+          </span>
+          {replayHost && (
+            <>
+              <button onClick={() => controlReplay({ action: replayState.paused ? 'resume' : 'pause' })}>
+                {replayState.paused ? 'Play' : 'Pause'}
+              </button>
+              <button onClick={() => controlReplay({ action: 'seek', atMs: replayPosition(replayState, Date.now()) - 10000 })}>-10s</button>
+              <button onClick={() => controlReplay({ action: 'seek', atMs: replayPosition(replayState, Date.now()) + 10000 })}>+10s</button>
+              <select value={replayState.speed} onChange={(e) => controlReplay({ action: 'speed', speed: Number(e.target.value) })}>
+                {[0.5, 1, 2, 4].map((speed) => (
+                  <option key={speed} value={speed}>{speed}x</option>
+                ))}
+              </select>
+            </>
+          )}
+        </div>
+      )}
+
       {screen === 'battle' && (!match || !terrain) && (
         <div className="screen panel end-screen">
           <h2>Connecting To Host Match</h2>
@@ -3424,6 +3751,15 @@ export default function App(): JSX.Element {
               ))}
           </ul>
           <div className="row">
+            {lanRoomHost && (
+              <button onClick={() => {
+                const live = lanSessionRef.current;
+                live?.client.rematch(live.roomId);
+              }}
+              >
+                Rematch
+              </button>
+            )}
             <button onClick={() => {
               clearLanSession();
               setScreen('players');
@@ -12,7 +12,7 @@ import rock005Url from '../../assets/mtn/ROCK005.MTN?url';
 import rock006Url from '../../assets/mtn/ROCK006.MTN?url';
 import snow001Url from '../../assets/mtn/SNOW001.MTN?url';
 
-interface ParsedMtn {
+export interface ParsedMtn {
   width: number;
   height: number;
   palette: Array<[number, number, number]>;
@@ -144,6 +144,22 @@ export function terrainFromParsedMtn(parsed: ParsedMtn, width: number, height: n
   };
 }
 
+// parsedMtnFromTerrain turns a map sent as terrain at its own size back into
+// the columns and pixels terrainFromParsedMtn cuts battles from.
+export function parsedMtnFromTerrain(terrain: TerrainState): ParsedMtn {
+  const pixels = Array.from({ length: terrain.height }, (_, y) =>
+    Array.from({ length: terrain.width }, (_, x) => terrain.colorIndices?.[y * terrain.width + x] ?? 0),
+  );
+  const columns = terrain.heights.map((top, x) => pixels.slice(top).map((row) => row[x]));
+  return {
+    width: terrain.width,
+    height: terrain.height,
+    palette: terrain.colorPalette ?? [],
+    columns,
+    pixels,
+  };
+}
+
 export async function preloadMtnTerrains(): Promise<LoadedMtn[]> {
   if (loadedMtns) {
     return loadedMtns;
@@ -18,8 +18,8 @@ function fractalHeight(x: number, width: number, base: number, preset: TerrainPr
   return base + hills + crinkles + noise;
 }
 
-export function generateTerrain(width: number, height: number, preset: TerrainPreset): TerrainState {
-  const selected = preset === 'random' ? (['rolling', 'canyon', 'islands'] as TerrainPreset[])[Math.floor(Math.random() * 3)] : preset;
+export function generateTerrain(width: number, height: number, preset: TerrainPreset, rng: () => number = Math.random): TerrainState {
+  const selected = preset === 'random' ? (['rolling', 'canyon', 'islands'] as TerrainPreset[])[Math.floor(rng() * 3)] : preset;
   const rawHeights = new Array<number>(width).fill(0);
 
   for (let x = 0; x < width; x += 1) {
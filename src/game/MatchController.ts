@@ -2,12 +2,12 @@ import type { GameSettings, MatchState, PlayerConfig, PlayerState, TerrainState
 import { STARTER_WEAPON_ID } from './WeaponCatalog';
 import { generateTerrain } from '../engine/terrain/TerrainGenerator';
 
-function randomWind(settings: GameSettings): number {
+function randomWind(settings: GameSettings, rng: () => number): number {
   if (settings.windMode === 'off') {
     return 0;
   }
   const max = 10;
-  return (Math.random() * 2 - 1) * max;
+  return (rng() * 2 - 1) * max;
 }
 
 function createPlayerState(config: PlayerConfig, settings: GameSettings): PlayerState {
@@ -32,9 +32,15 @@ function createPlayerState(config: PlayerConfig, settings: GameSettings): Player
   };
 }
 
-export function initMatch(settings: GameSettings, playerConfigs: PlayerConfig[], width: number, height: number): { match: MatchState; terrain: TerrainState } {
+export function initMatch(
+  settings: GameSettings,
+  playerConfigs: PlayerConfig[],
+  width: number,
+  height: number,
+  rng: () => number = Math.random,
+): { match: MatchState; terrain: TerrainState } {
   const players = playerConfigs.filter((p) => p.enabled).map((config) => createPlayerState(config, settings));
-  const terrain = generateTerrain(width, height, settings.terrainPreset);
+  const terrain = generateTerrain(width, height, settings.terrainPreset, rng);
   const spacing = width / (players.length + 1);
 
   const seededPlayers = players.map((p, i) => {
@@ -60,7 +66,7 @@ export function initMatch(settings: GameSettings, playerConfigs: PlayerConfig[],
     settings,
     players: seededPlayers,
     roundIndex: 1,
-    wind: randomWind(settings),
+    wind: randomWind(settings, rng),
     activePlayerId: seededPlayers[0]?.config.id ?? '',
     phase: 'aim',
     width,
@@ -81,7 +87,7 @@ export function updatePlayer(match: MatchState, player: PlayerState): MatchState
   };
 }
 
-export function nextActivePlayer(match: MatchState): MatchState {
+export function nextActivePlayer(match: MatchState, rng: () => number = Math.random): MatchState {
   const alive = match.players.filter((p) => p.alive);
   if (alive.length <= 1) {
     return { ...match, phase: alive.length === 1 ? 'roundEnd' : 'matchEnd' };
@@ -93,11 +99,11 @@ export function nextActivePlayer(match: MatchState): MatchState {
     ...match,
     activePlayerId: next.config.id,
     phase: 'aim',
-    wind: match.settings.windMode === 'changing' ? randomWind(match.settings) : match.wind,
+    wind: match.settings.windMode === 'changing' ? randomWind(match.settings, rng) : match.wind,
   };
 }
 
-export function applyRoundEnd(match: MatchState): MatchState {
+export function applyRoundEnd(match: MatchState, rng: () => number = Math.random): MatchState {
   const winner = match.players.find((p) => p.alive);
   const players: PlayerState[] = match.players.map((p) => ({
     ...p,
@@ -128,6 +134,6 @@ export function applyRoundEnd(match: MatchState): MatchState {
     roundIndex: match.roundIndex + 1,
     phase: 'aim',
     activePlayerId: updated[0].config.id,
-    wind: randomWind(match.settings),
+    wind: randomWind(match.settings, rng),
   };
 }
@@ -0,0 +1,59 @@
+import { describe, expect, it } from 'vitest';
+import { DEFAULT_SETTINGS, type PlayerConfig } from '../types/game';
+import { initMatch } from './MatchController';
+import { Prng, createRoundRandom, formatSeed, parseSeed, roundSeed } from './Prng';
+
+// Shared with server/signal-go/engine/prng_test.go.
+const VECTORS: Array<{ seed: bigint; want: bigint[] }> = [
+  { seed: 0n, want: [0xe220a8397b1dcdafn, 0x6e789e6aa1b965f4n, 0x06c45d188009454fn, 0xf88bb8a8724c81ecn] },
+  { seed: 1n, want: [0x910a2dec89025cc1n, 0xbeeb8da1658eec67n, 0xf893a2eefb32555en, 0x71c18690ee42c90bn] },
+  { seed: 0x0123456789abcdefn, want: [0x157a3807a48faa9dn, 0xd573529b34a1d093n, 0x2f90b72e996dccben, 0xa2d419334c4667ecn] },
+];
+
+// Shared with roundVectors in server/signal-go/engine/prng_test.go: the first
+// rounds of match seed 0x0123456789abcdef with three tanks on a 320x180 field.
+const ROUNDS: Array<{ wind: number; heightSum: number; spawns: number[][] }> = [
+  { wind: -4.253770923687499, heightSum: 34756, spawns: [[80, 127], [160, 88], [240, 57]] },
+  { wind: 6.443608111782644, heightSum: 30189, spawns: [[80, 121], [160, 46], [240, 50]] },
+  { wind: 4.430987895049903, heightSum: 35331, spawns: [[80, 128], [160, 84], [240, 109]] },
+];
+
+describe('Prng', () => {
+  it('matches the Go reference vectors', () => {
+    for (const { seed, want } of VECTORS) {
+      const prng = new Prng(seed);
+      expect(want.map(() => prng.nextUint64())).toEqual(want);
+      expect(want.map((_, i) => roundSeed(seed, i + 1))).toEqual(want);
+    }
+  });
+
+  it('draws floats and ints like the Go reference', () => {
+    const floats = new Prng(42n);
+    expect([floats.nextFloat(), floats.nextFloat(), floats.nextFloat()]).toEqual([0.7415648787718233, 0.1599103928769201, 0.27860113025513866]);
+    const ints = new Prng(42n);
+    expect(Array.from({ length: 6 }, () => ints.nextInt(10))).toEqual([7, 1, 2, 3, 0, 8]);
+  });
+
+  it('replays a round from its seed', () => {
+    const a = createRoundRandom(99n, 3);
+    const b = createRoundRandom(99n, 3);
+    expect([a(), a(), a()]).toEqual([b(), b(), b()]);
+  });
+
+  it('builds the same battlefields as the Go engine', () => {
+    const settings = { ...DEFAULT_SETTINGS, windMode: 'constant' as const };
+    const configs: PlayerConfig[] = ['a', 'b', 'c'].map((id, colorIndex) => ({ id, name: id, kind: 'human', aiLevel: 'normal', colorIndex, enabled: true }));
+    ROUNDS.forEach((want, i) => {
+      const { match, terrain } = initMatch(settings, configs, 320, 180, createRoundRandom(0x0123456789abcdefn, i + 1));
+      expect(match.wind).toBe(want.wind);
+      expect(terrain.heights.reduce((sum, h) => sum + h, 0)).toBe(want.heightSum);
+      expect(match.players.map((p) => [p.x, p.y])).toEqual(want.spawns);
+    });
+  });
+
+  it('round-trips seeds through hex', () => {
+    expect(formatSeed(0x0123456789abcdefn)).toBe('0123456789abcdef');
+    expect(parseSeed('0123456789abcdef')).toBe(0x0123456789abcdefn);
+    expect(parseSeed('not a seed')).toBeNull();
+  });
+});
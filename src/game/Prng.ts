@@ -0,0 +1,66 @@
+// Match random numbers: SplitMix64, bit for bit the same as
+// server/signal-go/engine/prng.go. A match seed from match.start rebuilds the
+// same terrain and wind on every client and in replays.
+
+const MASK_64 = (1n << 64n) - 1n;
+const GAMMA = 0x9e3779b97f4a7c15n;
+const MUL_1 = 0xbf58476d1ce4e5b9n;
+const MUL_2 = 0x94d049bb133111ebn;
+const FLOAT_SCALE = 2 ** 53;
+
+function splitMix(input: bigint): bigint {
+  let z = input & MASK_64;
+  z = ((z ^ (z >> 30n)) * MUL_1) & MASK_64;
+  z = ((z ^ (z >> 27n)) * MUL_2) & MASK_64;
+  return z ^ (z >> 31n);
+}
+
+export class Prng {
+  private state: bigint;
+
+  constructor(seed: bigint) {
+    this.state = seed & MASK_64;
+  }
+
+  nextUint64(): bigint {
+    this.state = (this.state + GAMMA) & MASK_64;
+    return splitMix(this.state);
+  }
+
+  // A number in [0, 1) from the top 53 bits, like Go's PRNG.Float64.
+  nextFloat(): number {
+    return Number(this.nextUint64() >> 11n) / FLOAT_SCALE;
+  }
+
+  // A number in [0, n), like Go's PRNG.Intn.
+  nextInt(n: number): number {
+    return Math.floor(this.nextFloat() * n);
+  }
+}
+
+// The seed of round `round` (from 1): the round-th output of new Prng(matchSeed).
+export function roundSeed(matchSeed: bigint, round: number): bigint {
+  return splitMix(matchSeed + BigInt(round) * GAMMA);
+}
+
+// A Math.random stand-in drawing from the given round of a match.
+export function createRoundRandom(matchSeed: bigint, round: number): () => number {
+  const prng = new Prng(roundSeed(matchSeed, round));
+  return () => prng.nextFloat();
+}
+
+// Seeds travel as 16 hex digits since JSON numbers can't hold 64 bits.
+export function parseSeed(hex: string): bigint | null {
+  return /^[0-9a-f]{1,16}$/i.test(hex) ? BigInt(`0x${hex}`) : null;
+}
+
+export function formatSeed(seed: bigint): string {
+  return seed.toString(16).padStart(16, '0');
+}
+
+// A fresh seed for matches nobody handed one to, such as offline games.
+export function createMatchSeed(): bigint {
+  const words = new Uint32Array(2);
+  crypto.getRandomValues(words);
+  return (BigInt(words[0]) << 32n) | BigInt(words[1]);
+}
@@ -1,12 +1,33 @@
+import type { AILevel, GameSettings, PlayerKind } from '../types/game';
+
 export type RoomStatus = 'lobby' | 'in-game';
 
 export interface RoomSummary {
   roomId: string;
+  // Short join code; room.join accepts it in place of roomId.
+  code?: string;
   roomName: string;
   hostName: string;
   players: number;
   maxPlayers: number;
+  // Spectators watching; they don't take one of the maxPlayers seats.
+  spectators?: number;
   status: RoomStatus;
+  serverHosted?: boolean;
+  // Replay rooms play a recorded match back; everyone in them watches.
+  replay?: boolean;
+  passwordProtected?: boolean;
+  settings?: RoomSettingsSummary;
+}
+
+// RoomSettingsSummary is the part of a room's settings shown in the room list.
+export interface RoomSettingsSummary {
+  terrainPreset: GameSettings['terrainPreset'];
+  roundsToWin: number;
+  windMode: GameSettings['windMode'];
+  freeFireMode: boolean;
+  // Absent when turns are untimed.
+  turnTimeLimitSec?: number;
 }
 
 export interface LobbyPlayer {
@@ -14,14 +35,76 @@ export interface LobbyPlayer {
   name: string;
   ready: boolean;
   isHost: boolean;
+  connected: boolean;
+  // Spectators watch the match but can't play or shop.
+  spectator?: boolean;
+  // Bots are AI seats the host added; they have no connection.
+  bot?: boolean;
+  aiLevel?: AILevel;
+  // Set after too many turns in a row ran out; the server skips their turns
+  // until they ready up or act again.
+  afk?: boolean;
+  // Index into TANK_COLORS, unique among seated players; -1 for spectators.
+  colorIndex?: number;
+  // Heartbeat round trip to the server, once measured.
+  latencyMs?: number;
 }
 
 export interface RoomState {
   roomId: string;
+  code?: string;
   roomName: string;
   status: RoomStatus;
   maxPlayers: number;
   players: LobbyPlayer[];
+  serverHosted?: boolean;
+  // Unlisted rooms are only reachable by id.
+  unlisted?: boolean;
+  passwordProtected?: boolean;
+  // Locked rooms turn away new joins.
+  locked?: boolean;
+  // Recording rooms save each match as a replay, listed at /replays.
+  record?: boolean;
+  // mapId is the map the host picked from the server's /api/maps library;
+  // empty to build terrain from the settings' preset.
+  mapId?: string;
+  // upload is a map the host sent the room over HTTP; mapId names it while
+  // it is picked.
+  upload?: MapInfo;
+  // Settings the next match is played with; only the host changes them.
+  settings?: GameSettings;
+  // Set in rooms playing a replay back; such rooms are read-only.
+  replay?: ReplayPlaybackState;
+}
+
+// ReplayPlaybackState is where a replay room's playback stands. positionMs
+// was current when the room.state was sent; it moves on at speed while not
+// paused.
+export interface ReplayPlaybackState {
+  replayId: string;
+  positionMs: number;
+  durationMs: number;
+  paused: boolean;
+  speed: number;
+  ended: boolean;
+}
+
+// ReplayControl is a replay.control request from a replay room's host.
+// Speeds run from 0.25 to 8.
+export type ReplayControl =
+  | { action: 'pause' }
+  | { action: 'resume' }
+  | { action: 'seek'; atMs: number }
+  | { action: 'speed'; speed: number };
+
+// ReplayInfo is one entry of the server's /replays listing.
+export interface ReplayInfo {
+  id: string;
+  roomName: string;
+  seed: string;
+  players: Array<{ peerId: string; name: string }>;
+  startedAt: number;
+  size: number;
 }
 
 export interface SignalEnvelope<T = unknown> {
@@ -31,7 +114,18 @@ export interface SignalEnvelope<T = unknown> {
 }
 
 export interface SignalErrorPayload {
-  code: 'room_full' | 'room_not_found' | 'forbidden' | 'bad_request';
+  code:
+    | 'room_full'
+    | 'room_not_found'
+    | 'forbidden'
+    | 'bad_request'
+    | 'session_expired'
+    | 'insufficient_funds'
+    | 'insufficient_stock'
+    | 'invalid_item'
+    | 'shop_closed'
+    | 'replay_not_found'
+    | 'map_not_found';
   message: string;
 }
 
@@ -42,11 +136,77 @@ export interface SignalRoomListResponse {
 export interface SignalRoomCreated {
   selfPeerId: string;
   room: RoomState;
+  resumeToken: string;
 }
 
 export interface SignalRoomJoined {
   selfPeerId: string;
   room: RoomState;
+  resumeToken: string;
+}
+
+export interface SignalSessionResumed {
+  selfPeerId: string;
+  room: RoomState;
+  resumeToken: string;
+}
+
+export interface PeerDisconnectedPayload {
+  peerId: string;
+  roomId: string;
+  graceSec: number;
+}
+
+export interface HostMigratedPayload {
+  roomId: string;
+  hostPeerId: string;
+  previousHostId: string;
+  // host_disconnected moves the role on while the old host may still resume
+  // its seat, as a guest.
+  reason: 'host_left' | 'host_disconnected' | 'vote';
+  snapshot?: GameSnapshotPayload;
+}
+
+export interface HostVotePayload {
+  roomId: string;
+  voterPeerId: string;
+  candidatePeerId: string;
+  votes: number;
+  needed: number;
+}
+
+export interface PeerReconnectedPayload {
+  peerId: string;
+  roomId: string;
+}
+
+export interface SignalRoomAuth {
+  roomId: string;
+}
+
+// Sent to spectators when the last player leaves.
+export interface RoomClosedPayload {
+  roomId: string;
+  reason: string;
+}
+
+export interface RoomKickedPayload {
+  roomId: string;
+  reason: 'kicked' | 'banned';
+}
+
+// turn.timer ticks about once a second while a timed turn runs.
+export interface TurnTimerPayload {
+  roomId: string;
+  peerId: string;
+  remainingMs: number;
+  limitMs: number;
+}
+
+// turn.skip asks the browser host to pass a turn whose time ran out.
+export interface TurnSkipPayload {
+  roomId: string;
+  peerId: string;
 }
 
 export interface SignalRoomNotFound {
@@ -55,8 +215,10 @@ export interface SignalRoomNotFound {
 
 export interface SignalRoomFull {
   roomId: string;
-  currentPlayers: number;
-  maxPlayers: number;
+  currentPlayers?: number;
+  maxPlayers?: number;
+  currentSpectators?: number;
+  maxSpectators?: number;
 }
 
 export interface ChatMessage {
@@ -85,6 +247,56 @@ export interface TerrainPayload {
 export interface MatchStartPayload {
   roomId: string;
   startedAt: number;
+  // Set when the host restarted with the roster of the last match.
+  rematch?: boolean;
+  // Everyone playing, bots included, in seat order.
+  roster?: RosterEntry[];
+  // The match seed as 16 hex digits; terrain and wind derive from it.
+  seed?: string;
+  // The room's picked map at its own size; every battle is cut from it.
+  map?: MatchMap;
+}
+
+export interface MatchMap {
+  id: string;
+  terrain: TerrainPayload;
+}
+
+// MapInfo is one entry of the server's /api/maps listing.
+export interface MapInfo {
+  id: string;
+  // builtin maps ship with the server; custom ones come from its maps
+  // directory and upload is one a host sent their room.
+  source: 'builtin' | 'custom' | 'upload';
+  width: number;
+  height: number;
+  // Path of a PNG preview on the server, sky transparent.
+  thumbnailUrl: string;
+  // Path of an uploaded map's file, an .MTN or heightmap PNG.
+  fileUrl?: string;
+}
+
+export interface RosterEntry {
+  peerId: string;
+  name: string;
+  kind: PlayerKind;
+  aiLevel?: AILevel;
+}
+
+export interface MatchResult {
+  peerId: string;
+  name: string;
+  score: number;
+}
+
+export interface MatchEndPayload {
+  roomId: string;
+  results: MatchResult[];
+  // Absent on a tie for first place.
+  winnerPeerId?: string;
+  endedAt: number;
+  // The seed the match was played with, to check the results against.
+  seed?: string;
 }
 
 export interface GameInputPayload {
@@ -0,0 +1,135 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest';
+import { SignalClient, type SignalClientHandlers } from './signalingClient';
+import type { SignalEnvelope } from './protocol';
+
+// FakeSocket stands in for the browser WebSocket; tests open, drop and
+// answer it by hand.
+class FakeSocket {
+  static readonly OPEN = 1;
+  static instances: FakeSocket[] = [];
+
+  readonly url: string;
+  readyState = 0;
+  binaryType = '';
+  sent: SignalEnvelope[] = [];
+  onopen: (() => void) | null = null;
+  onclose: (() => void) | null = null;
+  onerror: (() => void) | null = null;
+  onmessage: ((event: { data: unknown }) => void) | null = null;
+
+  constructor(url: string) {
+    this.url = url;
+    FakeSocket.instances.push(this);
+  }
+
+  send(data: string): void {
+    this.sent.push(JSON.parse(data) as SignalEnvelope);
+  }
+
+  close(): void {
+    this.readyState = 3;
+  }
+
+  open(): void {
+    this.readyState = FakeSocket.OPEN;
+    this.onopen?.();
+  }
+
+  drop(): void {
+    this.readyState = 3;
+    this.onclose?.();
+  }
+
+  receive(type: string, payload: unknown, requestId?: string): void {
+    this.onmessage?.({ data: JSON.stringify({ type, payload, requestId }) });
+  }
+
+  lastSent(type: string): SignalEnvelope | undefined {
+    const matching = this.sent.filter((message) => message.type === type);
+    return matching[matching.length - 1];
+  }
+}
+
+async function joinedClient(handlers: SignalClientHandlers): Promise<{ client: SignalClient; socket: FakeSocket }> {
+  const client = new SignalClient(handlers);
+  const connected = client.connect('lan:8787');
+  const socket = FakeSocket.instances[0];
+  socket.open();
+  await connected;
+  const joined = client.joinRoom('room-1', 'Guest');
+  const request = socket.lastSent('room.join')!;
+  socket.receive('room.joined', { selfPeerId: 'peer-2', room: {}, resumeToken: 'token-1' }, request.requestId);
+  await joined;
+  return { client, socket };
+}
+
+describe('SignalClient', () => {
+  beforeEach(() => {
+    FakeSocket.instances = [];
+    vi.useFakeTimers();
+    vi.stubGlobal('window', globalThis);
+    vi.stubGlobal('WebSocket', FakeSocket);
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+    vi.unstubAllGlobals();
+  });
+
+  it('reconnects and resumes the session when the socket drops', async () => {
+    const onReconnecting = vi.fn();
+    const onSessionResumed = vi.fn();
+    const { socket } = await joinedClient({ onReconnecting, onSessionResumed });
+
+    socket.drop();
+    expect(onReconnecting).toHaveBeenCalledWith(1);
+    await vi.advanceTimersByTimeAsync(1000);
+    const retry = FakeSocket.instances[1];
+    expect(retry.url).toBe('ws://lan:8787/ws');
+    retry.open();
+    await vi.advanceTimersByTimeAsync(0);
+
+    const resume = retry.lastSent('session.resume')!;
+    expect(resume.payload).toEqual({ resumeToken: 'token-1' });
+    const resumed = { selfPeerId: 'peer-2', room: {}, resumeToken: 'token-2' };
+    retry.receive('session.resumed', resumed, resume.requestId);
+    await vi.advanceTimersByTimeAsync(0);
+    expect(onSessionResumed).toHaveBeenCalledWith(resumed);
+
+    // The next drop resumes with the token the server just handed out.
+    retry.drop();
+    expect(onReconnecting).toHaveBeenLastCalledWith(1);
+    await vi.advanceTimersByTimeAsync(1000);
+    FakeSocket.instances[2].open();
+    await vi.advanceTimersByTimeAsync(0);
+    expect(FakeSocket.instances[2].lastSent('session.resume')!.payload).toEqual({ resumeToken: 'token-2' });
+  });
+
+  it('gives the session up when the server turns the token down', async () => {
+    const onSessionLost = vi.fn();
+    const { socket } = await joinedClient({ onSessionLost });
+
+    socket.drop();
+    await vi.advanceTimersByTimeAsync(1000);
+    const retry = FakeSocket.instances[1];
+    retry.open();
+    await vi.advanceTimersByTimeAsync(0);
+    const resume = retry.lastSent('session.resume')!;
+    retry.receive('error', { code: 'session_expired', message: 'Session can no longer be resumed' }, resume.requestId);
+    await vi.advanceTimersByTimeAsync(0);
+
+    expect(onSessionLost).toHaveBeenCalledOnce();
+    expect(FakeSocket.instances).toHaveLength(2);
+  });
+
+  it('does not reconnect after leaving the room', async () => {
+    const onReconnecting = vi.fn();
+    const { client, socket } = await joinedClient({ onReconnecting });
+
+    client.leaveRoom('room-1');
+    socket.drop();
+    await vi.advanceTimersByTimeAsync(10000);
+    expect(onReconnecting).not.toHaveBeenCalled();
+    expect(FakeSocket.instances).toHaveLength(1);
+  });
+});
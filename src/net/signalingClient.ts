@@ -2,7 +2,15 @@ import type {
   ChatMessage,
   GameInputPayload,
   GameSnapshotPayload,
+  HostMigratedPayload,
+  HostVotePayload,
+  MatchEndPayload,
   MatchStartPayload,
+  PeerDisconnectedPayload,
+  PeerReconnectedPayload,
+  ReplayControl,
+  RoomClosedPayload,
+  RoomKickedPayload,
   RoomState,
   RoomSummary,
   SignalEnvelope,
@@ -11,7 +19,29 @@ import type {
   SignalRoomJoined,
   SignalRoomListResponse,
   SignalRoomNotFound,
+  TurnSkipPayload,
+  TurnTimerPayload,
+  SignalSessionResumed,
 } from './protocol';
+import { SnapshotDecoder, type DecodedSnapshot } from './snapshotCodec';
+import type { AILevel, GameSettings, TerrainState } from '../types/game';
+
+// A dropped connection is retried this many times, RECONNECT_DELAY_MS
+// further apart each time, which stays inside the server's default 30s
+// resume grace period.
+const RECONNECT_ATTEMPTS = 6;
+const RECONNECT_DELAY_MS = 1000;
+
+// RoomAuthError rejects a join to a password-protected room: failed is
+// false when no password was given and true when it was wrong.
+export class RoomAuthError extends Error {
+  readonly failed: boolean;
+
+  constructor(failed: boolean) {
+    super(failed ? 'Wrong room password' : 'This room needs a password');
+    this.failed = failed;
+  }
+}
 
 interface PendingRequest {
   resolve: (value: unknown) => void;
@@ -23,12 +53,27 @@ export interface SignalClientHandlers {
   onRoomState?: (room: RoomState) => void;
   onChat?: (msg: ChatMessage) => void;
   onMatchStart?: (payload: MatchStartPayload) => void;
+  onMatchEnd?: (payload: MatchEndPayload) => void;
   onGameInput?: (peerId: string, payload: GameInputPayload) => void;
-  onGameSnapshot?: (payload: GameSnapshotPayload) => void;
+  onGameSnapshot?: (payload: GameSnapshotPayload, terrain?: TerrainState) => void;
   onShopBuy?: (peerId: string, roomId: string, weaponId: string) => void;
   onShopSell?: (peerId: string, roomId: string, weaponId: string) => void;
   onShopDone?: (peerId: string, roomId: string, done: boolean) => void;
   onPeerRename?: (peerId: string, roomId: string, name: string) => void;
+  onPeerDisconnected?: (payload: PeerDisconnectedPayload) => void;
+  onPeerReconnected?: (payload: PeerReconnectedPayload) => void;
+  onHostMigrated?: (payload: HostMigratedPayload) => void;
+  onHostVote?: (payload: HostVotePayload) => void;
+  // The connection dropped while seated in a room and is being retried.
+  onReconnecting?: (attempt: number) => void;
+  // The retried connection took the old seat back with session.resume.
+  onSessionResumed?: (payload: SignalSessionResumed) => void;
+  // Retrying gave up or the seat was gone; the client is disconnected.
+  onSessionLost?: () => void;
+  onRoomClosed?: (payload: RoomClosedPayload) => void;
+  onKicked?: (payload: RoomKickedPayload) => void;
+  onTurnTimer?: (payload: TurnTimerPayload) => void;
+  onTurnSkip?: (payload: TurnSkipPayload) => void;
   onError?: (message: string) => void;
 }
 
@@ -37,6 +82,12 @@ export class SignalClient {
   private requestSeq = 1;
   private pending = new Map<string, PendingRequest>();
   private handlers: SignalClientHandlers;
+  private snapshots = new SnapshotDecoder();
+  private resyncRequested = false;
+  private sessionToken = '';
+  private endpoint = '';
+  private closedByUser = false;
+  private reconnecting = false;
 
   constructor(handlers: SignalClientHandlers = {}) {
     this.handlers = handlers;
@@ -46,20 +97,41 @@ export class SignalClient {
     this.handlers = handlers;
   }
 
+  // The resume token of the current session, which HTTP calls such as map
+  // uploads send as a bearer token.
+  get resumeToken(): string {
+    return this.sessionToken;
+  }
+
   connect(endpoint: string): Promise<void> {
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       return Promise.resolve();
     }
+    this.endpoint = endpoint;
+    this.closedByUser = false;
     return new Promise((resolve, reject) => {
       const normalized = endpoint.startsWith('ws://') || endpoint.startsWith('wss://') ? endpoint : `ws://${endpoint}`;
       const ws = new WebSocket(`${normalized}/ws`);
+      ws.binaryType = 'arraybuffer';
       this.ws = ws;
+      this.snapshots.reset();
+      this.resyncRequested = false;
 
-      ws.onopen = () => resolve();
+      ws.onopen = () => {
+        // Servers without binary snapshots reject this and keep sending JSON.
+        this.request('snapshot.mode', { mode: 'binary' }).catch(() => undefined);
+        resolve();
+      };
       ws.onerror = () => reject(new Error('Unable to connect to LAN signaling server'));
       ws.onclose = () => {
+        if (this.ws !== ws) {
+          return;
+        }
         this.ws = null;
         this.failPending('Disconnected from signaling server');
+        if (!this.closedByUser && !this.reconnecting && this.sessionToken) {
+          void this.reconnect();
+        }
       };
       ws.onmessage = (event) => {
         this.onMessage(event.data);
@@ -68,6 +140,8 @@ export class SignalClient {
   }
 
   disconnect(): void {
+    this.closedByUser = true;
+    this.sessionToken = '';
     if (!this.ws) {
       return;
     }
@@ -80,12 +154,35 @@ export class SignalClient {
     return this.request<SignalRoomListResponse>('room.list.request', {}).then((res) => res.rooms);
   }
 
-  createRoom(roomName: string, hostName: string, maxPlayers: number): Promise<SignalRoomCreated> {
-    return this.request<SignalRoomCreated>('room.create', { roomName, hostName, maxPlayers });
+  createRoom(
+    roomName: string,
+    hostName: string,
+    maxPlayers: number,
+    serverHosted = false,
+    password = '',
+    unlisted = false,
+    settings?: GameSettings,
+  ): Promise<SignalRoomCreated> {
+    return this.request<SignalRoomCreated>('room.create', { roomName, hostName, maxPlayers, serverHosted, password, unlisted, settings });
+  }
+
+  // Rooms already in a match always seat newcomers as spectators.
+  joinRoom(roomId: string, playerName: string, spectator = false, password = ''): Promise<SignalRoomJoined> {
+    return this.request<SignalRoomJoined>('room.join', { roomId, playerName, spectator, password });
   }
 
-  joinRoom(roomId: string, playerName: string): Promise<SignalRoomJoined> {
-    return this.request<SignalRoomJoined>('room.join', { roomId, playerName });
+  // Opens a recorded match as a new read-only room, with this client as the
+  // host who controls playback.
+  playReplay(replayId: string, playerName: string): Promise<SignalRoomJoined> {
+    return this.request<SignalRoomJoined>('replay.play', { replayId, playerName });
+  }
+
+  controlReplay(roomId: string, control: ReplayControl): void {
+    this.send('replay.control', { roomId, ...control });
+  }
+
+  resumeSession(resumeToken: string): Promise<SignalSessionResumed> {
+    return this.request<SignalSessionResumed>('session.resume', { resumeToken });
   }
 
   setReady(roomId: string, ready: boolean): void {
@@ -100,6 +197,14 @@ export class SignalClient {
     this.send('match.start', { roomId, forceStart });
   }
 
+  endMatch(roomId: string, scores: Array<{ peerId: string; score: number }>): void {
+    this.send('match.end', { roomId, scores });
+  }
+
+  rematch(roomId: string): void {
+    this.send('match.rematch', { roomId });
+  }
+
   sendGameInput(payload: GameInputPayload): void {
     this.send('game.input', payload);
   }
@@ -124,8 +229,93 @@ export class SignalClient {
     this.send('peer.rename', { roomId, name });
   }
 
+  setSpectator(roomId: string, spectator: boolean): void {
+    this.send('peer.spectate', { roomId, spectator });
+  }
+
+  // The server answers color_taken if another player already has the color.
+  setColor(roomId: string, colorIndex: number): void {
+    this.send('peer.color', { roomId, colorIndex });
+  }
+
+  kickPlayer(roomId: string, peerId: string): void {
+    this.send('room.kick', { roomId, peerId });
+  }
+
+  // A ban also covers the player's address for the rest of the room's life.
+  banPlayer(roomId: string, peerId: string): void {
+    this.send('room.ban', { roomId, peerId });
+  }
+
+  // Fields left out keep their current value; the server validates the rest.
+  updateSettings(roomId: string, settings: Partial<GameSettings>): void {
+    this.send('room.settings.update', { roomId, settings });
+  }
+
+  addBot(roomId: string, aiLevel: AILevel): void {
+    this.send('room.ai.add', { roomId, aiLevel });
+  }
+
+  removeBot(roomId: string, peerId: string): void {
+    this.send('room.ai.remove', { roomId, peerId });
+  }
+
+  lockRoom(roomId: string, locked: boolean): void {
+    this.send('room.lock', { roomId, locked });
+  }
+
+  // Takes effect from the next match; servers can turn recording off.
+  setRecording(roomId: string, record: boolean): void {
+    this.send('room.record', { roomId, record });
+  }
+
+  // An empty mapId goes back to the settings' terrain preset.
+  setMap(roomId: string, mapId: string): void {
+    this.send('room.map', { roomId, mapId });
+  }
+
+  voteHost(roomId: string, peerId: string): void {
+    this.send('host.vote', { roomId, peerId });
+  }
+
   leaveRoom(roomId: string): void {
     this.send('room.leave', { roomId });
+    // The server gives the seat up for good, and the token with it.
+    this.sessionToken = '';
+  }
+
+  // reconnect dials the endpoint again after an unexpected close and resumes
+  // the session, so a brief network drop keeps the player's seat.
+  private async reconnect(): Promise<void> {
+    this.reconnecting = true;
+    for (let attempt = 1; attempt <= RECONNECT_ATTEMPTS && !this.closedByUser; attempt += 1) {
+      this.handlers.onReconnecting?.(attempt);
+      await new Promise((resolve) => window.setTimeout(resolve, RECONNECT_DELAY_MS * attempt));
+      if (this.closedByUser) {
+        break;
+      }
+      try {
+        await this.connect(this.endpoint);
+      } catch {
+        continue;
+      }
+      try {
+        const resumed = await this.resumeSession(this.sessionToken);
+        this.reconnecting = false;
+        this.handlers.onSessionResumed?.(resumed);
+        return;
+      } catch {
+        // A server that answered turned the token down; the seat is gone.
+        if (this.ws) {
+          break;
+        }
+      }
+    }
+    this.reconnecting = false;
+    if (!this.closedByUser) {
+      this.disconnect();
+      this.handlers.onSessionLost?.();
+    }
   }
 
   private request<T>(type: string, payload: unknown): Promise<T> {
@@ -152,7 +342,32 @@ export class SignalClient {
     this.ws.send(JSON.stringify(message));
   }
 
+  private onBinarySnapshot(buffer: ArrayBuffer): void {
+    let decoded: DecodedSnapshot | null;
+    try {
+      decoded = this.snapshots.decode(buffer);
+    } catch {
+      decoded = null;
+    }
+    if (!decoded) {
+      // Frames already in flight also fail until the keyframe arrives, so
+      // only ask once.
+      if (!this.resyncRequested) {
+        this.resyncRequested = true;
+        this.snapshots.reset();
+        this.send('snapshot.resync', {});
+      }
+      return;
+    }
+    this.resyncRequested = false;
+    this.handlers.onGameSnapshot?.(decoded.payload, decoded.terrain);
+  }
+
   private onMessage(raw: unknown): void {
+    if (raw instanceof ArrayBuffer) {
+      this.onBinarySnapshot(raw);
+      return;
+    }
     if (typeof raw !== 'string') {
       return;
     }
@@ -163,6 +378,11 @@ export class SignalClient {
       return;
     }
 
+    const token = (parsed.payload as { resumeToken?: unknown } | undefined)?.resumeToken;
+    if (typeof token === 'string') {
+      this.sessionToken = token;
+    }
+
     if (parsed.requestId && this.pending.has(parsed.requestId)) {
       const pending = this.pending.get(parsed.requestId)!;
       this.pending.delete(parsed.requestId);
@@ -171,6 +391,8 @@ export class SignalClient {
       if (parsed.type === 'error') {
         const payload = parsed.payload as { message?: string };
         pending.reject(new Error(payload.message ?? 'Unknown signaling error'));
+      } else if (parsed.type === 'room.auth_required' || parsed.type === 'room.auth_failed') {
+        pending.reject(new RoomAuthError(parsed.type === 'room.auth_failed'));
       } else {
         pending.resolve(parsed.payload);
       }
@@ -191,6 +413,10 @@ export class SignalClient {
         this.handlers.onMatchStart?.(parsed.payload as MatchStartPayload);
         break;
       }
+      case 'match.end': {
+        this.handlers.onMatchEnd?.(parsed.payload as MatchEndPayload);
+        break;
+      }
       case 'game.input': {
         const payload = parsed.payload as { peerId: string; data: GameInputPayload };
         this.handlers.onGameInput?.(payload.peerId, payload.data);
@@ -220,9 +446,47 @@ export class SignalClient {
         this.handlers.onPeerRename?.(payload.peerId, payload.roomId, payload.name);
         break;
       }
+      case 'peer.disconnected': {
+        this.handlers.onPeerDisconnected?.(parsed.payload as PeerDisconnectedPayload);
+        break;
+      }
+      case 'peer.reconnected': {
+        this.handlers.onPeerReconnected?.(parsed.payload as PeerReconnectedPayload);
+        break;
+      }
+      case 'host.migrated': {
+        this.handlers.onHostMigrated?.(parsed.payload as HostMigratedPayload);
+        break;
+      }
+      case 'host.vote': {
+        this.handlers.onHostVote?.(parsed.payload as HostVotePayload);
+        break;
+      }
       case 'room.full': {
         const payload = parsed.payload as SignalRoomFull;
-        this.handlers.onError?.(`Room is full (${payload.currentPlayers}/${payload.maxPlayers})`);
+        if (payload.maxSpectators !== undefined) {
+          this.handlers.onError?.(`Too many spectators (${payload.currentSpectators}/${payload.maxSpectators})`);
+        } else {
+          this.handlers.onError?.(`Room is full (${payload.currentPlayers}/${payload.maxPlayers})`);
+        }
+        break;
+      }
+      case 'room.kicked': {
+        this.sessionToken = '';
+        this.handlers.onKicked?.(parsed.payload as RoomKickedPayload);
+        break;
+      }
+      case 'turn.timer': {
+        this.handlers.onTurnTimer?.(parsed.payload as TurnTimerPayload);
+        break;
+      }
+      case 'turn.skip': {
+        this.handlers.onTurnSkip?.(parsed.payload as TurnSkipPayload);
+        break;
+      }
+      case 'room.closed': {
+        this.sessionToken = '';
+        this.handlers.onRoomClosed?.(parsed.payload as RoomClosedPayload);
         break;
       }
       case 'room.not_found': {
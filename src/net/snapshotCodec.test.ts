@@ -0,0 +1,44 @@
+import { describe, expect, it } from 'vitest';
+import { SnapshotDecoder } from './snapshotCodec';
+
+function frame(flags: number, seq: number, baseSeq: number, body: number[], terrain: number[] = []): ArrayBuffer {
+  const header = [1, flags, seq, 0, 0, 0, baseSeq, 0, 0, 0, body.length];
+  return new Uint8Array([...header, ...body, ...terrain]).buffer;
+}
+
+const bytes = (text: string): number[] => Array.from(new TextEncoder().encode(text));
+
+describe('SnapshotDecoder', () => {
+  it('applies body deltas against the previous frame', () => {
+    const decoder = new SnapshotDecoder();
+    const first = decoder.decode(frame(1, 1, 0, bytes('{"roomId":"r","tick":1}')));
+    expect(first?.payload.tick).toBe(1);
+
+    // copy the first 21 bytes ('{"roomId":"r","tick":'), then insert '2}'.
+    const delta = [0, 0, 21, 1, 2, ...bytes('2}')];
+    const second = decoder.decode(frame(0, 2, 1, delta));
+    expect(second?.payload.tick).toBe(2);
+    expect(second?.terrain).toBeUndefined();
+  });
+
+  it('decodes terrain keyframes and run deltas', () => {
+    const decoder = new SnapshotDecoder();
+    const body = bytes('{"tick":1}');
+    // 2x2 terrain, revision 1, heights [1, 1], mask [0, 0, 1, 1], no colors, empty palette.
+    const keyframe = [2, 2, 1, 1, 0, 1, 0, 0, 0, 1, 1, 0, 0];
+    const first = decoder.decode(frame(1 | 2 | 4, 1, 0, body, keyframe));
+    expect(Array.from(first!.terrain!.mask)).toEqual([0, 0, 1, 1]);
+
+    // revision 2, heights run at 0 -> [2], mask run at 2 -> [0], empty palette.
+    const delta = [2, 1, 0, 1, 2, 0, 1, 2, 1, 0, 0];
+    const second = decoder.decode(frame(2, 2, 1, [0, 0, body.length], delta));
+    expect(second!.terrain!.revision).toBe(2);
+    expect(second!.terrain!.heights).toEqual([2, 1]);
+    expect(Array.from(second!.terrain!.mask)).toEqual([0, 0, 0, 1]);
+  });
+
+  it('asks for a resync when the baseline does not match', () => {
+    const decoder = new SnapshotDecoder();
+    expect(decoder.decode(frame(0, 5, 4, []))).toBeNull();
+  });
+});
@@ -0,0 +1,212 @@
+import type { TerrainState } from '../types/game';
+import type { GameSnapshotPayload } from './protocol';
+
+// Decoder for the binary game.snapshot frames described in
+// server/signal-go/snapshot.go.
+
+export const SNAPSHOT_WIRE_VERSION = 1;
+
+const FLAG_KEYFRAME = 1 << 0;
+const FLAG_TERRAIN = 1 << 1;
+const FLAG_TERRAIN_KEYFRAME = 1 << 2;
+
+const OP_COPY = 0;
+const OP_INSERT = 1;
+
+export interface DecodedSnapshot {
+  payload: GameSnapshotPayload;
+  terrain?: TerrainState;
+}
+
+class FrameReader {
+  private readonly bytes: Uint8Array;
+  private offset = 0;
+
+  constructor(bytes: Uint8Array) {
+    this.bytes = bytes;
+  }
+
+  get remaining(): number {
+    return this.bytes.length - this.offset;
+  }
+
+  u8(): number {
+    return this.take(1)[0];
+  }
+
+  u16(): number {
+    const b = this.take(2);
+    return b[0] | (b[1] << 8);
+  }
+
+  u32(): number {
+    const b = this.take(4);
+    return (b[0] | (b[1] << 8) | (b[2] << 16)) + b[3] * 0x1000000;
+  }
+
+  uvarint(): number {
+    let value = 0;
+    let scale = 1;
+    for (;;) {
+      const b = this.u8();
+      value += (b & 0x7f) * scale;
+      if ((b & 0x80) === 0) {
+        return value;
+      }
+      scale *= 128;
+    }
+  }
+
+  take(n: number): Uint8Array {
+    if (n > this.remaining) {
+      throw new Error('Truncated snapshot frame');
+    }
+    const out = this.bytes.subarray(this.offset, this.offset + n);
+    this.offset += n;
+    return out;
+  }
+}
+
+function applyBytesDelta(base: Uint8Array, ops: Uint8Array): Uint8Array {
+  const reader = new FrameReader(ops);
+  const chunks: Uint8Array[] = [];
+  let length = 0;
+  while (reader.remaining > 0) {
+    const op = reader.u8();
+    let chunk: Uint8Array;
+    if (op === OP_COPY) {
+      const from = reader.uvarint();
+      const n = reader.uvarint();
+      if (from + n > base.length) {
+        throw new Error('Snapshot delta copies past its baseline');
+      }
+      chunk = base.subarray(from, from + n);
+    } else if (op === OP_INSERT) {
+      chunk = reader.take(reader.uvarint());
+    } else {
+      throw new Error(`Unknown snapshot delta op ${op}`);
+    }
+    chunks.push(chunk);
+    length += chunk.length;
+  }
+  const out = new Uint8Array(length);
+  let offset = 0;
+  for (const chunk of chunks) {
+    out.set(chunk, offset);
+    offset += chunk.length;
+  }
+  return out;
+}
+
+function readPalette(reader: FrameReader): Array<[number, number, number]> | undefined {
+  const count = reader.uvarint();
+  if (count === 0) {
+    return undefined;
+  }
+  const palette: Array<[number, number, number]> = [];
+  for (let i = 0; i < count; i += 1) {
+    palette.push([reader.u8(), reader.u8(), reader.u8()]);
+  }
+  return palette;
+}
+
+function readTerrainKeyframe(reader: FrameReader): TerrainState {
+  const width = reader.uvarint();
+  const height = reader.uvarint();
+  const revision = reader.uvarint();
+  const heights: number[] = new Array(width);
+  for (let x = 0; x < width; x += 1) {
+    heights[x] = reader.u16();
+  }
+  const mask = reader.take(width * height).slice();
+  const colorIndices = reader.u8() === 1 ? reader.take(width * height).slice() : undefined;
+  return { width, height, revision, heights, mask, colorIndices, colorPalette: readPalette(reader) };
+}
+
+function applyByteRuns(reader: FrameReader, base: Uint8Array): Uint8Array {
+  const out = base.slice();
+  for (let runs = reader.uvarint(); runs > 0; runs -= 1) {
+    const at = reader.uvarint();
+    out.set(reader.take(reader.uvarint()), at);
+  }
+  return out;
+}
+
+function readTerrainDelta(reader: FrameReader, base: TerrainState): TerrainState {
+  const revision = reader.uvarint();
+  const heights = base.heights.slice();
+  for (let runs = reader.uvarint(); runs > 0; runs -= 1) {
+    const at = reader.uvarint();
+    const n = reader.uvarint();
+    for (let i = 0; i < n; i += 1) {
+      heights[at + i] = reader.u16();
+    }
+  }
+  const mask = applyByteRuns(reader, base.mask);
+  const colorIndices = base.colorIndices ? applyByteRuns(reader, base.colorIndices) : undefined;
+  return {
+    width: base.width,
+    height: base.height,
+    revision,
+    heights,
+    mask,
+    colorIndices,
+    colorPalette: readPalette(reader),
+  };
+}
+
+export class SnapshotDecoder {
+  private seq = 0;
+  private body: Uint8Array | null = null;
+  private terrain: TerrainState | null = null;
+  private readonly text = new TextDecoder();
+
+  reset(): void {
+    this.seq = 0;
+    this.body = null;
+    this.terrain = null;
+  }
+
+  // decode returns null when the frame does not follow the baseline we hold;
+  // the caller should then ask the server for a resync.
+  decode(buffer: ArrayBuffer): DecodedSnapshot | null {
+    const reader = new FrameReader(new Uint8Array(buffer));
+    if (reader.u8() !== SNAPSHOT_WIRE_VERSION) {
+      return null;
+    }
+    const flags = reader.u8();
+    const seq = reader.u32();
+    const baseSeq = reader.u32();
+    const section = reader.take(reader.uvarint());
+
+    let body: Uint8Array;
+    if (flags & FLAG_KEYFRAME) {
+      body = section.slice();
+    } else {
+      if (!this.body || baseSeq !== this.seq) {
+        return null;
+      }
+      body = applyBytesDelta(this.body, section);
+    }
+
+    let terrain: TerrainState | undefined;
+    if (flags & FLAG_TERRAIN) {
+      if (flags & FLAG_TERRAIN_KEYFRAME) {
+        terrain = readTerrainKeyframe(reader);
+      } else {
+        if (!this.terrain) {
+          return null;
+        }
+        terrain = readTerrainDelta(reader, this.terrain);
+      }
+    }
+
+    const payload = JSON.parse(this.text.decode(body)) as GameSnapshotPayload;
+    this.seq = seq;
+    this.body = body;
+    if (terrain) {
+      this.terrain = terrain;
+    }
+    return { payload, terrain };
+  }
+}
@@ -1,6 +1,8 @@
 import { useEffect, useMemo, useRef, useState } from 'react';
-import { SignalClient } from '../net/signalingClient';
-import type { ChatMessage, RoomState, RoomSummary } from '../net/protocol';
+import { RoomAuthError, SignalClient } from '../net/signalingClient';
+import type { ChatMessage, MapInfo, MatchMap, ReplayInfo, RoomState, RoomSummary } from '../net/protocol';
+import { TANK_COLORS } from '../types/game';
+import type { AILevel, GameSettings } from '../types/game';
 import { loadNetPrefs, saveNetPrefs } from '../utils/storage';
 
 export interface LanMatchSession {
@@ -8,25 +10,42 @@ export interface LanMatchSession {
   roomId: string;
   selfPeerId: string;
   room: RoomState;
+  // seed is the match seed from match.start, as 16 hex digits.
+  seed?: string;
+  // map is the room's picked map from match.start.
+  map?: MatchMap;
 }
 
 interface LanScreenProps {
   initialMode: 'host' | 'join';
+  // inviteCode comes from a /join/CODE link served by the same server.
+  inviteCode?: string;
+  // settings are the local settings a host offers the room.
+  settings: GameSettings;
   onBack: () => void;
   onMatchStart: (session: LanMatchSession) => void;
 }
 
-export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps): JSX.Element {
+export function LanScreen({ initialMode, inviteCode, settings, onBack, onMatchStart }: LanScreenProps): JSX.Element {
   const prefs = useMemo(() => loadNetPrefs(), []);
   const [mode, setMode] = useState<'host' | 'join'>(initialMode);
-  const [endpoint, setEndpoint] = useState(prefs?.lastEndpoint || '127.0.0.1:8787');
+  const [endpoint, setEndpoint] = useState(inviteCode ? window.location.host : prefs?.lastEndpoint || '127.0.0.1:8787');
   const [preferredName, setPreferredName] = useState(prefs?.lastPlayerName || '');
   const [roomName, setRoomName] = useState("Host's Game");
+  const [serverHosted, setServerHosted] = useState(false);
+  const [roomPassword, setRoomPassword] = useState('');
+  const [unlisted, setUnlisted] = useState(false);
+  const [joinPassword, setJoinPassword] = useState('');
+  const [manualRoomId, setManualRoomId] = useState(inviteCode ?? '');
+  const [botLevel, setBotLevel] = useState<AILevel>('normal');
+  const [terrainFilter, setTerrainFilter] = useState<GameSettings['terrainPreset'] | ''>('');
   const [renameDraft, setRenameDraft] = useState('');
   const [connected, setConnected] = useState(false);
   const [busy, setBusy] = useState(false);
   const [error, setError] = useState('');
   const [rooms, setRooms] = useState<RoomSummary[]>([]);
+  const [replays, setReplays] = useState<ReplayInfo[]>([]);
+  const [maps, setMaps] = useState<MapInfo[]>([]);
   const [roomId, setRoomId] = useState('');
   const [roomState, setRoomState] = useState<RoomState | null>(null);
   const [chatText, setChatText] = useState('');
@@ -160,6 +179,18 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
     };
   }, []);
 
+  const handOff = (client: SignalClient, room: RoomState, peerId: string, seed?: string, map?: MatchMap): void => {
+    handoffInProgressRef.current = true;
+    onMatchStart({
+      client,
+      roomId: room.roomId,
+      selfPeerId: peerId,
+      room,
+      seed,
+      map,
+    });
+  };
+
   const setClientHandlers = (client: SignalClient): void => {
     client.setHandlers({
       onRoomState: (nextRoom) => {
@@ -168,7 +199,7 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
       onChat: (msg) => {
         setChatMessages((prev) => [...prev.slice(-79), msg]);
       },
-      onMatchStart: () => {
+      onMatchStart: (payload) => {
         const client = clientRef.current;
         const currentRoom = roomStateRef.current;
         const currentPeerId = selfPeerIdRef.current;
@@ -176,13 +207,40 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
           setError('Match start received but room session is incomplete');
           return;
         }
-        handoffInProgressRef.current = true;
-        onMatchStart({
-          client,
-          roomId: currentRoom.roomId,
-          selfPeerId: currentPeerId,
-          room: currentRoom,
-        });
+        handOff(client, currentRoom, currentPeerId, payload.seed, payload.map);
+      },
+      onRoomClosed: () => {
+        setRoomState(null);
+        setChatMessages([]);
+        setSelfPeerId('');
+        setError('Room closed: every player left');
+      },
+      onKicked: (payload) => {
+        setRoomState(null);
+        setChatMessages([]);
+        setSelfPeerId('');
+        setError(payload.reason === 'banned' ? 'You were banned from the room' : 'You were kicked from the room');
+      },
+      onReconnecting: (attempt) => {
+        setError(`Connection lost, reconnecting (attempt ${attempt})...`);
+      },
+      onSessionResumed: (payload) => {
+        setError('');
+        if (payload.room.status === 'in-game') {
+          // The match started while this browser was away.
+          handOff(client, payload.room, payload.selfPeerId);
+          return;
+        }
+        setSelfPeerId(payload.selfPeerId);
+        setRoomState(payload.room);
+      },
+      onSessionLost: () => {
+        clientRef.current = null;
+        setConnected(false);
+        setRoomState(null);
+        setChatMessages([]);
+        setSelfPeerId('');
+        setError('Lost connection to the server');
       },
       onError: (msg) => {
         setError(msg);
@@ -224,7 +282,15 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
     setError('');
     try {
       const client = await ensureConnected();
-      const room = await client.createRoom(roomName.trim() || "Host's Game", preferredName.trim(), 10);
+      const room = await client.createRoom(
+        roomName.trim() || "Host's Game",
+        preferredName.trim(),
+        10,
+        serverHosted,
+        roomPassword,
+        unlisted,
+        settings,
+      );
       setSelfPeerId(room.selfPeerId);
       setRoomState(room.room);
       if (preferredName.trim()) {
@@ -242,8 +308,9 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
     }
   };
 
-  const joinSelectedRoom = async (): Promise<void> => {
-    if (!roomId) {
+  const joinSelectedRoom = async (spectator = false): Promise<void> => {
+    const targetRoomId = manualRoomId.trim() || roomId;
+    if (!targetRoomId) {
       setError('Select a room first');
       return;
     }
@@ -251,7 +318,13 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
     setError('');
     try {
       const client = await ensureConnected();
-      const joined = await client.joinRoom(roomId, preferredName.trim());
+      const joined = await client.joinRoom(targetRoomId, preferredName.trim(), spectator, joinPassword);
+      if (joined.room.status === 'in-game') {
+        // Late arrivals go straight to the battlefield; the server follows
+        // room.joined with its latest snapshot.
+        handOff(client, joined.room, joined.selfPeerId);
+        return;
+      }
       setSelfPeerId(joined.selfPeerId);
       setRoomState(joined.room);
       if (preferredName.trim()) {
@@ -261,6 +334,79 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
       setMode('join');
     } catch (err) {
       const msg = err instanceof Error ? err.message : 'Unable to join room';
+      setError(err instanceof RoomAuthError ? `${msg}: enter it below and join again` : msg);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const refreshReplays = async (): Promise<void> => {
+    setBusy(true);
+    setError('');
+    try {
+      const res = await fetch(`http://${endpoint.trim()}/replays`);
+      if (!res.ok) {
+        throw new Error(res.status === 404 ? 'Recording is off on this server' : `Replay list failed: ${res.status}`);
+      }
+      const body = (await res.json()) as { replays: ReplayInfo[] };
+      setReplays(body.replays);
+    } catch (err) {
+      const msg = err instanceof Error ? err.message : 'Unable to fetch replays';
+      setError(msg);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const refreshMaps = async (): Promise<void> => {
+    setError('');
+    try {
+      const res = await fetch(`http://${endpoint.trim()}/api/maps`);
+      if (!res.ok) {
+        throw new Error(`Map list failed: ${res.status}`);
+      }
+      const body = (await res.json()) as { maps: MapInfo[] };
+      setMaps(body.maps);
+    } catch (err) {
+      const msg = err instanceof Error ? err.message : 'Unable to fetch maps';
+      setError(msg);
+    }
+  };
+
+  const uploadMap = async (file: File): Promise<void> => {
+    const client = clientRef.current;
+    if (!client || !roomState) {
+      return;
+    }
+    setBusy(true);
+    setError('');
+    try {
+      const res = await fetch(
+        `http://${endpoint.trim()}/api/rooms/${roomState.roomId}/map?name=${encodeURIComponent(file.name)}`,
+        { method: 'POST', headers: { Authorization: `Bearer ${client.resumeToken}` }, body: file },
+      );
+      if (!res.ok) {
+        throw new Error((await res.text()).trim() || `Map upload failed: ${res.status}`);
+      }
+    } catch (err) {
+      const msg = err instanceof Error ? err.message : 'Unable to upload map';
+      setError(msg);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const watchReplay = async (replayId: string): Promise<void> => {
+    setBusy(true);
+    setError('');
+    try {
+      const client = await ensureConnected();
+      // The replay room starts playing right away, so go straight to the
+      // battlefield like a late arrival.
+      const joined = await client.playReplay(replayId, preferredName.trim());
+      handOff(client, joined.room, joined.selfPeerId);
+    } catch (err) {
+      const msg = err instanceof Error ? err.message : 'Unable to play replay';
       setError(msg);
     } finally {
       setBusy(false);
@@ -308,6 +454,113 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
     }
   };
 
+  const toggleSpectator = (): void => {
+    if (!roomState) {
+      return;
+    }
+    const self = roomState.players.find((p) => p.peerId === selfPeerId);
+    if (!self) {
+      return;
+    }
+    try {
+      clientRef.current?.setSpectator(roomState.roomId, !self.spectator);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const moderate = (peerId: string, action: 'kick' | 'ban'): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      if (action === 'ban') {
+        clientRef.current?.banPlayer(roomState.roomId, peerId);
+      } else {
+        clientRef.current?.kickPlayer(roomState.roomId, peerId);
+      }
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const applySettings = (): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.updateSettings(roomState.roomId, settings);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const pickMap = (mapId: string): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.setMap(roomState.roomId, mapId);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const addBot = (): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.addBot(roomState.roomId, botLevel);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const removeBot = (peerId: string): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.removeBot(roomState.roomId, peerId);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const pickColor = (colorIndex: number): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.setColor(roomState.roomId, colorIndex);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const toggleLock = (): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.lockRoom(roomState.roomId, !roomState.locked);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
+  const toggleRecording = (): void => {
+    if (!roomState) {
+      return;
+    }
+    try {
+      clientRef.current?.setRecording(roomState.roomId, !roomState.record);
+    } catch {
+      setError('Not connected');
+    }
+  };
+
   const startMatch = (forceStart = false): void => {
     if (!roomState) {
       return;
@@ -322,6 +575,16 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
   const self = roomState?.players.find((p) => p.peerId === selfPeerId) ?? null;
   const isHost = Boolean(self?.isHost);
   const readyCount = roomState?.players.filter((p) => p.ready).length ?? 0;
+  const seatedCount = roomState?.players.filter((p) => !p.spectator).length ?? 0;
+  const takenColors = new Set((roomState?.players ?? []).map((p) => p.colorIndex ?? -1));
+  const selectedRoom = rooms.find((room) => room.roomId === roomId) ?? null;
+  const visibleRooms = terrainFilter ? rooms.filter((room) => room.settings?.terrainPreset === terrainFilter) : rooms;
+  const roomSettings = roomState?.settings;
+  const pickedUpload = roomState?.upload && roomState.upload.id === roomState.mapId ? roomState.upload : undefined;
+  // An upload keeps its address when the host replaces it, so its id tells
+  // the browser the picture changed.
+  const mapThumbUrl = (map: MapInfo): string =>
+    `http://${endpoint.trim()}${map.thumbnailUrl}${map.source === 'upload' ? `?id=${encodeURIComponent(map.id)}` : ''}`;
   const liveNameByPeerId = new Map((roomState?.players ?? []).map((p) => [p.peerId, p.name]));
 
   return (
@@ -350,6 +613,18 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
                 Room Name
                 <input value={roomName} onChange={(e) => setRoomName(e.target.value)} maxLength={32} />
               </label>
+              <label>
+                <input type="checkbox" checked={serverHosted} onChange={(e) => setServerHosted(e.target.checked)} />
+                Run match on server
+              </label>
+              <label>
+                Password (optional)
+                <input type="password" value={roomPassword} onChange={(e) => setRoomPassword(e.target.value)} maxLength={64} />
+              </label>
+              <label>
+                <input type="checkbox" checked={unlisted} onChange={(e) => setUnlisted(e.target.checked)} />
+                Unlisted (join by room id only)
+              </label>
               <div className="row">
                 <button onClick={createRoom} disabled={busy}>Create Room</button>
                 <button onClick={onBack} disabled={busy}>Back</button>
@@ -362,10 +637,21 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
               <div className="row">
                 <button onClick={onBack} disabled={busy}>Back</button>
               </div>
+              <label>
+                Terrain
+                <select value={terrainFilter} onChange={(e) => setTerrainFilter(e.target.value as GameSettings['terrainPreset'] | '')}>
+                  <option value="">Any</option>
+                  <option value="rolling">Rolling</option>
+                  <option value="canyon">Canyon</option>
+                  <option value="islands">Islands</option>
+                  <option value="random">Random</option>
+                  <option value="mtn">MTN</option>
+                </select>
+              </label>
               <div className="room-list">
                 {busy && <p>Searching rooms...</p>}
-                {!busy && rooms.length === 0 && <p>No rooms found.</p>}
-                {rooms.map((room) => (
+                {!busy && visibleRooms.length === 0 && <p>No rooms found.</p>}
+                {visibleRooms.map((room) => (
                   <label key={room.roomId} className="room-row">
                     <input
                       type="radio"
@@ -375,11 +661,49 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
                     />
                     <span>
                       {room.roomName} ({room.players}/{room.maxPlayers}) - Host: {room.hostName}
+                      {room.code ? ` - Code: ${room.code}` : ''}
+                      {room.serverHosted ? ' [server]' : ''}
+                      {room.replay ? ' [replay]' : room.status === 'in-game' ? ' [in game]' : ''}
+                      {room.passwordProtected ? ' [password]' : ''}
+                      {room.settings ? ` - ${room.settings.terrainPreset}, first to ${room.settings.roundsToWin}` : ''}
+                      {room.settings?.turnTimeLimitSec ? `, ${room.settings.turnTimeLimitSec}s turns` : ''}
+                      {room.spectators ? ` - ${room.spectators} watching` : ''}
                     </span>
                   </label>
                 ))}
               </div>
-              <button onClick={joinSelectedRoom} disabled={busy || !roomId}>Join Selected Room</button>
+              <label>
+                Room Code or ID (for unlisted rooms)
+                <input value={manualRoomId} onChange={(e) => setManualRoomId(e.target.value)} placeholder="Leave empty to use the selection" />
+              </label>
+              <label>
+                Room Password (if required)
+                <input type="password" value={joinPassword} onChange={(e) => setJoinPassword(e.target.value)} maxLength={64} />
+              </label>
+              <div className="row">
+                <button
+                  onClick={() => void joinSelectedRoom()}
+                  disabled={busy || (!roomId && !manualRoomId.trim()) || (!manualRoomId.trim() && selectedRoom?.status === 'in-game')}
+                >
+                  Join Room
+                </button>
+                <button onClick={() => void joinSelectedRoom(true)} disabled={busy || (!roomId && !manualRoomId.trim())}>Watch Room</button>
+              </div>
+              <div className="row">
+                <button onClick={() => void refreshReplays()} disabled={busy}>Show Replays</button>
+              </div>
+              {replays.length > 0 && (
+                <div className="room-list">
+                  {replays.map((replay) => (
+                    <div key={replay.id} className="replay-row">
+                      <span>
+                        {replay.roomName} - {new Date(replay.startedAt).toLocaleString()} - {replay.players.map((p) => p.name).join(', ')}
+                      </span>
+                      <button onClick={() => void watchReplay(replay.id)} disabled={busy}>Watch Replay</button>
+                    </div>
+                  ))}
+                </div>
+              )}
             </>
           )}
         </>
@@ -388,26 +712,147 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
       {roomState && (
         <>
           <p>
-            Room: <strong>{roomState.roomName}</strong> ({roomState.players.length}/{roomState.maxPlayers})
+            Room: <strong>{roomState.roomName}</strong> ({seatedCount}/{roomState.maxPlayers})
+            {roomState.code && (
+              <>
+                {' '}- Join code: <code>{roomState.code}</code> (invite link: <code>{`http://${endpoint.trim()}/join/${roomState.code}`}</code>)
+              </>
+            )}
+            {!roomState.code && roomState.unlisted && <> - Room ID: <code>{roomState.roomId}</code></>}
           </p>
+          {roomSettings && (
+            <p>
+              Terrain: {roomSettings.terrainPreset} - First to {roomSettings.roundsToWin} - Wind: {roomSettings.windMode} - Cash: {roomSettings.cashStart}
+              {roomSettings.turnTimeLimitSec ? ` - ${roomSettings.turnTimeLimitSec}s turns` : ''}
+              {roomSettings.freeFireMode ? ' - Free fire' : ''}
+            </p>
+          )}
+          {roomState.mapId && (
+            <p>
+              Map: <strong>{roomState.mapId}</strong>
+              {pickedUpload ? (
+                <>
+                  {' '}(uploaded, <a href={`http://${endpoint.trim()}${pickedUpload.fileUrl}`} download>download</a>)
+                  <img className="map-thumb" src={mapThumbUrl(pickedUpload)} alt="" />
+                </>
+              ) : (
+                <img className="map-thumb" src={`http://${endpoint.trim()}/api/maps/${roomState.mapId}.png`} alt="" />
+              )}
+            </p>
+          )}
+          {roomState.record && (
+            <p>Recording: matches are saved to <code>{`http://${endpoint.trim()}/replays`}</code></p>
+          )}
           <div className="grid">
             {roomState.players.map((player) => (
               <div className="player-card" key={player.peerId}>
-                <strong>{player.name}</strong>
-                <span>{player.isHost ? 'Host' : 'Client'}</span>
-                <span>{player.ready ? 'Ready' : 'Not Ready'}</span>
+                <strong>
+                  {player.colorIndex !== undefined && player.colorIndex >= 0 && (
+                    <span className="color-swatch" style={{ background: TANK_COLORS[player.colorIndex % TANK_COLORS.length] }} />
+                  )}
+                  {player.name}
+                </strong>
+                <span>{player.isHost ? 'Host' : player.bot ? `Bot (${player.aiLevel ?? 'normal'})` : player.spectator ? 'Spectator' : 'Client'}</span>
+                {!player.spectator && <span>{player.afk ? 'AFK' : player.ready ? 'Ready' : 'Not Ready'}</span>}
+                {!player.connected && !player.bot && <span>Disconnected</span>}
+                {player.latencyMs !== undefined && <span>{player.latencyMs} ms</span>}
+                {isHost && player.bot && roomState.status === 'lobby' && (
+                  <button onClick={() => removeBot(player.peerId)}>Remove</button>
+                )}
+                {isHost && !player.bot && player.peerId !== selfPeerId && (
+                  <span className="row">
+                    <button onClick={() => moderate(player.peerId, 'kick')}>Kick</button>
+                    <button onClick={() => moderate(player.peerId, 'ban')}>Ban</button>
+                  </span>
+                )}
               </div>
             ))}
           </div>
 
+          {self && !self.spectator && roomState.status === 'lobby' && (
+            <div className="row">
+              {TANK_COLORS.map((color, idx) => (
+                <button
+                  key={color}
+                  className={`color-swatch${self.colorIndex === idx ? ' selected' : ''}`}
+                  style={{ background: color }}
+                  title={`Color ${idx + 1}`}
+                  disabled={takenColors.has(idx) && self.colorIndex !== idx}
+                  onClick={() => pickColor(idx)}
+                />
+              ))}
+            </div>
+          )}
           <div className="row">
-            <button onClick={toggleReady}>{self?.ready ? 'Unready' : 'Ready'}</button>
+            {!self?.spectator && <button onClick={toggleReady}>{self?.ready ? 'Unready' : 'Ready'}</button>}
+            {!isHost && <button onClick={toggleSpectator}>{self?.spectator ? 'Take a Seat' : 'Spectate'}</button>}
             {isHost && (
               <button onClick={() => startMatch(false)} disabled={readyCount < 2}>Start Match</button>
             )}
             {isHost && (
-              <button onClick={() => startMatch(true)} disabled={roomState.players.length < 2}>Force Start</button>
+              <button onClick={() => startMatch(true)} disabled={seatedCount < 2}>Force Start</button>
             )}
+            {isHost && roomState.status === 'lobby' && <button onClick={applySettings}>Apply My Settings</button>}
+          </div>
+          {isHost && roomState.status === 'lobby' && (
+            <div className="row">
+              <select value={botLevel} onChange={(e) => setBotLevel(e.target.value as AILevel)}>
+                <option value="easy">Easy</option>
+                <option value="normal">Normal</option>
+                <option value="hard">Hard</option>
+              </select>
+              <button onClick={addBot} disabled={seatedCount >= roomState.maxPlayers}>Add Bot</button>
+              <button onClick={() => void refreshMaps()}>Pick Map</button>
+            </div>
+          )}
+          {isHost && roomState.status === 'lobby' && (
+            <label
+              className="map-drop"
+              onDragOver={(e) => e.preventDefault()}
+              onDrop={(e) => {
+                e.preventDefault();
+                const file = e.dataTransfer.files[0];
+                if (file) {
+                  void uploadMap(file);
+                }
+              }}
+            >
+              Drop an .MTN map or heightmap PNG here, or pick one
+              <input
+                type="file"
+                accept=".mtn,.MTN,image/png"
+                disabled={busy}
+                onChange={(e) => {
+                  const file = e.target.files?.[0];
+                  e.target.value = '';
+                  if (file) {
+                    void uploadMap(file);
+                  }
+                }}
+              />
+            </label>
+          )}
+          {isHost && roomState.status === 'lobby' && (maps.length > 0 || roomState.upload) && (
+            <div className="map-picker">
+              <button className={!roomState.mapId ? 'selected' : ''} onClick={() => pickMap('')}>
+                No map ({roomSettings?.terrainPreset ?? 'preset'})
+              </button>
+              {[...(roomState.upload ? [roomState.upload] : []), ...maps].map((map) => (
+                <button
+                  key={map.id}
+                  className={roomState.mapId === map.id ? 'selected' : ''}
+                  title={`${map.width}x${map.height} (${map.source})`}
+                  onClick={() => pickMap(map.id)}
+                >
+                  <img className="map-thumb" src={mapThumbUrl(map)} alt="" />
+                  {map.id}
+                </button>
+              ))}
+            </div>
+          )}
+          <div className="row">
+            {isHost && <button onClick={toggleLock}>{roomState.locked ? 'Unlock Room' : 'Lock Room'}</button>}
+            {isHost && <button onClick={toggleRecording}>{roomState.record ? 'Stop Recording' : 'Record Matches'}</button>}
             <button onClick={leaveRoom}>Leave Room</button>
           </div>
           <div className="row">
@@ -445,7 +890,7 @@ export function LanScreen({ initialMode, onBack, onMatchStart }: LanScreenProps)
         </>
       )}
 
-      <p className="subtitle">LAN-only mode: no room code or password required.</p>
+      <p className="subtitle">LAN-only mode: rooms can be password protected, or unlisted and shared by join code.</p>
       {!roomState && <p className="subtitle">Unnamed joins are auto-labeled Player1, Player2, ... in join order.</p>}
       {connected && !roomState && <p>Connected to signaling server.</p>}
       {error && <p className="error">{error}</p>}